@@ -0,0 +1,205 @@
+// Package arguments converts tool call arguments between the JSON objects generated by models
+// and the list<tuple<string, string>> carried by mcp.CallToolParams, and checks them against the
+// input schema of a tool.
+//
+// The argument list follows the contract of the MCP tool servers: string values are stored as-is
+// so tools use them directly, every other value (numbers, booleans, null, arrays and objects) is
+// stored as its compact JSON text. The list itself does not tell the string "42" from the number
+// 42, the input schema of the tool does: EncodeCall and Validate read a value as a string when
+// its property is declared a string. Without a schema, values that read as JSON other than a
+// string are taken for that value. Keys keep the order they had in the model's output.
+package arguments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+)
+
+// Decode converts a JSON object of arguments into an argument list. Some models emit the
+// object as a JSON encoded string, which is accepted as well. Empty input and null give no
+// arguments.
+func Decode(raw []byte) ([][2]string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if raw[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return nil, fmt.Errorf("failed to parse tool arguments: %v", err)
+		}
+		return Decode([]byte(encoded))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("failed to parse tool arguments: expected a JSON object")
+	}
+
+	var args [][2]string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tool arguments: %v", err)
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse tool arguments: invalid key %v", token)
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("failed to parse tool argument %s: %v", key, err)
+		}
+
+		arg, err := FormatRaw(value)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, [2]string{key, arg})
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("failed to parse tool arguments: %v", err)
+	}
+	return args, nil
+}
+
+// Encode converts an argument list into a compact JSON object, keeping the argument order.
+// Without the input schema of the tool, strings that read as other JSON values are written as
+// those values, EncodeCall keeps them strings.
+func Encode(args [][2]string) json.RawMessage {
+	return encode(args, nil)
+}
+
+// EncodeCall converts the arguments of a call into a compact JSON object, keeping the argument
+// order. The input schema of the called tool, looked up in tools, types the values.
+func EncodeCall(tools []mcp.Tool, call mcp.CallToolParams) json.RawMessage {
+	return encode(call.Arguments.Slice(), schema.Find(tools, call.Name))
+}
+
+func encode(args [][2]string, s *schema.Schema) json.RawMessage {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, arg := range args {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(quote(arg[0]))
+		buf.WriteByte(':')
+		buf.Write(RawFor(s.Property(arg[0]), arg[1]))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// FormatRaw converts a single JSON value into its argument string
+func FormatRaw(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", fmt.Errorf("failed to parse tool argument: %v", err)
+		}
+		return s, nil
+	}
+
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, raw); err != nil {
+		return "", fmt.Errorf("failed to parse tool argument: %v", err)
+	}
+	return compact.String(), nil
+}
+
+// FormatValue converts a Go value, as produced by encoding/json, into its argument string
+func FormatValue(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	raw, err := marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tool argument: %v", err)
+	}
+	return FormatRaw(raw)
+}
+
+// Raw returns the JSON text of an argument string. Arguments that read as a JSON value other
+// than a string are returned as that value, any other argument is returned as a JSON string.
+func Raw(arg string) json.RawMessage {
+	return RawFor(nil, arg)
+}
+
+// RawFor returns the JSON text of an argument string typed by the schema of its property. A
+// property declared a string, and only a string, keeps its argument a string, except null for a
+// nullable one. A nil schema types nothing, as with Raw.
+func RawFor(s *schema.Schema, arg string) json.RawMessage {
+	if isString(s) {
+		if s.Nullable() && arg == "null" {
+			return json.RawMessage("null")
+		}
+		return quote(arg)
+	}
+
+	trimmed := bytes.TrimSpace([]byte(arg))
+	if len(trimmed) > 0 && trimmed[0] != '"' {
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, trimmed); err == nil {
+			return compact.Bytes()
+		}
+	}
+	return quote(arg)
+}
+
+// isString reports whether a schema only accepts strings, and possibly null
+func isString(s *schema.Schema) bool {
+	if s == nil || s.Type() != "string" {
+		return false
+	}
+	for _, t := range s.Types {
+		if t != "string" && t != "null" {
+			return false
+		}
+	}
+	return true
+}
+
+// Value returns the JSON value of an argument string, read as Raw does. Numbers are returned
+// as json.Number so their original text is preserved.
+func Value(arg string) interface{} {
+	return ValueFor(nil, arg)
+}
+
+// ValueFor returns the JSON value of an argument string typed by the schema of its property,
+// read as RawFor does
+func ValueFor(s *schema.Schema, arg string) interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(RawFor(s, arg)))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return arg
+	}
+	return value
+}
+
+// quote encodes a string as JSON without escaping HTML characters, which are common in code
+func quote(s string) []byte {
+	raw, _ := marshal(s)
+	return raw
+}
+
+func marshal(value interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,237 @@
+package arguments
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    [][2]string
+		wantErr bool
+	}{
+		{
+			name:  "typed values keep their JSON text and order",
+			input: `{"z": "Paris", "count": 3, "ratio": 1e6, "exact": true, "tags": ["a", "b"], "filter": {"a": 1, "nested": {"b": null}}, "none": null}`,
+			want: [][2]string{
+				{"z", "Paris"},
+				{"count", "3"},
+				{"ratio", "1e6"},
+				{"exact", "true"},
+				{"tags", `["a","b"]`},
+				{"filter", `{"a":1,"nested":{"b":null}}`},
+				{"none", "null"},
+			},
+		},
+		{
+			name:  "strings are stored as-is",
+			input: `{"zip": "02134", "count": "42", "flag": "true", "quoted": "\"hi\"", "empty": ""}`,
+			want: [][2]string{
+				{"zip", "02134"},
+				{"count", "42"},
+				{"flag", "true"},
+				{"quoted", `"hi"`},
+				{"empty", ""},
+			},
+		},
+		{
+			name:  "arguments encoded as a JSON string",
+			input: `"{\"city\": \"Paris\", \"days\": 2}"`,
+			want:  [][2]string{{"city", "Paris"}, {"days", "2"}},
+		},
+		{
+			name:  "null arguments",
+			input: `null`,
+		},
+		{
+			name:    "not an object",
+			input:   `[1, 2]`,
+			wantErr: true,
+		},
+		{
+			name:    "truncated object",
+			input:   `{"city": "Par`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Decode() = %q, want %q", got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("Decode()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		args [][2]string
+		want string
+	}{
+		{[][2]string{{"city", "Paris"}, {"days", "2"}, {"metric", "false"}}, `{"city":"Paris","days":2,"metric":false}`},
+		{[][2]string{{"code", "<b>&</b>"}, {"quoted", `"hi"`}, {"zip", "02134"}}, `{"code":"<b>&</b>","quoted":"\"hi\"","zip":"02134"}`},
+		{[][2]string{{"list", `[1,"2"]`}, {"none", "null"}, {"empty", ""}}, `{"list":[1,"2"],"none":null,"empty":""}`},
+		{nil, `{}`},
+	}
+
+	for _, tt := range tests {
+		if got := string(Encode(tt.args)); got != tt.want {
+			t.Errorf("Encode(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeCall(t *testing.T) {
+	tools := []mcp.Tool{{
+		Name: "lookup",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{
+				{"zip", `{"type": "string"}`},
+				{"flag", `{"type": "string"}`},
+				{"note", `{"type": ["string", "null"]}`},
+				{"count", `{"type": "integer"}`},
+				{"any", `{"type": ["string", "number"]}`},
+			}),
+		},
+	}}
+
+	inputs := []string{
+		`{"zip":"42","flag":"true","note":"false","count":42,"any":1.5}`,
+		`{"zip":"{\"a\":1}","note":null,"any":"x","extra":[true]}`,
+		`{"zip":"line one\nline \"two\"","flag":"héllo"}`,
+		`{}`,
+	}
+
+	for _, input := range inputs {
+		args, err := Decode([]byte(input))
+		if err != nil {
+			t.Fatalf("Decode(%s) error = %v", input, err)
+		}
+		call := mcp.CallToolParams{Name: "lookup", Arguments: cm.ToList(args)}
+		if got := string(EncodeCall(tools, call)); got != input {
+			t.Errorf("EncodeCall(Decode(x)) mismatch\nwant: %s\ngot:  %s", input, got)
+		}
+	}
+
+	call := mcp.CallToolParams{Name: "unknown", Arguments: cm.ToList([][2]string{{"zip", "42"}, {"flag", "true"}})}
+	if got := string(EncodeCall(tools, call)); got != `{"zip":42,"flag":true}` {
+		t.Errorf("EncodeCall() of an unknown tool = %s, want the untyped encoding", got)
+	}
+}
+
+func TestValue(t *testing.T) {
+	if v, ok := Value("42").(json.Number); !ok || v.String() != "42" {
+		t.Errorf("Expected json.Number 42, got %#v", Value("42"))
+	}
+	if v, ok := Value("true").(bool); !ok || !v {
+		t.Errorf("Expected boolean true, got %#v", Value("true"))
+	}
+	if v, ok := Value("Paris").(string); !ok || v != "Paris" {
+		t.Errorf("Expected string Paris, got %#v", Value("Paris"))
+	}
+	if v, ok := Value(`"hi"`).(string); !ok || v != `"hi"` {
+		t.Errorf("Expected string \"hi\", got %#v", Value(`"hi"`))
+	}
+	if v, ok := Value(`{"a":[true]}`).(map[string]interface{}); !ok || len(v) != 1 {
+		t.Errorf("Expected an object, got %#v", Value(`{"a":[true]}`))
+	}
+	if Value("null") != nil {
+		t.Errorf("Expected nil, got %#v", Value("null"))
+	}
+
+	str := &schema.Schema{Types: []string{"string"}}
+	if v, ok := ValueFor(str, "42").(string); !ok || v != "42" {
+		t.Errorf("Expected string 42, got %#v", ValueFor(str, "42"))
+	}
+	if v, ok := ValueFor(str, "true").(string); !ok || v != "true" {
+		t.Errorf("Expected string true, got %#v", ValueFor(str, "true"))
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"Paris", "Paris"},
+		{"123", "123"},
+		{"false", "false"},
+		{float64(1000000), "1000000"},
+		{map[string]interface{}{"a": 1.5}, `{"a":1.5}`},
+		{nil, "null"},
+		{[]interface{}{"x", true}, `["x",true]`},
+	}
+
+	for _, tt := range tests {
+		got, err := FormatValue(tt.value)
+		if err != nil {
+			t.Fatalf("FormatValue(%v) error = %v", tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("FormatValue(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tool := mcp.Tool{
+		Name: "get_weather",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{
+				{"city", `{"type": "string"}`},
+				{"days", `{"type": "integer"}`},
+				{"unit", `{"type": "string", "enum": ["celsius", "fahrenheit"]}`},
+				{"hourly", `{"type": ["boolean", "null"]}`},
+			}),
+			Required: cm.ToList([]string{"city"}),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		args    [][2]string
+		wantErr string
+	}{
+		{name: "valid", args: [][2]string{{"city", "Paris"}, {"days", "3"}, {"unit", "celsius"}, {"hourly", "null"}, {"extra", "kept"}}},
+		{name: "string that reads as a number", args: [][2]string{{"city", "42"}}},
+		{name: "string that reads as a boolean", args: [][2]string{{"city", "true"}}},
+		{name: "text for a number", args: [][2]string{{"city", "Paris"}, {"days", "three"}}, wantErr: "argument days must be of type integer, got string"},
+		{name: "missing required", args: [][2]string{{"days", "3"}}, wantErr: "missing required argument city"},
+		{name: "wrong type", args: [][2]string{{"city", "Paris"}, {"days", "2.5"}}, wantErr: "argument days must be of type integer, got number"},
+		{name: "not in enum", args: [][2]string{{"city", "Paris"}, {"unit", "kelvin"}}, wantErr: `argument unit must be one of "celsius", "fahrenheit"`},
+		{name: "every problem", args: [][2]string{{"hourly", "yes"}}, wantErr: "missing required argument city; argument hourly must be of type boolean or null, got string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tool, tt.args)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate failed: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
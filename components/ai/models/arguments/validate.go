@@ -0,0 +1,95 @@
+package arguments
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+)
+
+// Validate checks an argument list against the input schema of a tool: every required argument
+// must be present, and arguments declared by the schema must match its type and enum. Arguments
+// the schema does not declare are left to the tool. The error lists every problem found, worded
+// so the model can correct its call.
+func Validate(tool mcp.Tool, args [][2]string) error {
+	s := schema.FromTool(tool)
+
+	given := map[string]string{}
+	for _, arg := range args {
+		given[arg[0]] = arg[1]
+	}
+
+	var problems []string
+	for _, property := range s.Properties {
+		arg, ok := given[property.Name]
+		if !ok {
+			if property.Required {
+				problems = append(problems, fmt.Sprintf("missing required argument %s", property.Name))
+			}
+			continue
+		}
+		if problem := check(property.Name, arg, property.Schema); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// check returns the problem with an argument value, empty when it follows the schema
+func check(name, arg string, s *schema.Schema) string {
+	raw := RawFor(s, arg)
+
+	if len(s.Enum) > 0 {
+		var values []string
+		for _, value := range s.Enum {
+			compact := &bytes.Buffer{}
+			if err := json.Compact(compact, value); err != nil {
+				continue
+			}
+			if bytes.Equal(compact.Bytes(), raw) {
+				return ""
+			}
+			values = append(values, compact.String())
+		}
+		return fmt.Sprintf("argument %s must be one of %s", name, strings.Join(values, ", "))
+	}
+
+	if len(s.Types) == 0 {
+		return ""
+	}
+	kind := kindOf(ValueFor(s, arg))
+	for _, t := range s.Types {
+		if t == kind || t == "number" && kind == "integer" {
+			return ""
+		}
+	}
+	return fmt.Sprintf("argument %s must be of type %s, got %s", name, strings.Join(s.Types, " or "), kind)
+}
+
+// kindOf returns the JSON Schema type of a value returned by ValueFor
+func kindOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,12 @@
+//go:build chat_template
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/chattemplate"
+)
+
+func build() export.Constructor {
+	return chattemplate.Constructor
+}
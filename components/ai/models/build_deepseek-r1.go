@@ -0,0 +1,12 @@
+//go:build deepseek_r1
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/deepseek"
+)
+
+func build() export.Constructor {
+	return deepseek.ConstructorDeepSeek_R1
+}
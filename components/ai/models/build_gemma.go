@@ -0,0 +1,12 @@
+//go:build gemma
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/gemma"
+)
+
+func build() export.Constructor {
+	return gemma.ConstructorGemma
+}
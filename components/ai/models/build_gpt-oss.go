@@ -0,0 +1,12 @@
+//go:build gpt_oss
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/harmony"
+)
+
+func build() export.Constructor {
+	return harmony.ConstructorGptOss
+}
@@ -3,10 +3,13 @@
 package main
 
 import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
 	"github.com/hayride-dev/morphs/components/ai/models/llama3"
 )
 
 func build() export.Constructor {
-	return llama3.Constructor_3_1
+	return func() (models.Format, error) {
+		return llama3.New(llama3.Config{Today: today})
+	}
 }
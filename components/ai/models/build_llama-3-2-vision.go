@@ -1,14 +1,15 @@
-//go:build !llama_3_1 && !qwen_2_5 && !qwen_3
+//go:build llama_3_2_vision
 
 package main
 
 import (
 	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/llama3"
 )
 
 func build() export.Constructor {
 	return func() (models.Format, error) {
-		return nil, nil // No-op for non-llama3 builds
+		return llama3.NewVision(llama3.Config{Today: today})
 	}
 }
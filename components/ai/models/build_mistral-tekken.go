@@ -0,0 +1,12 @@
+//go:build mistral_tekken
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/morphs/components/ai/models/mistral"
+)
+
+func build() export.Constructor {
+	return mistral.ConstructorTekken
+}
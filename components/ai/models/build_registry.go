@@ -0,0 +1,35 @@
+//go:build !llama_3_1 && !llama_3_2_vision && !qwen_2_5 && !qwen_2_vl && !qwen_3 && !mistral_tekken && !mistral_v3 && !mistral && !gemma && !deepseek_r1 && !chat_template && !gpt_oss && !hermes
+
+package main
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/export"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models/repository"
+	"github.com/hayride-dev/morphs/components/ai/models/llama3"
+	"github.com/hayride-dev/morphs/components/ai/models/registry"
+)
+
+// Without a format tag every format is bundled and one is selected at runtime from the
+// MODEL and MODEL_FORMAT environment variables, reading the GGUF metadata of the model
+func build() export.Constructor {
+	formats := registry.Default()
+
+	// The Llama 3 system header carries the date reported by the hayride:datetime import
+	config := llama3.Config{Today: today}
+	if err := formats.Replace("llama_3_1", func() (models.Format, error) { return llama3.New(config) }); err != nil {
+		return failed(err)
+	}
+	if err := formats.Replace("llama_3_2_vision", func() (models.Format, error) { return llama3.NewVision(config) }); err != nil {
+		return failed(err)
+	}
+
+	return formats.Detect(repository.New().GetModel)
+}
+
+// failed returns a constructor that reports err once the format is created
+func failed(err error) export.Constructor {
+	return func() (models.Format, error) {
+		return nil, err
+	}
+}
@@ -0,0 +1,467 @@
+// Package chattemplate renders prompts with a model's own Hugging Face chat_template.
+//
+// Instead of hand-writing the prompt layout of every model, the Jinja template that ships
+// in the model's tokenizer_config.json (or GGUF metadata) is interpreted directly. Messages
+// are converted to the structure transformers passes to apply_chat_template: a list of
+// {role, content, tool_calls} dicts, a tools list in the OpenAI function format,
+// add_generation_prompt, bos_token and eos_token.
+package chattemplate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/chattemplate/jinja"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+var (
+	// Most tool-aware templates (Qwen, Hermes, ...) ask the model to answer with
+	// <tool_call>{"name": ..., "arguments": {...}}</tool_call>
+	toolCallRegex = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+
+	// defaultStopTokens are end-of-turn markers stripped from generated output
+	defaultStopTokens = []string{"<|im_end|>", "<|eot_id|>", "<|eom_id|>", "<|end|>", "<end_of_turn>", "<|endoftext|>", "</s>"}
+)
+
+const (
+	toolCall    = "<tool_call>"
+	toolCallEnd = "</tool_call>"
+
+	// TemplateEnv names the environment variable read by Constructor. It holds the path
+	// of a tokenizer_config.json or of a raw Jinja template file.
+	TemplateEnv = "CHAT_TEMPLATE"
+)
+
+var _ models.Format = (*chatTemplate)(nil)
+var _ format.Format = (*chatTemplate)(nil)
+
+// Config configures a chat template format
+type Config struct {
+	// ChatTemplate is the Jinja source of the template
+	ChatTemplate string
+	// BosToken and EosToken are exposed to the template as bos_token and eos_token
+	BosToken string
+	EosToken string
+	// StopTokens are stripped from generated output in addition to EosToken.
+	// When empty the common end-of-turn markers are used.
+	StopTokens []string
+}
+
+type chatTemplate struct {
+	format.Codec
+	template   *jinja.Template
+	config     Config
+	stopTokens []string
+}
+
+// New parses the chat template in config and returns a format that renders with it
+func New(config Config) (models.Format, error) {
+	if strings.TrimSpace(config.ChatTemplate) == "" {
+		return nil, fmt.Errorf("chat template is empty")
+	}
+
+	template, err := jinja.Parse(config.ChatTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse chat template: %v", err)
+	}
+
+	stopTokens := config.StopTokens
+	if len(stopTokens) == 0 {
+		stopTokens = defaultStopTokens
+	}
+	if config.EosToken != "" {
+		stopTokens = append([]string{config.EosToken}, stopTokens...)
+	}
+
+	m := &chatTemplate{template: template, config: config, stopTokens: stopTokens}
+	// Tool calls are written in the <tool_call> layout decode parses
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.ToolCall(stopTokens),
+		Stops:          stopTokens,
+		Calls:          grammar.Hermes,
+	}
+	return m, nil
+}
+
+// Constructor loads the chat template from the file named by the CHAT_TEMPLATE
+// environment variable
+func Constructor() (models.Format, error) {
+	path := os.Getenv(TemplateEnv)
+	if path == "" {
+		return nil, fmt.Errorf("%s is not set", TemplateEnv)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat template: %v", err)
+	}
+
+	// A tokenizer_config.json carries the template and the special tokens,
+	// anything else is treated as the template source itself
+	if strings.HasSuffix(path, ".json") {
+		config, err := ParseTokenizerConfig(data)
+		if err != nil {
+			return nil, err
+		}
+		return New(config)
+	}
+	return New(Config{ChatTemplate: string(data)})
+}
+
+// ParseTokenizerConfig reads the chat template and special tokens from a Hugging Face
+// tokenizer_config.json. When several named templates are present the "default" one is used.
+func ParseTokenizerConfig(data []byte) (Config, error) {
+	var raw struct {
+		ChatTemplate json.RawMessage `json:"chat_template"`
+		BosToken     json.RawMessage `json:"bos_token"`
+		EosToken     json.RawMessage `json:"eos_token"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Config{}, fmt.Errorf("failed to parse tokenizer config: %v", err)
+	}
+
+	config := Config{
+		BosToken: specialToken(raw.BosToken),
+		EosToken: specialToken(raw.EosToken),
+	}
+
+	// chat_template is either a string or a list of {name, template}
+	if err := json.Unmarshal(raw.ChatTemplate, &config.ChatTemplate); err != nil {
+		var named []struct {
+			Name     string `json:"name"`
+			Template string `json:"template"`
+		}
+		if err := json.Unmarshal(raw.ChatTemplate, &named); err != nil {
+			return Config{}, fmt.Errorf("failed to parse chat_template: %v", err)
+		}
+		for _, t := range named {
+			if t.Name == "default" || config.ChatTemplate == "" {
+				config.ChatTemplate = t.Template
+			}
+		}
+	}
+
+	if config.ChatTemplate == "" {
+		return Config{}, fmt.Errorf("tokenizer config has no chat_template")
+	}
+	return config, nil
+}
+
+// specialToken reads a special token given either as a string or as {"content": ...}
+func specialToken(raw json.RawMessage) string {
+	var token string
+	if err := json.Unmarshal(raw, &token); err == nil {
+		return token
+	}
+	var added struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(raw, &added); err == nil {
+		return added.Content
+	}
+	return ""
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *chatTemplate) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	choice, prefill := opts.ToolChoice, ""
+
+	templateMessages, tools := m.templateInput(messages)
+
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+	if instruction := choice.Instruction(); instruction != "" {
+		templateMessages = withInstruction(templateMessages, instruction)
+	}
+
+	addGenerationPrompt := len(messages) == 0 || messages[len(messages)-1].Role != ai.RoleAssistant
+
+	vars := map[string]interface{}{
+		"messages":              templateMessages,
+		"add_generation_prompt": addGenerationPrompt,
+		"bos_token":             m.config.BosToken,
+		"eos_token":             m.config.EosToken,
+		"tools":                 nil,
+	}
+	if len(tools) > 0 {
+		var toolDicts []jinja.Value
+		for _, tool := range tools {
+			toolDicts = append(toolDicts, toolDict(tool))
+		}
+		vars["tools"] = toolDicts
+	}
+
+	rendered, err := m.template.Render(vars)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render chat template: %v", err)
+	}
+
+	// A call can only be prefilled for templates that teach the <tool_call> layout Decode parses,
+	// other templates rely on the instruction and on the check in Decode
+	if addGenerationPrompt && strings.Contains(rendered, toolCall) {
+		switch choice.Mode {
+		case toolchoice.Required:
+			prefill = toolCall + "\n"
+		case toolchoice.Function:
+			name, _ := json.Marshal(choice.Name)
+			prefill = fmt.Sprintf("%s\n{\"name\": %s, \"arguments\": ", toolCall, name)
+		}
+	}
+	return &format.Prompt{Data: []byte(rendered + prefill), Prefill: prefill, Choice: choice}, nil
+}
+
+// withInstruction appends the tool choice instruction to the first system message, a system
+// message is added when there is none
+func withInstruction(templateMessages []jinja.Value, instruction string) []jinja.Value {
+	for _, value := range templateMessages {
+		dict, ok := value.(*jinja.Dict)
+		if !ok {
+			continue
+		}
+		if role, _ := dict.Get("role"); role != "system" {
+			continue
+		}
+		content, _ := dict.Get("content")
+		if text, _ := content.(string); text != "" {
+			instruction = text + "\n\n" + instruction
+		}
+		dict.Set("content", instruction)
+		return templateMessages
+	}
+
+	system := jinja.NewDict().Set("role", "system").Set("content", instruction)
+	return append([]jinja.Value{system}, templateMessages...)
+}
+
+// templateInput converts messages to the message dicts expected by chat templates and collects
+// the tools
+func (m *chatTemplate) templateInput(messages []ai.Message) ([]jinja.Value, []mcp.Tool) {
+	var templateMessages []jinja.Value
+	var tools []mcp.Tool
+
+	// Ids of tool calls that have not been answered yet, consumed by tool results in order
+	type pendingCall struct {
+		id   string
+		name string
+	}
+	var pending []pendingCall
+
+	// result returns a tool message answering the oldest pending call
+	result := func(text string) *jinja.Dict {
+		dict := jinja.NewDict().
+			Set("role", "tool").
+			Set("content", text)
+		if len(pending) > 0 {
+			dict.Set("tool_call_id", pending[0].id).Set("name", pending[0].name)
+			pending = pending[1:]
+		}
+		return dict
+	}
+
+	for i, msg := range messages {
+		var texts []string
+		var calls []jinja.Value
+
+		// Text of a tool message goes into its results, as toolresult.Message flattens it
+		toolText, results := "", 0
+
+		for _, content := range msg.Content.Slice() {
+			switch content.String() {
+			case "text":
+				if msg.Role == ai.RoleTool {
+					toolText += *content.Text()
+					continue
+				}
+				texts = append(texts, *content.Text())
+			case "tools":
+				tools = append(tools, content.Tools().Slice()...)
+			case "tool-input":
+				call := content.ToolInput()
+				id := toolresult.CallID(i, len(calls), call.Name)
+				pending = append(pending, pendingCall{id: id, name: call.Name})
+				calls = append(calls, jinja.NewDict().
+					Set("id", id).
+					Set("type", "function").
+					Set("function", jinja.NewDict().
+						Set("name", call.Name).
+						Set("arguments", argumentsDict(tools, call))))
+			case "tool-output":
+				// Every tool result is its own message answering the oldest pending call, with
+				// the text written before it
+				templateMessages = append(templateMessages, result(toolText+toolresult.Text(content.ToolOutput(), "")))
+				toolText, results = "", results+1
+			}
+		}
+
+		if msg.Role == ai.RoleTool {
+			// Text after the last result ends it, text alone is a result of its own
+			if toolText != "" && results > 0 {
+				last := templateMessages[len(templateMessages)-1].(*jinja.Dict)
+				content, _ := last.Get("content")
+				last.Set("content", content.(string)+toolText)
+			} else if toolText != "" {
+				templateMessages = append(templateMessages, result(toolText))
+			}
+			continue
+		}
+		if msg.Role == ai.RoleSystem && len(texts) == 0 {
+			continue
+		}
+
+		templateMessage := jinja.NewDict().
+			Set("role", roleName(msg.Role)).
+			Set("content", strings.Join(texts, "\n"))
+		if len(calls) > 0 {
+			templateMessage.Set("tool_calls", calls)
+		}
+		templateMessages = append(templateMessages, templateMessage)
+	}
+
+	return templateMessages, tools
+}
+
+func roleName(role ai.Role) string {
+	switch role {
+	case ai.RoleSystem:
+		return "system"
+	case ai.RoleAssistant:
+		return "assistant"
+	case ai.RoleTool:
+		return "tool"
+	default:
+		return "user"
+	}
+}
+
+// toolDict converts a tool to the OpenAI function format used by chat templates
+func toolDict(tool mcp.Tool) *jinja.Dict {
+	parameters, err := jinja.FromJSON(schema.Parameters(tool))
+	if err != nil {
+		parameters = jinja.NewDict()
+	}
+
+	// Templates commonly iterate the required list, so it is always present
+	if dict, ok := parameters.(*jinja.Dict); ok {
+		if _, ok := dict.Get("required"); !ok {
+			dict.Set("required", []jinja.Value{})
+		}
+	}
+
+	return jinja.NewDict().
+		Set("type", "function").
+		Set("function", jinja.NewDict().
+			Set("name", tool.Name).
+			Set("description", tool.Description).
+			Set("parameters", parameters))
+}
+
+// argumentsDict converts call arguments to a dict holding their JSON values, typed by the input
+// schema of the called tool
+func argumentsDict(tools []mcp.Tool, call *mcp.CallToolParams) *jinja.Dict {
+	value, err := jinja.FromJSON(arguments.EncodeCall(tools, *call))
+	if dict, ok := value.(*jinja.Dict); ok && err == nil {
+		return dict
+	}
+	return jinja.NewDict()
+}
+
+func (m *chatTemplate) decode(data string) (*ai.Message, error) {
+	content := strings.TrimSpace(data)
+
+	complete := false
+	for _, stop := range m.stopTokens {
+		if idx := strings.Index(content, stop); idx != -1 {
+			content = strings.TrimSpace(content[:idx])
+			complete = true
+		}
+	}
+
+	if !strings.Contains(content, toolCall) {
+		return &ai.Message{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text(content)),
+			}),
+			Final: true,
+		}, nil
+	}
+
+	// A tool call block that has not been closed yet is still being generated
+	if strings.Count(content, toolCall) > strings.Count(content, toolCallEnd) {
+		if !complete {
+			return nil, &models.PartialDecodeError{}
+		}
+		return nil, fmt.Errorf("failed to parse tool call, missing %s", toolCallEnd)
+	}
+
+	var contents []ai.MessageContent
+	last := 0
+	for _, match := range toolCallRegex.FindAllStringSubmatchIndex(content, -1) {
+		if text := strings.TrimSpace(content[last:match[0]]); text != "" {
+			contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+		}
+		last = match[1]
+
+		call, err := parseToolCall(content[match[2]:match[3]])
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, ai.NewMessageContent(call))
+	}
+	if text := strings.TrimSpace(content[last:]); text != "" {
+		contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(contents),
+	}, nil
+}
+
+// parseToolCall parses a {"name": ..., "arguments": {...}} tool call
+func parseToolCall(data string) (mcp.CallToolParams, error) {
+	var call struct {
+		Name       string          `json:"name"`
+		Arguments  json.RawMessage `json:"arguments"`
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(data), &call); err != nil {
+		return mcp.CallToolParams{}, fmt.Errorf("failed to parse tool call JSON: %v", err)
+	}
+	if call.Name == "" {
+		return mcp.CallToolParams{}, fmt.Errorf("failed to parse tool call, missing name")
+	}
+
+	params := call.Arguments
+	if len(params) == 0 {
+		params = call.Parameters
+	}
+
+	args, err := arguments.Decode(params)
+	if err != nil {
+		return mcp.CallToolParams{}, err
+	}
+
+	return mcp.CallToolParams{
+		Name:      call.Name,
+		Arguments: cm.ToList(args),
+	}, nil
+}
@@ -0,0 +1,329 @@
+package chattemplate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+// qwen25Template is the chat_template shipped with Qwen2.5-Instruct
+const qwen25Template = `{%- if tools %}
+    {{- '<|im_start|>system\n' }}
+    {%- if messages[0]['role'] == 'system' %}
+        {{- messages[0]['content'] }}
+    {%- else %}
+        {{- 'You are Qwen, created by Alibaba Cloud. You are a helpful assistant.' }}
+    {%- endif %}
+    {{- "\n\n# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>" }}
+    {%- for tool in tools %}
+        {{- "\n" }}
+        {{- tool | tojson }}
+    {%- endfor %}
+    {{- "\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call><|im_end|>\n" }}
+{%- else %}
+    {%- if messages[0]['role'] == 'system' %}
+        {{- '<|im_start|>system\n' + messages[0]['content'] + '<|im_end|>\n' }}
+    {%- else %}
+        {{- '<|im_start|>system\nYou are Qwen, created by Alibaba Cloud. You are a helpful assistant.<|im_end|>\n' }}
+    {%- endif %}
+{%- endif %}
+{%- for message in messages %}
+    {%- if (message.role == "user") or (message.role == "system" and not loop.first) or (message.role == "assistant" and not message.tool_calls) %}
+        {{- '<|im_start|>' + message.role + '\n' + message.content + '<|im_end|>' + '\n' }}
+    {%- elif message.role == "assistant" %}
+        {{- '<|im_start|>' + message.role }}
+        {%- if message.content %}
+            {{- '\n' + message.content }}
+        {%- endif %}
+        {%- for tool_call in message.tool_calls %}
+            {%- if tool_call.function is defined %}
+                {%- set tool_call = tool_call.function %}
+            {%- endif %}
+            {{- '\n<tool_call>\n{"name": "' }}
+            {{- tool_call.name }}
+            {{- '", "arguments": ' }}
+            {{- tool_call.arguments | tojson }}
+            {{- '}\n</tool_call>' }}
+        {%- endfor %}
+        {{- '<|im_end|>\n' }}
+    {%- elif message.role == "tool" %}
+        {%- if (loop.index0 == 0) or (messages[loop.index0 - 1].role != "tool") %}
+            {{- '<|im_start|>user' }}
+        {%- endif %}
+        {{- '\n<tool_response>\n' }}
+        {{- message.content }}
+        {{- '\n</tool_response>' }}
+        {%- if loop.last or (messages[loop.index0 + 1].role != "tool") %}
+            {{- '<|im_end|>\n' }}
+        {%- endif %}
+    {%- endif %}
+{%- endfor %}
+{%- if add_generation_prompt %}
+    {{- '<|im_start|>assistant\n' }}
+{%- endif %}
+`
+
+// mistralTemplate is a reduced Mistral template that rejects conversations which
+// do not alternate between user and assistant
+const mistralTemplate = `{{- bos_token }}
+{%- for message in messages %}
+    {%- if (message['role'] == 'user') != (loop.index0 % 2 == 0) %}
+        {{- raise_exception('Conversation roles must alternate user/assistant/user/assistant/...') }}
+    {%- endif %}
+    {%- if message['role'] == 'user' %}
+        {{- '[INST] ' + message['content'] + ' [/INST]' }}
+    {%- else %}
+        {{- message['content'] + eos_token }}
+    {%- endif %}
+{%- endfor %}
+`
+
+func TestEncode_Qwen25Template(t *testing.T) {
+	format, err := New(Config{ChatTemplate: qwen25Template, EosToken: "<|im_end|>"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("You are a helpful assistant.")),
+				ai.NewMessageContent(cm.ToList([]mcp.Tool{
+					{
+						Name:        "get_weather",
+						Description: "Get the weather for a city",
+						InputSchema: mcp.ToolSchema{
+							SchemaType: "object",
+							Properties: cm.ToList([][2]string{
+								{"city", `{"type": "string"}`},
+							}),
+							Required: cm.ToList([]string{"city"}),
+						},
+					},
+				})),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("What's the weather in Paris?")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "get_weather",
+					Arguments: cm.ToList([][2]string{{"city", "Paris"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny, 22C"}),
+					}),
+				}),
+			}),
+		},
+	}
+
+	encoded, err := format.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	expected := "<|im_start|>system\nYou are a helpful assistant.\n\n# Tools\n\n" +
+		"You may call one or more functions to assist with the user query.\n\n" +
+		"You are provided with function signatures within <tools></tools> XML tags:\n<tools>\n" +
+		`{"type": "function", "function": {"name": "get_weather", "description": "Get the weather for a city", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]}}}` +
+		"\n</tools>\n\n" +
+		"For each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n" +
+		"<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call><|im_end|>\n" +
+		"<|im_start|>user\nWhat's the weather in Paris?<|im_end|>\n" +
+		"<|im_start|>assistant\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call><|im_end|>\n" +
+		"<|im_start|>user\n<tool_response>\nSunny, 22C\n</tool_response><|im_end|>\n" +
+		"<|im_start|>assistant\n"
+
+	if result != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, result)
+	}
+}
+
+func TestEncode_NoGenerationPromptAfterAssistant(t *testing.T) {
+	format, err := New(Config{ChatTemplate: qwen25Template})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	encoded, err := format.Encode(
+		ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})},
+		ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hello!"))})},
+	)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|im_start|>system\nYou are Qwen, created by Alibaba Cloud. You are a helpful assistant.<|im_end|>\n" +
+		"<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\nHello!<|im_end|>\n"
+	if string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, string(encoded))
+	}
+}
+
+func TestEncode_ToolMessageText(t *testing.T) {
+	template := `{%- for message in messages %}{{ message['role'] }}:{{ message['content'] }}{% if message['tool_call_id'] %}@{{ message['name'] }}{% endif %}|{%- endfor %}`
+	format, err := New(Config{ChatTemplate: template})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	call := func(name string) ai.MessageContent {
+		return ai.NewMessageContent(mcp.CallToolParams{Name: name})
+	}
+	output := ai.NewMessageContent(mcp.CallToolResult{
+		Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny"})}),
+	})
+
+	encoded, err := format.Encode(
+		ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{call("get_weather"), call("get_time")})},
+		ai.Message{Role: ai.RoleTool, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Paris: ")), output, ai.NewMessageContent(ai.Text(" (cached)"))})},
+		ai.Message{Role: ai.RoleTool, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("12:00"))})},
+	)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// The text of a tool message is kept with its results, text alone answers the next call
+	expected := "assistant:|tool:Paris: Sunny (cached)@get_weather|tool:12:00@get_time|"
+	if string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, string(encoded))
+	}
+}
+
+func TestEncode_RaiseException(t *testing.T) {
+	format, err := New(Config{ChatTemplate: mistralTemplate, BosToken: "<s>", EosToken: "</s>"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	user := ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})}
+	assistant := ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hello"))})}
+
+	encoded, err := format.Encode(user, assistant, user)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if expected := "<s>[INST] Hi [/INST]Hello</s>[INST] Hi [/INST]"; string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, string(encoded))
+	}
+
+	_, err = format.Encode(user, user)
+	if err == nil || !strings.Contains(err.Error(), "Conversation roles must alternate") {
+		t.Errorf("Expected the template exception, got %v", err)
+	}
+}
+
+func TestParseTokenizerConfig(t *testing.T) {
+	config, err := ParseTokenizerConfig([]byte(`{
+		"bos_token": {"content": "<s>", "lstrip": false},
+		"eos_token": "</s>",
+		"chat_template": [
+			{"name": "tool_use", "template": "tools"},
+			{"name": "default", "template": "{{ bos_token }}"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseTokenizerConfig failed: %v", err)
+	}
+
+	if config.BosToken != "<s>" || config.EosToken != "</s>" || config.ChatTemplate != "{{ bos_token }}" {
+		t.Errorf("Unexpected config: %+v", config)
+	}
+
+	if _, err := ParseTokenizerConfig([]byte(`{"eos_token": "</s>"}`)); err == nil {
+		t.Error("Expected an error for a config without chat_template")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         []byte
+		wantTexts     []string
+		wantToolNames []string
+		wantPartial   bool
+		wantErr       bool
+	}{
+		{
+			name:      "text",
+			input:     []byte("Hello, world!<|im_end|>"),
+			wantTexts: []string{"Hello, world!"},
+		},
+		{
+			name:          "tool calls with text",
+			input:         []byte("Let me check.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_time\", \"arguments\": {}}\n</tool_call><|im_end|>"),
+			wantTexts:     []string{"Let me check."},
+			wantToolNames: []string{"get_weather", "get_time"},
+		},
+		{
+			name:        "incomplete tool call while streaming",
+			input:       []byte("<tool_call>\n{\"name\": \"get_wea"),
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:    "unterminated tool call",
+			input:   []byte("<tool_call>\n{\"name\": \"get_weather\"}<|im_end|>"),
+			wantErr: true,
+		},
+	}
+
+	format, err := New(Config{ChatTemplate: qwen25Template, EosToken: "<|im_end|>"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := format.Decode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var partial *models.PartialDecodeError
+				if errors.As(err, &partial) != tt.wantPartial {
+					t.Errorf("Decode() error = %v, wantPartial %v", err, tt.wantPartial)
+				}
+				return
+			}
+
+			var texts, names []string
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					texts = append(texts, *content.Text())
+				case "tool-input":
+					names = append(names, content.ToolInput().Name)
+				}
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.wantTexts, "|") {
+				t.Errorf("Expected texts %q, got %q", tt.wantTexts, texts)
+			}
+			if strings.Join(names, "|") != strings.Join(tt.wantToolNames, "|") {
+				t.Errorf("Expected tool calls %q, got %q", tt.wantToolNames, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,894 @@
+package jinja
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Global functions
+
+func raiseException(args []Value, _ map[string]Value) (Value, error) {
+	message := "template raised an exception"
+	if len(args) > 0 {
+		message = toString(args[0])
+	}
+	return nil, &Exception{Message: message}
+}
+
+func namespace(args []Value, kwargs map[string]Value) (Value, error) {
+	ns := NewDict()
+	if len(args) > 0 {
+		if d, ok := args[0].(*Dict); ok {
+			for _, key := range d.Keys() {
+				value, _ := d.Get(key)
+				ns.Set(key, value)
+			}
+		}
+	}
+	for _, key := range sortedKeys(kwargs) {
+		ns.Set(key, kwargs[key])
+	}
+	return ns, nil
+}
+
+func dictFunc(args []Value, kwargs map[string]Value) (Value, error) {
+	return namespace(args, kwargs)
+}
+
+func rangeFunc(args []Value, _ map[string]Value) (Value, error) {
+	bounds := make([]int64, len(args))
+	for i, arg := range args {
+		n, ok := toInt(arg)
+		if !ok {
+			return nil, fmt.Errorf("range() arguments must be integers")
+		}
+		bounds[i] = n
+	}
+
+	var start, stop, step int64 = 0, 0, 1
+	switch len(bounds) {
+	case 1:
+		stop = bounds[0]
+	case 2:
+		start, stop = bounds[0], bounds[1]
+	case 3:
+		start, stop, step = bounds[0], bounds[1], bounds[2]
+	default:
+		return nil, fmt.Errorf("range() takes 1 to 3 arguments")
+	}
+	if step == 0 {
+		return nil, fmt.Errorf("range() step cannot be zero")
+	}
+
+	var items []Value
+	for i := start; step > 0 && i < stop || step < 0 && i > stop; i += step {
+		items = append(items, i)
+	}
+	return items, nil
+}
+
+// strftimeNow formats the current local time, as transformers' strftime_now does
+func strftimeNow(args []Value, _ map[string]Value) (Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("strftime_now() requires a format")
+	}
+	return strftime(time.Now(), toString(args[0])), nil
+}
+
+// strftime formats t using the common C strftime directives
+func strftime(t time.Time, format string) string {
+	builder := &strings.Builder{}
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			builder.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'd':
+			fmt.Fprintf(builder, "%02d", t.Day())
+		case '-':
+			// %-d and friends drop the padding
+			if i+1 < len(format) {
+				i++
+				switch format[i] {
+				case 'd':
+					fmt.Fprintf(builder, "%d", t.Day())
+				case 'm':
+					fmt.Fprintf(builder, "%d", int(t.Month()))
+				case 'H':
+					fmt.Fprintf(builder, "%d", t.Hour())
+				default:
+					builder.WriteString("%-" + string(format[i]))
+				}
+			}
+		case 'm':
+			fmt.Fprintf(builder, "%02d", int(t.Month()))
+		case 'Y':
+			fmt.Fprintf(builder, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(builder, "%02d", t.Year()%100)
+		case 'b':
+			builder.WriteString(t.Format("Jan"))
+		case 'B':
+			builder.WriteString(t.Format("January"))
+		case 'a':
+			builder.WriteString(t.Format("Mon"))
+		case 'A':
+			builder.WriteString(t.Format("Monday"))
+		case 'H':
+			fmt.Fprintf(builder, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(builder, "%02d", (t.Hour()+11)%12+1)
+		case 'p':
+			builder.WriteString(t.Format("PM"))
+		case 'M':
+			fmt.Fprintf(builder, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(builder, "%02d", t.Second())
+		case 'j':
+			fmt.Fprintf(builder, "%03d", t.YearDay())
+		case 'Z':
+			builder.WriteString(t.Format("MST"))
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(format[i])
+		}
+	}
+	return builder.String()
+}
+
+// Attribute and item access
+
+// getAttr resolves a.b: dict keys first, then methods of the value's type
+func getAttr(target Value, name string) Value {
+	switch t := target.(type) {
+	case *Dict:
+		if value, ok := t.Get(name); ok {
+			return value
+		}
+		if method := dictMethod(t, name); method != nil {
+			return method
+		}
+	case string:
+		if method := stringMethod(t, name); method != nil {
+			return method
+		}
+	case []Value:
+		var index int64
+		if _, err := fmt.Sscan(name, &index); err == nil {
+			return getItem(t, index)
+		}
+	}
+	return Undefined{Name: name}
+}
+
+// getItem resolves a[b] for dicts, lists and strings
+func getItem(target, index Value) Value {
+	switch t := target.(type) {
+	case *Dict:
+		if key, ok := index.(string); ok {
+			if value, ok := t.Get(key); ok {
+				return value
+			}
+			return Undefined{Name: key}
+		}
+	case []Value:
+		if i, ok := toInt(index); ok {
+			if i < 0 {
+				i += int64(len(t))
+			}
+			if i >= 0 && i < int64(len(t)) {
+				return t[i]
+			}
+		}
+	case string:
+		if i, ok := toInt(index); ok {
+			runes := []rune(t)
+			if i < 0 {
+				i += int64(len(runes))
+			}
+			if i >= 0 && i < int64(len(runes)) {
+				return string(runes[i])
+			}
+		}
+	}
+	return Undefined{Name: toString(index)}
+}
+
+func dictMethod(d *Dict, name string) Value {
+	switch name {
+	case "items":
+		return Func(func(_ []Value, _ map[string]Value) (Value, error) {
+			return dictItems(d), nil
+		})
+	case "keys":
+		return Func(func(_ []Value, _ map[string]Value) (Value, error) {
+			return iterate(d)
+		})
+	case "values":
+		return Func(func(_ []Value, _ map[string]Value) (Value, error) {
+			values := make([]Value, 0, d.Len())
+			for _, key := range d.Keys() {
+				value, _ := d.Get(key)
+				values = append(values, value)
+			}
+			return values, nil
+		})
+	case "get":
+		return Func(func(args []Value, _ map[string]Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("get() requires a key")
+			}
+			if value, ok := d.Get(toString(args[0])); ok {
+				return value, nil
+			}
+			if len(args) > 1 {
+				return args[1], nil
+			}
+			return nil, nil
+		})
+	}
+	return nil
+}
+
+func dictItems(d *Dict) []Value {
+	items := make([]Value, 0, d.Len())
+	for _, key := range d.Keys() {
+		value, _ := d.Get(key)
+		items = append(items, []Value{key, value})
+	}
+	return items
+}
+
+func stringMethod(s, name string) Value {
+	// stripArg returns the optional characters argument of the strip methods
+	stripArg := func(args []Value) string {
+		if len(args) > 0 && args[0] != nil {
+			return toString(args[0])
+		}
+		return " \t\n\r\v\f"
+	}
+
+	var fn func(args []Value) (Value, error)
+	switch name {
+	case "strip":
+		fn = func(args []Value) (Value, error) { return strings.Trim(s, stripArg(args)), nil }
+	case "lstrip":
+		fn = func(args []Value) (Value, error) { return strings.TrimLeft(s, stripArg(args)), nil }
+	case "rstrip":
+		fn = func(args []Value) (Value, error) { return strings.TrimRight(s, stripArg(args)), nil }
+	case "upper":
+		fn = func(args []Value) (Value, error) { return strings.ToUpper(s), nil }
+	case "lower":
+		fn = func(args []Value) (Value, error) { return strings.ToLower(s), nil }
+	case "title":
+		fn = func(args []Value) (Value, error) { return title(s), nil }
+	case "capitalize":
+		fn = func(args []Value) (Value, error) { return capitalize(s), nil }
+	case "startswith", "endswith":
+		fn = func(args []Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("%s() requires an argument", name)
+			}
+			prefixes := []Value{args[0]}
+			if list, ok := args[0].([]Value); ok {
+				prefixes = list
+			}
+			for _, prefix := range prefixes {
+				if name == "startswith" && strings.HasPrefix(s, toString(prefix)) ||
+					name == "endswith" && strings.HasSuffix(s, toString(prefix)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+	case "split":
+		fn = func(args []Value) (Value, error) {
+			limit := -1
+			if len(args) > 1 {
+				if n, ok := toInt(args[1]); ok && n >= 0 {
+					limit = int(n) + 1
+				}
+			}
+			var parts []string
+			if len(args) == 0 || args[0] == nil {
+				parts = strings.Fields(s)
+			} else {
+				parts = strings.SplitN(s, toString(args[0]), limit)
+			}
+			result := make([]Value, len(parts))
+			for i, part := range parts {
+				result[i] = part
+			}
+			return result, nil
+		}
+	case "splitlines":
+		fn = func(args []Value) (Value, error) {
+			var result []Value
+			for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+				result = append(result, strings.TrimSuffix(line, "\r"))
+			}
+			if s == "" {
+				return []Value{}, nil
+			}
+			return result, nil
+		}
+	case "replace":
+		fn = func(args []Value) (Value, error) {
+			if len(args) < 2 {
+				return nil, fmt.Errorf("replace() requires two arguments")
+			}
+			count := -1
+			if len(args) > 2 {
+				if n, ok := toInt(args[2]); ok {
+					count = int(n)
+				}
+			}
+			return strings.Replace(s, toString(args[0]), toString(args[1]), count), nil
+		}
+	case "find":
+		fn = func(args []Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("find() requires an argument")
+			}
+			i := strings.Index(s, toString(args[0]))
+			if i < 0 {
+				return int64(-1), nil
+			}
+			return int64(len([]rune(s[:i]))), nil
+		}
+	case "count":
+		fn = func(args []Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("count() requires an argument")
+			}
+			return int64(strings.Count(s, toString(args[0]))), nil
+		}
+	case "join":
+		fn = func(args []Value) (Value, error) {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("join() requires an argument")
+			}
+			return join(args[0], s, "")
+		}
+	default:
+		return nil
+	}
+
+	return Func(func(args []Value, _ map[string]Value) (Value, error) {
+		return fn(args)
+	})
+}
+
+// Filters
+
+// applyFilter applies a named filter to a value
+func applyFilter(name string, value Value, args []Value, kwargs map[string]Value) (Value, error) {
+	arg := func(i int, key string) (Value, bool) {
+		if i < len(args) {
+			return args[i], true
+		}
+		v, ok := kwargs[key]
+		return v, ok
+	}
+
+	switch name {
+	case "trim":
+		chars := " \t\n\r\v\f"
+		if c, ok := arg(0, "chars"); ok && c != nil {
+			chars = toString(c)
+		}
+		return strings.Trim(toString(value), chars), nil
+
+	case "tojson":
+		indent := ""
+		if n, ok := arg(0, "indent"); ok && n != nil {
+			if i, ok := toInt(n); ok {
+				indent = strings.Repeat(" ", int(i))
+			} else {
+				indent = toString(n)
+			}
+		}
+		sortKeys := false
+		if s, ok := kwargs["sort_keys"]; ok {
+			sortKeys = truthy(s)
+		}
+		return toJSON(value, indent, sortKeys)
+
+	case "length", "count":
+		n, err := length(value)
+		return int64(n), err
+
+	case "string":
+		return toString(value), nil
+
+	case "safe", "e", "escape", "forceescape":
+		// Chat templates are rendered without autoescaping
+		return value, nil
+
+	case "upper":
+		return strings.ToUpper(toString(value)), nil
+
+	case "lower":
+		return strings.ToLower(toString(value)), nil
+
+	case "title":
+		return title(toString(value)), nil
+
+	case "capitalize":
+		return capitalize(toString(value)), nil
+
+	case "replace":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("replace filter requires two arguments")
+		}
+		count := -1
+		if c, ok := arg(2, "count"); ok {
+			if n, ok := toInt(c); ok {
+				count = int(n)
+			}
+		}
+		return strings.Replace(toString(value), toString(args[0]), toString(args[1]), count), nil
+
+	case "default", "d":
+		fallback, _ := arg(0, "default_value")
+		if fallback == nil {
+			fallback = ""
+		}
+		boolean := false
+		if b, ok := arg(1, "boolean"); ok {
+			boolean = truthy(b)
+		}
+		if isUndefined(value) || boolean && !truthy(value) {
+			return fallback, nil
+		}
+		return value, nil
+
+	case "first", "last":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return Undefined{Name: name}, nil
+		}
+		if name == "first" {
+			return items[0], nil
+		}
+		return items[len(items)-1], nil
+
+	case "join":
+		sep := ""
+		if s, ok := arg(0, "d"); ok {
+			sep = toString(s)
+		}
+		attribute := ""
+		if a, ok := arg(1, "attribute"); ok {
+			attribute = toString(a)
+		}
+		return join(value, sep, attribute)
+
+	case "items":
+		switch v := value.(type) {
+		case *Dict:
+			return dictItems(v), nil
+		case nil, Undefined:
+			return []Value{}, nil
+		}
+		return nil, fmt.Errorf("items filter requires a mapping, got %s", typeName(value))
+
+	case "list":
+		return iterate(value)
+
+	case "reverse":
+		if s, ok := value.(string); ok {
+			runes := []rune(s)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return string(runes), nil
+		}
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		reversed := make([]Value, len(items))
+		for i, item := range items {
+			reversed[len(items)-1-i] = item
+		}
+		return reversed, nil
+
+	case "sort":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		sorted := append([]Value{}, items...)
+		attribute := ""
+		if a, ok := kwargs["attribute"]; ok {
+			attribute = toString(a)
+		}
+		reverse := false
+		if r, ok := arg(0, "reverse"); ok {
+			reverse = truthy(r)
+		}
+		var sortErr error
+		sort.SliceStable(sorted, func(i, j int) bool {
+			a, b := sorted[i], sorted[j]
+			if attribute != "" {
+				a, b = getAttr(a, attribute), getAttr(b, attribute)
+			}
+			c, err := compare(a, b)
+			if err != nil {
+				sortErr = err
+			}
+			if reverse {
+				return c > 0
+			}
+			return c < 0
+		})
+		return sorted, sortErr
+
+	case "dictsort":
+		d, ok := value.(*Dict)
+		if !ok {
+			return nil, fmt.Errorf("dictsort filter requires a mapping, got %s", typeName(value))
+		}
+		keys := append([]string{}, d.Keys()...)
+		sort.Strings(keys)
+		items := make([]Value, len(keys))
+		for i, key := range keys {
+			v, _ := d.Get(key)
+			items[i] = []Value{key, v}
+		}
+		return items, nil
+
+	case "unique":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		var unique []Value
+		for _, item := range items {
+			found, _ := contains(unique, item)
+			if !found {
+				unique = append(unique, item)
+			}
+		}
+		return unique, nil
+
+	case "selectattr", "rejectattr":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s filter requires an attribute", name)
+		}
+		return selectItems(value, args[1:], name == "rejectattr", func(item Value) Value {
+			return getAttr(item, toString(args[0]))
+		})
+
+	case "select", "reject":
+		return selectItems(value, args, name == "reject", func(item Value) Value {
+			return item
+		})
+
+	case "map":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]Value, len(items))
+		for i, item := range items {
+			if attribute, ok := kwargs["attribute"]; ok {
+				result[i] = getAttr(item, toString(attribute))
+				if d, ok := kwargs["default"]; ok && isUndefined(result[i]) {
+					result[i] = d
+				}
+				continue
+			}
+			if len(args) == 0 {
+				return nil, fmt.Errorf("map filter requires a filter name or attribute")
+			}
+			if result[i], err = applyFilter(toString(args[0]), item, args[1:], nil); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+
+	case "attr":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("attr filter requires a name")
+		}
+		return getAttr(value, toString(args[0])), nil
+
+	case "int":
+		switch v := value.(type) {
+		case string:
+			var n int64
+			if _, err := fmt.Sscan(strings.TrimSpace(v), &n); err == nil {
+				return n, nil
+			}
+			var f float64
+			if _, err := fmt.Sscan(strings.TrimSpace(v), &f); err == nil {
+				return int64(f), nil
+			}
+			return int64(0), nil
+		default:
+			n, _ := toInt(v)
+			return n, nil
+		}
+
+	case "float":
+		if s, ok := value.(string); ok {
+			var f float64
+			fmt.Sscan(strings.TrimSpace(s), &f)
+			return f, nil
+		}
+		return toFloat(value), nil
+
+	case "abs":
+		switch v := value.(type) {
+		case int64:
+			if v < 0 {
+				return -v, nil
+			}
+			return v, nil
+		case float64:
+			return math.Abs(v), nil
+		}
+		return nil, fmt.Errorf("abs filter requires a number, got %s", typeName(value))
+
+	case "round":
+		precision := int64(0)
+		if p, ok := arg(0, "precision"); ok {
+			precision, _ = toInt(p)
+		}
+		scale := math.Pow(10, float64(precision))
+		return math.Round(toFloat(value)*scale) / scale, nil
+
+	case "sum":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		var total Value = int64(0)
+		for _, item := range items {
+			if total, err = arithmetic("+", total, item); err != nil {
+				return nil, err
+			}
+		}
+		return total, nil
+
+	case "min", "max":
+		items, err := iterate(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return Undefined{Name: name}, nil
+		}
+		best := items[0]
+		for _, item := range items[1:] {
+			c, err := compare(item, best)
+			if err != nil {
+				return nil, err
+			}
+			if name == "min" && c < 0 || name == "max" && c > 0 {
+				best = item
+			}
+		}
+		return best, nil
+
+	case "indent":
+		width := "    "
+		if w, ok := arg(0, "width"); ok {
+			if n, ok := toInt(w); ok {
+				width = strings.Repeat(" ", int(n))
+			} else {
+				width = toString(w)
+			}
+		}
+		first := false
+		if f, ok := arg(1, "first"); ok {
+			first = truthy(f)
+		}
+		blank := false
+		if b, ok := arg(2, "blank"); ok {
+			blank = truthy(b)
+		}
+		lines := strings.Split(toString(value), "\n")
+		for i, line := range lines {
+			if i == 0 && !first || line == "" && !blank {
+				continue
+			}
+			lines[i] = width + line
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "wordcount":
+		return int64(len(strings.Fields(toString(value)))), nil
+	}
+
+	return nil, fmt.Errorf("unknown filter '%s'", name)
+}
+
+// selectItems keeps (or rejects) the items for which the test on the selected value passes.
+// Without a test name the value's truthiness is used.
+func selectItems(value Value, args []Value, reject bool, selector func(Value) Value) (Value, error) {
+	items, err := iterate(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Value{}
+	for _, item := range items {
+		selected := selector(item)
+
+		pass := truthy(selected)
+		if len(args) > 0 {
+			if pass, err = applyTest(toString(args[0]), selected, args[1:]); err != nil {
+				return nil, err
+			}
+		}
+		if pass != reject {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// join joins the items of a list, optionally selecting an attribute of each item
+func join(value Value, sep, attribute string) (Value, error) {
+	items, err := iterate(value)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		if attribute != "" {
+			item = getAttr(item, attribute)
+		}
+		parts[i] = toString(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// Tests
+
+// applyTest applies a named test, e.g. "x is defined"
+func applyTest(name string, value Value, args []Value) (bool, error) {
+	switch name {
+	case "defined":
+		return !isUndefined(value), nil
+	case "undefined":
+		return isUndefined(value), nil
+	case "none":
+		return value == nil, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	case "number":
+		switch value.(type) {
+		case int64, float64:
+			return true, nil
+		}
+		return false, nil
+	case "integer":
+		_, ok := value.(int64)
+		return ok, nil
+	case "float":
+		_, ok := value.(float64)
+		return ok, nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok, nil
+	case "true":
+		b, ok := value.(bool)
+		return ok && b, nil
+	case "false":
+		b, ok := value.(bool)
+		return ok && !b, nil
+	case "mapping":
+		_, ok := value.(*Dict)
+		return ok, nil
+	case "sequence", "iterable":
+		switch value.(type) {
+		case string, []Value, *Dict:
+			return true, nil
+		}
+		return false, nil
+	case "callable":
+		_, ok := value.(Func)
+		return ok, nil
+	case "lower":
+		s, ok := value.(string)
+		return ok && s == strings.ToLower(s), nil
+	case "upper":
+		s, ok := value.(string)
+		return ok && s == strings.ToUpper(s), nil
+	case "even", "odd":
+		n, ok := value.(int64)
+		if !ok {
+			return false, fmt.Errorf("%s test requires an integer, got %s", name, typeName(value))
+		}
+		return (n%2 == 0) == (name == "even"), nil
+	}
+
+	if len(args) == 0 {
+		return false, fmt.Errorf("unknown test '%s'", name)
+	}
+
+	switch name {
+	case "divisibleby":
+		n, ok1 := toInt(value)
+		d, ok2 := toInt(args[0])
+		if !ok1 || !ok2 || d == 0 {
+			return false, fmt.Errorf("divisibleby test requires non-zero integers")
+		}
+		return n%d == 0, nil
+	case "eq", "equalto", "==", "sameas":
+		return equal(value, args[0]), nil
+	case "ne", "!=":
+		return !equal(value, args[0]), nil
+	case "in":
+		return contains(args[0], value)
+	case "lt", "gt", "le", "ge", "<", ">", "<=", ">=", "lessthan", "greaterthan":
+		c, err := compare(value, args[0])
+		if err != nil {
+			return false, err
+		}
+		switch name {
+		case "lt", "<", "lessthan":
+			return c < 0, nil
+		case "gt", ">", "greaterthan":
+			return c > 0, nil
+		case "le", "<=":
+			return c <= 0, nil
+		}
+		return c >= 0, nil
+	}
+
+	return false, fmt.Errorf("unknown test '%s'", name)
+}
+
+// String helpers
+
+func title(s string) string {
+	runes := []rune(s)
+	upper := true
+	for i, r := range runes {
+		if unicode.IsLetter(r) {
+			if upper {
+				runes[i] = unicode.ToUpper(r)
+			} else {
+				runes[i] = unicode.ToLower(r)
+			}
+			upper = false
+		} else {
+			upper = true
+		}
+	}
+	return string(runes)
+}
+
+func capitalize(s string) string {
+	runes := []rune(strings.ToLower(s))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
+	}
+	return string(runes)
+}
+
+func sortedKeys(m map[string]Value) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
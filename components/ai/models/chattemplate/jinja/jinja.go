@@ -0,0 +1,769 @@
+// Package jinja implements the subset of Jinja2 used by Hugging Face chat templates.
+//
+// Templates are rendered with trim_blocks and lstrip_blocks enabled, matching how
+// transformers renders chat_template. Supported statements are if/elif/else, for (with
+// loop variables, filtering and else), set (including namespace attributes and block
+// assignments), macro, break and continue. Expressions support literals, arithmetic,
+// comparisons, membership, inline conditionals, attribute and item access, slicing,
+// filters, tests and the common string, list and dict methods.
+package jinja
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Template is a parsed template
+type Template struct {
+	body []node
+}
+
+// Parse parses a template source
+func Parse(source string) (*Template, error) {
+	segments, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	body, err := parseTemplate(segments)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{body: body}, nil
+}
+
+// Exception is the error returned when a template calls raise_exception
+type Exception struct {
+	Message string
+}
+
+func (e *Exception) Error() string {
+	return e.Message
+}
+
+// Render renders the template with the given variables. Values in vars are converted with
+// FromGo, so decoded JSON can be passed as-is.
+func (t *Template) Render(vars map[string]interface{}) (string, error) {
+	globals := map[string]Value{
+		"raise_exception": Func(raiseException),
+		"namespace":       Func(namespace),
+		"range":           Func(rangeFunc),
+		"dict":            Func(dictFunc),
+		"strftime_now":    Func(strftimeNow),
+	}
+	for name, value := range vars {
+		globals[name] = FromGo(value)
+	}
+
+	r := &renderer{scope: &scope{vars: globals}}
+	builder := &strings.Builder{}
+	if _, err := r.execute(builder, t.body); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// scope is a chain of variable frames; loops and macros push a new frame
+type scope struct {
+	vars   map[string]Value
+	parent *scope
+}
+
+func (s *scope) lookup(name string) (Value, bool) {
+	for current := s; current != nil; current = current.parent {
+		if value, ok := current.vars[name]; ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// control signals a break or continue out of a loop body
+type control int
+
+const (
+	controlNone control = iota
+	controlBreak
+	controlContinue
+)
+
+type renderer struct {
+	scope *scope
+}
+
+func (r *renderer) push() {
+	r.scope = &scope{vars: map[string]Value{}, parent: r.scope}
+}
+
+func (r *renderer) pop() {
+	r.scope = r.scope.parent
+}
+
+// execute renders a list of nodes
+func (r *renderer) execute(out *strings.Builder, body []node) (control, error) {
+	for _, n := range body {
+		ctrl, err := r.executeNode(out, n)
+		if err != nil || ctrl != controlNone {
+			return ctrl, err
+		}
+	}
+	return controlNone, nil
+}
+
+func (r *renderer) executeNode(out *strings.Builder, n node) (control, error) {
+	switch n := n.(type) {
+	case *textNode:
+		out.WriteString(n.text)
+
+	case *outputNode:
+		value, err := r.eval(n.expr)
+		if err != nil {
+			return controlNone, err
+		}
+		out.WriteString(toString(value))
+
+	case *ifNode:
+		for i, condition := range n.conditions {
+			value, err := r.eval(condition)
+			if err != nil {
+				return controlNone, err
+			}
+			if truthy(value) {
+				return r.execute(out, n.bodies[i])
+			}
+		}
+		return r.execute(out, n.elseBody)
+
+	case *forNode:
+		return controlNone, r.executeFor(out, n)
+
+	case *setNode:
+		return controlNone, r.executeSet(n)
+
+	case *macroNode:
+		r.scope.vars[n.name] = r.macro(n)
+
+	case *groupNode:
+		return r.execute(out, n.body)
+
+	case *breakNode:
+		return controlBreak, nil
+
+	case *continueNode:
+		return controlContinue, nil
+	}
+	return controlNone, nil
+}
+
+func (r *renderer) executeFor(out *strings.Builder, n *forNode) error {
+	iterable, err := r.eval(n.iterable)
+	if err != nil {
+		return err
+	}
+	items, err := iterate(iterable)
+	if err != nil {
+		return err
+	}
+
+	r.push()
+	defer r.pop()
+
+	// Apply the loop filter first so loop.index and loop.last ignore skipped items
+	if n.filter != nil {
+		var filtered []Value
+		for _, item := range items {
+			if err := r.assignTargets(n.targets, item); err != nil {
+				return err
+			}
+			keep, err := r.eval(n.filter)
+			if err != nil {
+				return err
+			}
+			if truthy(keep) {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	if len(items) == 0 {
+		_, err := r.execute(out, n.elseBody)
+		return err
+	}
+
+	for i, item := range items {
+		if err := r.assignTargets(n.targets, item); err != nil {
+			return err
+		}
+		r.scope.vars["loop"] = loopInfo(items, i)
+
+		ctrl, err := r.execute(out, n.body)
+		if err != nil {
+			return err
+		}
+		if ctrl == controlBreak {
+			break
+		}
+	}
+	return nil
+}
+
+// loopInfo builds the loop variable for iteration i
+func loopInfo(items []Value, i int) *Dict {
+	loop := NewDict().
+		Set("index", int64(i+1)).
+		Set("index0", int64(i)).
+		Set("revindex", int64(len(items)-i)).
+		Set("revindex0", int64(len(items)-i-1)).
+		Set("first", i == 0).
+		Set("last", i == len(items)-1).
+		Set("length", int64(len(items)))
+
+	if i > 0 {
+		loop.Set("previtem", items[i-1])
+	} else {
+		loop.Set("previtem", Undefined{Name: "previtem"})
+	}
+	if i < len(items)-1 {
+		loop.Set("nextitem", items[i+1])
+	} else {
+		loop.Set("nextitem", Undefined{Name: "nextitem"})
+	}
+
+	loop.Set("cycle", Func(func(args []Value, _ map[string]Value) (Value, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("loop.cycle requires at least one argument")
+		}
+		return args[i%len(args)], nil
+	}))
+	return loop
+}
+
+// assignTargets binds a loop item to one or more (unpacked) loop variables
+func (r *renderer) assignTargets(targets []string, item Value) error {
+	if len(targets) == 1 {
+		r.scope.vars[targets[0]] = item
+		return nil
+	}
+
+	values, err := iterate(item)
+	if err != nil {
+		return err
+	}
+	if len(values) != len(targets) {
+		return fmt.Errorf("cannot unpack %d values into %d variables", len(values), len(targets))
+	}
+	for i, target := range targets {
+		r.scope.vars[target] = values[i]
+	}
+	return nil
+}
+
+func (r *renderer) executeSet(n *setNode) error {
+	var value Value
+	if n.body != nil {
+		builder := &strings.Builder{}
+		if _, err := r.execute(builder, n.body); err != nil {
+			return err
+		}
+		value = builder.String()
+	} else {
+		var err error
+		if value, err = r.eval(n.value); err != nil {
+			return err
+		}
+	}
+
+	if n.attribute != "" {
+		target, ok := r.scope.lookup(n.targets[0])
+		if !ok {
+			return fmt.Errorf("'%s' is undefined", n.targets[0])
+		}
+		ns, ok := target.(*Dict)
+		if !ok {
+			return fmt.Errorf("cannot assign attribute on %s", typeName(target))
+		}
+		ns.Set(n.attribute, value)
+		return nil
+	}
+
+	return r.assignTargets(n.targets, value)
+}
+
+// macro turns a macro definition into a callable value
+func (r *renderer) macro(n *macroNode) Func {
+	return func(args []Value, kwargs map[string]Value) (Value, error) {
+		if len(args) > len(n.params) {
+			return nil, fmt.Errorf("macro '%s' takes %d arguments, got %d", n.name, len(n.params), len(args))
+		}
+
+		r.push()
+		defer r.pop()
+
+		for i, param := range n.params {
+			switch value, ok := kwargs[param]; {
+			case i < len(args):
+				r.scope.vars[param] = args[i]
+			case ok:
+				r.scope.vars[param] = value
+			case n.defaults[param] != nil:
+				value, err := r.eval(n.defaults[param])
+				if err != nil {
+					return nil, err
+				}
+				r.scope.vars[param] = value
+			default:
+				r.scope.vars[param] = Undefined{Name: param}
+			}
+		}
+
+		builder := &strings.Builder{}
+		if _, err := r.execute(builder, n.body); err != nil {
+			return nil, err
+		}
+		return builder.String(), nil
+	}
+}
+
+// eval evaluates an expression
+func (r *renderer) eval(e expr) (Value, error) {
+	switch e := e.(type) {
+	case *literalExpr:
+		return e.value, nil
+
+	case *nameExpr:
+		if value, ok := r.scope.lookup(e.name); ok {
+			return value, nil
+		}
+		return Undefined{Name: e.name}, nil
+
+	case *listExpr:
+		items := make([]Value, len(e.items))
+		for i, item := range e.items {
+			value, err := r.eval(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = value
+		}
+		return items, nil
+
+	case *dictExpr:
+		d := NewDict()
+		for i := range e.keys {
+			key, err := r.eval(e.keys[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.eval(e.values[i])
+			if err != nil {
+				return nil, err
+			}
+			d.Set(toString(key), value)
+		}
+		return d, nil
+
+	case *attrExpr:
+		target, err := r.eval(e.target)
+		if err != nil {
+			return nil, err
+		}
+		return getAttr(target, e.name), nil
+
+	case *itemExpr:
+		target, err := r.eval(e.target)
+		if err != nil {
+			return nil, err
+		}
+		index, err := r.eval(e.index)
+		if err != nil {
+			return nil, err
+		}
+		return getItem(target, index), nil
+
+	case *sliceExpr:
+		return r.evalSlice(e)
+
+	case *callExpr:
+		target, err := r.eval(e.target)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := target.(Func)
+		if !ok {
+			return nil, fmt.Errorf("%s is not callable", describe(e.target, target))
+		}
+		args, kwargs, err := r.evalArguments(e.args, e.kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return fn(args, kwargs)
+
+	case *filterExpr:
+		target, err := r.eval(e.target)
+		if err != nil {
+			return nil, err
+		}
+		args, kwargs, err := r.evalArguments(e.args, e.kwargs)
+		if err != nil {
+			return nil, err
+		}
+		return applyFilter(e.name, target, args, kwargs)
+
+	case *testExpr:
+		target, err := r.eval(e.target)
+		if err != nil {
+			return nil, err
+		}
+		args, _, err := r.evalArguments(e.args, nil)
+		if err != nil {
+			return nil, err
+		}
+		result, err := applyTest(e.name, target, args)
+		if err != nil {
+			return nil, err
+		}
+		return result != e.negate, nil
+
+	case *unaryExpr:
+		operand, err := r.eval(e.operand)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "not":
+			return !truthy(operand), nil
+		case "-":
+			switch v := operand.(type) {
+			case int64:
+				return -v, nil
+			case float64:
+				return -v, nil
+			}
+			return nil, fmt.Errorf("bad operand type for unary -: %s", typeName(operand))
+		}
+		return operand, nil
+
+	case *binaryExpr:
+		return r.evalBinary(e)
+
+	case *condExpr:
+		condition, err := r.eval(e.condition)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(condition) {
+			return r.eval(e.then)
+		}
+		if e.else_ == nil {
+			return Undefined{}, nil
+		}
+		return r.eval(e.else_)
+	}
+
+	return nil, fmt.Errorf("unknown expression %T", e)
+}
+
+func (r *renderer) evalArguments(argExprs []expr, kwargExprs []kwarg) ([]Value, map[string]Value, error) {
+	args := make([]Value, len(argExprs))
+	for i, arg := range argExprs {
+		value, err := r.eval(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = value
+	}
+
+	kwargs := map[string]Value{}
+	for _, kw := range kwargExprs {
+		value, err := r.eval(kw.value)
+		if err != nil {
+			return nil, nil, err
+		}
+		kwargs[kw.name] = value
+	}
+	return args, kwargs, nil
+}
+
+func (r *renderer) evalBinary(e *binaryExpr) (Value, error) {
+	left, err := r.eval(e.left)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short circuit the boolean operators, returning the deciding operand as Python does
+	switch e.op {
+	case "and":
+		if !truthy(left) {
+			return left, nil
+		}
+		return r.eval(e.right)
+	case "or":
+		if truthy(left) {
+			return left, nil
+		}
+		return r.eval(e.right)
+	}
+
+	right, err := r.eval(e.right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "<", ">", "<=", ">=":
+		c, err := compare(left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch e.op {
+		case "<":
+			return c < 0, nil
+		case ">":
+			return c > 0, nil
+		case "<=":
+			return c <= 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		return contains(right, left)
+	case "not in":
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, err
+		}
+		return !found, nil
+	case "~":
+		return toString(left) + toString(right), nil
+	}
+
+	return arithmetic(e.op, left, right)
+}
+
+func (r *renderer) evalSlice(e *sliceExpr) (Value, error) {
+	target, err := r.eval(e.target)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := make([]*int64, 3)
+	for i, part := range []expr{e.start, e.stop, e.step} {
+		if part == nil {
+			continue
+		}
+		value, err := r.eval(part)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		n, ok := toInt(value)
+		if !ok {
+			return nil, fmt.Errorf("slice indices must be integers")
+		}
+		bounds[i] = &n
+	}
+
+	switch v := target.(type) {
+	case []Value:
+		indices, err := sliceIndices(len(v), bounds)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]Value, 0, len(indices))
+		for _, i := range indices {
+			result = append(result, v[i])
+		}
+		return result, nil
+	case string:
+		runes := []rune(v)
+		indices, err := sliceIndices(len(runes), bounds)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]rune, 0, len(indices))
+		for _, i := range indices {
+			result = append(result, runes[i])
+		}
+		return string(result), nil
+	case Undefined:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s cannot be sliced", typeName(target))
+}
+
+// sliceIndices resolves Python slice bounds into the selected indices
+func sliceIndices(n int, bounds []*int64) ([]int, error) {
+	step := 1
+	if bounds[2] != nil {
+		step = int(*bounds[2])
+		if step == 0 {
+			return nil, fmt.Errorf("slice step cannot be zero")
+		}
+	}
+
+	clamp := func(bound *int64, def int) int {
+		if bound == nil {
+			return def
+		}
+		i := int(*bound)
+		if i < 0 {
+			i += n
+		}
+		if step > 0 {
+			return max(0, min(i, n))
+		}
+		return max(-1, min(i, n-1))
+	}
+
+	var indices []int
+	if step > 0 {
+		for i := clamp(bounds[0], 0); i < clamp(bounds[1], n); i += step {
+			indices = append(indices, i)
+		}
+	} else {
+		for i := clamp(bounds[0], n-1); i > clamp(bounds[1], -1); i += step {
+			indices = append(indices, i)
+		}
+	}
+	return indices, nil
+}
+
+// contains implements the 'in' operator
+func contains(container, item Value) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", typeName(item))
+		}
+		return strings.Contains(c, s), nil
+	case []Value:
+		for _, v := range c {
+			if equal(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case *Dict:
+		key, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c.Get(key)
+		return found, nil
+	case nil, Undefined:
+		return false, nil
+	}
+	return false, fmt.Errorf("argument of type %s is not iterable", typeName(container))
+}
+
+// arithmetic implements + - * / // % and **
+func arithmetic(op string, left, right Value) (Value, error) {
+	switch l := left.(type) {
+	case string:
+		switch op {
+		case "+":
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case "*":
+			if n, ok := right.(int64); ok {
+				return strings.Repeat(l, int(max(n, 0))), nil
+			}
+		}
+	case []Value:
+		if r, ok := right.([]Value); ok && op == "+" {
+			return append(append([]Value{}, l...), r...), nil
+		}
+	}
+
+	if !isNumber(left) || !isNumber(right) {
+		return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", op, typeName(left), typeName(right))
+	}
+
+	li, lInt := left.(int64)
+	ri, rInt := right.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "//", "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("integer division or modulo by zero")
+			}
+			q, m := li/ri, li%ri
+			// Python floors towards negative infinity
+			if m != 0 && (m < 0) != (ri < 0) {
+				q--
+				m += ri
+			}
+			if op == "//" {
+				return q, nil
+			}
+			return m, nil
+		case "**":
+			if ri >= 0 {
+				result := int64(1)
+				for i := int64(0); i < ri; i++ {
+					result *= li
+				}
+				return result, nil
+			}
+		}
+	}
+
+	lf, rf := toFloat(left), toFloat(right)
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	case "//":
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Floor(lf / rf), nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		return lf - rf*math.Floor(lf/rf), nil
+	case "**":
+		return math.Pow(lf, rf), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+// describe names an expression in error messages
+func describe(e expr, v Value) string {
+	switch e := e.(type) {
+	case *nameExpr:
+		if isUndefined(v) {
+			return fmt.Sprintf("'%s' is undefined and", e.name)
+		}
+		return fmt.Sprintf("'%s'", e.name)
+	case *attrExpr:
+		if isUndefined(v) {
+			return fmt.Sprintf("'%s' is undefined and", e.name)
+		}
+		return fmt.Sprintf("'%s'", e.name)
+	}
+	return typeName(v)
+}
@@ -0,0 +1,207 @@
+package jinja
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		vars     map[string]interface{}
+		want     string
+	}{
+		{
+			name:     "output and concatenation",
+			template: "{{ 'Hello' + ', ' ~ name }}!",
+			vars:     map[string]interface{}{"name": "world"},
+			want:     "Hello, world!",
+		},
+		{
+			name:     "trim_blocks and lstrip_blocks",
+			template: "<a>\n  {% if true %}\n  yes\n  {% endif %}\n</a>",
+			want:     "<a>\n  yes\n</a>",
+		},
+		{
+			name:     "explicit whitespace control",
+			template: "a  {%- if true -%}  b  {%- endif -%}  c",
+			want:     "abc",
+		},
+		{
+			name:     "comments",
+			template: "a{# ignored #}b",
+			want:     "ab",
+		},
+		{
+			name:     "for loop variables",
+			template: "{% for x in items %}{{ loop.index }}{{ x }}{% if not loop.last %},{% endif %}{% endfor %}",
+			vars:     map[string]interface{}{"items": []interface{}{"a", "b", "c"}},
+			want:     "1a,2b,3c",
+		},
+		{
+			name:     "for loop filter and else",
+			template: "{% for x in items if x > 1 %}{{ x }}{% else %}none{% endfor %}|{% for x in [] %}{% else %}empty{% endfor %}",
+			vars:     map[string]interface{}{"items": []interface{}{1, 2, 3}},
+			want:     "23|empty",
+		},
+		{
+			name:     "dict items unpacking",
+			template: "{% for k, v in d.items() %}{{ k }}={{ v }};{% endfor %}",
+			vars:     map[string]interface{}{"d": map[string]interface{}{"a": 1, "b": "x"}},
+			want:     "a=1;b=x;",
+		},
+		{
+			name:     "loop scope does not leak but namespaces do",
+			template: "{% set x = 1 %}{% set ns = namespace(y=1) %}{% for i in range(3) %}{% set x = i %}{% set ns.y = ns.y + i %}{% endfor %}{{ x }} {{ ns.y }}",
+			want:     "1 4",
+		},
+		{
+			name:     "elif and else",
+			template: "{% for n in [1, 2, 3] %}{% if n == 1 %}one{% elif n == 2 %}two{% else %}many{% endif %} {% endfor %}",
+			want:     "one two many ",
+		},
+		{
+			name:     "tests",
+			template: "{{ a is defined }} {{ b is defined }} {{ c is none }} {{ a is string }} {{ d is mapping }} {{ 4 is divisibleby 2 }} {{ c is not none }}",
+			vars:     map[string]interface{}{"a": "x", "c": nil, "d": map[string]interface{}{}},
+			want:     "True False True True True True False",
+		},
+		{
+			name:     "inline conditional and boolean operators",
+			template: "{{ 'yes' if a and not b else 'no' }} {{ b or 'fallback' }}",
+			vars:     map[string]interface{}{"a": true, "b": ""},
+			want:     "yes fallback",
+		},
+		{
+			name:     "subscripts and slices",
+			template: "{{ items[0] }}{{ items[-1] }} {{ items[1:] }} {{ items[::-1] | join(',') }} {{ s[:3] }}",
+			vars:     map[string]interface{}{"items": []interface{}{1, 2, 3}, "s": "abcdef"},
+			want:     "13 [2, 3] 3,2,1 abc",
+		},
+		{
+			name:     "string methods",
+			template: "{{ s.strip() }}|{{ s.split('b')[1] }}|{{ s.strip().startswith('a') }}|{{ s | trim | upper }}",
+			vars:     map[string]interface{}{"s": "  abc  "},
+			want:     "abc|c  |True|ABC",
+		},
+		{
+			name:     "tojson with python separators",
+			template: "{{ d | tojson }}",
+			vars:     map[string]interface{}{"d": map[string]interface{}{"b": []interface{}{1, true, nil}, "a": "é\"<"}},
+			want:     `{"a": "é\"<", "b": [1, true, null]}`,
+		},
+		{
+			name:     "tojson with indent",
+			template: "{{ d | tojson(indent=2) }}",
+			vars:     map[string]interface{}{"d": map[string]interface{}{"a": []interface{}{1, 2}}},
+			want:     "{\n  \"a\": [\n    1,\n    2\n  ]\n}",
+		},
+		{
+			name:     "filters",
+			template: "{{ items | length }} {{ items | first }} {{ items | last }} {{ missing | default('d') }} {{ users | selectattr('admin') | map(attribute='name') | join(',') }}",
+			vars: map[string]interface{}{
+				"items": []interface{}{"x", "y"},
+				"users": []interface{}{
+					map[string]interface{}{"name": "a", "admin": true},
+					map[string]interface{}{"name": "b", "admin": false},
+				},
+			},
+			want: "2 x y d a",
+		},
+		{
+			name:     "macros",
+			template: "{% macro greet(name, punct='!') %}Hi {{ name }}{{ punct }}{% endmacro %}{{ greet('Bob') }} {{ greet('Al', punct='?') }}",
+			want:     "Hi Bob! Hi Al?",
+		},
+		{
+			name:     "block set",
+			template: "{% set body %}x{{ 1 + 1 }}{% endset %}[{{ body }}]",
+			want:     "[x2]",
+		},
+		{
+			name:     "arithmetic",
+			template: "{{ 7 // 2 }} {{ 7 % 3 }} {{ 1 / 2 }} {{ 2 ** 3 }} {{ -7 // 2 }}",
+			want:     "3 1 0.5 8 -4",
+		},
+		{
+			name:     "membership",
+			template: "{{ 'a' in 'cat' }} {{ 2 in [1, 2] }} {{ 'k' in d }} {{ 'z' not in d }}",
+			vars:     map[string]interface{}{"d": map[string]interface{}{"k": 1}},
+			want:     "True True True True",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got, err := template.Render(tt.vars)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render()\nwant: %q\ngot:  %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRender_RaiseException(t *testing.T) {
+	template, err := Parse("{% if messages | length == 0 %}{{ raise_exception('No messages') }}{% endif %}")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	_, err = template.Render(map[string]interface{}{"messages": []interface{}{}})
+	var exception *Exception
+	if !errors.As(err, &exception) {
+		t.Fatalf("Expected an Exception, got %v", err)
+	}
+	if exception.Message != "No messages" {
+		t.Errorf("Expected message %q, got %q", "No messages", exception.Message)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []string{
+		"{% if x %}unclosed",
+		"{% for x in %}{% endfor %}",
+		"{{ x ",
+		"{% endif %}",
+		"{% unknown %}",
+	}
+
+	for _, source := range tests {
+		if _, err := Parse(source); err == nil {
+			t.Errorf("Parse(%q) expected an error", source)
+		}
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	value, err := FromJSON([]byte(`{"z": 1, "a": {"y": [true, null, 1.5], "b": "x"}}`))
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+
+	tmpl, err := Parse(`{{ v | tojson }}`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got, err := tmpl.Render(map[string]interface{}{"v": value})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	expected := `{"z": 1, "a": {"y": [true, null, 1.5], "b": "x"}}`
+	if got != expected {
+		t.Errorf("got %s, expected %s", got, expected)
+	}
+
+	if _, err := FromJSON([]byte(`{"z": `)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
@@ -0,0 +1,170 @@
+package jinja
+
+import (
+	"fmt"
+	"strings"
+)
+
+// segmentKind identifies the kind of a template segment
+type segmentKind int
+
+const (
+	segmentText segmentKind = iota
+	segmentOutput
+	segmentBlock
+)
+
+// segment is a piece of template source: raw text, an {{ output }} or a {% block %}
+type segment struct {
+	kind   segmentKind
+	source string
+	line   int
+}
+
+// lex splits the template source into segments, applying whitespace control the way
+// Hugging Face configures Jinja for chat templates (trim_blocks and lstrip_blocks enabled)
+func lex(source string) ([]segment, error) {
+	var segments []segment
+	line := 1
+	pos := 0
+	lstripNext := false
+
+	for pos < len(source) {
+		start := nextTag(source, pos)
+
+		text := source[pos:]
+		if start != -1 {
+			text = source[pos:start]
+		}
+
+		if lstripNext {
+			text = strings.TrimLeft(text, " \t\r\n")
+			lstripNext = false
+		}
+
+		if start == -1 {
+			if text != "" {
+				segments = append(segments, segment{kind: segmentText, source: text, line: line})
+			}
+			break
+		}
+
+		open := source[start : start+2]
+		inner := start + 2
+
+		// Explicit whitespace control on the opening side
+		stripBefore := false
+		keepBefore := false
+		if inner < len(source) {
+			switch source[inner] {
+			case '-':
+				stripBefore = true
+				inner++
+			case '+':
+				keepBefore = true
+				inner++
+			}
+		}
+
+		if stripBefore {
+			text = strings.TrimRight(text, " \t\r\n")
+		} else if open != "{{" && !keepBefore {
+			// lstrip_blocks: strip spaces and tabs before a block tag at the start of a line
+			lineStart := strings.LastIndex(text, "\n") + 1
+			if strings.TrimLeft(text[lineStart:], " \t") == "" && (lineStart > 0 || atLineStart(source, pos)) {
+				text = text[:lineStart]
+			}
+		}
+
+		if text != "" {
+			segments = append(segments, segment{kind: segmentText, source: text, line: line})
+		}
+		line += strings.Count(source[pos:start], "\n")
+
+		closeTag := map[string]string{"{{": "}}", "{%": "%}", "{#": "#}"}[open]
+		end := findClose(source, inner, closeTag, open != "{#")
+		if end == -1 {
+			return nil, fmt.Errorf("line %d: unclosed %s tag", line, open)
+		}
+
+		body := source[inner:end]
+		after := end + len(closeTag)
+
+		// Explicit whitespace control on the closing side
+		stripAfter := false
+		keepAfter := false
+		if strings.HasSuffix(body, "-") {
+			stripAfter = true
+			body = body[:len(body)-1]
+		} else if strings.HasSuffix(body, "+") && open != "{{" {
+			keepAfter = true
+			body = body[:len(body)-1]
+		}
+
+		switch open {
+		case "{{":
+			segments = append(segments, segment{kind: segmentOutput, source: strings.TrimSpace(body), line: line})
+		case "{%":
+			segments = append(segments, segment{kind: segmentBlock, source: strings.TrimSpace(body), line: line})
+		}
+
+		line += strings.Count(source[start:after], "\n")
+
+		if stripAfter {
+			lstripNext = true
+		} else if open != "{{" && !keepAfter {
+			// trim_blocks: remove the first newline after a block tag
+			if strings.HasPrefix(source[after:], "\r\n") {
+				after += 2
+				line++
+			} else if strings.HasPrefix(source[after:], "\n") {
+				after++
+				line++
+			}
+		}
+
+		pos = after
+	}
+
+	return segments, nil
+}
+
+// atLineStart reports whether pos is at the start of a line of source
+func atLineStart(source string, pos int) bool {
+	return pos == 0 || source[pos-1] == '\n'
+}
+
+// nextTag finds the next opening tag at or after pos
+func nextTag(source string, pos int) int {
+	for i := pos; i+1 < len(source); i++ {
+		if source[i] == '{' && (source[i+1] == '{' || source[i+1] == '%' || source[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// findClose finds the closing tag, skipping over string literals inside expressions
+func findClose(source string, pos int, closeTag string, skipStrings bool) int {
+	var quote byte
+	for i := pos; i < len(source); i++ {
+		c := source[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if skipStrings && (c == '"' || c == '\'') {
+			quote = c
+			continue
+		}
+		// A trailing "-" or "+" stays part of the body and is handled by the caller
+		if strings.HasPrefix(source[i:], closeTag) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,899 @@
+package jinja
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Statement nodes
+
+type node interface{}
+
+type textNode struct {
+	text string
+}
+
+type outputNode struct {
+	expr expr
+}
+
+type ifNode struct {
+	conditions []expr
+	bodies     [][]node
+	elseBody   []node
+}
+
+type forNode struct {
+	targets  []string
+	iterable expr
+	filter   expr
+	body     []node
+	elseBody []node
+}
+
+type setNode struct {
+	targets   []string
+	attribute string
+	value     expr
+	body      []node
+}
+
+type macroNode struct {
+	name     string
+	params   []string
+	defaults map[string]expr
+	body     []node
+}
+
+type groupNode struct {
+	body []node
+}
+
+type breakNode struct{}
+
+type continueNode struct{}
+
+// Expression nodes
+
+type expr interface{}
+
+type literalExpr struct {
+	value Value
+}
+
+type nameExpr struct {
+	name string
+}
+
+type listExpr struct {
+	items []expr
+}
+
+type dictExpr struct {
+	keys   []expr
+	values []expr
+}
+
+type attrExpr struct {
+	target expr
+	name   string
+}
+
+type itemExpr struct {
+	target expr
+	index  expr
+}
+
+type sliceExpr struct {
+	target            expr
+	start, stop, step expr
+}
+
+type callExpr struct {
+	target expr
+	args   []expr
+	kwargs []kwarg
+}
+
+type filterExpr struct {
+	target expr
+	name   string
+	args   []expr
+	kwargs []kwarg
+}
+
+type testExpr struct {
+	target expr
+	name   string
+	args   []expr
+	negate bool
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+type condExpr struct {
+	condition   expr
+	then, else_ expr
+}
+
+type kwarg struct {
+	name  string
+	value expr
+}
+
+// parser turns template segments into a tree of statement nodes
+type parser struct {
+	segments []segment
+	pos      int
+}
+
+// parseTemplate parses the template segments into a list of nodes
+func parseTemplate(segments []segment) ([]node, error) {
+	p := &parser{segments: segments}
+	body, end, _, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if end != "" {
+		return nil, fmt.Errorf("unexpected '%s' tag", end)
+	}
+	return body, nil
+}
+
+// parseBody parses nodes until one of the given end keywords, returning the keyword
+// that stopped the body together with the remaining tokens of that tag
+func (p *parser) parseBody(ends ...string) ([]node, string, *exprParser, error) {
+	var body []node
+
+	for p.pos < len(p.segments) {
+		seg := p.segments[p.pos]
+		p.pos++
+
+		switch seg.kind {
+		case segmentText:
+			body = append(body, &textNode{text: seg.source})
+
+		case segmentOutput:
+			ep, err := newExprParser(seg.source)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			e, err := ep.parseExpression()
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			if err := ep.expectEnd(); err != nil {
+				return nil, "", nil, fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			body = append(body, &outputNode{expr: e})
+
+		case segmentBlock:
+			ep, err := newExprParser(seg.source)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			keyword := ep.next()
+			if keyword.kind != tokenName {
+				return nil, "", nil, fmt.Errorf("line %d: expected statement, got %s", seg.line, keyword)
+			}
+
+			for _, end := range ends {
+				if keyword.value == end {
+					return body, end, ep, nil
+				}
+			}
+
+			n, err := p.parseStatement(keyword.value, ep)
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("line %d: %w", seg.line, err)
+			}
+			if n != nil {
+				body = append(body, n)
+			}
+		}
+	}
+
+	if len(ends) > 0 {
+		return nil, "", nil, fmt.Errorf("missing '%s' tag", ends[len(ends)-1])
+	}
+	return body, "", nil, nil
+}
+
+// parseStatement parses a single block statement and its body
+func (p *parser) parseStatement(keyword string, ep *exprParser) (node, error) {
+	switch keyword {
+	case "if":
+		return p.parseIf(ep)
+	case "for":
+		return p.parseFor(ep)
+	case "set":
+		return p.parseSet(ep)
+	case "macro":
+		return p.parseMacro(ep)
+	case "break":
+		return &breakNode{}, ep.expectEnd()
+	case "continue":
+		return &continueNode{}, ep.expectEnd()
+	case "generation":
+		// Hugging Face marks assistant output for masking, the content renders as-is
+		body, _, _, err := p.parseBody("endgeneration")
+		if err != nil {
+			return nil, err
+		}
+		return &groupNode{body: body}, nil
+	default:
+		return nil, fmt.Errorf("unsupported statement '%s'", keyword)
+	}
+}
+
+func (p *parser) parseIf(ep *exprParser) (node, error) {
+	n := &ifNode{}
+
+	for {
+		condition, err := ep.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := ep.expectEnd(); err != nil {
+			return nil, err
+		}
+
+		body, end, next, err := p.parseBody("elif", "else", "endif")
+		if err != nil {
+			return nil, err
+		}
+		n.conditions = append(n.conditions, condition)
+		n.bodies = append(n.bodies, body)
+
+		switch end {
+		case "elif":
+			ep = next
+			continue
+		case "else":
+			elseBody, _, _, err := p.parseBody("endif")
+			if err != nil {
+				return nil, err
+			}
+			n.elseBody = elseBody
+		}
+		return n, nil
+	}
+}
+
+func (p *parser) parseFor(ep *exprParser) (node, error) {
+	n := &forNode{}
+
+	for {
+		target := ep.next()
+		if target.kind != tokenName {
+			return nil, fmt.Errorf("expected loop variable, got %s", target)
+		}
+		n.targets = append(n.targets, target.value)
+		if !ep.skipOperator(",") {
+			break
+		}
+	}
+
+	if !ep.skipName("in") {
+		return nil, fmt.Errorf("expected 'in', got %s", ep.peek())
+	}
+
+	iterable, err := ep.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	n.iterable = iterable
+
+	if ep.skipName("if") {
+		if n.filter, err = ep.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ep.expectEnd(); err != nil {
+		return nil, err
+	}
+
+	body, end, _, err := p.parseBody("else", "endfor")
+	if err != nil {
+		return nil, err
+	}
+	n.body = body
+
+	if end == "else" {
+		if n.elseBody, _, _, err = p.parseBody("endfor"); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) parseSet(ep *exprParser) (node, error) {
+	n := &setNode{}
+
+	for {
+		target := ep.next()
+		if target.kind != tokenName {
+			return nil, fmt.Errorf("expected variable name, got %s", target)
+		}
+		n.targets = append(n.targets, target.value)
+
+		// Namespace attribute assignment, e.g. {% set ns.found = true %}
+		if ep.skipOperator(".") {
+			attr := ep.next()
+			if attr.kind != tokenName {
+				return nil, fmt.Errorf("expected attribute name, got %s", attr)
+			}
+			n.attribute = attr.value
+			break
+		}
+		if !ep.skipOperator(",") {
+			break
+		}
+	}
+
+	// Block assignment captures the rendered body
+	if ep.atEnd() {
+		body, _, _, err := p.parseBody("endset")
+		if err != nil {
+			return nil, err
+		}
+		n.body = body
+		return n, nil
+	}
+
+	if !ep.skipOperator("=") {
+		return nil, fmt.Errorf("expected '=', got %s", ep.peek())
+	}
+	value, err := ep.parseTuple()
+	if err != nil {
+		return nil, err
+	}
+	n.value = value
+	return n, ep.expectEnd()
+}
+
+func (p *parser) parseMacro(ep *exprParser) (node, error) {
+	name := ep.next()
+	if name.kind != tokenName {
+		return nil, fmt.Errorf("expected macro name, got %s", name)
+	}
+	n := &macroNode{name: name.value, defaults: map[string]expr{}}
+
+	if !ep.skipOperator("(") {
+		return nil, fmt.Errorf("expected '(', got %s", ep.peek())
+	}
+	for !ep.skipOperator(")") {
+		param := ep.next()
+		if param.kind != tokenName {
+			return nil, fmt.Errorf("expected parameter name, got %s", param)
+		}
+		n.params = append(n.params, param.value)
+		if ep.skipOperator("=") {
+			value, err := ep.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			n.defaults[param.value] = value
+		}
+		if !ep.skipOperator(",") && !ep.isOperator(")") {
+			return nil, fmt.Errorf("expected ',' or ')', got %s", ep.peek())
+		}
+	}
+	if err := ep.expectEnd(); err != nil {
+		return nil, err
+	}
+
+	body, _, _, err := p.parseBody("endmacro")
+	if err != nil {
+		return nil, err
+	}
+	n.body = body
+	return n, nil
+}
+
+// exprParser is a recursive descent parser over the tokens of a single tag
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func newExprParser(source string) (*exprParser, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{tokens: tokens}, nil
+}
+
+func (ep *exprParser) peek() token {
+	return ep.tokens[ep.pos]
+}
+
+func (ep *exprParser) next() token {
+	t := ep.tokens[ep.pos]
+	if t.kind != tokenEOF {
+		ep.pos++
+	}
+	return t
+}
+
+func (ep *exprParser) atEnd() bool {
+	return ep.peek().kind == tokenEOF
+}
+
+func (ep *exprParser) expectEnd() error {
+	if !ep.atEnd() {
+		return fmt.Errorf("unexpected %s", ep.peek())
+	}
+	return nil
+}
+
+func (ep *exprParser) isOperator(op string) bool {
+	t := ep.peek()
+	return t.kind == tokenOperator && t.value == op
+}
+
+func (ep *exprParser) isName(name string) bool {
+	t := ep.peek()
+	return t.kind == tokenName && t.value == name
+}
+
+func (ep *exprParser) skipOperator(op string) bool {
+	if ep.isOperator(op) {
+		ep.pos++
+		return true
+	}
+	return false
+}
+
+func (ep *exprParser) skipName(name string) bool {
+	if ep.isName(name) {
+		ep.pos++
+		return true
+	}
+	return false
+}
+
+func (ep *exprParser) expectOperator(op string) error {
+	if !ep.skipOperator(op) {
+		return fmt.Errorf("expected '%s', got %s", op, ep.peek())
+	}
+	return nil
+}
+
+// parseTuple parses an expression, collecting a bare comma separated list into a tuple
+func (ep *exprParser) parseTuple() (expr, error) {
+	first, err := ep.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if !ep.isOperator(",") {
+		return first, nil
+	}
+
+	items := []expr{first}
+	for ep.skipOperator(",") {
+		if ep.atEnd() {
+			break
+		}
+		item, err := ep.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return &listExpr{items: items}, nil
+}
+
+// parseExpression parses a full expression including inline conditionals
+func (ep *exprParser) parseExpression() (expr, error) {
+	e, err := ep.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	for ep.skipName("if") {
+		condition, err := ep.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		var otherwise expr
+		if ep.skipName("else") {
+			if otherwise, err = ep.parseOr(); err != nil {
+				return nil, err
+			}
+		}
+		e = &condExpr{condition: condition, then: e, else_: otherwise}
+	}
+	return e, nil
+}
+
+func (ep *exprParser) parseOr() (expr, error) {
+	left, err := ep.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for ep.skipName("or") {
+		right, err := ep.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseAnd() (expr, error) {
+	left, err := ep.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for ep.skipName("and") {
+		right, err := ep.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseNot() (expr, error) {
+	if ep.skipName("not") {
+		operand, err := ep.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: "not", operand: operand}, nil
+	}
+	return ep.parseCompare()
+}
+
+func (ep *exprParser) parseCompare() (expr, error) {
+	left, err := ep.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var op string
+		t := ep.peek()
+		switch {
+		case t.kind == tokenOperator && (t.value == "==" || t.value == "!=" || t.value == "<" || t.value == ">" || t.value == "<=" || t.value == ">="):
+			op = t.value
+			ep.pos++
+		case t.kind == tokenName && t.value == "in":
+			op = "in"
+			ep.pos++
+		case t.kind == tokenName && t.value == "not" && ep.tokens[ep.pos+1].kind == tokenName && ep.tokens[ep.pos+1].value == "in":
+			op = "not in"
+			ep.pos += 2
+		case t.kind == tokenName && t.value == "is":
+			ep.pos++
+			if left, err = ep.parseTest(left); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			return left, nil
+		}
+
+		right, err := ep.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+// parseTest parses the test after 'is', e.g. "is not none" or "is divisibleby(3)"
+func (ep *exprParser) parseTest(target expr) (expr, error) {
+	n := &testExpr{target: target, negate: ep.skipName("not")}
+
+	name := ep.next()
+	if name.kind != tokenName {
+		return nil, fmt.Errorf("expected test name, got %s", name)
+	}
+	n.name = name.value
+
+	if ep.skipOperator("(") {
+		args, _, err := ep.parseArguments()
+		if err != nil {
+			return nil, err
+		}
+		n.args = args
+	} else if t := ep.peek(); t.kind == tokenString || t.kind == tokenInt || t.kind == tokenFloat {
+		// A single literal argument may be given without parentheses
+		arg, err := ep.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		n.args = []expr{arg}
+	}
+	return n, nil
+}
+
+func (ep *exprParser) parseConcat() (expr, error) {
+	left, err := ep.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	for ep.skipOperator("~") {
+		right, err := ep.parseAdd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "~", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseAdd() (expr, error) {
+	left, err := ep.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for ep.isOperator("+") || ep.isOperator("-") {
+		op := ep.next().value
+		right, err := ep.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseMul() (expr, error) {
+	left, err := ep.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for ep.isOperator("*") || ep.isOperator("/") || ep.isOperator("//") || ep.isOperator("%") {
+		op := ep.next().value
+		right, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (ep *exprParser) parseUnary() (expr, error) {
+	if ep.isOperator("-") || ep.isOperator("+") {
+		op := ep.next().value
+		operand, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, operand: operand}, nil
+	}
+	return ep.parsePower()
+}
+
+func (ep *exprParser) parsePower() (expr, error) {
+	left, err := ep.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	if ep.skipOperator("**") {
+		right, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryExpr{op: "**", left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+// parsePostfix parses attribute access, subscripts, calls and filters
+func (ep *exprParser) parsePostfix() (expr, error) {
+	e, err := ep.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case ep.skipOperator("."):
+			name := ep.next()
+			if name.kind != tokenName && name.kind != tokenInt {
+				return nil, fmt.Errorf("expected attribute name, got %s", name)
+			}
+			e = &attrExpr{target: e, name: name.value}
+
+		case ep.skipOperator("["):
+			if e, err = ep.parseSubscript(e); err != nil {
+				return nil, err
+			}
+
+		case ep.skipOperator("("):
+			args, kwargs, err := ep.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			e = &callExpr{target: e, args: args, kwargs: kwargs}
+
+		case ep.skipOperator("|"):
+			name := ep.next()
+			if name.kind != tokenName {
+				return nil, fmt.Errorf("expected filter name, got %s", name)
+			}
+			f := &filterExpr{target: e, name: name.value}
+			if ep.skipOperator("(") {
+				if f.args, f.kwargs, err = ep.parseArguments(); err != nil {
+					return nil, err
+				}
+			}
+			e = f
+
+		default:
+			return e, nil
+		}
+	}
+}
+
+// parseSubscript parses an index or a slice after the opening bracket
+func (ep *exprParser) parseSubscript(target expr) (expr, error) {
+	var parts [3]expr
+	colons := 0
+
+	for !ep.skipOperator("]") {
+		if ep.skipOperator(":") {
+			colons++
+			if colons > 2 {
+				return nil, fmt.Errorf("invalid slice")
+			}
+			continue
+		}
+		if parts[colons] != nil {
+			return nil, fmt.Errorf("expected ']', got %s", ep.peek())
+		}
+		part, err := ep.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		parts[colons] = part
+	}
+
+	if colons == 0 {
+		if parts[0] == nil {
+			return nil, fmt.Errorf("empty subscript")
+		}
+		return &itemExpr{target: target, index: parts[0]}, nil
+	}
+	return &sliceExpr{target: target, start: parts[0], stop: parts[1], step: parts[2]}, nil
+}
+
+// parseArguments parses call arguments after the opening parenthesis
+func (ep *exprParser) parseArguments() ([]expr, []kwarg, error) {
+	var args []expr
+	var kwargs []kwarg
+
+	for !ep.skipOperator(")") {
+		if ep.atEnd() {
+			return nil, nil, fmt.Errorf("expected ')'")
+		}
+
+		t := ep.peek()
+		if t.kind == tokenName && ep.tokens[ep.pos+1].kind == tokenOperator && ep.tokens[ep.pos+1].value == "=" {
+			ep.pos += 2
+			value, err := ep.parseExpression()
+			if err != nil {
+				return nil, nil, err
+			}
+			kwargs = append(kwargs, kwarg{name: t.value, value: value})
+		} else {
+			value, err := ep.parseExpression()
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, value)
+		}
+
+		if !ep.skipOperator(",") && !ep.isOperator(")") {
+			return nil, nil, fmt.Errorf("expected ',' or ')', got %s", ep.peek())
+		}
+	}
+	return args, kwargs, nil
+}
+
+func (ep *exprParser) parsePrimary() (expr, error) {
+	t := ep.next()
+
+	switch t.kind {
+	case tokenString:
+		value := t.value
+		// Adjacent string literals are concatenated
+		for ep.peek().kind == tokenString {
+			value += ep.next().value
+		}
+		return &literalExpr{value: value}, nil
+
+	case tokenInt:
+		n, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{value: n}, nil
+
+	case tokenFloat:
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{value: f}, nil
+
+	case tokenName:
+		switch t.value {
+		case "true", "True":
+			return &literalExpr{value: true}, nil
+		case "false", "False":
+			return &literalExpr{value: false}, nil
+		case "none", "None":
+			return &literalExpr{value: nil}, nil
+		}
+		return &nameExpr{name: t.value}, nil
+
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			if ep.skipOperator(")") {
+				return &listExpr{}, nil
+			}
+			e, err := ep.parseTuple()
+			if err != nil {
+				return nil, err
+			}
+			return e, ep.expectOperator(")")
+
+		case "[":
+			list := &listExpr{}
+			for !ep.skipOperator("]") {
+				item, err := ep.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !ep.skipOperator(",") && !ep.isOperator("]") {
+					return nil, fmt.Errorf("expected ',' or ']', got %s", ep.peek())
+				}
+			}
+			return list, nil
+
+		case "{":
+			dict := &dictExpr{}
+			for !ep.skipOperator("}") {
+				key, err := ep.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				if err := ep.expectOperator(":"); err != nil {
+					return nil, err
+				}
+				value, err := ep.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				dict.keys = append(dict.keys, key)
+				dict.values = append(dict.values, value)
+				if !ep.skipOperator(",") && !ep.isOperator("}") {
+					return nil, fmt.Errorf("expected ',' or '}', got %s", ep.peek())
+				}
+			}
+			return dict, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %s", strings.TrimSpace(t.String()))
+}
@@ -0,0 +1,162 @@
+package jinja
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind identifies the kind of an expression token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenString
+	tokenInt
+	tokenFloat
+	tokenOperator
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.value)
+}
+
+// operators are matched longest first
+var operators = []string{
+	"//", "**", "==", "!=", "<=", ">=",
+	"+", "-", "*", "/", "%", "~", "<", ">", "=",
+	"(", ")", "[", "]", "{", "}", ".", ",", ":", "|",
+}
+
+// tokenize splits an expression or statement into tokens
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		c := source[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			value, n, err := readString(source[i:])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, value: value})
+			i += n
+
+		case c >= '0' && c <= '9':
+			start := i
+			kind := tokenInt
+			for i < len(source) && (isDigit(source[i]) || source[i] == '_') {
+				i++
+			}
+			if i+1 < len(source) && source[i] == '.' && isDigit(source[i+1]) {
+				kind = tokenFloat
+				i++
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			if i < len(source) && (source[i] == 'e' || source[i] == 'E') {
+				kind = tokenFloat
+				i++
+				if i < len(source) && (source[i] == '+' || source[i] == '-') {
+					i++
+				}
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			tokens = append(tokens, token{kind: kind, value: strings.ReplaceAll(source[start:i], "_", "")})
+
+		case isNameStart(c):
+			start := i
+			for i < len(source) && isNamePart(source[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, value: source[start:i]})
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, value: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// readString reads a quoted string literal, returning its value and the consumed length
+func readString(source string) (string, int, error) {
+	quote := source[0]
+	builder := &strings.Builder{}
+
+	for i := 1; i < len(source); i++ {
+		c := source[i]
+		switch {
+		case c == quote:
+			return builder.String(), i + 1, nil
+		case c == '\\' && i+1 < len(source):
+			i++
+			switch source[i] {
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			case 'r':
+				builder.WriteByte('\r')
+			case '\\', '\'', '"':
+				builder.WriteByte(source[i])
+			case 'u':
+				if i+4 < len(source) {
+					if r, err := strconv.ParseUint(source[i+1:i+5], 16, 32); err == nil {
+						builder.WriteRune(rune(r))
+						i += 4
+						continue
+					}
+				}
+				builder.WriteString(`\u`)
+			default:
+				builder.WriteByte('\\')
+				builder.WriteByte(source[i])
+			}
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string literal")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || isDigit(c)
+}
@@ -0,0 +1,593 @@
+package jinja
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Value is a template value: nil (none), Undefined, bool, int64, float64, string,
+// []Value, *Dict or Func
+type Value interface{}
+
+// Undefined is the value of a name or attribute that does not exist
+type Undefined struct {
+	Name string
+}
+
+// Func is a callable template value such as a global function, a macro or a bound method
+type Func func(args []Value, kwargs map[string]Value) (Value, error)
+
+// Dict is a mapping that preserves insertion order, as Python dicts do
+type Dict struct {
+	keys   []string
+	values map[string]Value
+}
+
+// NewDict creates an empty dict
+func NewDict() *Dict {
+	return &Dict{values: map[string]Value{}}
+}
+
+// Set sets a key, appending it to the key order when it is new
+func (d *Dict) Set(key string, value Value) *Dict {
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = value
+	return d
+}
+
+// Get returns the value for a key
+func (d *Dict) Get(key string) (Value, bool) {
+	value, ok := d.values[key]
+	return value, ok
+}
+
+// Keys returns the keys in insertion order
+func (d *Dict) Keys() []string {
+	return d.keys
+}
+
+// Len returns the number of keys
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+// FromGo converts decoded JSON (or any nested map, slice and scalar) into a template value.
+// Map keys are sorted since Go maps carry no order, use FromJSON to keep the key order.
+func FromGo(v interface{}) Value {
+	switch v := v.(type) {
+	case nil, bool, int64, float64, string, *Dict, Func, Undefined:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []Value:
+		return v
+	case []string:
+		list := make([]Value, len(v))
+		for i, item := range v {
+			list[i] = item
+		}
+		return list
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		d := NewDict()
+		for _, key := range keys {
+			d.Set(key, FromGo(v[key]))
+		}
+		return d
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		d := NewDict()
+		for _, key := range keys {
+			d.Set(key, v[key])
+		}
+		return d
+	default:
+		// Remaining slices of interfaces (e.g. from encoding/json)
+		if list, ok := v.([]interface{}); ok {
+			converted := make([]Value, len(list))
+			for i, item := range list {
+				converted[i] = FromGo(item)
+			}
+			return converted
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// FromJSON converts JSON text into a template value, keeping the key order of objects
+func FromJSON(raw []byte) (Value, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	v, err := decodeJSON(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	return v, nil
+}
+
+func decodeJSON(decoder *json.Decoder) (Value, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		d := NewDict()
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			d.Set(fmt.Sprint(key), value)
+		}
+		_, err := decoder.Token()
+		return d, err
+	case json.Delim('['):
+		list := []Value{}
+		for decoder.More() {
+			value, err := decodeJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := decoder.Token()
+		return list, err
+	default:
+		return FromGo(token), nil
+	}
+}
+
+// isUndefined reports whether v is undefined
+func isUndefined(v Value) bool {
+	_, ok := v.(Undefined)
+	return ok
+}
+
+// truthy implements Python truthiness
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil, Undefined:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []Value:
+		return len(v) > 0
+	case *Dict:
+		return v.Len() > 0
+	default:
+		return true
+	}
+}
+
+// typeName returns a Python-like type name used in error messages
+func typeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "none"
+	case Undefined:
+		return "undefined"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []Value:
+		return "list"
+	case *Dict:
+		return "dict"
+	case Func:
+		return "function"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// toString converts a value to its printed form, as Python's str() does
+func toString(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case Undefined:
+		return ""
+	case string:
+		return v
+	default:
+		return repr(v)
+	}
+}
+
+// repr converts a value to its Python literal representation
+func repr(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "None"
+	case Undefined:
+		return ""
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return formatFloat(v)
+	case string:
+		return pythonQuote(v)
+	case []Value:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = repr(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *Dict:
+		items := make([]string, 0, v.Len())
+		for _, key := range v.keys {
+			items = append(items, pythonQuote(key)+": "+repr(v.values[key]))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case Func:
+		return "<function>"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// pythonQuote quotes a string the way Python's repr does
+func pythonQuote(s string) string {
+	quote := byte('\'')
+	if strings.Contains(s, "'") && !strings.Contains(s, "\"") {
+		quote = '"'
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteByte(quote)
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			builder.WriteString(`\\`)
+		case r == rune(quote):
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case r == '\n':
+			builder.WriteString(`\n`)
+		case r == '\t':
+			builder.WriteString(`\t`)
+		case r == '\r':
+			builder.WriteString(`\r`)
+		case r < 0x20:
+			fmt.Fprintf(builder, `\x%02x`, r)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	builder.WriteByte(quote)
+	return builder.String()
+}
+
+// formatFloat formats a float the way Python's repr does
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+
+	abs := math.Abs(f)
+	if abs == 0 || abs >= 1e-4 && abs < 1e16 {
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// toJSON serializes a value the way Hugging Face's tojson filter does: json.dumps with
+// ensure_ascii disabled, Python's default separators and an optional indent
+func toJSON(v Value, indent string, sortKeys bool) (string, error) {
+	builder := &strings.Builder{}
+	if err := writeJSON(builder, v, indent, "", sortKeys); err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+func writeJSON(builder *strings.Builder, v Value, indent, prefix string, sortKeys bool) error {
+	itemSep, keySep := ", ", ": "
+	if indent != "" {
+		itemSep = ","
+	}
+
+	switch v := v.(type) {
+	case nil, Undefined:
+		builder.WriteString("null")
+	case bool:
+		if v {
+			builder.WriteString("true")
+		} else {
+			builder.WriteString("false")
+		}
+	case int64:
+		builder.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("cannot serialize %v to JSON", v)
+		}
+		builder.WriteString(formatFloat(v))
+	case string:
+		writeJSONString(builder, v)
+	case []Value:
+		if len(v) == 0 {
+			builder.WriteString("[]")
+			return nil
+		}
+		builder.WriteByte('[')
+		inner := prefix + indent
+		for i, item := range v {
+			if i > 0 {
+				builder.WriteString(itemSep)
+			}
+			if indent != "" {
+				builder.WriteString("\n" + inner)
+			}
+			if err := writeJSON(builder, item, indent, inner, sortKeys); err != nil {
+				return err
+			}
+		}
+		if indent != "" {
+			builder.WriteString("\n" + prefix)
+		}
+		builder.WriteByte(']')
+	case *Dict:
+		if v.Len() == 0 {
+			builder.WriteString("{}")
+			return nil
+		}
+		keys := v.keys
+		if sortKeys {
+			keys = append([]string(nil), keys...)
+			sort.Strings(keys)
+		}
+		builder.WriteByte('{')
+		inner := prefix + indent
+		for i, key := range keys {
+			if i > 0 {
+				builder.WriteString(itemSep)
+			}
+			if indent != "" {
+				builder.WriteString("\n" + inner)
+			}
+			writeJSONString(builder, key)
+			builder.WriteString(keySep)
+			if err := writeJSON(builder, v.values[key], indent, inner, sortKeys); err != nil {
+				return err
+			}
+		}
+		if indent != "" {
+			builder.WriteString("\n" + prefix)
+		}
+		builder.WriteByte('}')
+	default:
+		return fmt.Errorf("cannot serialize %s to JSON", typeName(v))
+	}
+	return nil
+}
+
+// writeJSONString writes a JSON string without escaping non-ASCII or HTML characters
+func writeJSONString(builder *strings.Builder, s string) {
+	builder.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\f':
+			builder.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == utf8.RuneError {
+				fmt.Fprintf(builder, `\u%04x`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+	builder.WriteByte('"')
+}
+
+// equal implements Python equality
+func equal(a, b Value) bool {
+	if isNumber(a) && isNumber(b) {
+		return toFloat(a) == toFloat(b)
+	}
+
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case Undefined:
+		return isUndefined(b)
+	case bool:
+		bb, ok := b.(bool)
+		return ok && a == bb
+	case string:
+		bs, ok := b.(string)
+		return ok && a == bs
+	case []Value:
+		bl, ok := b.([]Value)
+		if !ok || len(a) != len(bl) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], bl[i]) {
+				return false
+			}
+		}
+		return true
+	case *Dict:
+		bd, ok := b.(*Dict)
+		if !ok || a.Len() != bd.Len() {
+			return false
+		}
+		for _, key := range a.keys {
+			value, ok := bd.values[key]
+			if !ok || !equal(a.values[key], value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compare orders two numbers or two strings
+func compare(a, b Value) (int, error) {
+	if isNumber(a) && isNumber(b) {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	as, aok := a.(string)
+	bs, bok := b.(string)
+	if aok && bok {
+		return strings.Compare(as, bs), nil
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeName(a), typeName(b))
+}
+
+// isNumber reports whether v is an int, float or bool (Python bools are ints)
+func isNumber(v Value) bool {
+	switch v.(type) {
+	case int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+func toFloat(v Value) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toInt(v Value) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// iterate returns the items of an iterable value. Iterating a dict yields its keys.
+func iterate(v Value) ([]Value, error) {
+	switch v := v.(type) {
+	case nil, Undefined:
+		return nil, nil
+	case []Value:
+		return v, nil
+	case *Dict:
+		items := make([]Value, v.Len())
+		for i, key := range v.keys {
+			items[i] = key
+		}
+		return items, nil
+	case string:
+		var items []Value
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", typeName(v))
+}
+
+// length returns the length of a string, list or dict
+func length(v Value) (int, error) {
+	switch v := v.(type) {
+	case string:
+		return utf8.RuneCountInString(v), nil
+	case []Value:
+		return len(v), nil
+	case *Dict:
+		return v.Len(), nil
+	case Undefined:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%s has no length", typeName(v))
+}
@@ -0,0 +1,350 @@
+package deepseek
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+const (
+	// DeepSeek-R1 special tokens, note the full width bars and the "▁" separators
+	beginOfSentence = "<｜begin▁of▁sentence｜>"
+	endOfSentence   = "<｜end▁of▁sentence｜>"
+	userTag         = "<｜User｜>"
+	assistantTag    = "<｜Assistant｜>"
+
+	// Tool call tokens
+	toolCallsBegin = "<｜tool▁calls▁begin｜>"
+	toolCallsEnd   = "<｜tool▁calls▁end｜>"
+	toolCallBegin  = "<｜tool▁call▁begin｜>"
+	toolCallEnd    = "<｜tool▁call▁end｜>"
+	toolSep        = "<｜tool▁sep｜>"
+
+	// Tool output tokens
+	toolOutputsBegin = "<｜tool▁outputs▁begin｜>"
+	toolOutputsEnd   = "<｜tool▁outputs▁end｜>"
+	toolOutputBegin  = "<｜tool▁output▁begin｜>"
+	toolOutputEnd    = "<｜tool▁output▁end｜>"
+
+	// Thinking tokens for reasoning
+	think    = "<think>"
+	thinkEnd = "</think>"
+
+	// json fence wrapping the tool call arguments
+	jsonFence = "```json"
+	fenceEnd  = "```"
+)
+
+var _ models.Format = (*deepseekR1)(nil)
+var _ format.Format = (*deepseekR1)(nil)
+
+// StopSequences returns the tokens that end the output of the DeepSeek-R1 format
+func StopSequences() []string {
+	return []string{endOfSentence}
+}
+
+func ConstructorDeepSeek_R1() (models.Format, error) {
+	return newDeepSeekR1(), nil
+}
+
+type deepseekR1 struct {
+	format.Codec
+}
+
+func newDeepSeekR1() *deepseekR1 {
+	m := &deepseekR1{}
+	// The output is streamed from the reasoning block opened by the generation prompt
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.DeepSeek,
+		Start:          think + "\n",
+		Stops:          StopSequences(),
+		Calls:          grammar.DeepSeek,
+	}
+	return m
+}
+
+// decode returns the chain-of-thought as a reasoning item, followed by the answer or the tool
+// calls. While the model is still thinking only the reasoning is returned and the message is not
+// final.
+func (m *deepseekR1) decode(content string) (*ai.Message, error) {
+
+	complete := strings.Contains(content, endOfSentence)
+	content = strings.Replace(content, endOfSentence, "", -1)
+
+	// The generation prompt opens the <think> block, so the output usually only carries the
+	// closing tag. Until it is closed, everything the model produced is reasoning.
+	if start := strings.Index(content, think); start != -1 {
+		content = content[start+len(think):]
+	}
+
+	var messageContents []ai.MessageContent
+	if end := strings.Index(content, thinkEnd); end != -1 {
+		if r := strings.TrimSpace(content[:end]); r != "" {
+			messageContents = append(messageContents, reasoning.Text(r))
+		}
+		content = content[end+len(thinkEnd):]
+	} else if !complete {
+		// Still inside the <think> block, stream the reasoning on its own
+		r := strings.TrimSpace(content)
+		if r == "" {
+			return nil, &models.PartialDecodeError{}
+		}
+		return &ai.Message{
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{reasoning.Text(r)}),
+			Final:   false,
+		}, nil
+	}
+
+	index := strings.Index(content, toolCallsBegin)
+	if index == -1 {
+		messageContents = append(messageContents, ai.NewMessageContent(ai.Text(strings.TrimSpace(content))))
+		return &ai.Message{
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList(messageContents),
+			Final:   true,
+		}, nil
+	}
+
+	// Keep any text the model produced before the tool calls
+	if text := strings.TrimSpace(content[:index]); text != "" {
+		messageContents = append(messageContents, ai.NewMessageContent(ai.Text(text)))
+	}
+
+	calls := content[index+len(toolCallsBegin):]
+	if end := strings.Index(calls, toolCallsEnd); end != -1 {
+		calls = calls[:end]
+	} else if !complete {
+		return nil, &models.PartialDecodeError{}
+	}
+
+	for _, segment := range strings.Split(calls, toolCallBegin)[1:] {
+		end := strings.Index(segment, toolCallEnd)
+		if end == -1 {
+			if !complete {
+				return nil, &models.PartialDecodeError{}
+			}
+			return nil, fmt.Errorf("failed to parse tool call, missing %s", toolCallEnd)
+		}
+
+		call, err := parseToolCall(segment[:end])
+		if err != nil {
+			return nil, err
+		}
+		messageContents = append(messageContents, ai.NewMessageContent(*call))
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(messageContents),
+	}, nil
+}
+
+// parseToolCall parses a single call in either the R1 layout
+//
+//	function<｜tool▁sep｜>name\n```json\n{...}\n```
+//
+// or the newer layout used by later checkpoints
+//
+//	name<｜tool▁sep｜>{...}
+func parseToolCall(segment string) (*mcp.CallToolParams, error) {
+	parts := strings.SplitN(segment, toolSep, 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("failed to parse tool call, missing %s", toolSep)
+	}
+
+	name := strings.TrimSpace(parts[0])
+	input := strings.TrimSpace(parts[1])
+	if name == "function" {
+		lines := strings.SplitN(input, "\n", 2)
+		name = strings.TrimSpace(lines[0])
+		input = ""
+		if len(lines) == 2 {
+			input = lines[1]
+		}
+	}
+
+	input = strings.TrimSpace(input)
+	input = strings.TrimPrefix(input, jsonFence)
+	input = strings.TrimSuffix(input, fenceEnd)
+	input = strings.TrimSpace(input)
+
+	if name == "" {
+		return nil, fmt.Errorf("failed to parse tool call, missing function name")
+	}
+
+	args, err := arguments.Decode([]byte(input))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcp.CallToolParams{
+		Name:      name,
+		Arguments: cm.ToList(args),
+	}, nil
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *deepseekR1) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	builder := &strings.Builder{}
+
+	choice, prefill := opts.ToolChoice, ""
+
+	var tools []mcp.Tool
+	for _, msg := range messages {
+		if msg.Role != ai.RoleSystem {
+			continue
+		}
+		for _, content := range msg.Content.Slice() {
+			if content.String() == "tools" {
+				tools = content.Tools().Slice()
+			}
+		}
+	}
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	builder.WriteString(beginOfSentence)
+
+	// The system prompt and tools are rendered before the first turn
+	for _, msg := range messages {
+		if msg.Role != ai.RoleSystem {
+			continue
+		}
+		for _, content := range msg.Content.Slice() {
+			switch content.String() {
+			case "text":
+				c := content.Text()
+				builder.WriteString(*c)
+			case "tools":
+				if len(tools) > 0 {
+					builder.WriteString(encodeTools(tools))
+				}
+				if instruction := choice.Instruction(); instruction != "" {
+					builder.WriteString(fmt.Sprintf("\n%s\n", instruction))
+				}
+			}
+		}
+	}
+
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			// Rendered before the first turn
+
+		case ai.RoleUser:
+			builder.WriteString(userTag)
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "text" {
+					c := content.Text()
+					builder.WriteString(*c)
+				}
+			}
+
+		case ai.RoleAssistant:
+			builder.WriteString(assistantTag)
+
+			calls := 0
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					// Reasoning from earlier turns is never fed back to the model, the calls
+					// close the turn
+					if reasoning.Is(content) || calls > 0 {
+						continue
+					}
+					builder.WriteString(stripReasoning(*content.Text()))
+				case "tool-input":
+					c := content.ToolInput()
+
+					argsBytes := arguments.EncodeCall(tools, *c)
+
+					if calls == 0 {
+						builder.WriteString(toolCallsBegin)
+					}
+					builder.WriteString(fmt.Sprintf("%sfunction%s%s\n%s\n%s\n%s%s", toolCallBegin, toolSep, c.Name, jsonFence, string(argsBytes), fenceEnd, toolCallEnd))
+					calls++
+				}
+			}
+
+			if calls > 0 {
+				builder.WriteString(toolCallsEnd)
+			}
+			builder.WriteString(endOfSentence)
+
+		case ai.RoleTool:
+			// Consecutive tool outputs are grouped in a single block
+			if i == 0 || messages[i-1].Role != ai.RoleTool {
+				builder.WriteString(toolOutputsBegin)
+			}
+
+			builder.WriteString(fmt.Sprintf("%s%s%s", toolOutputBegin, toolresult.Message(msg), toolOutputEnd))
+
+			if i == len(messages)-1 || messages[i+1].Role != ai.RoleTool {
+				builder.WriteString(toolOutputsEnd)
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported message role: %v", msg.Role)
+		}
+	}
+
+	// Add generation prompt if the last message is not from assistant, opening the reasoning block
+	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
+		builder.WriteString(fmt.Sprintf("%s%s\n", assistantTag, think))
+		prefill = toolCallPrefill(choice)
+		builder.WriteString(prefill)
+	}
+
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
+}
+
+// toolCallPrefill returns what is written after the generation prompt when the choice requires a
+// call: the reasoning block is closed and the tool calls are opened, with the name filled in when
+// a specific tool is required
+func toolCallPrefill(choice toolchoice.Choice) string {
+	prefill := fmt.Sprintf("\n%s\n\n%s%sfunction%s", thinkEnd, toolCallsBegin, toolCallBegin, toolSep)
+	switch choice.Mode {
+	case toolchoice.Required:
+		return prefill
+	case toolchoice.Function:
+		return fmt.Sprintf("%s%s\n%s\n", prefill, choice.Name, jsonFence)
+	}
+	return ""
+}
+
+// stripReasoning removes a <think> block from assistant text, keeping only what follows it
+func stripReasoning(text string) string {
+	if end := strings.LastIndex(text, thinkEnd); end != -1 {
+		text = text[end+len(thinkEnd):]
+	}
+	return strings.TrimSpace(text)
+}
+
+// encodeTools renders the tool definitions appended to the system prompt
+func encodeTools(tools []mcp.Tool) string {
+	builder := &strings.Builder{}
+	builder.WriteString("\n\n## Tools\n\n### Function\n\nYou have the following functions available:\n")
+
+	for _, t := range tools {
+		builder.WriteString(fmt.Sprintf("\n- `%s`:\n%s\n%s\n%s\n", t.Name, jsonFence, schema.Tool(t), fenceEnd))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,220 @@
+package deepseek
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         []byte
+		wantTexts     []string
+		wantToolNames []string
+		wantToolArgs  [][][2]string
+		wantFinal     bool
+		wantPartial   bool
+		wantErr       bool
+	}{
+		{
+			name:      "reasoning and answer",
+			input:     []byte("Let me think about it.\n</think>\n\nHello, world!<｜end▁of▁sentence｜>"),
+			wantTexts: []string{"<think>\nLet me think about it.\n</think>", "Hello, world!"},
+			wantFinal: true,
+		},
+		{
+			name:      "explicit think block",
+			input:     []byte("<think>\nHmm.\n</think>\n\nHi!"),
+			wantTexts: []string{"<think>\nHmm.\n</think>", "Hi!"},
+			wantFinal: true,
+		},
+		{
+			name:      "streaming reasoning is not final",
+			input:     []byte("The user wants the weather, so"),
+			wantTexts: []string{"<think>\nThe user wants the weather, so\n</think>"},
+			wantFinal: false,
+		},
+		{
+			name:      "answer without reasoning",
+			input:     []byte("Hello!<｜end▁of▁sentence｜>"),
+			wantTexts: []string{"Hello!"},
+			wantFinal: true,
+		},
+		{
+			name:          "tool call",
+			input:         []byte("I need the weather.</think>\n\n<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n{\"city\": \"Paris\"}\n```<｜tool▁call▁end｜><｜tool▁calls▁end｜><｜end▁of▁sentence｜>"),
+			wantTexts:     []string{"<think>\nI need the weather.\n</think>"},
+			wantToolNames: []string{"get_weather"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}},
+		},
+		{
+			name:          "multiple tool calls in the newer layout",
+			input:         []byte("</think><｜tool▁calls▁begin｜><｜tool▁call▁begin｜>get_weather<｜tool▁sep｜>{\"city\": \"Paris\"}<｜tool▁call▁end｜><｜tool▁call▁begin｜>get_time<｜tool▁sep｜>{}<｜tool▁call▁end｜><｜tool▁calls▁end｜>"),
+			wantToolNames: []string{"get_weather", "get_time"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}, nil},
+		},
+		{
+			name:        "incomplete tool call while streaming",
+			input:       []byte("</think><｜tool▁calls▁begin｜><｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n{\"ci"),
+			wantPartial: true,
+			wantErr:     true,
+		},
+	}
+
+	model := newDeepSeekR1()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var partial *models.PartialDecodeError
+				if errors.As(err, &partial) != tt.wantPartial {
+					t.Errorf("Decode() error = %v, wantPartial %v", err, tt.wantPartial)
+				}
+				return
+			}
+
+			if msg.Final != tt.wantFinal {
+				t.Errorf("Expected final %v, got %v", tt.wantFinal, msg.Final)
+			}
+
+			texts, calls := 0, 0
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					if texts >= len(tt.wantTexts) {
+						t.Fatalf("Unexpected text content: %q", *content.Text())
+					}
+					if *content.Text() != tt.wantTexts[texts] {
+						t.Errorf("Expected content %q, got %q", tt.wantTexts[texts], *content.Text())
+					}
+					texts++
+				case "tool-input":
+					if calls >= len(tt.wantToolNames) {
+						t.Fatalf("Unexpected tool call: %s", content.ToolInput().Name)
+					}
+					if content.ToolInput().Name != tt.wantToolNames[calls] {
+						t.Errorf("Expected tool input name '%s', got '%s'", tt.wantToolNames[calls], content.ToolInput().Name)
+					}
+					for i, arg := range content.ToolInput().Arguments.Slice() {
+						if arg != tt.wantToolArgs[calls][i] {
+							t.Errorf("Expected tool input argument '%s', got '%s'", tt.wantToolArgs[calls][i], arg)
+						}
+					}
+					calls++
+				default:
+					t.Errorf("Unexpected content type: %s", content.String())
+				}
+			}
+			if texts != len(tt.wantTexts) || calls != len(tt.wantToolNames) {
+				t.Errorf("Expected %d texts and %d tool calls, got %d and %d", len(tt.wantTexts), len(tt.wantToolNames), texts, calls)
+			}
+		})
+	}
+}
+
+func TestEncode_StripsReasoning(t *testing.T) {
+	model := newDeepSeekR1()
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("You are a helpful assistant.")),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Hello")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				reasoning.Text("The user greets me."),
+				ai.NewMessageContent(ai.Text("Hi there!")),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Weather?")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("<think>\nCall the tool.\n</think>\n\n")),
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "get_weather",
+					Arguments: cm.ToList([][2]string{{"city", "Paris"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny"}),
+					}),
+				}),
+			}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	expected := "<｜begin▁of▁sentence｜>You are a helpful assistant." +
+		"<｜User｜>Hello<｜Assistant｜>Hi there!<｜end▁of▁sentence｜>" +
+		"<｜User｜>Weather?<｜Assistant｜>" +
+		"<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n{\"city\":\"Paris\"}\n```<｜tool▁call▁end｜><｜tool▁calls▁end｜><｜end▁of▁sentence｜>" +
+		"<｜tool▁outputs▁begin｜><｜tool▁output▁begin｜>Sunny<｜tool▁output▁end｜><｜tool▁outputs▁end｜>" +
+		"<｜Assistant｜><think>\n"
+
+	if result != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, result)
+	}
+
+	if strings.Contains(result, "The user greets me.") || strings.Contains(result, "Call the tool.") {
+		t.Error("Reasoning from earlier turns must not be re-encoded")
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	model := newDeepSeekR1()
+	prompt, err := model.EncodePrompt(format.Options{}, ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})})
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+
+	// The generation prompt opens the reasoning block, the output starts inside it
+	decoder := model.NewDecoder(prompt)
+	deltas := decoder.Feed([]byte("Greeting.\n</think>\n\nHello!<｜end▁of▁sentence｜>"))
+
+	var kinds []string
+	for _, delta := range deltas {
+		kinds = append(kinds, string(delta.Kind)+":"+delta.Text)
+	}
+	want := []string{"reasoning:\nGreeting.\n", "text:\n\nHello!", "done:"}
+	if strings.Join(kinds, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %q, got %q", want, kinds)
+	}
+}
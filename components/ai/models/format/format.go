@@ -0,0 +1,190 @@
+// Package format declares what the runner needs from a prompt format beyond the format resource.
+//
+// The format resource of the models component only encodes messages and decodes output, so a
+// runner holding it cannot stream the output in the syntax of the format or stop it at the end
+// of a turn. Every format of this module implements Format by embedding a Codec, and a runner
+// that selects the same format as the models component, see the registry package, uses it
+// in-process and checks with Verify that both encode alike. Resource adapts a format resource for
+// runners that cannot select one, its output is streamed as plain text.
+package format
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"go.bytecodealliance.org/cm"
+)
+
+// Options are the settings of an encoded prompt that are not part of the history
+type Options struct {
+	// ToolChoice controls whether and which tools the model may call, auto when empty
+	ToolChoice toolchoice.Choice
+}
+
+// Prompt is an encoded prompt with what its output is read with. Formats keep no state between
+// encoding a prompt and decoding its output, so one format serves concurrent requests.
+type Prompt struct {
+	// Data is the encoded prompt
+	Data []byte
+	// Prefill is the start of the output written after the generation prompt, e.g. the start of
+	// a required tool call, which is put back in front of the output
+	Prefill string
+	// Choice is the tool choice the prompt was encoded with, the output is checked against it
+	Choice toolchoice.Choice
+	// Images are the images the prompt writes placeholders for, in prompt order, see the vision
+	// package. Formats without vision support refer to none.
+	Images [][]byte
+}
+
+// Format is a prompt format used in-process. Encode and Decode of models.Format use the default
+// options.
+type Format interface {
+	models.Format
+	// EncodePrompt encodes the messages with the given options
+	EncodePrompt(opts Options, messages ...ai.Message) (*Prompt, error)
+	// DecodePrompt decodes the output of a prompt
+	DecodePrompt(prompt *Prompt, output []byte) (*ai.Message, error)
+	// NewDecoder returns a decoder streaming the output of a prompt. The decoder starts after
+	// the prompt, including the prefill.
+	NewDecoder(prompt *Prompt) stream.Decoder
+	// StopSequences returns the sequences that end the output of the format. Output is cut
+	// after the first one and backends that support it stop generating there.
+	StopSequences() []string
+	// ToolSyntax returns the syntax the format writes tool calls in, which a grammar constrains
+	// them to. ok is false for formats whose calls cannot be constrained.
+	ToolSyntax() (syntax grammar.Syntax, ok bool)
+}
+
+// Codec implements Format from what differs between formats. A format embeds it and sets its
+// fields once it is created, it only encodes prompts and decodes output itself.
+type Codec struct {
+	// EncodeMessages encodes messages with the given options
+	EncodeMessages func(opts Options, messages ...ai.Message) (*Prompt, error)
+	// DecodeOutput decodes the output of a turn, which starts with the prefill of its prompt
+	DecodeOutput func(output string) (*ai.Message, error)
+	// Stream is the syntax the output is streamed in
+	Stream stream.Syntax
+	// Start is the text the generation prompt of every turn opens the output with, before the
+	// prefill, e.g. a reasoning block. It is not part of the output the model writes.
+	Start string
+	// Stops are the sequences that end the output
+	Stops []string
+	// Calls is the syntax of the tool calls
+	Calls grammar.Syntax
+}
+
+// Encode encodes the messages with the default options
+func (c Codec) Encode(messages ...ai.Message) ([]byte, error) {
+	prompt, err := c.EncodeMessages(Options{}, messages...)
+	if err != nil {
+		return nil, err
+	}
+	return prompt.Data, nil
+}
+
+// Decode decodes output written without a prefill or a tool choice
+func (c Codec) Decode(data []byte) (*ai.Message, error) {
+	return c.DecodePrompt(&Prompt{}, data)
+}
+
+// EncodePrompt encodes the messages with the given options
+func (c Codec) EncodePrompt(opts Options, messages ...ai.Message) (*Prompt, error) {
+	return c.EncodeMessages(opts, messages...)
+}
+
+// DecodePrompt decodes the output of a prompt with its prefill put back in front and checks the
+// tool calls against the tool choice of the prompt
+func (c Codec) DecodePrompt(prompt *Prompt, output []byte) (*ai.Message, error) {
+	msg, err := c.DecodeOutput(prompt.Prefill + string(output))
+	if err != nil {
+		return nil, err
+	}
+	if err := prompt.Choice.Validate(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// NewDecoder returns a decoder streaming the output of a prompt
+func (c Codec) NewDecoder(prompt *Prompt) stream.Decoder {
+	return stream.NewDecoder(c.Stream, c.Start+prompt.Prefill)
+}
+
+// StopSequences returns the sequences that end the output of the format
+func (c Codec) StopSequences() []string {
+	return c.Stops
+}
+
+// ToolSyntax returns the syntax the format writes tool calls in
+func (c Codec) ToolSyntax() (grammar.Syntax, bool) {
+	return c.Calls, c.Calls.Name != ""
+}
+
+// probe is the conversation Verify encodes, it takes every role a format renders without tools
+var probe = []ai.Message{
+	{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("You are a helpful assistant."))})},
+	{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("What is the capital of France?"))})},
+	{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Paris."))}), Final: true},
+	{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("And of Italy?"))})},
+}
+
+// Verify returns an error when f encodes a conversation differently from resource, the format
+// resource of the models component. A runner streaming with a format that is not the one the
+// model is prompted with would read its output in the wrong syntax.
+func Verify(f Format, resource models.Format) error {
+	want, err := resource.Encode(probe...)
+	if err != nil {
+		return fmt.Errorf("failed to encode with the format resource: %w", err)
+	}
+	got, err := f.Encode(probe...)
+	if err != nil {
+		return fmt.Errorf("failed to encode with the format selected in-process: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("the format selected in-process encodes differently from the format resource of the models component")
+	}
+	return nil
+}
+
+// Resource adapts a format that only encodes and decodes, its output is streamed as text and
+// runs until the backend ends it
+func Resource(format models.Format) Format {
+	return resource{Format: format}
+}
+
+type resource struct {
+	models.Format
+}
+
+// EncodePrompt encodes the messages with the resource, which only takes the default options
+func (r resource) EncodePrompt(opts Options, messages ...ai.Message) (*Prompt, error) {
+	if !opts.ToolChoice.IsAuto() {
+		return nil, fmt.Errorf("tool choice %s needs a format selected in-process, the format resource only encodes with auto", opts.ToolChoice)
+	}
+	data, err := r.Encode(messages...)
+	if err != nil {
+		return nil, err
+	}
+	return &Prompt{Data: data}, nil
+}
+
+func (r resource) DecodePrompt(_ *Prompt, output []byte) (*ai.Message, error) {
+	return r.Decode(output)
+}
+
+func (resource) NewDecoder(*Prompt) stream.Decoder {
+	return stream.NewDecoder(stream.Plain, "")
+}
+
+func (resource) StopSequences() []string {
+	return nil
+}
+
+func (resource) ToolSyntax() (grammar.Syntax, bool) {
+	return grammar.Syntax{}, false
+}
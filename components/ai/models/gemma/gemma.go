@@ -0,0 +1,331 @@
+package gemma
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+var (
+	// Gemma has no tool tokens, tool calls are requested as a fenced JSON block:
+	// ```tool_code\n{"name": "function_name", "arguments": {...}}\n```
+	toolCodeRegex = regexp.MustCompile("(?s)```(?:tool_code|json)[ \t]*\n(.*?)```")
+)
+
+const (
+	// Gemma 2/3 special tokens
+	bos         = "<bos>"
+	eos         = "<eos>"
+	startOfTurn = "<start_of_turn>"
+	endOfTurn   = "<end_of_turn>"
+
+	// Gemma only knows two roles, system and tool messages are rendered as user turns
+	user  = "user"
+	model = "model"
+
+	// Fences used for prompt-based function calling
+	toolCodeFence   = "```tool_code"
+	toolOutputFence = "```tool_output"
+	fenceEnd        = "```"
+)
+
+var _ models.Format = (*gemma)(nil)
+var _ format.Format = (*gemma)(nil)
+
+// StopSequences returns the tokens that end the output of the Gemma format
+func StopSequences() []string {
+	return []string{endOfTurn, eos}
+}
+
+func ConstructorGemma() (models.Format, error) {
+	return newGemma(), nil
+}
+
+type gemma struct {
+	format.Codec
+}
+
+func newGemma() *gemma {
+	m := &gemma{}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.Gemma,
+		Stops:          StopSequences(),
+		Calls:          grammar.Gemma,
+	}
+	return m
+}
+
+func (m *gemma) decode(content string) (*ai.Message, error) {
+
+	complete := strings.Contains(content, endOfTurn) || strings.Contains(content, eos)
+	content = strings.Replace(content, endOfTurn, "", -1)
+	content = strings.Replace(content, eos, "", -1)
+
+	// An opened tool_code fence without its closing fence is still streaming
+	if open := strings.LastIndex(content, toolCodeFence); open != -1 && !strings.Contains(content[open+len(toolCodeFence):], fenceEnd) {
+		if !complete {
+			return nil, &models.PartialDecodeError{}
+		}
+		return nil, fmt.Errorf("failed to parse tool call, unterminated tool_code block")
+	}
+
+	var messageContents []ai.MessageContent
+	last := 0
+	for _, match := range toolCodeRegex.FindAllStringSubmatchIndex(content, -1) {
+		calls, ok := parseToolCalls(content[match[2]:match[3]])
+		if !ok {
+			// A plain JSON block meant for the user, keep it as text
+			continue
+		}
+
+		if text := strings.TrimSpace(content[last:match[0]]); text != "" {
+			messageContents = append(messageContents, ai.NewMessageContent(ai.Text(text)))
+		}
+		for _, call := range calls {
+			messageContents = append(messageContents, ai.NewMessageContent(call))
+		}
+		last = match[1]
+	}
+
+	if text := strings.TrimSpace(content[last:]); text != "" || len(messageContents) == 0 {
+		messageContents = append(messageContents, ai.NewMessageContent(ai.Text(text)))
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(messageContents),
+	}, nil
+}
+
+// parseToolCalls parses the body of a fenced block as one tool call object or a list of them
+func parseToolCalls(body string) ([]mcp.CallToolParams, bool) {
+	type toolCallData struct {
+		Name       string          `json:"name"`
+		Arguments  json.RawMessage `json:"arguments"`
+		Parameters json.RawMessage `json:"parameters"`
+	}
+
+	body = strings.TrimSpace(body)
+
+	var data []toolCallData
+	if strings.HasPrefix(body, "[") {
+		if err := json.Unmarshal([]byte(body), &data); err != nil {
+			return nil, false
+		}
+	} else {
+		var call toolCallData
+		if err := json.Unmarshal([]byte(body), &call); err != nil {
+			return nil, false
+		}
+		data = append(data, call)
+	}
+
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var calls []mcp.CallToolParams
+	for _, call := range data {
+		if call.Name == "" {
+			return nil, false
+		}
+
+		// Gemma is not trained on a schema, accept the common "parameters" spelling too
+		raw := call.Arguments
+		if len(raw) == 0 {
+			raw = call.Parameters
+		}
+
+		args, err := arguments.Decode(raw)
+		if err != nil {
+			return nil, false
+		}
+
+		calls = append(calls, mcp.CallToolParams{
+			Name:      call.Name,
+			Arguments: cm.ToList(args),
+		})
+	}
+
+	return calls, true
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *gemma) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	builder := &strings.Builder{}
+
+	choice, prefill := opts.ToolChoice, ""
+
+	// First pass: collect the system prompt and tools. Gemma has no system role,
+	// both are folded into the first user turn.
+	var tools []mcp.Tool
+	systemContent := ""
+	for _, msg := range messages {
+		if msg.Role != ai.RoleSystem {
+			continue
+		}
+		for _, content := range msg.Content.Slice() {
+			switch content.String() {
+			case "text":
+				c := content.Text()
+				if systemContent != "" {
+					systemContent += "\n\n"
+				}
+				systemContent += *c
+			case "tools":
+				tools = content.Tools().Slice()
+			}
+		}
+	}
+
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	preamble := systemContent
+	if len(tools) > 0 {
+		manifest, err := toolManifest(tools)
+		if err != nil {
+			return nil, err
+		}
+		if preamble != "" {
+			preamble += "\n\n"
+		}
+		preamble += manifest
+		if instruction := choice.Instruction(); instruction != "" {
+			preamble += "\n" + instruction
+		}
+	}
+
+	builder.WriteString(bos)
+
+	firstUser := true
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			// Folded into the first user turn
+
+		case ai.RoleUser:
+			builder.WriteString(fmt.Sprintf("%s%s\n", startOfTurn, user))
+			if firstUser && preamble != "" {
+				builder.WriteString(fmt.Sprintf("%s\n\n", preamble))
+			}
+			firstUser = false
+
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "text" {
+					c := content.Text()
+					builder.WriteString(strings.TrimSpace(*c))
+				}
+			}
+
+			builder.WriteString(fmt.Sprintf("%s\n", endOfTurn))
+
+		case ai.RoleAssistant:
+			builder.WriteString(fmt.Sprintf("%s%s\n", startOfTurn, model))
+
+			var parts []string
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					c := content.Text()
+					if text := strings.TrimSpace(*c); text != "" {
+						parts = append(parts, text)
+					}
+				case "tool-input":
+					c := content.ToolInput()
+
+					toolCallJSON := map[string]interface{}{
+						"name":      c.Name,
+						"arguments": arguments.EncodeCall(tools, *c),
+					}
+
+					toolCallBytes, err := json.Marshal(toolCallJSON)
+					if err != nil {
+						return nil, fmt.Errorf("failed to marshal tool call: %v", err)
+					}
+					parts = append(parts, fmt.Sprintf("%s\n%s\n%s", toolCodeFence, string(toolCallBytes), fenceEnd))
+				}
+			}
+
+			builder.WriteString(strings.Join(parts, "\n"))
+			builder.WriteString(fmt.Sprintf("%s\n", endOfTurn))
+
+		case ai.RoleTool:
+			// Consecutive tool results share a single user turn
+			if i == 0 || messages[i-1].Role != ai.RoleTool {
+				builder.WriteString(fmt.Sprintf("%s%s\n", startOfTurn, user))
+				if firstUser && preamble != "" {
+					builder.WriteString(fmt.Sprintf("%s\n\n", preamble))
+				}
+				firstUser = false
+			} else {
+				builder.WriteString("\n")
+			}
+
+			builder.WriteString(fmt.Sprintf("%s\n%s\n%s", toolOutputFence, toolresult.Message(msg), fenceEnd))
+
+			if i == len(messages)-1 || messages[i+1].Role != ai.RoleTool {
+				builder.WriteString(fmt.Sprintf("%s\n", endOfTurn))
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported message role: %v", msg.Role)
+		}
+	}
+
+	// Add generation prompt if the last message is not from the model
+	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
+		builder.WriteString(fmt.Sprintf("%s%s\n", startOfTurn, model))
+		prefill = toolCallPrefill(choice)
+		builder.WriteString(prefill)
+	}
+
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
+}
+
+// toolCallPrefill returns the start of the tool_code block written after the generation prompt
+// when the choice requires a call, with the name filled in when a specific tool is required
+func toolCallPrefill(choice toolchoice.Choice) string {
+	switch choice.Mode {
+	case toolchoice.Required:
+		return toolCodeFence + "\n"
+	case toolchoice.Function:
+		name, _ := json.Marshal(choice.Name)
+		return fmt.Sprintf("%s\n{\"name\": %s, \"arguments\": ", toolCodeFence, name)
+	}
+	return ""
+}
+
+// toolManifest renders the prompt describing the available functions and how to call them
+func toolManifest(tools []mcp.Tool) (string, error) {
+	manifest := &bytes.Buffer{}
+	if err := json.Indent(manifest, schema.Functions(tools), "", "  "); err != nil {
+		return "", fmt.Errorf("failed to format tool manifest: %v", err)
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString("You have access to the following functions:\n")
+	builder.WriteString(manifest.String())
+	builder.WriteString("\n\nIf you decide to call a function, reply ONLY with a tool_code block containing a JSON object with the function name and its arguments:\n")
+	builder.WriteString(fmt.Sprintf("%s\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n%s\n", toolCodeFence, fenceEnd))
+	builder.WriteString(fmt.Sprintf("The result of the call will be provided to you in a %s block.", strings.TrimPrefix(toolOutputFence, fenceEnd)))
+	return builder.String(), nil
+}
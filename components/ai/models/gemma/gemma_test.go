@@ -0,0 +1,192 @@
+package gemma
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         []byte
+		wantTexts     []string
+		wantToolNames []string
+		wantToolArgs  [][][2]string
+		wantPartial   bool
+		wantErr       bool
+	}{
+		{
+			name:      "basic decode",
+			input:     []byte("Hello, world!<end_of_turn>"),
+			wantTexts: []string{"Hello, world!"},
+		},
+		{
+			name:          "decode tool_code block",
+			input:         []byte("```tool_code\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n```<end_of_turn>"),
+			wantToolNames: []string{"get_weather"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}},
+		},
+		{
+			name:          "decode json block with text",
+			input:         []byte("Let me check.\n```json\n[{\"name\": \"get_weather\", \"parameters\": {\"city\": \"Paris\"}}, {\"name\": \"get_time\"}]\n```"),
+			wantTexts:     []string{"Let me check."},
+			wantToolNames: []string{"get_weather", "get_time"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}, nil},
+		},
+		{
+			name:      "json block that is not a tool call",
+			input:     []byte("Here you go:\n```json\n{\"a\": 1}\n```<end_of_turn>"),
+			wantTexts: []string{"Here you go:\n```json\n{\"a\": 1}\n```"},
+		},
+		{
+			name:        "unterminated tool_code block while streaming",
+			input:       []byte("```tool_code\n{\"name\": \"get_weather\""),
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:    "unterminated tool_code block",
+			input:   []byte("```tool_code\n{\"name\": \"get_weather\"<end_of_turn>"),
+			wantErr: true,
+		},
+	}
+
+	model := newGemma()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var partial *models.PartialDecodeError
+				if errors.As(err, &partial) != tt.wantPartial {
+					t.Errorf("Decode() error = %v, wantPartial %v", err, tt.wantPartial)
+				}
+				return
+			}
+
+			texts, calls := 0, 0
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					if texts >= len(tt.wantTexts) {
+						t.Fatalf("Unexpected text content: %q", *content.Text())
+					}
+					if *content.Text() != tt.wantTexts[texts] {
+						t.Errorf("Expected content %q, got %q", tt.wantTexts[texts], *content.Text())
+					}
+					texts++
+				case "tool-input":
+					if calls >= len(tt.wantToolNames) {
+						t.Fatalf("Unexpected tool call: %s", content.ToolInput().Name)
+					}
+					if content.ToolInput().Name != tt.wantToolNames[calls] {
+						t.Errorf("Expected tool input name '%s', got '%s'", tt.wantToolNames[calls], content.ToolInput().Name)
+					}
+					for i, arg := range content.ToolInput().Arguments.Slice() {
+						if arg != tt.wantToolArgs[calls][i] {
+							t.Errorf("Expected tool input argument '%s', got '%s'", tt.wantToolArgs[calls][i], arg)
+						}
+					}
+					calls++
+				default:
+					t.Errorf("Unexpected content type: %s", content.String())
+				}
+			}
+			if texts != len(tt.wantTexts) || calls != len(tt.wantToolNames) {
+				t.Errorf("Expected %d texts and %d tool calls, got %d and %d", len(tt.wantTexts), len(tt.wantToolNames), texts, calls)
+			}
+		})
+	}
+}
+
+func TestEncode_ToolCall(t *testing.T) {
+	model := newGemma()
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("You are a helpful assistant.")),
+				ai.NewMessageContent(cm.ToList([]mcp.Tool{
+					{
+						Name:        "get_weather",
+						Description: "Get the weather for a city",
+						InputSchema: mcp.ToolSchema{
+							SchemaType: "object",
+							Properties: cm.ToList([][2]string{
+								{"city", `{"type": "string"}`},
+							}),
+						},
+					},
+				})),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("What's the weather in Paris?")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "get_weather",
+					Arguments: cm.ToList([][2]string{{"city", "Paris"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny, 22C"}),
+					}),
+				}),
+			}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	manifest, err := toolManifest(messages[0].Content.Slice()[1].Tools().Slice())
+	if err != nil {
+		t.Fatalf("toolManifest failed: %v", err)
+	}
+
+	expected := "<bos><start_of_turn>user\n" +
+		"You are a helpful assistant.\n\n" + manifest + "\n\n" +
+		"What's the weather in Paris?<end_of_turn>\n" +
+		"<start_of_turn>model\n" +
+		"```tool_code\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n```<end_of_turn>\n" +
+		"<start_of_turn>user\n" +
+		"```tool_output\nSunny, 22C\n```<end_of_turn>\n" +
+		"<start_of_turn>model\n"
+
+	if result != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, result)
+	}
+
+	// The encoded tool call must decode back into the same call
+	msg, err := model.Decode([]byte("```tool_code\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n```<end_of_turn>"))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if call := msg.Content.Slice()[0].ToolInput(); call == nil || call.Name != "get_weather" {
+		t.Errorf("Expected get_weather tool call, got %v", msg.Content.Slice()[0].String())
+	}
+}
@@ -0,0 +1,424 @@
+// Package gguf reads the metadata of GGUF model files.
+//
+// Only the header and the metadata key/value pairs are read, tensor information and tensor
+// data are never loaded. Open also skips the vocabulary arrays, which hold hundreds of
+// thousands of entries, until they are needed. See
+// https://github.com/ggml-org/ggml/blob/master/docs/gguf.md for the file layout.
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+const magic = "GGUF"
+
+// maxLength bounds string and array lengths so a corrupt file cannot exhaust memory, lengths
+// are also checked against what is left of the file when its size is known
+const maxLength = 1 << 24
+
+// vocabulary lists the arrays Open skips, LoadVocabulary reads them
+var vocabulary = []string{
+	"tokenizer.ggml.tokens",
+	"tokenizer.ggml.scores",
+	"tokenizer.ggml.token_type",
+	"tokenizer.ggml.merges",
+}
+
+// Metadata value types
+const (
+	typeUint8 uint32 = iota
+	typeInt8
+	typeUint16
+	typeInt16
+	typeUint32
+	typeInt32
+	typeFloat32
+	typeBool
+	typeString
+	typeArray
+	typeUint64
+	typeInt64
+	typeFloat64
+)
+
+// File holds the metadata of a GGUF file
+type File struct {
+	Version     uint32
+	TensorCount uint64
+	// Keys lists the metadata keys in file order
+	Keys []string
+	// Metadata maps keys to their values. Scalars are stored as their Go type (uint8 ... float64,
+	// bool and string), arrays as typed slices such as []string or []int32.
+	Metadata map[string]interface{}
+
+	// path and skipped locate the arrays Open skipped, by the offset of their element type
+	path    string
+	skipped map[string]int64
+}
+
+// Open reads the metadata of the GGUF file at path. The vocabulary arrays are skipped, Token
+// reads single tokens from the file and LoadVocabulary reads the arrays into Metadata.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gguf file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gguf file: %w", err)
+	}
+
+	file, err := read(newDecoder(f, info.Size()), true)
+	if err != nil {
+		return nil, err
+	}
+	file.path = path
+	return file, nil
+}
+
+// Read reads the GGUF header and metadata from r, it stops before the tensor information.
+// Every value is read, including the vocabulary arrays.
+func Read(r io.Reader) (*File, error) {
+	return read(newDecoder(r, -1), false)
+}
+
+func read(d *decoder, skipVocabulary bool) (*File, error) {
+	m := d.read(len(magic))
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to read gguf magic: %w", d.err)
+	}
+	if string(m) != magic {
+		return nil, fmt.Errorf("not a gguf file")
+	}
+
+	file := &File{Metadata: make(map[string]interface{})}
+	file.Version = d.uint32()
+	if d.err == nil && file.Version < 2 {
+		return nil, fmt.Errorf("unsupported gguf version %d", file.Version)
+	}
+
+	file.TensorCount = d.uint64()
+	count := d.uint64()
+	if d.err != nil {
+		return nil, fmt.Errorf("failed to read gguf header: %w", d.err)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		key := d.string()
+		t := d.uint32()
+		if skipVocabulary && t == typeArray && slices.Contains(vocabulary, key) {
+			if file.skipped == nil {
+				file.skipped = make(map[string]int64)
+			}
+			file.skipped[key] = d.pos
+			d.skipArray()
+			if d.err != nil {
+				return nil, fmt.Errorf("failed to read gguf metadata %q: %w", key, d.err)
+			}
+			if !slices.Contains(file.Keys, key) {
+				file.Keys = append(file.Keys, key)
+			}
+			continue
+		}
+
+		value := d.value(t)
+		if d.err != nil {
+			return nil, fmt.Errorf("failed to read gguf metadata %q: %w", key, d.err)
+		}
+		if _, ok := file.Metadata[key]; !ok {
+			file.Keys = append(file.Keys, key)
+		}
+		file.Metadata[key] = value
+	}
+
+	return file, nil
+}
+
+// LoadVocabulary reads the vocabulary arrays skipped by Open into Metadata
+func (f *File) LoadVocabulary() error {
+	if len(f.skipped) == 0 {
+		return nil
+	}
+
+	r, err := os.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to open gguf file: %w", err)
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open gguf file: %w", err)
+	}
+
+	for key, offset := range f.skipped {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to read gguf metadata %q: %w", key, err)
+		}
+		d := newDecoder(r, info.Size()-offset)
+		value := d.array()
+		if d.err != nil {
+			return fmt.Errorf("failed to read gguf metadata %q: %w", key, d.err)
+		}
+		f.Metadata[key] = value
+	}
+	f.skipped = nil
+	return nil
+}
+
+// token reads a single entry of tokenizer.ggml.tokens from the file
+func (f *File) token(id uint32) (string, bool) {
+	offset, ok := f.skipped["tokenizer.ggml.tokens"]
+	if !ok {
+		return "", false
+	}
+
+	r, err := os.Open(f.path)
+	if err != nil {
+		return "", false
+	}
+	defer r.Close()
+
+	info, err := r.Stat()
+	if err != nil {
+		return "", false
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return "", false
+	}
+
+	d := newDecoder(r, info.Size()-offset)
+	if t, n := d.uint32(), d.length(1); d.err != nil || t != typeString || int(id) >= n {
+		return "", false
+	}
+	for i := uint32(0); i < id; i++ {
+		d.discard(d.length(1))
+	}
+	token := d.string()
+	return token, d.err == nil
+}
+
+// String returns a string metadata value
+func (f *File) String(key string) (string, bool) {
+	v, ok := f.Metadata[key].(string)
+	return v, ok
+}
+
+// Uint returns an unsigned integer metadata value, signed values that are not negative are
+// accepted as well
+func (f *File) Uint(key string) (uint64, bool) {
+	switch v := f.Metadata[key].(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int8:
+		return uint64(v), v >= 0
+	case int16:
+		return uint64(v), v >= 0
+	case int32:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// Strings returns a string array metadata value
+func (f *File) Strings(key string) ([]string, bool) {
+	v, ok := f.Metadata[key].([]string)
+	return v, ok
+}
+
+// decoder reads little endian GGUF values, the first error is kept and stops further reads.
+// pos counts the bytes read, size is the number of bytes available or -1 when unknown.
+type decoder struct {
+	r    *bufio.Reader
+	buf  [8]byte
+	err  error
+	pos  int64
+	size int64
+}
+
+func newDecoder(r io.Reader, size int64) *decoder {
+	return &decoder{r: bufio.NewReaderSize(r, 1<<16), size: size}
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return d.buf[:n]
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:n]); err != nil {
+		d.err = err
+	}
+	d.pos += int64(n)
+	return d.buf[:n]
+}
+
+func (d *decoder) discard(n int) {
+	if d.err != nil {
+		return
+	}
+	discarded, err := d.r.Discard(n)
+	d.pos += int64(discarded)
+	if err != nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) uint32() uint32 { return binary.LittleEndian.Uint32(d.read(4)) }
+func (d *decoder) uint64() uint64 { return binary.LittleEndian.Uint64(d.read(8)) }
+
+// length reads a string or array length, elements of at least size bytes each must fit in
+// what is left of the file
+func (d *decoder) length(size int64) int {
+	n := d.uint64()
+	switch {
+	case d.err != nil:
+	case n > maxLength:
+		d.err = fmt.Errorf("length %d exceeds the supported maximum", n)
+	case d.size >= 0 && int64(n)*size > d.size-d.pos:
+		d.err = fmt.Errorf("length %d exceeds the remaining %d bytes", n, d.size-d.pos)
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	n := d.length(1)
+	if d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+		return ""
+	}
+	d.pos += int64(n)
+	return string(b)
+}
+
+// sizes holds the size of the fixed size value types
+var sizes = map[uint32]int{
+	typeUint8: 1, typeInt8: 1, typeBool: 1,
+	typeUint16: 2, typeInt16: 2,
+	typeUint32: 4, typeInt32: 4, typeFloat32: 4,
+	typeUint64: 8, typeInt64: 8, typeFloat64: 8,
+}
+
+// skipArray reads past an array without keeping its values
+func (d *decoder) skipArray() {
+	t := d.uint32()
+	if size, ok := sizes[t]; ok {
+		d.discard(d.length(int64(size)) * size)
+		return
+	}
+	if t != typeString {
+		if d.err == nil {
+			d.err = fmt.Errorf("unsupported array type %d", t)
+		}
+		return
+	}
+	n := d.length(8)
+	for i := 0; i < n && d.err == nil; i++ {
+		d.discard(d.length(1))
+	}
+}
+
+func (d *decoder) value(t uint32) interface{} {
+	switch t {
+	case typeUint8:
+		return d.read(1)[0]
+	case typeInt8:
+		return int8(d.read(1)[0])
+	case typeUint16:
+		return binary.LittleEndian.Uint16(d.read(2))
+	case typeInt16:
+		return int16(binary.LittleEndian.Uint16(d.read(2)))
+	case typeUint32:
+		return d.uint32()
+	case typeInt32:
+		return int32(d.uint32())
+	case typeFloat32:
+		return math.Float32frombits(d.uint32())
+	case typeBool:
+		return d.read(1)[0] != 0
+	case typeString:
+		return d.string()
+	case typeUint64:
+		return d.uint64()
+	case typeInt64:
+		return int64(d.uint64())
+	case typeFloat64:
+		return math.Float64frombits(d.uint64())
+	case typeArray:
+		return d.array()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown value type %d", t)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) array() interface{} {
+	t := d.uint32()
+	size := int64(8)
+	if fixed, ok := sizes[t]; ok {
+		size = int64(fixed)
+	}
+	n := d.length(size)
+	if d.err != nil {
+		return nil
+	}
+
+	switch t {
+	case typeString:
+		values := make([]string, n)
+		for i := range values {
+			values[i] = d.string()
+		}
+		return values
+	case typeInt32:
+		values := make([]int32, n)
+		for i := range values {
+			values[i] = int32(d.uint32())
+		}
+		return values
+	case typeUint32:
+		values := make([]uint32, n)
+		for i := range values {
+			values[i] = d.uint32()
+		}
+		return values
+	case typeFloat32:
+		values := make([]float32, n)
+		for i := range values {
+			values[i] = math.Float32frombits(d.uint32())
+		}
+		return values
+	default:
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = d.value(t)
+			if d.err != nil {
+				return nil
+			}
+		}
+		return values
+	}
+}
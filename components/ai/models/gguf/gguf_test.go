@@ -0,0 +1,193 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writer builds GGUF files for tests
+type writer struct {
+	buf   bytes.Buffer
+	count uint64
+	kv    bytes.Buffer
+}
+
+func (w *writer) put(v interface{}) {
+	binary.Write(&w.kv, binary.LittleEndian, v)
+}
+
+func (w *writer) putString(s string) {
+	w.put(uint64(len(s)))
+	w.kv.WriteString(s)
+}
+
+func (w *writer) str(key, value string) {
+	w.count++
+	w.putString(key)
+	w.put(typeString)
+	w.putString(value)
+}
+
+func (w *writer) u32(key string, value uint32) {
+	w.count++
+	w.putString(key)
+	w.put(typeUint32)
+	w.put(value)
+}
+
+func (w *writer) strings(key string, values []string) {
+	w.count++
+	w.putString(key)
+	w.put(typeArray)
+	w.put(typeString)
+	w.put(uint64(len(values)))
+	for _, v := range values {
+		w.putString(v)
+	}
+}
+
+func (w *writer) bytes() []byte {
+	w.buf.WriteString(magic)
+	binary.Write(&w.buf, binary.LittleEndian, uint32(3))
+	binary.Write(&w.buf, binary.LittleEndian, uint64(291))
+	binary.Write(&w.buf, binary.LittleEndian, w.count)
+	w.buf.Write(w.kv.Bytes())
+	// Tensor information follows the metadata and must not be read
+	w.buf.WriteString("tensor info that is never parsed")
+	return w.buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	w := &writer{}
+	w.str("general.architecture", "llama")
+	w.str("general.name", "Meta Llama 3.1 8B Instruct")
+	w.u32("general.file_type", 15)
+	w.u32("llama.context_length", 131072)
+	w.str("tokenizer.chat_template", "{{ bos_token }}{% for m in messages %}{{ m.content }}{% endfor %}")
+	w.strings("tokenizer.ggml.tokens", []string{"<unk>", "<|begin_of_text|>", "<|eot_id|>"})
+	w.u32("tokenizer.ggml.bos_token_id", 1)
+	w.u32("tokenizer.ggml.eos_token_id", 2)
+
+	file, err := Read(bytes.NewReader(w.bytes()))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	if file.Version != 3 || file.TensorCount != 291 {
+		t.Errorf("unexpected header: version %d, tensors %d", file.Version, file.TensorCount)
+	}
+	if len(file.Keys) != 8 || file.Keys[0] != "general.architecture" {
+		t.Errorf("unexpected keys: %v", file.Keys)
+	}
+	if file.Architecture() != "llama" || file.Name() != "Meta Llama 3.1 8B Instruct" {
+		t.Errorf("unexpected architecture %q or name %q", file.Architecture(), file.Name())
+	}
+	if file.ContextLength() != 131072 {
+		t.Errorf("expected context length 131072, got %d", file.ContextLength())
+	}
+	if file.Quantization() != "Q4_K_M" {
+		t.Errorf("expected Q4_K_M, got %s", file.Quantization())
+	}
+	if !strings.HasPrefix(file.ChatTemplate(), "{{ bos_token }}") {
+		t.Errorf("unexpected chat template %q", file.ChatTemplate())
+	}
+
+	special := file.SpecialTokens()
+	if special["bos"] != 1 || special["eos"] != 2 || len(special) != 2 {
+		t.Errorf("unexpected special tokens: %v", special)
+	}
+	if token, ok := file.Token(special["eos"]); !ok || token != "<|eot_id|>" {
+		t.Errorf("expected <|eot_id|>, got %q", token)
+	}
+}
+
+func TestRead_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "not gguf", input: []byte("GGML\x03\x00\x00\x00")},
+		{name: "truncated header", input: []byte("GGUF\x03\x00")},
+		{name: "unsupported version", input: append([]byte("GGUF\x01\x00\x00\x00"), make([]byte, 16)...)},
+		{name: "string longer than the maximum", input: func() []byte {
+			w := &writer{}
+			w.count++
+			w.put(uint64(maxLength + 1))
+			return w.bytes()
+		}()},
+		{name: "truncated metadata", input: func() []byte {
+			w := &writer{}
+			w.str("general.architecture", "llama")
+			b := w.bytes()
+			return b[:len(b)-40]
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(bytes.NewReader(tt.input)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestOpen_SkipsVocabulary(t *testing.T) {
+	w := &writer{}
+	w.str("general.architecture", "llama")
+	w.strings("tokenizer.ggml.tokens", []string{"<unk>", "<|begin_of_text|>", "<|eot_id|>"})
+	w.u32("tokenizer.ggml.eos_token_id", 2)
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, w.bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write gguf: %v", err)
+	}
+
+	file, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, ok := file.Strings("tokenizer.ggml.tokens"); ok {
+		t.Error("expected the vocabulary to be skipped")
+	}
+	if len(file.Keys) != 3 || file.SpecialTokens()["eos"] != 2 {
+		t.Errorf("expected the metadata after the vocabulary, got keys %v", file.Keys)
+	}
+
+	// Single tokens are read from the file
+	if token, ok := file.Token(2); !ok || token != "<|eot_id|>" {
+		t.Errorf("expected <|eot_id|>, got %q", token)
+	}
+	if _, ok := file.Token(3); ok {
+		t.Error("expected no token past the vocabulary")
+	}
+
+	if err := file.LoadVocabulary(); err != nil {
+		t.Fatalf("LoadVocabulary failed: %v", err)
+	}
+	if tokens, ok := file.Strings("tokenizer.ggml.tokens"); !ok || len(tokens) != 3 {
+		t.Errorf("expected the vocabulary to be loaded, got %v", tokens)
+	}
+}
+
+func TestOpen_LengthBeyondFile(t *testing.T) {
+	// An array claiming more tokens than the file can hold is rejected before it is read
+	w := &writer{}
+	w.count++
+	w.putString("tokenizer.ggml.tokens")
+	w.put(typeArray)
+	w.put(typeString)
+	w.put(uint64(1 << 20))
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, w.bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write gguf: %v", err)
+	}
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "remaining") {
+		t.Errorf("expected an error for the array length, got %v", err)
+	}
+}
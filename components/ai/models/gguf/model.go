@@ -0,0 +1,115 @@
+package gguf
+
+import "fmt"
+
+// fileTypes names the values of general.file_type, the quantization of most of the tensors
+var fileTypes = map[uint64]string{
+	0:  "F32",
+	1:  "F16",
+	2:  "Q4_0",
+	3:  "Q4_1",
+	7:  "Q8_0",
+	8:  "Q5_0",
+	9:  "Q5_1",
+	10: "Q2_K",
+	11: "Q3_K_S",
+	12: "Q3_K_M",
+	13: "Q3_K_L",
+	14: "Q4_K_S",
+	15: "Q4_K_M",
+	16: "Q5_K_S",
+	17: "Q5_K_M",
+	18: "Q6_K",
+	19: "IQ2_XXS",
+	20: "IQ2_XS",
+	21: "Q2_K_S",
+	22: "IQ3_XS",
+	23: "IQ3_XXS",
+	24: "IQ1_S",
+	25: "IQ4_NL",
+	26: "IQ3_S",
+	27: "IQ3_M",
+	28: "IQ2_S",
+	29: "IQ2_M",
+	30: "IQ4_XS",
+	31: "IQ1_M",
+	32: "BF16",
+	36: "TQ1_0",
+	37: "TQ2_0",
+	38: "MXFP4_MOE",
+}
+
+// specialTokens maps the names returned by SpecialTokens to their metadata keys
+var specialTokens = []struct {
+	name string
+	key  string
+}{
+	{"bos", "tokenizer.ggml.bos_token_id"},
+	{"eos", "tokenizer.ggml.eos_token_id"},
+	{"eot", "tokenizer.ggml.eot_token_id"},
+	{"eom", "tokenizer.ggml.eom_token_id"},
+	{"unknown", "tokenizer.ggml.unknown_token_id"},
+	{"separator", "tokenizer.ggml.seperator_token_id"},
+	{"padding", "tokenizer.ggml.padding_token_id"},
+}
+
+// Architecture returns general.architecture, e.g. llama, qwen3 or gpt-oss
+func (f *File) Architecture() string {
+	v, _ := f.String("general.architecture")
+	return v
+}
+
+// Name returns general.name, the human readable model name
+func (f *File) Name() string {
+	v, _ := f.String("general.name")
+	return v
+}
+
+// ChatTemplate returns tokenizer.chat_template, the Jinja template shipped with the model
+func (f *File) ChatTemplate() string {
+	v, _ := f.String("tokenizer.chat_template")
+	return v
+}
+
+// ContextLength returns the context length the model was trained with, 0 when unknown
+func (f *File) ContextLength() uint64 {
+	v, _ := f.Uint(f.Architecture() + ".context_length")
+	return v
+}
+
+// SpecialTokens returns the ids of the special tokens declared by the tokenizer, keyed by
+// bos, eos, eot, eom, unknown, separator and padding
+func (f *File) SpecialTokens() map[string]uint32 {
+	tokens := make(map[string]uint32)
+	for _, special := range specialTokens {
+		if id, ok := f.Uint(special.key); ok {
+			tokens[special.name] = uint32(id)
+		}
+	}
+	return tokens
+}
+
+// Token returns the text of a token from the embedded vocabulary, it is read from the file
+// when Open skipped the vocabulary
+func (f *File) Token(id uint32) (string, bool) {
+	tokens, ok := f.Strings("tokenizer.ggml.tokens")
+	if !ok {
+		return f.token(id)
+	}
+	if int(id) >= len(tokens) {
+		return "", false
+	}
+	return tokens[id], true
+}
+
+// Quantization returns the name of general.file_type, e.g. Q4_K_M
+func (f *File) Quantization() string {
+	fileType, ok := f.Uint("general.file_type")
+	if !ok {
+		return ""
+	}
+	if name, ok := fileTypes[fileType]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", fileType)
+}
@@ -4,5 +4,8 @@ go 1.23.6
 
 require (
 	github.com/hayride-dev/bindings v0.0.66
+	github.com/hayride-dev/morphs/components/util/datetime v0.0.0
 	go.bytecodealliance.org/cm v0.2.2
 )
+
+replace github.com/hayride-dev/morphs/components/util/datetime => ../../util/datetime
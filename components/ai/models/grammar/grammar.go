@@ -0,0 +1,312 @@
+// Package grammar converts tool definitions and JSON Schemas into GBNF, the grammar format of
+// llama.cpp, so the backend can constrain generation to well formed tool calls and answers.
+//
+// A grammar for tools matches one block of tool calls in the syntax of a prompt format, see
+// Syntax, with the arguments of every tool following its input schema. It is meant to be applied
+// lazily: generation is free until the model emits the trigger of the syntax, from which point the
+// rest of the output must match. A grammar for an output schema matches the whole answer.
+//
+// The JSON Schema keywords type, enum, properties, required and items are supported. Properties
+// are generated in the order they are declared, objects without properties and schemas without a
+// type accept any JSON value of the matching kind.
+package grammar
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+)
+
+const (
+	// TensorName names the graph input holding the GBNF grammar
+	TensorName = "grammar"
+	// TriggerTensorName names the graph input holding the text that activates the grammar, the
+	// grammar applies to the whole output when it is absent
+	TriggerTensorName = "grammar-trigger"
+)
+
+// invalidRuleChars matches the characters that may not appear in a rule name
+var invalidRuleChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
+// primitives are the rules shared by every grammar, with the rules they depend on
+var primitives = map[string]struct {
+	expr string
+	deps []string
+}{
+	"ws":       {expr: `[ \t\n]*`},
+	"value":    {expr: `object | array | string | number | boolean | null`, deps: []string{"object", "array", "string", "number", "boolean", "null"}},
+	"object":   {expr: `"{" ws ( string ws ":" ws value ( ws "," ws string ws ":" ws value )* )? ws "}"`, deps: []string{"ws", "string", "value"}},
+	"array":    {expr: `"[" ws ( value ( ws "," ws value )* )? ws "]"`, deps: []string{"ws", "value"}},
+	"string":   {expr: `"\"" char* "\""`, deps: []string{"char"}},
+	"char":     {expr: `[^"\\\x7F\x00-\x1F] | "\\" ( ["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] )`},
+	"number":   {expr: `"-"? integral ( "." [0-9]+ )? ( [eE] [-+]? [0-9]+ )?`, deps: []string{"integral"}},
+	"integer":  {expr: `"-"? integral`, deps: []string{"integral"}},
+	"integral": {expr: `"0" | [1-9] [0-9]*`},
+	"boolean":  {expr: `"true" | "false"`},
+	"null":     {expr: `"null"`},
+}
+
+// Config selects what a grammar matches
+type Config struct {
+	// Tools are the tools the model may call, written in Syntax
+	Tools  []mcp.Tool
+	Syntax Syntax
+	// Output is a JSON Schema the answer must follow, the answer is free text when empty
+	Output json.RawMessage
+}
+
+// Grammar is a GBNF grammar and the text that activates it
+type Grammar struct {
+	GBNF string
+	// Trigger is set when the grammar only applies from the first occurrence of it onwards
+	Trigger string
+}
+
+// New returns the grammar for config. With tools only, the grammar matches a block of tool calls
+// and is triggered by the start of the block. With an output schema only, or with both, it
+// applies to the whole output, which is then either a block of calls or the answer.
+func New(config Config) (*Grammar, error) {
+	b := newBuilder()
+
+	var alternatives []string
+	if len(config.Tools) > 0 {
+		if config.Syntax.call == nil {
+			return nil, fmt.Errorf("a syntax is required to constrain tool calls")
+		}
+		alternatives = append(alternatives, b.tools(config.Tools, config.Syntax))
+	}
+	if len(config.Output) > 0 {
+		output, err := schema.Parse(config.Output)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, b.schema("output", output))
+	}
+	if len(alternatives) == 0 {
+		return nil, fmt.Errorf("a grammar needs tools or an output schema")
+	}
+
+	b.add("root", strings.Join(alternatives, " | "))
+
+	grammar := &Grammar{GBNF: b.String()}
+	if len(config.Output) == 0 {
+		grammar.Trigger = config.Syntax.Trigger
+	}
+	return grammar, nil
+}
+
+// Schema returns a grammar matching JSON that follows the given JSON Schema
+func Schema(raw []byte) (string, error) {
+	grammar, err := New(Config{Output: raw})
+	if err != nil {
+		return "", err
+	}
+	return grammar.GBNF, nil
+}
+
+// Tools returns a grammar matching a block of calls to the given tools written in syntax
+func Tools(tools []mcp.Tool, syntax Syntax) (string, error) {
+	grammar, err := New(Config{Tools: tools, Syntax: syntax})
+	if err != nil {
+		return "", err
+	}
+	return grammar.GBNF, nil
+}
+
+// builder collects the rules of a grammar in the order they are defined
+type builder struct {
+	rules map[string]string
+	order []string
+}
+
+func newBuilder() *builder {
+	return &builder{rules: map[string]string{}}
+}
+
+// add defines a rule and returns its name
+func (b *builder) add(name, expr string) string {
+	if _, ok := b.rules[name]; !ok {
+		b.order = append(b.order, name)
+	}
+	b.rules[name] = expr
+	return name
+}
+
+// primitive defines a shared rule and the rules it depends on, and returns its name
+func (b *builder) primitive(name string) string {
+	if _, ok := b.rules[name]; ok {
+		return name
+	}
+	p := primitives[name]
+	b.add(name, p.expr)
+	for _, dep := range p.deps {
+		b.primitive(dep)
+	}
+	return name
+}
+
+// String renders the grammar with the root rule first
+func (b *builder) String() string {
+	builder := &strings.Builder{}
+	if expr, ok := b.rules["root"]; ok {
+		builder.WriteString(fmt.Sprintf("root ::= %s\n", expr))
+	}
+	for _, name := range b.order {
+		if name != "root" {
+			builder.WriteString(fmt.Sprintf("%s ::= %s\n", name, b.rules[name]))
+		}
+	}
+	return builder.String()
+}
+
+// tools defines the rules for a block of calls to any of the tools and returns its name
+func (b *builder) tools(tools []mcp.Tool, syntax Syntax) string {
+	b.primitive("ws")
+
+	var calls []string
+	for _, tool := range tools {
+		name := ruleName(tool.Name)
+		args := b.arguments(name+"-arguments", schema.FromTool(tool))
+		calls = append(calls, b.add(name+"-call", syntax.call(tool.Name, args)))
+	}
+	b.add("call", strings.Join(calls, " | "))
+
+	return b.add("tool-calls", syntax.block("call"))
+}
+
+// arguments defines the rule for the arguments of a tool, a tool without declared parameters
+// accepts any object
+func (b *builder) arguments(name string, s *schema.Schema) string {
+	if len(s.Properties) == 0 {
+		return b.primitive("object")
+	}
+	return b.schema(name, s)
+}
+
+// schema defines the rule for values following s and returns its name
+func (b *builder) schema(name string, s *schema.Schema) string {
+	name = ruleName(name)
+
+	if len(s.Enum) > 0 {
+		var values []string
+		for _, value := range s.Enum {
+			compact := &bytes.Buffer{}
+			if err := json.Compact(compact, value); err != nil {
+				continue
+			}
+			values = append(values, literal(compact.String()))
+		}
+		return b.add(name, strings.Join(values, " | "))
+	}
+
+	types := s.Types
+	if len(types) == 0 {
+		switch {
+		case len(s.Properties) > 0:
+			types = []string{"object"}
+		case s.Items != nil:
+			types = []string{"array"}
+		default:
+			return b.primitive("value")
+		}
+	}
+
+	var alternatives []string
+	for _, t := range types {
+		switch t {
+		case "string", "number", "integer", "boolean", "null":
+			alternatives = append(alternatives, b.primitive(t))
+		case "object":
+			alternatives = append(alternatives, b.object(name, s))
+		case "array":
+			alternatives = append(alternatives, b.array(name, s))
+		default:
+			alternatives = append(alternatives, b.primitive("value"))
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+	return b.add(name, strings.Join(alternatives, " | "))
+}
+
+// object defines the rule for an object with the properties of s. Required properties always
+// appear, optional ones may be left out, and all of them keep their declared order.
+func (b *builder) object(name string, s *schema.Schema) string {
+	if len(s.Properties) == 0 {
+		return b.primitive("object")
+	}
+	b.primitive("ws")
+
+	members := make([]string, len(s.Properties))
+	for i, property := range s.Properties {
+		value := b.schema(name+"-"+property.Name, property.Schema)
+		members[i] = fmt.Sprintf(`%s ws ":" ws %s`, literal(string(quote(property.Name))), value)
+	}
+
+	// next holds the members that follow one already written, so each has a leading comma
+	next := make([]string, len(members)+1)
+	for i := len(members) - 1; i >= 0; i-- {
+		member := fmt.Sprintf(`ws "," ws %s`, members[i])
+		if !s.Properties[i].Required {
+			member = fmt.Sprintf("( %s )?", member)
+		}
+		next[i] = strings.TrimSpace(member + " " + next[i+1])
+	}
+
+	// first holds the members from i on when none has been written yet
+	first := ""
+	for i := len(members) - 1; i >= 0; i-- {
+		member := strings.TrimSpace(members[i] + " " + next[i+1])
+		switch {
+		case s.Properties[i].Required:
+			first = member
+		case first == "":
+			first = fmt.Sprintf("( %s )?", member)
+		default:
+			first = fmt.Sprintf("( %s | %s )", member, first)
+		}
+	}
+
+	return b.add(name, fmt.Sprintf(`"{" ws %s ws "}"`, first))
+}
+
+// array defines the rule for an array whose items follow the items schema of s
+func (b *builder) array(name string, s *schema.Schema) string {
+	if s.Items == nil {
+		return b.primitive("array")
+	}
+	b.primitive("ws")
+
+	item := b.schema(name+"-item", s.Items)
+	return b.add(name, fmt.Sprintf(`"[" ws ( %s ( ws "," ws %s )* )? ws "]"`, item, item))
+}
+
+// ruleName turns a name into a valid rule name
+func ruleName(name string) string {
+	name = strings.Trim(invalidRuleChars.ReplaceAllString(name, "-"), "-")
+	if name == "" {
+		return "x"
+	}
+	return name
+}
+
+// literal renders text as a GBNF string literal
+func literal(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + replacer.Replace(text) + `"`
+}
+
+// quote encodes a string as JSON without escaping HTML characters
+func quote(s string) []byte {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
@@ -0,0 +1,160 @@
+package grammar
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+var weatherTool = mcp.Tool{
+	Name: "get_weather",
+	InputSchema: mcp.ToolSchema{
+		SchemaType: "object",
+		Properties: cm.ToList([][2]string{
+			{"city", `{"type": "string"}`},
+			{"unit", `{"type": "string", "enum": ["celsius", "fahrenheit"]}`},
+		}),
+		Required: cm.ToList([]string{"city"}),
+	},
+}
+
+// ruleReference matches rule names outside of literals and character classes
+var ruleReference = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9-]*`)
+
+// checkRules fails when a rule is referenced but never defined
+func checkRules(t *testing.T, gbnf string) {
+	t.Helper()
+
+	stripLiterals := regexp.MustCompile(`"(\\.|[^"\\])*"|\[(\\.|[^\]\\])*\]`)
+	defined := map[string]bool{}
+	var exprs []string
+	for _, line := range strings.Split(strings.TrimSpace(gbnf), "\n") {
+		name, expr, ok := strings.Cut(line, " ::= ")
+		if !ok {
+			t.Fatalf("Invalid rule %q", line)
+		}
+		defined[name] = true
+		exprs = append(exprs, stripLiterals.ReplaceAllString(expr, ""))
+	}
+	for _, expr := range exprs {
+		for _, name := range ruleReference.FindAllString(expr, -1) {
+			if !defined[name] {
+				t.Errorf("Rule %s is referenced but not defined in\n%s", name, gbnf)
+			}
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	gbnf, err := Schema([]byte(`{"type": "object", "properties": {"answer": {"type": "string"}, "confidence": {"type": "number"}}, "required": ["answer"]}`))
+	if err != nil {
+		t.Fatalf("Schema failed: %v", err)
+	}
+
+	expected := `root ::= output
+ws ::= [ \t\n]*
+string ::= "\"" char* "\""
+char ::= [^"\\\x7F\x00-\x1F] | "\\" ( ["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] )
+number ::= "-"? integral ( "." [0-9]+ )? ( [eE] [-+]? [0-9]+ )?
+integral ::= "0" | [1-9] [0-9]*
+output ::= "{" ws "\"answer\"" ws ":" ws string ( ws "," ws "\"confidence\"" ws ":" ws number )? ws "}"
+`
+	if gbnf != expected {
+		t.Errorf("Schema() =\n%s\nwant\n%s", gbnf, expected)
+	}
+	checkRules(t, gbnf)
+}
+
+func TestSchema_Keywords(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   string
+	}{
+		{name: "enum", schema: `{"enum": ["a", 1, null]}`, want: `output ::= "\"a\"" | "1" | "null"`},
+		{name: "typed items", schema: `{"type": "array", "items": {"type": "integer"}}`, want: `output ::= "[" ws ( integer ( ws "," ws integer )* )? ws "]"`},
+		{name: "nullable", schema: `{"type": ["string", "null"]}`, want: `output ::= string | null`},
+		{name: "optional first", schema: `{"type": "object", "properties": {"a": {"type": "boolean"}, "b": {"type": "null"}}}`, want: `( "\"a\"" ws ":" ws boolean ( ws "," ws "\"b\"" ws ":" ws null )? | ( "\"b\"" ws ":" ws null )? )`},
+		{name: "untyped", schema: `{"description": "anything"}`, want: `root ::= value`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gbnf, err := Schema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("Schema failed: %v", err)
+			}
+			if !strings.Contains(gbnf, tt.want) {
+				t.Errorf("Expected %q in\n%s", tt.want, gbnf)
+			}
+			checkRules(t, gbnf)
+		})
+	}
+
+	if _, err := Schema([]byte(`{"type": `)); err == nil {
+		t.Error("Expected an error for an invalid schema")
+	}
+}
+
+func TestTools(t *testing.T) {
+	noArgs := mcp.Tool{Name: "get.time"}
+
+	tests := []struct {
+		syntax Syntax
+		want   string
+	}{
+		{syntax: Hermes, want: `get-weather-call ::= "<tool_call>" ws "{" ws "\"name\"" ws ":" ws "\"get_weather\"" ws "," ws "\"arguments\"" ws ":" ws get-weather-arguments ws "}" ws "</tool_call>"`},
+		{syntax: Llama3, want: `get-weather-call ::= "<function=get_weather>" get-weather-arguments "</function>"`},
+		{syntax: Mistral, want: `tool-calls ::= "[TOOL_CALLS]" ws "[" ws call ( ws "," ws call )* ws "]"`},
+		{syntax: Gemma, want: "get-time-call ::= \"```tool_code\" ws"},
+		{syntax: DeepSeek, want: `get-weather-call ::= "<｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n` + "```json" + `\n" get-weather-arguments`},
+		{syntax: Harmony, want: `get-time-call ::= "<|channel|>commentary to=functions.get.time <|constrain|>json<|message|>" object "<|call|>"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.syntax.Name, func(t *testing.T) {
+			gbnf, err := Tools([]mcp.Tool{weatherTool, noArgs}, tt.syntax)
+			if err != nil {
+				t.Fatalf("Tools failed: %v", err)
+			}
+			if !strings.Contains(gbnf, tt.want) {
+				t.Errorf("Expected %q in\n%s", tt.want, gbnf)
+			}
+			if !strings.Contains(gbnf, `get-weather-arguments ::= "{" ws "\"city\"" ws ":" ws string ( ws "," ws "\"unit\"" ws ":" ws get-weather-arguments-unit )? ws "}"`) {
+				t.Errorf("Expected the arguments to follow the input schema in\n%s", gbnf)
+			}
+			checkRules(t, gbnf)
+		})
+	}
+
+	if _, err := Tools([]mcp.Tool{weatherTool}, Syntax{}); err == nil {
+		t.Error("Expected an error without a syntax")
+	}
+}
+
+func TestNew(t *testing.T) {
+	grammar, err := New(Config{Tools: []mcp.Tool{weatherTool}, Syntax: Hermes})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if grammar.Trigger != "<tool_call>" {
+		t.Errorf("Expected the tool grammar to be triggered by <tool_call>, got %q", grammar.Trigger)
+	}
+
+	// With an output schema the model either calls tools or answers, from the first token on
+	grammar, err = New(Config{Tools: []mcp.Tool{weatherTool}, Syntax: Hermes, Output: []byte(`{"type": "string"}`)})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if grammar.Trigger != "" || !strings.HasPrefix(grammar.GBNF, "root ::= tool-calls | string\n") {
+		t.Errorf("Expected an untriggered grammar for calls or the answer, got %q\n%s", grammar.Trigger, grammar.GBNF)
+	}
+	checkRules(t, grammar.GBNF)
+
+	if _, err := New(Config{}); err == nil {
+		t.Error("Expected an error for an empty config")
+	}
+}
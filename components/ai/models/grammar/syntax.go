@@ -0,0 +1,91 @@
+package grammar
+
+import "fmt"
+
+// Syntax is the way a prompt format writes tool calls, every format declares its own
+type Syntax struct {
+	// Name identifies the syntax
+	Name string
+	// Trigger starts a block of tool calls in the model output
+	Trigger string
+	// call returns the expression of a single call given the tool name and the rule of its
+	// arguments
+	call func(name, args string) string
+	// block returns the expression of a block of calls given the rule matching one call
+	block func(call string) string
+}
+
+var (
+	// Hermes writes every call as <tool_call>{"name": ..., "arguments": {...}}</tool_call>, used by
+	// the Qwen formats and most chat templates
+	Hermes = Syntax{
+		Name:    "hermes",
+		Trigger: "<tool_call>",
+		call: func(name, args string) string {
+			return fmt.Sprintf(`"<tool_call>" ws %s ws "</tool_call>"`, jsonCall(name, args))
+		},
+		block: repeated,
+	}
+
+	// Llama3 writes a single custom function call as <function=name>{...}</function>
+	Llama3 = Syntax{
+		Name:    "llama3",
+		Trigger: "<function=",
+		call: func(name, args string) string {
+			return fmt.Sprintf(`%s %s %s`, literal("<function="+name+">"), args, literal("</function>"))
+		},
+		block: func(call string) string { return call },
+	}
+
+	// Mistral writes a JSON array of calls after [TOOL_CALLS]
+	Mistral = Syntax{
+		Name:    "mistral",
+		Trigger: "[TOOL_CALLS]",
+		call:    jsonCall,
+		block: func(call string) string {
+			return fmt.Sprintf(`"[TOOL_CALLS]" ws "[" ws %s ( ws "," ws %s )* ws "]"`, call, call)
+		},
+	}
+
+	// Gemma writes every call as a ```tool_code fenced JSON object
+	Gemma = Syntax{
+		Name:    "gemma",
+		Trigger: "```tool_code",
+		call: func(name, args string) string {
+			return fmt.Sprintf(`%s ws %s ws %s`, literal("```tool_code"), jsonCall(name, args), literal("```"))
+		},
+		block: repeated,
+	}
+
+	// DeepSeek writes the calls between tool call tokens with the arguments in a json fence
+	DeepSeek = Syntax{
+		Name:    "deepseek",
+		Trigger: "<｜tool▁calls▁begin｜>",
+		call: func(name, args string) string {
+			return fmt.Sprintf(`%s %s %s`, literal("<｜tool▁call▁begin｜>function<｜tool▁sep｜>"+name+"\n```json\n"), args, literal("\n```<｜tool▁call▁end｜>"))
+		},
+		block: func(call string) string {
+			return fmt.Sprintf(`%s %s+ %s`, literal("<｜tool▁calls▁begin｜>"), call, literal("<｜tool▁calls▁end｜>"))
+		},
+	}
+
+	// Harmony sends every call as a commentary message to the functions namespace
+	Harmony = Syntax{
+		Name:    "harmony",
+		Trigger: "<|channel|>commentary to=functions.",
+		call: func(name, args string) string {
+			return fmt.Sprintf(`%s %s %s`, literal("<|channel|>commentary to=functions."+name+" <|constrain|>json<|message|>"), args, literal("<|call|>"))
+		},
+		block: func(call string) string { return call },
+	}
+)
+
+// jsonCall returns the expression of a {"name": ..., "arguments": {...}} object
+func jsonCall(name, args string) string {
+	return fmt.Sprintf(`"{" ws "\"name\"" ws ":" ws %s ws "," ws "\"arguments\"" ws ":" ws %s ws "}"`, literal(string(quote(name))), args)
+}
+
+// repeated matches one or more calls separated by whitespace
+func repeated(call string) string {
+	return fmt.Sprintf(`%s ( ws %s )*`, call, call)
+}
@@ -0,0 +1,406 @@
+package harmony
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+const (
+	// Harmony special tokens
+	start     = "<|start|>"
+	end       = "<|end|>"
+	message   = "<|message|>"
+	channel   = "<|channel|>"
+	constrain = "<|constrain|>"
+	call      = "<|call|>"
+	ret       = "<|return|>"
+
+	// Channels an assistant message can be sent on
+	analysisChannel   = "analysis"
+	commentaryChannel = "commentary"
+	finalChannel      = "final"
+
+	// Function tools live in the functions namespace and are addressed as functions.name
+	functionsNamespace = "functions"
+	recipientPrefix    = "to="
+
+	modelIdentity   = "You are ChatGPT, a large language model trained by OpenAI."
+	knowledgeCutoff = "2024-06"
+)
+
+// Reasoning effort levels understood by gpt-oss
+const (
+	ReasoningLow    = "low"
+	ReasoningMedium = "medium"
+	ReasoningHigh   = "high"
+)
+
+var _ models.Format = (*harmony)(nil)
+var _ format.Format = (*harmony)(nil)
+
+// StopSequences returns the tokens that end the output of the harmony format. <|end|> only
+// closes a message and the model goes on with the next one, <|call|> hands over to a tool and
+// <|return|> ends the answer.
+func StopSequences() []string {
+	return []string{call, ret}
+}
+
+// Config holds the settings rendered in the harmony system message
+type Config struct {
+	// ReasoningEffort is one of low, medium or high, medium when empty
+	ReasoningEffort string
+	// CurrentDate is rendered as the current date when set, e.g. 2025-06-28
+	CurrentDate string
+}
+
+// New returns a harmony format for the given config
+func New(config Config) (models.Format, error) {
+	switch config.ReasoningEffort {
+	case "":
+		config.ReasoningEffort = ReasoningMedium
+	case ReasoningLow, ReasoningMedium, ReasoningHigh:
+	default:
+		return nil, fmt.Errorf("unsupported reasoning effort: %s", config.ReasoningEffort)
+	}
+	return newHarmony(config), nil
+}
+
+func ConstructorGptOss() (models.Format, error) {
+	return New(Config{})
+}
+
+type harmony struct {
+	format.Codec
+	config Config
+}
+
+func newHarmony(config Config) *harmony {
+	m := &harmony{config: config}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.Harmony,
+		Stops:          StopSequences(),
+		Calls:          grammar.Harmony,
+	}
+	return m
+}
+
+// segment is a single harmony message in the model output
+type segment struct {
+	channel   string
+	recipient string
+	body      string
+	// terminator is the token that closed the message, empty while it is still generated
+	terminator string
+}
+
+// decode parses the messages generated after the <|start|>assistant generation prompt in the
+// order they were written. The analysis channel is returned as reasoning items, see
+// reasoning.Text, commentary preambles and the final answer as text and function calls as
+// tool inputs. The message is final once the final channel starts.
+func (m *harmony) decode(content string) (*ai.Message, error) {
+
+	// A model that skipped the harmony headers answered in plain text
+	if !strings.Contains(content, "<|") {
+		if strings.TrimSpace(content) == "" {
+			return nil, &models.PartialDecodeError{}
+		}
+		return &ai.Message{
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text(strings.TrimSpace(content)))}),
+			Final:   true,
+		}, nil
+	}
+
+	var contents []ai.MessageContent
+	final := false
+	calls := 0
+	for _, seg := range parseSegments(content) {
+		if seg.recipient != "" && seg.recipient != "assistant" {
+			if seg.terminator == "" {
+				return nil, &models.PartialDecodeError{}
+			}
+
+			args, err := arguments.Decode([]byte(seg.body))
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, ai.NewMessageContent(mcp.CallToolParams{
+				Name:      strings.TrimPrefix(seg.recipient, functionsNamespace+"."),
+				Arguments: cm.ToList(args),
+			}))
+			calls++
+			continue
+		}
+
+		text := strings.TrimSpace(seg.body)
+		switch seg.channel {
+		case analysisChannel:
+			if text != "" {
+				contents = append(contents, reasoning.Text(text))
+			}
+		case commentaryChannel:
+			if text != "" {
+				contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+			}
+		default:
+			contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+			final = true
+		}
+	}
+
+	if len(contents) == 0 {
+		return nil, &models.PartialDecodeError{}
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(contents),
+		Final:   final && calls == 0,
+	}, nil
+}
+
+// parseSegments splits model output into harmony messages. A message whose header is still
+// being generated is left out.
+func parseSegments(content string) []segment {
+	var segments []segment
+	for content != "" {
+		content = strings.TrimPrefix(strings.TrimSpace(content), start)
+
+		index := strings.Index(content, message)
+		if index == -1 {
+			break
+		}
+		seg := parseHeader(content[:index])
+		content = content[index+len(message):]
+
+		terminator, next := "", len(content)
+		for _, token := range []string{end, call, ret} {
+			if i := strings.Index(content, token); i != -1 && i < next {
+				terminator, next = token, i
+			}
+		}
+		seg.body = content[:next]
+		seg.terminator = terminator
+		segments = append(segments, seg)
+
+		if terminator == "" {
+			break
+		}
+		content = content[next+len(terminator):]
+	}
+	return segments
+}
+
+// parseHeader parses a message header such as
+//
+//	assistant<|channel|>commentary to=functions.get_weather <|constrain|>json
+//
+// the recipient may also be placed before the channel.
+func parseHeader(header string) segment {
+	var seg segment
+	header = strings.ReplaceAll(header, constrain, " ")
+
+	if i := strings.Index(header, channel); i != -1 {
+		fields := strings.Fields(header[i+len(channel):])
+		if len(fields) > 0 {
+			seg.channel = fields[0]
+		}
+	}
+
+	header = strings.ReplaceAll(header, channel, " ")
+	for _, field := range strings.Fields(header) {
+		if strings.HasPrefix(field, recipientPrefix) {
+			seg.recipient = strings.TrimPrefix(field, recipientPrefix)
+		}
+	}
+	return seg
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *harmony) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	builder := &strings.Builder{}
+
+	choice, prefill := opts.ToolChoice, ""
+
+	var instructions []string
+	var tools []mcp.Tool
+	for _, msg := range messages {
+		if msg.Role != ai.RoleSystem {
+			continue
+		}
+		for _, content := range msg.Content.Slice() {
+			switch content.String() {
+			case "text":
+				instructions = append(instructions, *content.Text())
+			case "tools":
+				tools = append(tools, content.Tools().Slice()...)
+			}
+		}
+	}
+
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+	if instruction := choice.Instruction(); instruction != "" {
+		instructions = append(instructions, instruction)
+	}
+
+	builder.WriteString(m.systemMessage(len(tools) > 0))
+	if developer := developerMessage(strings.Join(instructions, "\n\n"), tools); developer != "" {
+		builder.WriteString(developer)
+	}
+
+	// Tool results are addressed from the function that was called, in call order
+	var pending []string
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			// Rendered in the developer message
+
+		case ai.RoleUser:
+			builder.WriteString(fmt.Sprintf("%suser%s", start, message))
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "text" {
+					builder.WriteString(*content.Text())
+				}
+			}
+			builder.WriteString(end)
+
+		case ai.RoleAssistant:
+			// Reasoning that led to tool calls is kept as the analysis channel, reasoning of a
+			// finished turn is dropped. Other text is a commentary preamble when the message
+			// calls tools and the final answer otherwise.
+			items := msg.Content.Slice()
+			hasCalls := false
+			for _, content := range items {
+				if content.String() == "tool-input" {
+					hasCalls = true
+				}
+			}
+
+			for _, content := range items {
+				switch content.String() {
+				case "text":
+					text := *content.Text()
+					thought, isReasoning := reasoning.Cut(text)
+					switch {
+					case isReasoning && hasCalls:
+						builder.WriteString(fmt.Sprintf("%sassistant%s%s%s%s%s", start, channel, analysisChannel, message, thought, end))
+					case isReasoning:
+						// dropped with the finished turn
+					case hasCalls:
+						builder.WriteString(fmt.Sprintf("%sassistant%s%s%s%s%s", start, channel, commentaryChannel, message, text, end))
+					default:
+						builder.WriteString(fmt.Sprintf("%sassistant%s%s%s%s%s", start, channel, finalChannel, message, text, end))
+					}
+				case "tool-input":
+					c := content.ToolInput()
+					builder.WriteString(fmt.Sprintf("%sassistant%s%s %s%s.%s %sjson%s%s%s",
+						start, channel, commentaryChannel, recipientPrefix, functionsNamespace, c.Name,
+						constrain, message, arguments.EncodeCall(tools, *c), call))
+					pending = append(pending, c.Name)
+				}
+			}
+
+		case ai.RoleTool:
+			for _, content := range msg.Content.Slice() {
+				var output string
+				switch content.String() {
+				case "tool-output":
+					output = toolresult.Text(content.ToolOutput(), "")
+				case "text":
+					output = *content.Text()
+				default:
+					continue
+				}
+
+				if len(pending) == 0 {
+					return nil, fmt.Errorf("tool message %d does not follow a tool call", i)
+				}
+				name := pending[0]
+				pending = pending[1:]
+
+				builder.WriteString(fmt.Sprintf("%s%s.%s %sassistant%s%s%s%s%s",
+					start, functionsNamespace, name, recipientPrefix, channel, commentaryChannel, message, output, end))
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported message role: %v", msg.Role)
+		}
+	}
+
+	// Add generation prompt if the last message is not from the assistant
+	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
+		builder.WriteString(fmt.Sprintf("%sassistant", start))
+		prefill = toolCallPrefill(choice)
+		builder.WriteString(prefill)
+	}
+
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
+}
+
+// toolCallPrefill returns the header of a commentary message to the functions namespace written
+// after the generation prompt when the choice requires a call, addressed to the tool when a
+// specific tool is required
+func toolCallPrefill(choice toolchoice.Choice) string {
+	switch choice.Mode {
+	case toolchoice.Required:
+		return fmt.Sprintf("%s%s %s%s.", channel, commentaryChannel, recipientPrefix, functionsNamespace)
+	case toolchoice.Function:
+		return fmt.Sprintf("%s%s %s%s.%s %sjson%s", channel, commentaryChannel, recipientPrefix, functionsNamespace, choice.Name, constrain, message)
+	}
+	return ""
+}
+
+// systemMessage renders the harmony system message with the reasoning effort and channels
+func (m *harmony) systemMessage(hasTools bool) string {
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%ssystem%s%s\nKnowledge cutoff: %s\n", start, message, modelIdentity, knowledgeCutoff))
+	if m.config.CurrentDate != "" {
+		builder.WriteString(fmt.Sprintf("Current date: %s\n", m.config.CurrentDate))
+	}
+	builder.WriteString(fmt.Sprintf("\nReasoning: %s\n\n", m.config.ReasoningEffort))
+	builder.WriteString(fmt.Sprintf("# Valid channels: %s, %s, %s. Channel must be included for every message.", analysisChannel, commentaryChannel, finalChannel))
+	if hasTools {
+		builder.WriteString(fmt.Sprintf("\nCalls to these tools must go to the %s channel: '%s'.", commentaryChannel, functionsNamespace))
+	}
+	builder.WriteString(end)
+	return builder.String()
+}
+
+// developerMessage renders the instructions and the functions namespace, it is empty when
+// there is neither
+func developerMessage(instructions string, tools []mcp.Tool) string {
+	if instructions == "" && len(tools) == 0 {
+		return ""
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("%sdeveloper%s", start, message))
+	if instructions != "" {
+		builder.WriteString(fmt.Sprintf("# Instructions\n\n%s", instructions))
+		if len(tools) > 0 {
+			builder.WriteString("\n\n")
+		}
+	}
+	if len(tools) > 0 {
+		builder.WriteString(functionsNamespaceDeclaration(tools))
+	}
+	builder.WriteString(end)
+	return builder.String()
+}
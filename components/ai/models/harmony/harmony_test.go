@@ -0,0 +1,366 @@
+package harmony
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"go.bytecodealliance.org/cm"
+)
+
+var weatherTool = mcp.Tool{
+	Name:        "get_weather",
+	Description: "Gets the current weather in the provided location.",
+	InputSchema: mcp.ToolSchema{
+		SchemaType: "object",
+		Properties: cm.ToList([][2]string{
+			{"location", `{"type": "string", "description": "The city and state, e.g. San Francisco, CA"}`},
+			{"format", `{"type": "string", "enum": ["celsius", "fahrenheit"], "default": "celsius"}`},
+		}),
+		Required: cm.ToList([]string{"location"}),
+	},
+}
+
+func TestEncode_ToolConversation(t *testing.T) {
+	model, err := New(Config{ReasoningEffort: ReasoningHigh, CurrentDate: "2025-06-28"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Use a friendly tone.")),
+				ai.NewMessageContent(cm.ToList([]mcp.Tool{weatherTool, {Name: "get_location", Description: "Gets the location of the user."}})),
+			}),
+		},
+		{
+			Role:    ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("What is the weather like in SF?"))}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				reasoning.Text("Need to use function get_weather."),
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "get_weather",
+					Arguments: cm.ToList([][2]string{{"location", "San Francisco, CA"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: `{"sunny": true, "temperature": 20}`})}),
+				}),
+			}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|start|>system<|message|>You are ChatGPT, a large language model trained by OpenAI.\n" +
+		"Knowledge cutoff: 2024-06\n" +
+		"Current date: 2025-06-28\n\n" +
+		"Reasoning: high\n\n" +
+		"# Valid channels: analysis, commentary, final. Channel must be included for every message.\n" +
+		"Calls to these tools must go to the commentary channel: 'functions'.<|end|>" +
+		"<|start|>developer<|message|># Instructions\n\nUse a friendly tone.\n\n" +
+		"# Tools\n\n## functions\n\nnamespace functions {\n\n" +
+		"// Gets the current weather in the provided location.\n" +
+		"type get_weather = (_: {\n" +
+		"// The city and state, e.g. San Francisco, CA\n" +
+		"location: string,\n" +
+		"format?: \"celsius\" | \"fahrenheit\", // default: celsius\n" +
+		"}) => any;\n\n" +
+		"// Gets the location of the user.\n" +
+		"type get_location = () => any;\n\n" +
+		"} // namespace functions<|end|>" +
+		"<|start|>user<|message|>What is the weather like in SF?<|end|>" +
+		"<|start|>assistant<|channel|>analysis<|message|>Need to use function get_weather.<|end|>" +
+		"<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":\"San Francisco, CA\"}<|call|>" +
+		"<|start|>functions.get_weather to=assistant<|channel|>commentary<|message|>{\"sunny\": true, \"temperature\": 20}<|end|>" +
+		"<|start|>assistant"
+
+	if string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, string(encoded))
+	}
+}
+
+func TestEncode_DropsEarlierReasoning(t *testing.T) {
+	model, _ := ConstructorGptOss()
+
+	encoded, err := model.Encode(
+		ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})},
+		ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{
+			reasoning.Text("The user greets me."),
+			ai.NewMessageContent(ai.Text("Hello!")),
+		})},
+		ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Bye"))})},
+	)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|start|>system<|message|>You are ChatGPT, a large language model trained by OpenAI.\n" +
+		"Knowledge cutoff: 2024-06\n\n" +
+		"Reasoning: medium\n\n" +
+		"# Valid channels: analysis, commentary, final. Channel must be included for every message.<|end|>" +
+		"<|start|>user<|message|>Hi<|end|>" +
+		"<|start|>assistant<|channel|>final<|message|>Hello!<|end|>" +
+		"<|start|>user<|message|>Bye<|end|>" +
+		"<|start|>assistant"
+
+	if string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, string(encoded))
+	}
+}
+
+func TestNew_InvalidReasoningEffort(t *testing.T) {
+	if _, err := New(Config{ReasoningEffort: "extreme"}); err == nil {
+		t.Error("expected an error for an unsupported reasoning effort")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type call struct {
+		name string
+		args [][2]string
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		wantTexts []string
+		wantCalls []call
+		wantFinal bool
+	}{
+		{
+			name:      "analysis then final answer",
+			input:     "<|channel|>analysis<|message|>User asks a simple question.<|end|><|start|>assistant<|channel|>final<|message|>2 + 2 = 4.<|return|>",
+			wantTexts: []string{"<think>\nUser asks a simple question.\n</think>", "2 + 2 = 4."},
+			wantFinal: true,
+		},
+		{
+			name:      "analysis then tool call",
+			input:     "<|channel|>analysis<|message|>Need the weather.<|end|><|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":\"SF\",\"days\":2}<|call|>",
+			wantTexts: []string{"<think>\nNeed the weather.\n</think>"},
+			wantCalls: []call{{name: "get_weather", args: [][2]string{{"location", "SF"}, {"days", "2"}}}},
+		},
+		{
+			name:      "recipient before the channel",
+			input:     "<|start|>assistant to=functions.get_location<|channel|>commentary json<|message|>{}<|call|>",
+			wantCalls: []call{{name: "get_location"}},
+		},
+		{
+			name:      "commentary preamble before tool call",
+			input:     "<|channel|>commentary<|message|>Let me check.<|end|><|start|>assistant<|channel|>commentary to=functions.get_location <|constrain|>json<|message|>{}<|call|>",
+			wantTexts: []string{"Let me check."},
+			wantCalls: []call{{name: "get_location"}},
+		},
+		{
+			name:      "streaming analysis",
+			input:     "<|channel|>analysis<|message|>Thinking about",
+			wantTexts: []string{"<think>\nThinking about\n</think>"},
+		},
+		{
+			name:      "streaming final answer",
+			input:     "<|channel|>analysis<|message|>Easy.<|end|><|start|>assistant<|channel|>final<|message|>The answer",
+			wantTexts: []string{"<think>\nEasy.\n</think>", "The answer"},
+			wantFinal: true,
+		},
+		{
+			name:      "plain text without headers",
+			input:     "Hello there",
+			wantTexts: []string{"Hello there"},
+			wantFinal: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, _ := ConstructorGptOss()
+			msg, err := model.Decode([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+
+			var texts []string
+			var calls []*mcp.CallToolParams
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					texts = append(texts, *content.Text())
+				case "tool-input":
+					calls = append(calls, content.ToolInput())
+				}
+			}
+
+			if len(texts) != len(tt.wantTexts) {
+				t.Fatalf("Expected texts %q, got %q", tt.wantTexts, texts)
+			}
+			for i := range texts {
+				if texts[i] != tt.wantTexts[i] {
+					t.Errorf("Expected text %q, got %q", tt.wantTexts[i], texts[i])
+				}
+			}
+
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("Expected %d tool calls, got %d", len(tt.wantCalls), len(calls))
+			}
+			for i, c := range calls {
+				if c.Name != tt.wantCalls[i].name {
+					t.Errorf("Expected tool name %q, got %q", tt.wantCalls[i].name, c.Name)
+				}
+				args := c.Arguments.Slice()
+				if len(args) != len(tt.wantCalls[i].args) {
+					t.Fatalf("Expected arguments %q, got %q", tt.wantCalls[i].args, args)
+				}
+				for j := range args {
+					if args[j] != tt.wantCalls[i].args[j] {
+						t.Errorf("Expected argument %q, got %q", tt.wantCalls[i].args[j], args[j])
+					}
+				}
+			}
+
+			if msg.Final != tt.wantFinal {
+				t.Errorf("Expected Final %v, got %v", tt.wantFinal, msg.Final)
+			}
+		})
+	}
+}
+
+func TestDecode_Partial(t *testing.T) {
+	inputs := []string{
+		"",
+		"<|channel|>anal",
+		"<|channel|>analysis<|message|>",
+		"<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{\"location\":",
+	}
+
+	for _, input := range inputs {
+		model, _ := ConstructorGptOss()
+		_, err := model.Decode([]byte(input))
+		var partial *models.PartialDecodeError
+		if !errors.As(err, &partial) {
+			t.Errorf("Decode(%q) expected a partial decode error, got %v", input, err)
+		}
+	}
+}
+
+func TestFunctionsNamespace_NestedSchema(t *testing.T) {
+	tool := mcp.Tool{
+		Name:        "search",
+		Description: "Search documents.\nReturns the best matches.",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{
+				{"filter", `{"type": "object", "properties": {"tags": {"type": "array", "items": {"type": "string"}}, "limit": {"type": ["integer", "null"]}}, "required": ["tags"]}`},
+				{"ids", `{"type": "array", "items": {"enum": [1, 2]}}`},
+				{"query", `plain description`},
+			}),
+			Required: cm.ToList([]string{"query"}),
+		},
+	}
+
+	expected := "# Tools\n\n## functions\n\nnamespace functions {\n\n" +
+		"// Search documents.\n" +
+		"// Returns the best matches.\n" +
+		"type search = (_: {\n" +
+		"filter?: {\n" +
+		"tags: string[],\n" +
+		"limit?: number | null,\n" +
+		"},\n" +
+		"ids?: (1 | 2)[],\n" +
+		"// plain description\n" +
+		"query: string,\n" +
+		"}) => any;\n\n" +
+		"} // namespace functions"
+
+	if got := functionsNamespaceDeclaration([]mcp.Tool{tool}); got != expected {
+		t.Errorf("Unexpected declaration\nwant: %q\ngot:  %q", expected, got)
+	}
+}
+
+func TestEncode_ToolChoice(t *testing.T) {
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(cm.ToList([]mcp.Tool{weatherTool}))})},
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Tokyo?"))})},
+	}
+
+	f, err := New(Config{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	model := f.(format.Format)
+	prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.Function, Name: "get_weather"}}, messages...)
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+
+	wantSuffix := "<|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>"
+	if !strings.HasSuffix(string(prompt.Data), wantSuffix) {
+		t.Errorf("Expected the prompt to end with %q, got %q", wantSuffix, prompt.Data)
+	}
+
+	msg, err := model.DecodePrompt(prompt, []byte(`{"location": "Tokyo"}<|call|>`))
+	if err != nil {
+		t.Fatalf("DecodePrompt failed: %v", err)
+	}
+	if call := msg.Content.Slice()[0].ToolInput(); call == nil || call.Name != "get_weather" {
+		t.Errorf("Expected the get_weather call, got %v", msg.Content.Slice())
+	}
+
+	// Without tools the model must not call any
+	prompt, err = model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.None}}, messages...)
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+	if strings.Contains(string(prompt.Data), "namespace functions") {
+		t.Errorf("Expected no tools in the prompt, got %q", prompt.Data)
+	}
+	if _, err := model.DecodePrompt(prompt, []byte("<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{}<|call|>")); err == nil {
+		t.Error("Expected an error for a tool call when tools are disabled")
+	}
+
+	// The prompt of one request does not change how the output of another is read
+	if _, err := model.Decode([]byte("<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>{}<|call|>")); err != nil {
+		t.Errorf("Expected the call to be accepted without a tool choice, got %v", err)
+	}
+}
+
+func TestDecode_RoundTrip(t *testing.T) {
+	model, _ := ConstructorGptOss()
+
+	output := "<|channel|>analysis<|message|>Need the location.<|end|>" +
+		"<|start|>assistant<|channel|>commentary<|message|>Let me check.<|end|>" +
+		"<|start|>assistant<|channel|>commentary to=functions.get_location <|constrain|>json<|message|>{}<|call|>"
+	msg, err := model.Decode([]byte(output))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	encoded, err := model.Encode(
+		ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Where am I?"))})},
+		*msg,
+	)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// The reasoning and the preamble written before the call are both kept
+	if want := "<|start|>assistant" + output; !strings.Contains(string(encoded), want) {
+		t.Errorf("Expected the decoded turn %q in the prompt, got %q", want, encoded)
+	}
+}
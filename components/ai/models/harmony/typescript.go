@@ -0,0 +1,125 @@
+package harmony
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+)
+
+// functionsNamespaceDeclaration renders the tools as TypeScript-style declarations in the
+// functions namespace, the way gpt-oss was trained to read them:
+//
+//	namespace functions {
+//
+//	// Gets the weather
+//	type get_weather = (_: {
+//	// The city name
+//	city: string,
+//	unit?: "celsius" | "fahrenheit", // default: celsius
+//	}) => any;
+//
+//	} // namespace functions
+func functionsNamespaceDeclaration(tools []mcp.Tool) string {
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("# Tools\n\n## %s\n\nnamespace %s {\n\n", functionsNamespace, functionsNamespace))
+
+	for _, tool := range tools {
+		writeComment(builder, tool.Description)
+
+		parameters := schema.FromTool(tool)
+		if len(parameters.Properties) == 0 {
+			builder.WriteString(fmt.Sprintf("type %s = () => any;\n\n", tool.Name))
+			continue
+		}
+
+		builder.WriteString(fmt.Sprintf("type %s = (_: {\n", tool.Name))
+		writeProperties(builder, parameters.Properties)
+		builder.WriteString("}) => any;\n\n")
+	}
+
+	builder.WriteString(fmt.Sprintf("} // namespace %s", functionsNamespace))
+	return builder.String()
+}
+
+// writeProperties writes one field per property, optional properties are marked with ?
+func writeProperties(builder *strings.Builder, properties []schema.Property) {
+	for _, property := range properties {
+		writeComment(builder, property.Schema.Description)
+
+		optional := "?"
+		if property.Required {
+			optional = ""
+		}
+		builder.WriteString(fmt.Sprintf("%s%s: %s,", property.Name, optional, typeScriptType(property.Schema)))
+
+		if len(property.Schema.Default) > 0 {
+			builder.WriteString(fmt.Sprintf(" // default: %s", strings.Trim(string(property.Schema.Default), `"`)))
+		}
+		builder.WriteString("\n")
+	}
+}
+
+// typeScriptType converts a JSON schema into a TypeScript type
+func typeScriptType(s *schema.Schema) string {
+	if len(s.Enum) > 0 {
+		values := make([]string, len(s.Enum))
+		for i, value := range s.Enum {
+			values[i] = string(value)
+		}
+		return strings.Join(values, " | ")
+	}
+
+	if len(s.Types) > 1 {
+		types := make([]string, len(s.Types))
+		for i, t := range s.Types {
+			if t == "null" {
+				types[i] = "null"
+				continue
+			}
+			types[i] = typeScriptType(&schema.Schema{Types: []string{t}, Properties: s.Properties, Items: s.Items})
+		}
+		return strings.Join(types, " | ")
+	}
+
+	switch s.Type() {
+	case "string":
+		return "string"
+	case "number", "integer":
+		return "number"
+	case "boolean":
+		return "boolean"
+	case "array":
+		if s.Items == nil {
+			return "any[]"
+		}
+		items := typeScriptType(s.Items)
+		if strings.Contains(items, " | ") {
+			items = fmt.Sprintf("(%s)", items)
+		}
+		return items + "[]"
+	case "object":
+		if len(s.Properties) == 0 {
+			return "object"
+		}
+		builder := &strings.Builder{}
+		builder.WriteString("{\n")
+		writeProperties(builder, s.Properties)
+		builder.WriteString("}")
+		return builder.String()
+	default:
+		return "any"
+	}
+}
+
+// writeComment writes text as // comment lines
+func writeComment(builder *strings.Builder, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		builder.WriteString(fmt.Sprintf("// %s\n", line))
+	}
+}
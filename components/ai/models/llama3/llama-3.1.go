@@ -1,28 +1,39 @@
 package llama3
 
 import (
-	"encoding/json"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/hayride-dev/bindings/go/hayride/ai"
 	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"github.com/hayride-dev/morphs/components/ai/models/vision"
 	"go.bytecodealliance.org/cm"
 )
 
 var (
-	// Define a regular expression to match the function name and the parameter list that may appear anywhere in the input string
-	// Example: [func_name1(params_name1=params_value1, params_name2=params_value2)]
-	parseFunc = regexp.MustCompile(`\[([a-zA-Z_][a-zA-Z0-9_@:.\-\/ ]*)\((.*)\)\]`)
+	// Define a regular expression to match a single python function call, used for the zero-shot
+	// pythonic format and for built-in tool calls
+	// Example: func_name1(params_name1=params_value1, params_name2=params_value2)
+	parseFunc = regexp.MustCompile(`(?s)^([a-zA-Z_][a-zA-Z0-9_@:.\-\/]*)\((.*)\)$`)
 
 	// The function definition for a custom defined function for llama 3.1
 	// Example: <function=example_function_name>{"example_name": "example_value"}</function>
 	customFunc = regexp.MustCompile(`<function=(?P<name>[^\s>]+)>\s*(?P<input>\{.*?\})?\s*<.*function>`)
 
-	// Split the parameters by comma
-	parseFuncParams = regexp.MustCompile(`[a-zA-Z_][a-zA-Z0-9_]*=('[^']*'|"[^"]*"|[^,]+)`)
+	// Match a single parameter of a python function call
+	// Example: params_name1=params_value1
+	parseFuncParams = regexp.MustCompile(`(?s)^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*(.*?)\s*$`)
 )
 
 const (
@@ -71,17 +82,76 @@ const (
 	tool      = "ipython"
 	assistant = "assistant"
 
-	// environment token
+	// environment token, only emitted when tools are available
 	env = "Environment: ipython"
+
+	// dates rendered in the system header by the official template
+	knowledgeCutoff = "Cutting Knowledge Date: December 2023"
+	todayPrefix     = "Today Date: "
+	todayLayout     = "02 Jan 2006"
+
+	// built-in tools, listed after the environment token as "Tools: brave_search, wolfram_alpha".
+	// code_interpreter is enabled by the environment token alone.
+	toolsPrefix     = "Tools: "
+	braveSearch     = "brave_search"
+	wolframAlpha    = "wolfram_alpha"
+	codeInterpreter = "code_interpreter"
+
+	// built-in tools are called as <tool>.call(query="...")
+	builtinCallSuffix = ".call"
 )
 
 var _ models.Format = (*llama3)(nil)
+var _ format.Format = (*llama3)(nil)
+
+// StopSequences returns the tokens that end the output of the Llama 3 formats. <|eom_id|> ends a
+// tool call the executor has to answer, <|eot_id|> the turn.
+func StopSequences() []string {
+	return []string{endOfTurn, endOfMessage, endOfText}
+}
+
+// Config configures the Llama 3 formats
+type Config struct {
+	// Today returns the date rendered as "Today Date" in the system header, the clock of the
+	// runtime is used when nil
+	Today func() time.Time
+}
+
+// New returns a Llama 3.1 format for the given config
+func New(config Config) (models.Format, error) {
+	return newLlama3(config, false), nil
+}
 
 func Constructor_3_1() (models.Format, error) {
-	return &llama3{}, nil
+	return New(Config{})
 }
 
-type llama3 struct{}
+type llama3 struct {
+	format.Codec
+	config Config
+	// vision renders images as <|image|> tokens, see Constructor_3_2_Vision
+	vision bool
+}
+
+func newLlama3(config Config, vision bool) *llama3 {
+	m := &llama3{config: config, vision: vision}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.Llama3,
+		Stops:          StopSequences(),
+		Calls:          grammar.Llama3,
+	}
+	return m
+}
+
+// today returns the date of the system header, e.g. 26 Jul 2024
+func (m *llama3) today() string {
+	if m.config.Today != nil {
+		return m.config.Today().Format(todayLayout)
+	}
+	return time.Now().Format(todayLayout)
+}
 
 // Helper function to check if text ends with a complete sentence
 func (m *llama3) endsWithCompleteSentence(text string) bool {
@@ -109,8 +179,7 @@ func (m *llama3) isMidWord(text string) bool {
 		(lastChar >= '0' && lastChar <= '9')
 }
 
-func (m *llama3) Decode(data []byte) (*ai.Message, error) {
-	text := string(data)
+func (m *llama3) decode(text string) (*ai.Message, error) {
 
 	// If we don't have any content, return partial decode error
 	if len(strings.TrimSpace(text)) == 0 {
@@ -125,8 +194,7 @@ func (m *llama3) Decode(data []byte) (*ai.Message, error) {
 			trimmedText := strings.TrimSpace(text)
 
 			// Check if this is a function call even without headers
-			functionMatches := customFunc.FindAllStringSubmatch(trimmedText, -1)
-			if len(functionMatches) > 0 {
+			if m.hasToolCall(trimmedText) {
 				// Parse as function call
 				return m.parseFunctionCallContent(trimmedText)
 			}
@@ -158,8 +226,90 @@ func (m *llama3) Decode(data []byte) (*ai.Message, error) {
 }
 
 func (m *llama3) parseFunctionCallContent(content string) (*ai.Message, error) {
+	hasEndToken := strings.Contains(content, endOfTurn) ||
+		strings.Contains(content, endOfMessage) ||
+		strings.Contains(content, endOfText)
+
+	content = strings.Replace(content, endOfTurn, "", -1)
+	content = strings.Replace(content, endOfMessage, "", -1)
+	content = strings.Replace(content, endOfText, "", -1)
+
+	messageContents, err := m.parseToolCalls(strings.TrimSpace(content), hasEndToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(messageContents) == 0 {
+		return nil, &models.PartialDecodeError{}
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(messageContents),
+	}, nil
+}
+
+// hasToolCall reports whether the content holds a tool call in any of the llama 3.1 styles
+func (m *llama3) hasToolCall(content string) bool {
+	if strings.Contains(content, pythonTag) || customFunc.MatchString(content) {
+		return true
+	}
+	_, ok := parsePythonicCalls(strings.TrimSpace(content))
+	return ok
+}
+
+// parseToolCalls extracts the tool calls from cleaned assistant content. Llama 3.1 calls tools in
+// three ways:
+//
+//	<function=get_weather>{"city": "SF"}</function>           custom functions
+//	[get_weather(city="SF"), get_time()]                      zero-shot pythonic calls
+//	<|python_tag|>brave_search.call(query="...")              built-in tools, after the python tag
+//
+// The python tag may also prefix pythonic or JSON calls, anything else after it is code for the
+// code_interpreter built-in tool.
+func (m *llama3) parseToolCalls(content string, hasEndToken bool) ([]ai.MessageContent, error) {
 	var messageContents []ai.MessageContent
 
+	if index := strings.Index(content, pythonTag); index != -1 {
+		// Keep any text the model produced before the python tag
+		if text := strings.TrimSpace(content[:index]); text != "" {
+			messageContents = append(messageContents, ai.NewMessageContent(ai.Text(text)))
+		}
+
+		calls, err := m.parsePythonTag(strings.TrimSpace(content[index+len(pythonTag):]), hasEndToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range calls {
+			messageContents = append(messageContents, ai.NewMessageContent(call))
+		}
+		return messageContents, nil
+	}
+
+	if customFunc.MatchString(content) {
+		calls, err := m.parseCustomFunctions(content, hasEndToken)
+		if err != nil {
+			return nil, err
+		}
+		for _, call := range calls {
+			messageContents = append(messageContents, ai.NewMessageContent(call))
+		}
+		return messageContents, nil
+	}
+
+	if calls, ok := parsePythonicCalls(content); ok {
+		for _, call := range calls {
+			messageContents = append(messageContents, ai.NewMessageContent(call))
+		}
+	}
+
+	return messageContents, nil
+}
+
+// parseCustomFunctions parses every <function=...> call in the content
+func (m *llama3) parseCustomFunctions(content string, hasEndToken bool) ([]mcp.CallToolParams, error) {
+	var calls []mcp.CallToolParams
+
 	functionMatches := customFunc.FindAllStringSubmatch(content, -1)
 	for _, match := range functionMatches {
 		if len(match) < 3 {
@@ -170,41 +320,75 @@ func (m *llama3) parseFunctionCallContent(content string) (*ai.Message, error) {
 		jsonInput := match[2]
 
 		// Parse JSON input into arguments
-		var args map[string]interface{}
-		if jsonInput != "" {
-			if err := json.Unmarshal([]byte(jsonInput), &args); err != nil {
-				return nil, fmt.Errorf("invalid function call JSON: %v", err)
+		args, err := arguments.Decode([]byte(jsonInput))
+		if err != nil {
+			// If we can't parse JSON and don't have end token, this might be partial
+			if !hasEndToken {
+				return nil, &models.PartialDecodeError{}
 			}
+			// If we have end token but invalid JSON, treat as error
+			return nil, fmt.Errorf("invalid function call JSON: %v", err)
 		}
 
-		// Convert to tool call format using CallToolParams
-		var arguments [][2]string
-		for key, value := range args {
-			valueStr := fmt.Sprintf("%v", value)
-			arguments = append(arguments, [2]string{key, valueStr})
-		}
-
-		toolCall := mcp.CallToolParams{
+		calls = append(calls, mcp.CallToolParams{
 			Name:      functionName,
-			Arguments: cm.ToList(arguments),
-		}
-
-		messageContents = append(messageContents, ai.NewMessageContent(toolCall))
+			Arguments: cm.ToList(args),
+		})
 	}
 
-	if len(messageContents) == 0 {
+	return calls, nil
+}
+
+// parsePythonTag parses the content following <|python_tag|>
+func (m *llama3) parsePythonTag(code string, hasEndToken bool) ([]mcp.CallToolParams, error) {
+	if code == "" {
 		return nil, &models.PartialDecodeError{}
 	}
 
-	return &ai.Message{
-		Role:    ai.RoleAssistant,
-		Content: cm.ToList(messageContents),
+	// Custom functions may also be emitted after the python tag
+	if customFunc.MatchString(code) {
+		return m.parseCustomFunctions(code, hasEndToken)
+	}
+
+	// Zero-shot pythonic calls: [get_weather(city="SF"), get_time()]
+	if calls, ok := parsePythonicCalls(code); ok {
+		return calls, nil
+	}
+
+	// Built-in tools: brave_search.call(query="...") and wolfram_alpha.call(query="...")
+	if call, ok := parsePythonicCall(code); ok && strings.HasSuffix(call.Name, builtinCallSuffix) {
+		call.Name = strings.TrimSuffix(call.Name, builtinCallSuffix)
+		return []mcp.CallToolParams{call}, nil
+	}
+
+	// JSON based calls, several calls are separated by semicolons:
+	// {"name": "get_weather", "parameters": {"city": "SF"}}; {"name": "get_time", "parameters": {}}
+	if strings.HasPrefix(code, "{") {
+		calls, err := parseJSONCalls(code)
+		if err != nil {
+			if !hasEndToken {
+				return nil, &models.PartialDecodeError{}
+			}
+			return nil, fmt.Errorf("invalid function call JSON: %v", err)
+		}
+		return calls, nil
+	}
+
+	// Anything else is python code for the code interpreter, which only ends with the message
+	if !hasEndToken {
+		return nil, &models.PartialDecodeError{}
+	}
+	return []mcp.CallToolParams{
+		{
+			Name:      codeInterpreter,
+			Arguments: cm.ToList([][2]string{{"code", code}}),
+		},
 	}, nil
 }
 
 func (m *llama3) parseStructuredMessage(text string) (*ai.Message, error) {
 	// Find the last complete message in the text
-	headerPattern := regexp.MustCompile(startHeaderId + `([^<]+)` + endHeaderId)
+	headerPattern := regexp.MustCompile(regexp.QuoteMeta(startHeaderId) + `([^<]+)` + regexp.QuoteMeta(endHeaderId))
 	headerMatches := headerPattern.FindAllStringSubmatch(text, -1)
 
 	if len(headerMatches) == 0 {
@@ -264,46 +448,14 @@ func (m *llama3) parseAssistantMessage(content string, hasEndToken bool) (*ai.Me
 
 	var messageContents []ai.MessageContent
 
-	// Check for function calls using the custom function pattern
-	functionMatches := customFunc.FindAllStringSubmatch(cleanContent, -1)
-
-	if len(functionMatches) > 0 {
+	// Check for function calls in any of the supported styles
+	if m.hasToolCall(cleanContent) {
 		// This is a function call message
-		for _, match := range functionMatches {
-			if len(match) < 3 {
-				continue
-			}
-
-			functionName := match[1]
-			jsonInput := match[2]
-
-			// Parse JSON input into arguments
-			var args map[string]interface{}
-			if jsonInput != "" {
-				if err := json.Unmarshal([]byte(jsonInput), &args); err != nil {
-					// If we can't parse JSON and don't have end token, this might be partial
-					if !hasEndToken {
-						return nil, &models.PartialDecodeError{}
-					}
-					// If we have end token but invalid JSON, treat as error
-					return nil, fmt.Errorf("invalid function call JSON: %v", err)
-				}
-			}
-
-			// Convert to tool call format using CallToolParams
-			var arguments [][2]string
-			for key, value := range args {
-				valueStr := fmt.Sprintf("%v", value)
-				arguments = append(arguments, [2]string{key, valueStr})
-			}
-
-			toolCall := mcp.CallToolParams{
-				Name:      functionName,
-				Arguments: cm.ToList(arguments),
-			}
-
-			messageContents = append(messageContents, ai.NewMessageContent(toolCall))
+		calls, err := m.parseToolCalls(cleanContent, hasEndToken)
+		if err != nil {
+			return nil, err
 		}
+		messageContents = append(messageContents, calls...)
 
 		// For function calls, we need the end of message token to be complete
 		if !strings.Contains(content, endOfMessage) && !hasEndToken {
@@ -398,51 +550,59 @@ func (m *llama3) parseToolMessage(content string, hasEndToken bool) (*ai.Message
 	}, nil
 }
 
-func (m *llama3) Encode(messages ...ai.Message) ([]byte, error) {
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *llama3) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
 	builder := &strings.Builder{}
 
+	choice := opts.ToolChoice
+
+	// Start of the tool call prefilled after the generation prompt
+	callPrefill := ""
+
+	// The system header is written even when the conversation has no system message, with the
+	// dates and the tool choice, like the template of the model does. The Llama 3.2 Vision template
+	// leaves it out of conversations holding images.
+	hasSystem := len(messages) > 0 && messages[0].Role == ai.RoleSystem
+	if !hasSystem && !(m.vision && len(vision.Images(messages...)) > 0) {
+		prefill, err := m.writeSystem(builder, ai.Message{Role: ai.RoleSystem}, choice)
+		if err != nil {
+			return nil, err
+		}
+		callPrefill = prefill
+	}
+
+	// Tools offered by the system message, which type the arguments of earlier calls
+	var tools []mcp.Tool
+
 	for i, msg := range messages {
 		switch msg.Role {
 		case ai.RoleSystem:
-			// System message header
-			builder.WriteString(fmt.Sprintf("%s%s%s\n", startHeaderId, system, endHeaderId))
-
-			// Add environment token to enable tools by default
-			builder.WriteString(fmt.Sprintf("%s\n\n", env))
-
-			// Process system message content
-			tools := []mcp.Tool{}
-			for _, content := range msg.Content.Slice() {
-				switch content.String() {
-				case "text":
-					c := content.Text()
-					builder.WriteString(fmt.Sprintf("%s\n", *c))
-				case "tools":
-					c := content.Tools().Slice()
-					tools = c
-				}
+			prefill, err := m.writeSystem(builder, msg, choice)
+			if err != nil {
+				return nil, err
 			}
+			callPrefill = prefill
 
-			// Add tool definitions if available
-			if len(tools) > 0 {
-				toolString := customToolEncode(tools)
-				if toolString != "" {
-					builder.WriteString(fmt.Sprintf("%s", toolString))
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "tools" {
+					tools = content.Tools().Slice()
 				}
 			}
 
-			// End system message turn
-			builder.WriteString(endOfTurn)
-
 		case ai.RoleUser:
 			// User message header
 			builder.WriteString(fmt.Sprintf("%s%s%s\n", startHeaderId, user, endHeaderId))
 
 			// Process user message content
 			for _, content := range msg.Content.Slice() {
-				if content.String() == "text" {
+				switch content.String() {
+				case "text":
 					c := content.Text()
 					builder.WriteString(fmt.Sprintf("%s", *c))
+				case "blob":
+					if m.vision {
+						builder.WriteString(image)
+					}
 				}
 			}
 
@@ -466,24 +626,25 @@ func (m *llama3) Encode(messages ...ai.Message) ([]byte, error) {
 
 				case "tool-input":
 					c := content.ToolInput()
-					// Convert arguments to JSON format
-					args := make(map[string]string)
-					for _, arg := range c.Arguments.Slice() {
-						if len(arg) != 2 {
-							return nil, fmt.Errorf("invalid tool input argument format: %v", arg)
-						}
-						args[arg[0]] = arg[1]
-					}
 
-					input := "{}"
-					if len(args) > 0 {
-						jsonArgs, err := json.Marshal(args)
-						if err != nil {
-							return nil, fmt.Errorf("failed to marshal tool input arguments: %v", err)
+					// Built-in tools are called after the python tag
+					switch c.Name {
+					case braveSearch, wolframAlpha:
+						builder.WriteString(fmt.Sprintf("%s%s", pythonTag, formatPythonicCall(c.Name+builtinCallSuffix, c.Arguments.Slice(), schema.Find(tools, c.Name))))
+						hasContent = true
+						continue
+					case codeInterpreter:
+						for _, arg := range c.Arguments.Slice() {
+							if arg[0] == "code" {
+								builder.WriteString(fmt.Sprintf("%s%s", pythonTag, arg[1]))
+							}
 						}
-						input = string(jsonArgs)
+						hasContent = true
+						continue
 					}
 
+					input := string(arguments.EncodeCall(tools, *c))
+
 					builder.WriteString(fmt.Sprintf("<function=%s>%s</function>", c.Name, input))
 					hasContent = true
 				}
@@ -510,30 +671,12 @@ func (m *llama3) Encode(messages ...ai.Message) ([]byte, error) {
 					builder.WriteString(fmt.Sprintf("%s", *c))
 
 				case "tool-output":
-					output := content.ToolOutput()
-					for _, c := range output.Content.Slice() {
-						switch c.String() {
-						case "text":
-							builder.WriteString(fmt.Sprintf("%s", c.Text().Text))
-						case "image":
-							image := c.Image()
-							builder.WriteString(fmt.Sprintf("Image Data: %v", image.Data))
-						case "audio":
-							audio := c.Audio()
-							builder.WriteString(fmt.Sprintf("Audio Data: %v", audio.Data))
-						case "resource-link":
-							resource := c.ResourceLink()
-							builder.WriteString(fmt.Sprintf("Resource Link: %s", resource.URI))
-						case "resource-content":
-							content := c.ResourceContent()
-							switch content.ResourceContents.String() {
-							case "text":
-								builder.WriteString(fmt.Sprintf("Resource Content (Text): %s", content.ResourceContents.Text()))
-							case "blob":
-								builder.WriteString(fmt.Sprintf("Resource Content (Blob): %v", content.ResourceContents.Blob()))
-							}
-						}
+					// Vision models see the images of tool results, other models a placeholder
+					token := ""
+					if m.vision {
+						token = image
 					}
+					builder.WriteString(toolresult.Text(content.ToolOutput(), token))
 				}
 			}
 
@@ -546,70 +689,147 @@ func (m *llama3) Encode(messages ...ai.Message) ([]byte, error) {
 	}
 
 	// If the last message is not from assistant, add assistant header to prompt for response
+	prefill := ""
 	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
 		builder.WriteString(fmt.Sprintf("%s%s%s\n", startHeaderId, assistant, endHeaderId))
+		prefill = callPrefill
+		builder.WriteString(prefill)
 	}
 
-	return []byte(builder.String()), nil
+	prompt := &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}
+	if m.vision {
+		prompt.Images = vision.Images(messages...)
+	}
+	return prompt, nil
 }
 
-func customToolEncode(tools []mcp.Tool) string {
-	if len(tools) == 0 {
-		return ""
+// writeSystem writes a system turn with the dates, the tools of the message and the tool choice
+// instruction, and returns the start of the tool call prefilled after the generation prompt
+func (m *llama3) writeSystem(builder *strings.Builder, msg ai.Message, choice toolchoice.Choice) (string, error) {
+	builder.WriteString(fmt.Sprintf("%s%s%s\n", startHeaderId, system, endHeaderId))
+
+	// Process system message content
+	text := []string{}
+	tools := []mcp.Tool{}
+	for _, content := range msg.Content.Slice() {
+		switch content.String() {
+		case "text":
+			c := content.Text()
+			text = append(text, *c)
+		case "tools":
+			c := content.Tools().Slice()
+			tools = c
+		}
 	}
 
-	result := `
-	# Tool Instructions
-	- Calling a tool is not necessary, use relevant functions only if needed
-	- If you call a function, put the entire function call reply on one line
-	- Only add parameters when the params are specified in the tool schema
-	- When you get a response from a tool, use that information to answer the user query
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return "", err
+	}
 
-	You have access to the following functions:
-	{
-	`
-	for _, tool := range tools {
-		result += fmt.Sprintf(`"name": "%s",\n`, tool.Name)
-		result += fmt.Sprintf(`"description": "%s",\n`, tool.Description)
-		// Add input schema properties
-		if len(tool.InputSchema.Properties.Slice()) > 0 {
-			result += `"parameters": {`
-			for i, prop := range tool.InputSchema.Properties.Slice() {
-				result += fmt.Sprintf(`"%s":`, prop[0])
-				result += fmt.Sprintf(`"%s"`, prop[1])
-				if i < len(tool.InputSchema.Properties.Slice())-1 {
-					result += ", "
-				}
-			}
-			result += "},\n"
-		} else {
-			result += `"parameters": {},\n`
+	// Built-in tools are enabled in the header, every other tool is a custom function
+	builtins, custom := splitBuiltinTools(tools)
+
+	// The environment token enables tool calls, it is only added when tools are available
+	if len(tools) > 0 {
+		builder.WriteString(fmt.Sprintf("%s\n", env))
+		if len(builtins) > 0 {
+			builder.WriteString(fmt.Sprintf("%s%s\n\n", toolsPrefix, strings.Join(builtins, ", ")))
+		}
+	}
+	builder.WriteString(fmt.Sprintf("%s\n%s%s\n\n", knowledgeCutoff, todayPrefix, m.today()))
+
+	for _, t := range text {
+		builder.WriteString(fmt.Sprintf("%s\n", t))
+	}
+
+	// Add tool definitions if available
+	if len(custom) > 0 {
+		toolString := customToolEncode(custom)
+		if toolString != "" {
+			builder.WriteString(fmt.Sprintf("%s", toolString))
 		}
 	}
 
-	// remove last comma
-	result = strings.TrimSuffix(result, ",\n")
+	if instruction := choice.Instruction(); instruction != "" {
+		builder.WriteString(fmt.Sprintf("\n%s\n", instruction))
+	}
+
+	// End system message turn
+	builder.WriteString(endOfTurn)
 
-	result += `
+	return toolCallPrefill(choice, tools), nil
+}
+
+// toolCallPrefill returns the start of the tool call written after the generation prompt when
+// the choice requires a call. Built-in tools are called after the python tag and custom tools
+// with <function=name>, a required call uses custom functions when there are any.
+func toolCallPrefill(choice toolchoice.Choice, tools []mcp.Tool) string {
+	builtins, custom := splitBuiltinTools(tools)
+	switch choice.Mode {
+	case toolchoice.Required:
+		if len(custom) > 0 {
+			return "<function="
+		}
+		return pythonTag
+	case toolchoice.Function:
+		switch {
+		case choice.Name == codeInterpreter:
+			return pythonTag
+		case slices.Contains(builtins, choice.Name):
+			return fmt.Sprintf("%s%s%s(", pythonTag, choice.Name, builtinCallSuffix)
+		}
+		return fmt.Sprintf("<function=%s>", choice.Name)
 	}
-		
-	If a you choose to call a function ONLY reply in the following format:
-	<{start_tag}={function_name}>{parameters}{end_tag}
-	where
+	return ""
+}
 
-	start_tag => <function
-	parameters => a JSON dict with the function argument name as key and function argument value as value.
-	end_tag => </function>
+// splitBuiltinTools separates the names of the llama 3.1 built-in tools from the custom tools.
+// code_interpreter needs no listing since the environment token enables it.
+func splitBuiltinTools(tools []mcp.Tool) ([]string, []mcp.Tool) {
+	var builtins []string
+	var custom []mcp.Tool
+	for _, t := range tools {
+		switch t.Name {
+		case braveSearch, wolframAlpha:
+			builtins = append(builtins, t.Name)
+		case codeInterpreter:
+		default:
+			custom = append(custom, t)
+		}
+	}
+	return builtins, custom
+}
 
-	Here is an example,
-	<function=example_function_name>{"example_name": "example_value"}</function>
+func customToolEncode(tools []mcp.Tool) string {
+	if len(tools) == 0 {
+		return ""
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString("\n# Tool Instructions\n")
+	builder.WriteString("- Calling a tool is not necessary, use relevant functions only if needed\n")
+	builder.WriteString("- If you call a function, put the entire function call reply on one line\n")
+	builder.WriteString("- Only add parameters when the params are specified in the tool schema\n")
+	builder.WriteString("- When you get a response from a tool, use that information to answer the user query\n\n")
+	builder.WriteString("You have access to the following functions:\n")
+	for _, tool := range tools {
+		builder.WriteString(fmt.Sprintf("\nUse the function '%s' to: %s\n%s\n", tool.Name, tool.Description, schema.Function(tool)))
+	}
 
-	Reminder:
-	- Function calls MUST follow the specified format
-	- Required parameters MUST be specified
-	- Only call one function at a time
-	- Put the entire function call reply on one line
-	- Always add your sources when using search results to answer the user query
-	`
-	return result
+	builder.WriteString("\nIf you choose to call a function ONLY reply in the following format:\n")
+	builder.WriteString("<{start_tag}={function_name}>{parameters}{end_tag}\n")
+	builder.WriteString("where\n\n")
+	builder.WriteString("start_tag => <function\n")
+	builder.WriteString("parameters => a JSON dict with the function argument name as key and function argument value as value.\n")
+	builder.WriteString("end_tag => </function>\n\n")
+	builder.WriteString("Here is an example,\n")
+	builder.WriteString("<function=example_function_name>{\"example_name\": \"example_value\"}</function>\n\n")
+	builder.WriteString("Reminder:\n")
+	builder.WriteString("- Function calls MUST follow the specified format\n")
+	builder.WriteString("- Required parameters MUST be specified\n")
+	builder.WriteString("- Only call one function at a time\n")
+	builder.WriteString("- Put the entire function call reply on one line\n")
+	builder.WriteString("- Always add your sources when using search results to answer the user query\n")
+	return builder.String()
 }
@@ -1,11 +1,17 @@
 package llama3
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
 	"go.bytecodealliance.org/cm"
 )
 
@@ -36,7 +42,7 @@ func TestDecode(t *testing.T) {
 		},
 	}
 
-	model := llama3{}
+	model := newLlama3(Config{}, false)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			msg, err := model.Decode(tt.input)
@@ -90,7 +96,7 @@ func TestDecode(t *testing.T) {
 }
 
 func TestEncode_BasicConversation(t *testing.T) {
-	model := &llama3{}
+	model := newLlama3(Config{Today: func() time.Time { return time.Date(2024, time.July, 26, 0, 0, 0, 0, time.UTC) }}, false)
 
 	// Create a basic conversation: system + user message
 	messages := []ai.Message{
@@ -121,8 +127,9 @@ func TestEncode_BasicConversation(t *testing.T) {
 		t.Error("Missing system header")
 	}
 
-	if !strings.Contains(result, "Environment: ipython") {
-		t.Error("Missing environment token for tools")
+	// Without tools the environment token must not be emitted
+	if strings.Contains(result, "Environment: ipython") {
+		t.Error("Unexpected environment token without tools")
 	}
 
 	if !strings.Contains(result, "You are a helpful assistant.") {
@@ -147,8 +154,7 @@ func TestEncode_BasicConversation(t *testing.T) {
 
 	// Validate the exact expected format
 	expectedParts := []string{
-		"<|start_header_id|>system<|end_header_id|>",
-		"Environment: ipython",
+		"<|start_header_id|>system<|end_header_id|>\nCutting Knowledge Date: December 2023\nToday Date: 26 Jul 2024\n\n",
 		"You are a helpful assistant.",
 		"<|eot_id|>",
 		"<|start_header_id|>user<|end_header_id|>",
@@ -164,8 +170,27 @@ func TestEncode_BasicConversation(t *testing.T) {
 	}
 }
 
+func TestEncode_NoSystemMessage(t *testing.T) {
+	model := newLlama3(Config{Today: func() time.Time { return time.Date(2024, time.July, 26, 0, 0, 0, 0, time.UTC) }}, false)
+
+	encoded, err := model.Encode(ai.Message{
+		Role:    ai.RoleUser,
+		Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hello"))}),
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// The system header is written with the dates like the template of the model does
+	want := "<|start_header_id|>system<|end_header_id|>\nCutting Knowledge Date: December 2023\nToday Date: 26 Jul 2024\n\n<|eot_id|>" +
+		"<|start_header_id|>user<|end_header_id|>\nHello<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n"
+	if string(encoded) != want {
+		t.Errorf("Encode() =\n%q\nwant\n%q", encoded, want)
+	}
+}
+
 func TestEncode_WithAssistantResponse(t *testing.T) {
-	model := &llama3{}
+	model := newLlama3(Config{}, false)
 
 	// Create conversation with assistant response
 	messages := []ai.Message{
@@ -213,7 +238,7 @@ func TestEncode_WithAssistantResponse(t *testing.T) {
 }
 
 func TestEncode_ToolCall(t *testing.T) {
-	model := &llama3{}
+	model := newLlama3(Config{}, false)
 
 	// Create a tool call scenario
 	messages := []ai.Message{
@@ -271,3 +296,374 @@ func TestEncode_ToolCall(t *testing.T) {
 		}
 	}
 }
+
+func TestDecode_PythonicAndBuiltinCalls(t *testing.T) {
+	type call struct {
+		name string
+		args [][2]string
+	}
+
+	tests := []struct {
+		name      string
+		input     []byte
+		wantCalls []call
+	}{
+		{
+			name:  "zero-shot pythonic calls",
+			input: []byte(`[get_weather(city="SF"), get_time()]`),
+			wantCalls: []call{
+				{name: "get_weather", args: [][2]string{{"city", "SF"}}},
+				{name: "get_time"},
+			},
+		},
+		{
+			name:  "pythonic call with typed and quoted values",
+			input: []byte(`<|python_tag|>[search(query='a, b', limit=5, exact=True, tags=['x', "y"])]<|eom_id|>`),
+			wantCalls: []call{
+				{name: "search", args: [][2]string{{"query", "a, b"}, {"limit", "5"}, {"exact", "true"}, {"tags", `["x","y"]`}}},
+			},
+		},
+		{
+			name:  "brave search built-in",
+			input: []byte(`<|python_tag|>brave_search.call(query="current weather in Menlo Park")<|eom_id|>`),
+			wantCalls: []call{
+				{name: "brave_search", args: [][2]string{{"query", "current weather in Menlo Park"}}},
+			},
+		},
+		{
+			name:  "wolfram alpha built-in with headers",
+			input: []byte("<|start_header_id|>assistant<|end_header_id|>\n\n<|python_tag|>wolfram_alpha.call(query=\"solve x^2 - 4 = 0\")<|eom_id|>"),
+			wantCalls: []call{
+				{name: "wolfram_alpha", args: [][2]string{{"query", "solve x^2 - 4 = 0"}}},
+			},
+		},
+		{
+			name:  "code interpreter built-in",
+			input: []byte("<|python_tag|>import math\nprint(math.sqrt(16))<|eom_id|>"),
+			wantCalls: []call{
+				{name: "code_interpreter", args: [][2]string{{"code", "import math\nprint(math.sqrt(16))"}}},
+			},
+		},
+		{
+			name:  "json calls after the python tag",
+			input: []byte(`<|python_tag|>{"name": "get_weather", "parameters": {"city": "SF"}}; {"name": "get_time", "parameters": {}}<|eom_id|>`),
+			wantCalls: []call{
+				{name: "get_weather", args: [][2]string{{"city", "SF"}}},
+				{name: "get_time"},
+			},
+		},
+	}
+
+	model := newLlama3(Config{}, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode(tt.input)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			var calls []mcp.CallToolParams
+			for _, content := range msg.Content.Slice() {
+				if content.String() != "tool-input" {
+					t.Fatalf("Unexpected content type: %s", content.String())
+				}
+				calls = append(calls, *content.ToolInput())
+			}
+
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("Expected %d tool calls, got %d", len(tt.wantCalls), len(calls))
+			}
+			for i, want := range tt.wantCalls {
+				if calls[i].Name != want.name {
+					t.Errorf("Expected tool input name '%s', got '%s'", want.name, calls[i].Name)
+				}
+				args := calls[i].Arguments.Slice()
+				if len(args) != len(want.args) {
+					t.Fatalf("Expected %d arguments, got %v", len(want.args), args)
+				}
+				for j, arg := range args {
+					if arg != want.args[j] {
+						t.Errorf("Expected tool input argument '%s', got '%s'", want.args[j], arg)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDecode_PythonTagPartial(t *testing.T) {
+	// Output after the python tag is only a tool call once the message has ended
+	inputs := []string{
+		"<|python_tag|>import math",
+		`<|python_tag|>brave_search.call(query="current weather`,
+		`<|python_tag|>[get_weather(city="S`,
+		`<|python_tag|>{"name": "get_weather", "parameters": {"ci`,
+	}
+
+	model := newLlama3(Config{}, false)
+	for _, input := range inputs {
+		_, err := model.Decode([]byte(input))
+		var partial *models.PartialDecodeError
+		if !errors.As(err, &partial) {
+			t.Errorf("Decode(%q) error = %v, want a partial decode error", input, err)
+		}
+	}
+}
+
+func TestEncode_BuiltinTools(t *testing.T) {
+	model := newLlama3(Config{}, false)
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("You are a helpful assistant.")),
+				ai.NewMessageContent(cm.ToList([]mcp.Tool{
+					{Name: "brave_search", Description: "Search the web"},
+					{Name: "wolfram_alpha", Description: "Compute answers"},
+					{Name: "code_interpreter", Description: "Run python"},
+				})),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Search for the weather")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "brave_search",
+					Arguments: cm.ToList([][2]string{{"query", "weather"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Sunny")),
+			}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	expectedParts := []string{
+		"<|start_header_id|>system<|end_header_id|>\nEnvironment: ipython\nTools: brave_search, wolfram_alpha\n\nCutting Knowledge Date: December 2023\nToday Date: ",
+		`<|python_tag|>brave_search.call(query="weather")<|eom_id|>`,
+		"<|start_header_id|>ipython<|end_header_id|>\nSunny<|eot_id|>",
+	}
+	for _, part := range expectedParts {
+		if !strings.Contains(result, part) {
+			t.Errorf("Missing expected part: %s", part)
+		}
+	}
+
+	// Built-in tools must not be described as custom functions
+	if strings.Contains(result, "You have access to the following functions") {
+		t.Error("Built-in tools should not be rendered as custom functions")
+	}
+}
+
+func TestToolArguments_RoundTrip(t *testing.T) {
+	model := newLlama3(Config{}, false)
+
+	call := `<function=search>{"filter":{"tags":["a","b"],"min":1.5},"limit":1e6,"exact":true,"zip":"02134"}</function>`
+
+	msg, err := model.Decode([]byte(call + "<|eom_id|>"))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	input := msg.Content.Slice()[0].ToolInput()
+	if input == nil {
+		t.Fatalf("Expected a tool input, got %s", msg.Content.Slice()[0].String())
+	}
+
+	expectedArgs := [][2]string{
+		{"filter", `{"tags":["a","b"],"min":1.5}`},
+		{"limit", "1e6"},
+		{"exact", "true"},
+		{"zip", "02134"},
+	}
+	args := input.Arguments.Slice()
+	if len(args) != len(expectedArgs) {
+		t.Fatalf("Expected arguments %q, got %q", expectedArgs, args)
+	}
+	for i := range expectedArgs {
+		if args[i] != expectedArgs[i] {
+			t.Errorf("Expected argument %q, got %q", expectedArgs[i], args[i])
+		}
+	}
+
+	// Re-encoding the decoded call must reproduce the model's original call
+	encoded, err := model.Encode(ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: msg.Content,
+	})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !strings.Contains(string(encoded), call) {
+		t.Errorf("Expected encoding to contain %s, got %s", call, string(encoded))
+	}
+}
+
+func TestDecode_PythonicTypedArguments(t *testing.T) {
+	model := newLlama3(Config{}, false)
+
+	msg, err := model.Decode([]byte(`[search(query="12345", limit=10, exact=True, tags=["a", None])]`))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	expectedArgs := [][2]string{
+		{"query", "12345"},
+		{"limit", "10"},
+		{"exact", "true"},
+		{"tags", `["a",null]`},
+	}
+	args := msg.Content.Slice()[0].ToolInput().Arguments.Slice()
+	if len(args) != len(expectedArgs) {
+		t.Fatalf("Expected arguments %q, got %q", expectedArgs, args)
+	}
+	for i := range expectedArgs {
+		if args[i] != expectedArgs[i] {
+			t.Errorf("Expected argument %q, got %q", expectedArgs[i], args[i])
+		}
+	}
+
+	tool := mcp.Tool{
+		Name: "search",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{{"query", `{"type": "string"}`}, {"limit", `{"type": "integer"}`}}),
+		},
+	}
+	if got := formatPythonicCall("search", args, schema.FromTool(tool)); got != `search(query="12345", limit=10, exact=True, tags=["a",None])` {
+		t.Errorf("Unexpected pythonic call: %s", got)
+	}
+	if got := formatPythonicCall("search", args, nil); got != `search(query=12345, limit=10, exact=True, tags=["a",None])` {
+		t.Errorf("Unexpected untyped pythonic call: %s", got)
+	}
+}
+
+func TestEncode_CustomToolSchema(t *testing.T) {
+	tool := mcp.Tool{
+		Name:        "search",
+		Description: `Search for "exact" phrases`,
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{
+				{"query", `{"type": "string", "description": "Text with \"quotes\""}`},
+				{"filter", `{"type": "object", "properties": {"lang": {"type": "string", "enum": ["en", "fr"]}}}`},
+			}),
+			Required: cm.ToList([]string{"query"}),
+		},
+	}
+
+	result := customToolEncode([]mcp.Tool{tool})
+	t.Logf("Encoded tools:\n%s", result)
+
+	expected := `{"name":"search","description":"Search for \"exact\" phrases","parameters":{"type":"object","properties":{"query":{"type":"string","description":"Text with \"quotes\""},"filter":{"type":"object","properties":{"lang":{"type":"string","enum":["en","fr"]}}}},"required":["query"]}}`
+	if !strings.Contains(result, expected) {
+		t.Errorf("Missing tool definition: %s", expected)
+	}
+	if strings.Contains(result, `\n`) {
+		t.Error("Tool definitions should not contain literal \\n sequences")
+	}
+	if strings.Contains(result, "\n\t") {
+		t.Error("Tool instructions should not be indented")
+	}
+}
+
+func TestEncode_ToolChoice(t *testing.T) {
+	system := ai.Message{
+		Role: ai.RoleSystem,
+		Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(cm.ToList([]mcp.Tool{
+				{Name: "brave_search", Description: "Search the web"},
+				{Name: "get_weather", Description: "Get the weather"},
+			})),
+		}),
+	}
+	user := ai.Message{
+		Role:    ai.RoleUser,
+		Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Paris?"))}),
+	}
+	generation := "<|start_header_id|>assistant<|end_header_id|>\n"
+
+	tests := []struct {
+		name       string
+		choice     toolchoice.Choice
+		wantSuffix string
+		// output is what the model generates after the prompt
+		output   string
+		wantCall string
+	}{
+		{
+			name:       "required",
+			choice:     toolchoice.Choice{Mode: toolchoice.Required},
+			wantSuffix: generation + "<function=",
+			output:     `get_weather>{"city": "Paris"}</function><|eom_id|>`,
+			wantCall:   "get_weather",
+		},
+		{
+			name:       "custom function",
+			choice:     toolchoice.Choice{Mode: toolchoice.Function, Name: "get_weather"},
+			wantSuffix: generation + "<function=get_weather>",
+			output:     `{"city": "Paris"}</function><|eom_id|>`,
+			wantCall:   "get_weather",
+		},
+		{
+			name:       "built-in tool",
+			choice:     toolchoice.Choice{Mode: toolchoice.Function, Name: "brave_search"},
+			wantSuffix: generation + "<|python_tag|>brave_search.call(",
+			output:     `query="weather in Paris")<|eom_id|>`,
+			wantCall:   "brave_search",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newLlama3(Config{}, false)
+			prompt, err := model.EncodePrompt(format.Options{ToolChoice: tt.choice}, system, user)
+			if err != nil {
+				t.Fatalf("EncodePrompt failed: %v", err)
+			}
+			if !strings.HasSuffix(string(prompt.Data), tt.wantSuffix) {
+				t.Errorf("Expected the prompt to end with %q, got %q", tt.wantSuffix, prompt.Data)
+			}
+			if !strings.Contains(string(prompt.Data), tt.choice.Instruction()) {
+				t.Errorf("Expected the instruction %q in the prompt", tt.choice.Instruction())
+			}
+
+			msg, err := model.DecodePrompt(prompt, []byte(tt.output))
+			if err != nil {
+				t.Fatalf("DecodePrompt failed: %v", err)
+			}
+			if call := msg.Content.Slice()[0].ToolInput(); call == nil || call.Name != tt.wantCall {
+				t.Errorf("Expected a call to %s, got %v", tt.wantCall, msg.Content.Slice())
+			}
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		model := newLlama3(Config{}, false)
+		prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.None}}, system, user)
+		if err != nil {
+			t.Fatalf("EncodePrompt failed: %v", err)
+		}
+		if strings.Contains(string(prompt.Data), env) || !strings.HasSuffix(string(prompt.Data), generation) {
+			t.Errorf("Expected a prompt without tools, got %q", prompt.Data)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package llama3
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+)
+
+// image marks where an image is placed in the prompt of Llama 3.2 Vision, the images are passed
+// to the model in prompt order as described by the vision package
+const image = "<|image|>"
+
+// NewVision returns a Llama 3.2 Vision format for the given config. It is the Llama 3.1 format
+// with images from user blobs and tool results rendered as <|image|> tokens.
+func NewVision(config Config) (models.Format, error) {
+	return newLlama3(config, true), nil
+}
+
+func Constructor_3_2_Vision() (models.Format, error) {
+	return NewVision(Config{})
+}
@@ -0,0 +1,67 @@
+package llama3
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/vision"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestEncode_Vision(t *testing.T) {
+	messages := []ai.Message{
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(cm.ToList([]uint8{0x89, 'P', 'N', 'G'})),
+				ai.NewMessageContent(ai.Text("What is in this image?")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{Name: "screenshot", Arguments: cm.ToList([][2]string{})}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.ImageContent{ContentType: "image", Data: cm.ToList([]uint8("iVBORw==")), MIMEType: "image/png"}),
+					}),
+				}),
+			}),
+		},
+	}
+
+	model, _ := Constructor_3_2_Vision()
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|start_header_id|>user<|end_header_id|>\n<|image|>What is in this image?<|eot_id|>" +
+		"<|start_header_id|>assistant<|end_header_id|>\n<function=screenshot>{}</function><|eom_id|>" +
+		"<|start_header_id|>ipython<|end_header_id|>\n<|image|><|eot_id|>" +
+		"<|start_header_id|>assistant<|end_header_id|>\n"
+	if string(encoded) != expected {
+		t.Errorf("Encode() =\n%q\nwant\n%q", encoded, expected)
+	}
+
+	// Every placeholder has an image tensor
+	if n := strings.Count(string(encoded), image); n != len(vision.Images(messages...)) {
+		t.Errorf("found %d placeholders for %d images", n, len(vision.Images(messages...)))
+	}
+
+	// Text models never see the image token
+	encoded, err = newLlama3(Config{}, false).Encode(messages[0])
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if strings.Contains(string(encoded), image) {
+		t.Errorf("unexpected image token in %q", encoded)
+	}
+}
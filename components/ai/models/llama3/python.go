@@ -0,0 +1,316 @@
+package llama3
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"go.bytecodealliance.org/cm"
+)
+
+// parsePythonicCalls parses a zero-shot pythonic call list such as
+// [get_weather(city="SF"), get_time()]. The whole content must be the list.
+func parsePythonicCalls(content string) ([]mcp.CallToolParams, bool) {
+	if !strings.HasPrefix(content, "[") || !strings.HasSuffix(content, "]") {
+		return nil, false
+	}
+
+	var calls []mcp.CallToolParams
+	for _, part := range splitTopLevel(content[1:len(content)-1], ',') {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		call, ok := parsePythonicCall(part)
+		if !ok {
+			return nil, false
+		}
+		calls = append(calls, call)
+	}
+
+	return calls, len(calls) > 0
+}
+
+// parsePythonicCall parses a single python function call with keyword arguments
+func parsePythonicCall(content string) (mcp.CallToolParams, bool) {
+	match := parseFunc.FindStringSubmatch(strings.TrimSpace(content))
+	if match == nil {
+		return mcp.CallToolParams{}, false
+	}
+
+	// The parameter list must be balanced, otherwise the match spans several calls
+	params := match[2]
+	if !balanced(params) {
+		return mcp.CallToolParams{}, false
+	}
+
+	var args [][2]string
+	for _, param := range splitTopLevel(params, ',') {
+		if strings.TrimSpace(param) == "" {
+			continue
+		}
+		p := parseFuncParams.FindStringSubmatch(param)
+		if p == nil {
+			return mcp.CallToolParams{}, false
+		}
+		args = append(args, [2]string{p[1], pythonValue(p[2])})
+	}
+
+	return mcp.CallToolParams{
+		Name:      strings.TrimSpace(match[1]),
+		Arguments: cm.ToList(args),
+	}, true
+}
+
+// parseJSONCalls parses semicolon separated {"name": ..., "parameters": {...}} objects
+func parseJSONCalls(content string) ([]mcp.CallToolParams, error) {
+	var calls []mcp.CallToolParams
+	for _, part := range splitTopLevel(content, ';') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var call struct {
+			Name       string          `json:"name"`
+			Parameters json.RawMessage `json:"parameters"`
+			Arguments  json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(part), &call); err != nil {
+			return nil, err
+		}
+		if call.Name == "" {
+			return nil, fmt.Errorf("missing function name")
+		}
+
+		params := call.Parameters
+		if len(params) == 0 {
+			params = call.Arguments
+		}
+
+		args, err := arguments.Decode(params)
+		if err != nil {
+			return nil, err
+		}
+
+		calls = append(calls, mcp.CallToolParams{
+			Name:      call.Name,
+			Arguments: cm.ToList(args),
+		})
+	}
+
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("no function call found")
+	}
+	return calls, nil
+}
+
+// splitTopLevel splits s on sep, ignoring separators inside quotes, brackets, braces and parentheses
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// balanced reports whether the quotes, brackets, braces and parentheses in s are balanced
+func balanced(s string) bool {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+
+	return depth == 0 && quote == 0
+}
+
+// pythonValue converts a python literal into its argument string, python literals are
+// converted to the matching JSON values
+func pythonValue(literal string) string {
+	literal = strings.TrimSpace(literal)
+
+	if len(literal) >= 2 && (literal[0] == '"' || literal[0] == '\'') && literal[len(literal)-1] == literal[0] {
+		value, err := unquotePython(literal)
+		if err != nil {
+			value = literal[1 : len(literal)-1]
+		}
+		return value
+	}
+
+	// None, True, False, numbers, lists and dicts are converted to JSON
+	value, err := arguments.FormatRaw(json.RawMessage(pythonToJSON(literal)))
+	if err != nil {
+		return literal
+	}
+	return value
+}
+
+// unquotePython unquotes a single or double quoted python string
+func unquotePython(literal string) (string, error) {
+	if literal[0] == '"' {
+		return strconv.Unquote(literal)
+	}
+
+	// Swap the quoting so strconv can handle the escapes
+	inner := literal[1 : len(literal)-1]
+	inner = strings.ReplaceAll(inner, `\'`, `'`)
+	inner = strings.ReplaceAll(inner, `"`, `\"`)
+	return strconv.Unquote(`"` + inner + `"`)
+}
+
+// pythonToJSON rewrites python list and dict literals as JSON
+func pythonToJSON(literal string) string {
+	builder := &strings.Builder{}
+
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		switch {
+		case c == '"' || c == '\'':
+			// Copy the quoted string and re-quote it as a JSON string
+			end := i + 1
+			for end < len(literal) && literal[end] != c {
+				if literal[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(literal) {
+				builder.WriteString(literal[i:])
+				return builder.String()
+			}
+			value, err := unquotePython(literal[i : end+1])
+			if err != nil {
+				value = literal[i+1 : end]
+			}
+			quoted, _ := json.Marshal(value)
+			builder.Write(quoted)
+			i = end
+		case strings.HasPrefix(literal[i:], "True"):
+			builder.WriteString("true")
+			i += len("True") - 1
+		case strings.HasPrefix(literal[i:], "False"):
+			builder.WriteString("false")
+			i += len("False") - 1
+		case strings.HasPrefix(literal[i:], "None"):
+			builder.WriteString("null")
+			i += len("None") - 1
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return builder.String()
+}
+
+// formatPythonicCall renders a call as a python function call, e.g. brave_search.call(query="...").
+// The input schema of the tool, which may be nil, types the arguments.
+func formatPythonicCall(name string, arguments [][2]string, s *schema.Schema) string {
+	var params []string
+	for _, arg := range arguments {
+		params = append(params, fmt.Sprintf("%s=%s", arg[0], pythonLiteral(arg[1], s.Property(arg[0]))))
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+}
+
+// pythonLiteral renders an argument string as a python literal
+func pythonLiteral(value string, s *schema.Schema) string {
+	switch v := arguments.ValueFor(s, value).(type) {
+	case string:
+		return strconv.Quote(v)
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case nil:
+		return "None"
+	case json.Number:
+		return v.String()
+	default:
+		return jsonToPython(string(arguments.RawFor(s, value)))
+	}
+}
+
+// jsonToPython rewrites the true, false and null literals of a JSON list or object
+// as their python spelling
+func jsonToPython(raw string) string {
+	builder := &strings.Builder{}
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == '"':
+			// Copy strings verbatim, JSON strings are valid python strings
+			end := i + 1
+			for end < len(raw) && raw[end] != '"' {
+				if raw[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(raw) {
+				builder.WriteString(raw[i:])
+				return builder.String()
+			}
+			builder.WriteString(raw[i : end+1])
+			i = end
+		case strings.HasPrefix(raw[i:], "true"):
+			builder.WriteString("True")
+			i += len("true") - 1
+		case strings.HasPrefix(raw[i:], "false"):
+			builder.WriteString("False")
+			i += len("false") - 1
+		case strings.HasPrefix(raw[i:], "null"):
+			builder.WriteString("None")
+			i += len("null") - 1
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return builder.String()
+}
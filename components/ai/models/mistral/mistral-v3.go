@@ -0,0 +1,332 @@
+package mistral
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+const (
+	// Mistral v3 / Tekken control tokens
+	bos = "<s>"
+	eos = "</s>"
+
+	inst    = "[INST]"
+	instEnd = "[/INST]"
+
+	// Tool-related tokens
+	availableTools    = "[AVAILABLE_TOOLS]"
+	availableToolsEnd = "[/AVAILABLE_TOOLS]"
+	toolCalls         = "[TOOL_CALLS]"
+	toolResults       = "[TOOL_RESULTS]"
+	toolResultsEnd    = "[/TOOL_RESULTS]"
+)
+
+var _ models.Format = (*mistral)(nil)
+var _ format.Format = (*mistral)(nil)
+
+// StopSequences returns the tokens that end the output of the Mistral formats
+func StopSequences() []string {
+	return []string{eos}
+}
+
+// Constructor_v3 returns the SentencePiece based v3 template used by
+// Mistral 7B v0.3 and Mixtral 8x22B, which separates control tokens with spaces.
+func Constructor_v3() (models.Format, error) {
+	return newMistral(false), nil
+}
+
+// ConstructorTekken returns the v3 Tekken template used by Mistral Nemo,
+// which renders control tokens without surrounding whitespace.
+func ConstructorTekken() (models.Format, error) {
+	return newMistral(true), nil
+}
+
+type mistral struct {
+	format.Codec
+	tekken bool
+}
+
+func newMistral(tekken bool) *mistral {
+	m := &mistral{tekken: tekken}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.Mistral,
+		Stops:          StopSequences(),
+		Calls:          grammar.Mistral,
+	}
+	return m
+}
+
+// toolCallData is a single entry of the [TOOL_CALLS] array
+type toolCallData struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	ID        string          `json:"id,omitempty"`
+}
+
+// toolResultData is the payload of a [TOOL_RESULTS] block
+type toolResultData struct {
+	Content string `json:"content"`
+	CallID  string `json:"call_id"`
+}
+
+// space returns the separator placed after control tokens, which is empty for Tekken
+func (m *mistral) space() string {
+	if m.tekken {
+		return ""
+	}
+	return " "
+}
+
+func (m *mistral) decode(content string) (*ai.Message, error) {
+	complete := strings.Contains(content, eos)
+	content = strings.Replace(content, eos, "", -1)
+	content = strings.TrimPrefix(content, bos)
+
+	index := strings.Index(content, toolCalls)
+	if index == -1 {
+		// Regular text message
+		return &ai.Message{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text(strings.TrimSpace(content))),
+			}),
+		}, nil
+	}
+
+	var messageContents []ai.MessageContent
+
+	// Keep any text the model produced before the tool calls
+	if text := strings.TrimSpace(content[:index]); text != "" {
+		messageContents = append(messageContents, ai.NewMessageContent(ai.Text(text)))
+	}
+
+	calls, err := parseToolCalls(strings.TrimSpace(content[index+len(toolCalls):]))
+	if err != nil {
+		// Without the end of sequence token the call may still be streaming
+		if !complete {
+			return nil, &models.PartialDecodeError{}
+		}
+		return nil, fmt.Errorf("failed to parse tool calls: %v", err)
+	}
+
+	for _, call := range calls {
+		args, err := arguments.Decode(call.Arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		messageContents = append(messageContents, ai.NewMessageContent(mcp.CallToolParams{
+			Name:      call.Name,
+			Arguments: cm.ToList(args),
+		}))
+	}
+
+	if len(messageContents) == 0 {
+		return nil, &models.PartialDecodeError{}
+	}
+
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(messageContents),
+	}, nil
+}
+
+// parseToolCalls parses the JSON following [TOOL_CALLS], which is either an
+// array of calls or, for some fine-tunes, a single call object
+func parseToolCalls(raw string) ([]toolCallData, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("empty tool call")
+	}
+
+	var calls []toolCallData
+	if strings.HasPrefix(raw, "{") {
+		var call toolCallData
+		if err := json.Unmarshal([]byte(raw), &call); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	} else if err := json.Unmarshal([]byte(raw), &calls); err != nil {
+		return nil, err
+	}
+
+	for _, call := range calls {
+		if call.Name == "" {
+			return nil, fmt.Errorf("tool call is missing a name")
+		}
+	}
+
+	return calls, nil
+}
+
+// toolCallPrefill returns the start of the [TOOL_CALLS] array written at the end of the prompt
+// when the choice requires a call, with the name filled in when a specific tool is required
+func (m *mistral) toolCallPrefill(choice toolchoice.Choice) string {
+	switch choice.Mode {
+	case toolchoice.Required:
+		return fmt.Sprintf("%s%s[", toolCalls, m.space())
+	case toolchoice.Function:
+		name, _ := json.Marshal(choice.Name)
+		return fmt.Sprintf("%s%s[{\"name\": %s, \"arguments\": ", toolCalls, m.space(), name)
+	}
+	return ""
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *mistral) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	builder := &strings.Builder{}
+
+	choice, prefill := opts.ToolChoice, ""
+
+	// First pass: collect the system prompt and tools, and find the last user message.
+	// Mistral has no system role, the system prompt is prepended to the last user message
+	// and the available tools are rendered right before it.
+	var tools []mcp.Tool
+	systemContent := ""
+	lastUserIndex := -1
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					c := content.Text()
+					if systemContent != "" {
+						systemContent += "\n\n"
+					}
+					systemContent += *c
+				case "tools":
+					tools = content.Tools().Slice()
+				}
+			}
+		case ai.RoleUser:
+			lastUserIndex = i
+		}
+	}
+
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+	if instruction := choice.Instruction(); instruction != "" {
+		if systemContent != "" {
+			systemContent += "\n\n"
+		}
+		systemContent += instruction
+	}
+
+	builder.WriteString(bos)
+
+	// Pending tool call ids, consumed in order by the tool messages that follow
+	var pendingIDs []string
+
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem:
+			// Rendered with the last user message
+
+		case ai.RoleUser:
+			if i == lastUserIndex && len(tools) > 0 {
+				builder.WriteString(fmt.Sprintf("%s%s%s%s", availableTools, m.space(), schema.Tools(tools), availableToolsEnd))
+			}
+
+			builder.WriteString(fmt.Sprintf("%s%s", inst, m.space()))
+			if i == lastUserIndex && systemContent != "" {
+				builder.WriteString(fmt.Sprintf("%s\n\n", systemContent))
+			}
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "text" {
+					c := content.Text()
+					builder.WriteString(*c)
+				}
+			}
+			builder.WriteString(instEnd)
+
+		case ai.RoleAssistant:
+			var calls []string
+			pendingIDs = nil
+
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					c := content.Text()
+					text := *c
+					if !m.tekken {
+						text = " " + strings.TrimSpace(text)
+					}
+					builder.WriteString(text)
+				case "tool-input":
+					c := content.ToolInput()
+					id := toolresult.CallID(i, len(calls), c.Name)
+
+					callBytes, err := json.Marshal(struct {
+						Name      string          `json:"name"`
+						Arguments json.RawMessage `json:"arguments"`
+						ID        string          `json:"id"`
+					}{
+						Name:      c.Name,
+						Arguments: arguments.EncodeCall(tools, *c),
+						ID:        id,
+					})
+					if err != nil {
+						return nil, fmt.Errorf("failed to marshal tool call: %v", err)
+					}
+
+					calls = append(calls, string(callBytes))
+					pendingIDs = append(pendingIDs, id)
+				}
+			}
+
+			if len(calls) > 0 {
+				builder.WriteString(fmt.Sprintf("%s%s[%s]", toolCalls, m.space(), strings.Join(calls, ", ")))
+			}
+
+			builder.WriteString(eos)
+
+		case ai.RoleTool:
+			callID := ""
+			if len(pendingIDs) > 0 {
+				callID = pendingIDs[0]
+				pendingIDs = pendingIDs[1:]
+			} else {
+				// Tool output without a preceding call, still give it a well formed id
+				callID = toolresult.CallID(i, 0, "")
+			}
+
+			resultBytes, err := json.Marshal(toolResultData{
+				Content: toolresult.Message(msg),
+				CallID:  callID,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal tool result: %v", err)
+			}
+
+			builder.WriteString(fmt.Sprintf("%s%s%s%s", toolResults, m.space(), string(resultBytes), toolResultsEnd))
+
+		default:
+			return nil, fmt.Errorf("unsupported message role: %v", msg.Role)
+		}
+	}
+
+	// The model answers right after the last message, a required tool call is prefilled there
+	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
+		prefill = m.toolCallPrefill(choice)
+		builder.WriteString(prefill)
+	}
+
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
+}
@@ -0,0 +1,214 @@
+package mistral
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         []byte
+		wantText      string
+		wantToolNames []string
+		wantToolArgs  [][][2]string
+		wantPartial   bool
+		wantErr       bool
+	}{
+		{
+			name:     "basic decode",
+			input:    []byte("Hello, world!</s>"),
+			wantText: "Hello, world!",
+		},
+		{
+			name:          "decode single tool call",
+			input:         []byte(`[TOOL_CALLS][{"name": "get_weather", "arguments": {"city": "Paris"}, "id": "a1b2c3d4e"}]</s>`),
+			wantToolNames: []string{"get_weather"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}},
+		},
+		{
+			name:          "decode multiple tool calls with spaced v3 output",
+			input:         []byte(`[TOOL_CALLS] [{"name": "get_weather", "arguments": {"city": "Paris"}}, {"name": "get_time", "arguments": {}}]</s>`),
+			wantToolNames: []string{"get_weather", "get_time"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}, nil},
+		},
+		{
+			name:          "decode string encoded arguments",
+			input:         []byte(`[TOOL_CALLS][{"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}]</s>`),
+			wantToolNames: []string{"get_weather"},
+			wantToolArgs:  [][][2]string{{{"city", "Paris"}}},
+		},
+		{
+			name:        "incomplete tool call while streaming",
+			input:       []byte(`[TOOL_CALLS][{"name": "get_weather", "argu`),
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:    "invalid tool call",
+			input:   []byte(`[TOOL_CALLS][{"name": "get_weather", "argu</s>`),
+			wantErr: true,
+		},
+	}
+
+	model := newMistral(true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var partial *models.PartialDecodeError
+				if errors.As(err, &partial) != tt.wantPartial {
+					t.Errorf("Decode() error = %v, wantPartial %v", err, tt.wantPartial)
+				}
+				return
+			}
+
+			if msg.Role != ai.RoleAssistant {
+				t.Errorf("Expected role '%s', got '%s'", ai.RoleAssistant, msg.Role)
+			}
+
+			calls := 0
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					if *content.Text() != tt.wantText {
+						t.Errorf("Expected content '%s', got '%s'", tt.wantText, *content.Text())
+					}
+				case "tool-input":
+					if calls >= len(tt.wantToolNames) {
+						t.Fatalf("Unexpected tool call: %s", content.ToolInput().Name)
+					}
+					if content.ToolInput().Name != tt.wantToolNames[calls] {
+						t.Errorf("Expected tool input name '%s', got '%s'", tt.wantToolNames[calls], content.ToolInput().Name)
+					}
+					for i, arg := range content.ToolInput().Arguments.Slice() {
+						if arg != tt.wantToolArgs[calls][i] {
+							t.Errorf("Expected tool input argument '%s', got '%s'", tt.wantToolArgs[calls][i], arg)
+						}
+					}
+					calls++
+				default:
+					t.Errorf("Unexpected content type: %s", content.String())
+				}
+			}
+			if calls != len(tt.wantToolNames) {
+				t.Errorf("Expected %d tool calls, got %d", len(tt.wantToolNames), calls)
+			}
+		})
+	}
+}
+
+func toolConversation() []ai.Message {
+	return []ai.Message{
+		{
+			Role: ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("You are a helpful assistant.")),
+				ai.NewMessageContent(cm.ToList([]mcp.Tool{
+					{
+						Name:        "get_weather",
+						Description: "Get the weather for a city",
+						InputSchema: mcp.ToolSchema{
+							SchemaType: "object",
+							Properties: cm.ToList([][2]string{
+								{"city", `{"type": "string", "description": "The city name"}`},
+							}),
+							Required: cm.ToList([]string{"city"}),
+						},
+					},
+				})),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("What's the weather in Paris?")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{
+					Name:      "get_weather",
+					Arguments: cm.ToList([][2]string{{"city", "Paris"}}),
+				}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny, 22C"}),
+					}),
+				}),
+			}),
+		},
+	}
+}
+
+func TestEncode_Tekken(t *testing.T) {
+	model := newMistral(true)
+
+	encoded, err := model.Encode(toolConversation()...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	id := toolresult.CallID(2, 0, "get_weather")
+	expected := `<s>[AVAILABLE_TOOLS][{"type":"function","function":{"name":"get_weather","description":"Get the weather for a city","parameters":{"type":"object","properties":{"city":{"type":"string","description":"The city name"}},"required":["city"]}}}][/AVAILABLE_TOOLS]` +
+		"[INST]You are a helpful assistant.\n\nWhat's the weather in Paris?[/INST]" +
+		`[TOOL_CALLS][{"name":"get_weather","arguments":{"city":"Paris"},"id":"` + id + `"}]</s>` +
+		`[TOOL_RESULTS]{"content":"Sunny, 22C","call_id":"` + id + `"}[/TOOL_RESULTS]`
+
+	if result != expected {
+		t.Errorf("Unexpected encoding\nwant: %s\ngot:  %s", expected, result)
+	}
+}
+
+func TestEncode_V3(t *testing.T) {
+	model := newMistral(false)
+
+	messages := []ai.Message{
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Hello")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Hi there! ")),
+			}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("How are you?")),
+			}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<s>[INST] Hello[/INST] Hi there!</s>[INST] How are you?[/INST]"
+	if string(encoded) != expected {
+		t.Errorf("Unexpected encoding\nwant: %s\ngot:  %s", expected, string(encoded))
+	}
+}
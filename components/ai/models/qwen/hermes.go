@@ -0,0 +1,154 @@
+package qwen
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+)
+
+const (
+	// hermesToolsPrompt opens the function calling system turn of the Hermes tool use template,
+	// the tool signatures follow it
+	hermesToolsPrompt = "You are a function calling AI model. You are provided with function signatures within <tools></tools> XML tags. You may call one or more functions to assist with the user query. Don't make assumptions about what values to plug into functions. Here are the available tools: <tools>"
+
+	// hermesCallPrompt describes the expected tool calls after the signatures
+	hermesCallPrompt = "Use the following pydantic model json schema for each tool call you will make: {\"properties\": {\"name\": {\"title\": \"Name\", \"type\": \"string\"}, \"arguments\": {\"title\": \"Arguments\", \"type\": \"object\"}}, \"required\": [\"name\", \"arguments\"], \"title\": \"FunctionCall\", \"type\": \"object\"}\nFor each function call return a json object with function name and arguments within <tool_call></tool_call> XML tags as follows:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-dict>}\n</tool_call>"
+)
+
+var _ models.Format = (*hermes)(nil)
+var _ format.Format = (*hermes)(nil)
+
+// ConstructorHermes returns the format of the NousResearch Hermes 2 Pro and Hermes 3 models.
+// Turns are written in ChatML like Qwen 2.5, but the tools get a system turn of their own with
+// the Hermes function calling prompt and tool results are sent back in a tool turn.
+func ConstructorHermes() (models.Format, error) {
+	return newHermes(), nil
+}
+
+type hermes struct {
+	format.Codec
+}
+
+func newHermes() *hermes {
+	m := &hermes{}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   decodeChatML,
+		Stream:         stream.ChatML,
+		Stops:          StopSequences(),
+		Calls:          grammar.Hermes,
+	}
+	return m
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *hermes) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
+	builder := &strings.Builder{}
+
+	choice, prefill := opts.ToolChoice, ""
+
+	var tools []mcp.Tool
+	for _, msg := range messages {
+		if msg.Role == ai.RoleSystem {
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "tools" {
+					tools = content.Tools().Slice()
+					break
+				}
+			}
+			break
+		}
+	}
+
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+
+	// The function calling prompt is a system turn of its own, written before any other
+	if len(tools) > 0 {
+		builder.WriteString(fmt.Sprintf("%ssystem\n%s", imStart, hermesToolsPrompt))
+		for _, tool := range tools {
+			builder.WriteString(fmt.Sprintf(" %s", schema.Tool(tool)))
+		}
+		builder.WriteString(fmt.Sprintf(" </tools> %s", hermesCallPrompt))
+
+		if instruction := choice.Instruction(); instruction != "" {
+			builder.WriteString(fmt.Sprintf("\n%s", instruction))
+		}
+
+		builder.WriteString(fmt.Sprintf("%s\n", imEnd))
+	}
+
+	for i, msg := range messages {
+		switch msg.Role {
+		case ai.RoleSystem, ai.RoleUser:
+			text := ""
+			for _, content := range msg.Content.Slice() {
+				if content.String() == "text" {
+					text += *content.Text()
+				}
+			}
+
+			// A system message may only carry the tools
+			if msg.Role == ai.RoleSystem && text == "" {
+				continue
+			}
+
+			role := "user"
+			if msg.Role == ai.RoleSystem {
+				role = "system"
+			}
+			builder.WriteString(fmt.Sprintf("%s%s\n%s%s\n", imStart, role, text, imEnd))
+
+		case ai.RoleAssistant:
+			builder.WriteString(fmt.Sprintf("%sassistant", imStart))
+
+			for _, content := range msg.Content.Slice() {
+				switch content.String() {
+				case "text":
+					builder.WriteString(fmt.Sprintf("\n%s", *content.Text()))
+				case "tool-input":
+					c := content.ToolInput()
+					name, _ := json.Marshal(c.Name)
+					builder.WriteString(fmt.Sprintf("\n%s\n{\"name\": %s, \"arguments\": %s}\n%s", toolCall, name, arguments.EncodeCall(tools, *c), toolCallEnd))
+				}
+			}
+
+			builder.WriteString(fmt.Sprintf("%s\n", imEnd))
+
+		case ai.RoleTool:
+			// Consecutive tool results share a single tool turn
+			if i == 0 || messages[i-1].Role != ai.RoleTool {
+				builder.WriteString(fmt.Sprintf("%stool", imStart))
+			}
+
+			writeToolResponses(builder, msg, false)
+
+			if i == len(messages)-1 || messages[i+1].Role != ai.RoleTool {
+				builder.WriteString(fmt.Sprintf("%s\n", imEnd))
+			}
+
+		default:
+			return nil, fmt.Errorf("unsupported message role: %v", msg.Role)
+		}
+	}
+
+	// Add generation prompt if the last message is not from assistant
+	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
+		builder.WriteString(fmt.Sprintf("%sassistant\n", imStart))
+		prefill = toolCallPrefill(choice)
+		builder.WriteString(prefill)
+	}
+
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
+}
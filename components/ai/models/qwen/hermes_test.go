@@ -0,0 +1,122 @@
+package qwen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestHermesEncode_ToolsRoundTrip(t *testing.T) {
+	weather := mcp.Tool{
+		Name:        "get_weather",
+		Description: "Get the weather",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{{"city", `{"type": "string"}`}}),
+			Required:   cm.ToList([]string{"city"}),
+		},
+	}
+	toolOutput := func(text string) ai.MessageContent {
+		return ai.NewMessageContent(mcp.CallToolResult{
+			Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text})}),
+		})
+	}
+
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(ai.Text("You are helpful.")),
+			ai.NewMessageContent(cm.ToList([]mcp.Tool{weather})),
+		})},
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Paris and London?"))})},
+		{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(mcp.CallToolParams{Name: "get_weather", Arguments: cm.ToList([][2]string{{"city", "Paris"}})}),
+			ai.NewMessageContent(mcp.CallToolParams{Name: "get_weather", Arguments: cm.ToList([][2]string{{"city", "London"}})}),
+		})},
+		{Role: ai.RoleTool, Content: cm.ToList([]ai.MessageContent{toolOutput("Sunny")})},
+		{Role: ai.RoleTool, Content: cm.ToList([]ai.MessageContent{toolOutput("Rainy")})},
+	}
+
+	model := newHermes()
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	prompt := string(encoded)
+
+	for _, want := range []string{
+		"<|im_start|>system\nYou are a function calling AI model.",
+		`Here are the available tools: <tools> {"type":"function","function":{"name":"get_weather","description":"Get the weather","parameters":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}}} </tools>`,
+		"</tool_call><|im_end|>\n<|im_start|>system\nYou are helpful.<|im_end|>\n",
+		"<|im_start|>assistant\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\":\"Paris\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\":\"London\"}}\n</tool_call><|im_end|>\n",
+		"<|im_start|>tool\n<tool_response>\nSunny\n</tool_response>\n<tool_response>\nRainy\n</tool_response><|im_end|>\n<|im_start|>assistant\n",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected %q in prompt:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "<|im_start|>user\n<tool_response>") {
+		t.Errorf("Expected tool results in a tool turn, got:\n%s", prompt)
+	}
+
+	// The calls written to the prompt decode back to the same calls
+	start := strings.Index(prompt, "<|im_start|>assistant\n") + len("<|im_start|>assistant\n")
+	end := strings.Index(prompt[start:], "<|im_end|>") + len("<|im_end|>")
+	msg, err := model.Decode([]byte(prompt[start : start+end]))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	contents := msg.Content.Slice()
+	if len(contents) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %d", len(contents))
+	}
+	for i, city := range []string{"Paris", "London"} {
+		call := contents[i].ToolInput()
+		if call == nil || call.Name != "get_weather" || call.Arguments.Slice()[0] != [2]string{"city", city} {
+			t.Errorf("Unexpected content %d: %s", i, contents[i].String())
+		}
+	}
+}
+
+func TestHermesEncode_WithoutTools(t *testing.T) {
+	model := newHermes()
+	encoded, err := model.Encode(ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})})
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\n"
+	if string(encoded) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(encoded))
+	}
+}
+
+func TestHermesEncode_ToolChoice(t *testing.T) {
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(cm.ToList([]mcp.Tool{{Name: "get_weather", InputSchema: mcp.ToolSchema{SchemaType: "object"}}})),
+		})},
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Paris?"))})},
+	}
+
+	model := newHermes()
+	prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.Function, Name: "get_weather"}}, messages...)
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+	if !strings.HasSuffix(string(prompt.Data), "<|im_start|>assistant\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": ") {
+		t.Errorf("Expected the call to be prefilled, got:\n%s", prompt.Data)
+	}
+
+	msg, err := model.DecodePrompt(prompt, []byte("{\"city\": \"Paris\"}}\n</tool_call><|im_end|>"))
+	if err != nil {
+		t.Fatalf("DecodePrompt failed: %v", err)
+	}
+	if call := msg.Content.Slice()[0].ToolInput(); call == nil || call.Name != "get_weather" {
+		t.Errorf("Expected the prefilled call, got %s", msg.Content.Slice()[0].String())
+	}
+}
@@ -0,0 +1,25 @@
+package qwen
+
+import (
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+)
+
+const (
+	// Qwen2-VL vision tokens, the image pad is expanded to the image patches by the runtime
+	visionStart = "<|vision_start|>"
+	imagePad    = "<|image_pad|>"
+	visionEnd   = "<|vision_end|>"
+
+	// imagePlaceholder marks where an image is placed in the prompt, the images are passed to
+	// the model in prompt order as described by the vision package
+	imagePlaceholder = visionStart + imagePad + visionEnd
+
+	// Default system message of Qwen2-VL and Qwen2.5-VL
+	defaultVisionSystem = "You are a helpful assistant."
+)
+
+// ConstructorQwen_2_VL returns the Qwen2-VL and Qwen2.5-VL format. It is the Qwen 2.5 format
+// with images from user blobs and tool results rendered as vision placeholders.
+func ConstructorQwen_2_VL() (models.Format, error) {
+	return newQwen25(true), nil
+}
@@ -0,0 +1,75 @@
+package qwen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/vision"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestQwen2VLEncode_Images(t *testing.T) {
+	messages := []ai.Message{
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(cm.ToList([]uint8{0x89, 'P', 'N', 'G'})),
+				ai.NewMessageContent(ai.Text("Describe this image.")),
+			}),
+		},
+		{
+			Role: ai.RoleAssistant,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolParams{Name: "screenshot", Arguments: cm.ToList([][2]string{})}),
+			}),
+		},
+		{
+			Role: ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(mcp.CallToolResult{
+					Content: cm.ToList([]mcp.Content{
+						mcp.NewContent(mcp.ImageContent{ContentType: "image", Data: cm.ToList([]uint8("iVBORw==")), MIMEType: "image/png"}),
+					}),
+				}),
+			}),
+		},
+	}
+
+	model, _ := ConstructorQwen_2_VL()
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|im_start|>system\nYou are a helpful assistant.<|im_end|>\n" +
+		"<|im_start|>user\n<|vision_start|><|image_pad|><|vision_end|>Describe this image.<|im_end|>\n" +
+		"<|im_start|>assistant\n<tool_call>\n{\"arguments\":{},\"name\":\"screenshot\"}\n</tool_call><|im_end|>\n" +
+		"<|im_start|>user\n<tool_response>\n<|vision_start|><|image_pad|><|vision_end|>\n</tool_response><|im_end|>\n" +
+		"<|im_start|>assistant\n"
+	if string(encoded) != expected {
+		t.Errorf("Encode() =\n%q\nwant\n%q", encoded, expected)
+	}
+
+	if n := strings.Count(string(encoded), imagePad); n != len(vision.Images(messages...)) {
+		t.Errorf("found %d placeholders for %d images", n, len(vision.Images(messages...)))
+	}
+
+	// The prompt carries the images of its placeholders, formats without vision carry none
+	prompt, err := model.(format.Format).EncodePrompt(format.Options{}, messages...)
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+	if len(prompt.Images) != 2 {
+		t.Errorf("Expected the 2 images of the prompt, got %d", len(prompt.Images))
+	}
+	text, err := newQwen25(false).EncodePrompt(format.Options{}, messages...)
+	if err != nil {
+		t.Fatalf("EncodePrompt failed: %v", err)
+	}
+	if len(text.Images) != 0 || strings.Contains(string(text.Data), "iVBORw==") {
+		t.Errorf("Expected no images and no image data in a text prompt, got %d images in %q", len(text.Images), text.Data)
+	}
+}
@@ -9,13 +9,19 @@ import (
 	"github.com/hayride-dev/bindings/go/hayride/ai"
 	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/vision"
 	"go.bytecodealliance.org/cm"
 )
 
 var (
 	// Regular expression to match tool calls in the format:
 	// <tool_call>\n{"name": "function_name", "arguments": {...}}\n</tool_call>
-	toolCallRegex = regexp.MustCompile(`<tool_call>\s*(\{.*?\})\s*</tool_call>`)
+	toolCallRegex = regexp.MustCompile(`(?s)<tool_call>\s*(\{.*?\})\s*</tool_call>`)
 )
 
 const (
@@ -31,64 +37,56 @@ const (
 )
 
 var _ models.Format = (*qwen25)(nil)
+var _ format.Format = (*qwen25)(nil)
 
 func ConstructorQwen_2_5() (models.Format, error) {
-	return &qwen25{}, nil
+	return newQwen25(false), nil
 }
 
-type qwen25 struct{}
-
-func (m *qwen25) Decode(data []byte) (*ai.Message, error) {
-	content := string(data)
+type qwen25 struct {
+	format.Codec
+	// vision renders images as vision placeholders, see ConstructorQwen_2_VL
+	vision bool
+}
 
-	// Check if this is a tool call response
-	if strings.Contains(content, toolCall) {
-		matches := toolCallRegex.FindStringSubmatch(content)
-		if len(matches) != 2 {
-			return nil, fmt.Errorf("failed to parse tool call, invalid format")
-		}
+func newQwen25(vision bool) *qwen25 {
+	m := &qwen25{vision: vision}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   decodeChatML,
+		Stream:         stream.ChatML,
+		Stops:          StopSequences(),
+		Calls:          grammar.Hermes,
+	}
+	return m
+}
 
-		// Parse the JSON tool call
-		var toolCallData struct {
-			Name      string          `json:"name"`
-			Arguments json.RawMessage `json:"arguments"`
-		}
+// decodeChatML decodes the output of the ChatML formats that write tool calls as <tool_call> blocks
+func decodeChatML(data string) (*ai.Message, error) {
+	content, complete := trimEndOfTurn(data)
 
-		if err := json.Unmarshal([]byte(matches[1]), &toolCallData); err != nil {
-			return nil, fmt.Errorf("failed to parse tool call JSON: %v", err)
-		}
+	// While streaming, wait for tags that are only partially generated
+	if !complete && hasPartialTag(content, imEnd, endOfText, toolCall, toolCallEnd) {
+		return nil, &models.PartialDecodeError{}
+	}
 
-		// Convert arguments to [][2]string format
-		var argsMap map[string]interface{}
-		if err := json.Unmarshal(toolCallData.Arguments, &argsMap); err != nil {
-			return nil, fmt.Errorf("failed to parse tool arguments: %v", err)
+	// Check if this is a tool call response, every <tool_call> block is its own tool-input
+	if strings.Contains(content, toolCall) {
+		if hasOpenBlock(content, toolCall, toolCallEnd) {
+			if !complete {
+				return nil, &models.PartialDecodeError{}
+			}
+			return nil, fmt.Errorf("failed to parse tool call, missing %s", toolCallEnd)
 		}
 
-		var args [][2]string
-		for k, v := range argsMap {
-			// Convert value to string
-			var valueStr string
-			switch val := v.(type) {
-			case string:
-				valueStr = val
-			case nil:
-				valueStr = ""
-			default:
-				// Convert other types to JSON string
-				jsonBytes, _ := json.Marshal(val)
-				valueStr = string(jsonBytes)
-			}
-			args = append(args, [2]string{k, valueStr})
+		contents, err := parseToolCalls(content, toolCallRegex)
+		if err != nil {
+			return nil, err
 		}
 
 		return &ai.Message{
-			Role: ai.RoleAssistant,
-			Content: cm.ToList([]ai.MessageContent{
-				ai.NewMessageContent(mcp.CallToolParams{
-					Name:      toolCallData.Name,
-					Arguments: cm.ToList(args),
-				}),
-			}),
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList(contents),
 		}, nil
 	}
 
@@ -101,9 +99,12 @@ func (m *qwen25) Decode(data []byte) (*ai.Message, error) {
 	}, nil
 }
 
-func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *qwen25) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
 	builder := &strings.Builder{}
 
+	choice, prefill := opts.ToolChoice, ""
+
 	// Track if we have tools available
 	var tools []mcp.Tool
 	hasTools := false
@@ -122,6 +123,17 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 		}
 	}
 
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+	hasTools = len(tools) > 0
+
+	// Qwen2-VL always starts with a system turn
+	if m.vision && (len(messages) == 0 || messages[0].Role != ai.RoleSystem) {
+		builder.WriteString(fmt.Sprintf("%ssystem\n%s%s\n", imStart, defaultVisionSystem, imEnd))
+	}
+
 	// Process messages
 	for i, msg := range messages {
 		switch msg.Role {
@@ -141,6 +153,9 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 			// Use default system message if none provided
 			if systemContent == "" {
 				systemContent = "You are Qwen, created by Alibaba Cloud. You are a helpful assistant."
+				if m.vision {
+					systemContent = defaultVisionSystem
+				}
 			}
 
 			builder.WriteString(systemContent)
@@ -150,26 +165,14 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 				builder.WriteString("\n\n# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>")
 
 				for _, tool := range tools {
-					toolJSON := map[string]interface{}{
-						"name":        tool.Name,
-						"description": tool.Description,
-						"parameters":  map[string]interface{}{},
-					}
-
-					// Add parameters if available
-					if len(tool.InputSchema.Properties.Slice()) > 0 {
-						params := make(map[string]interface{})
-						for _, prop := range tool.InputSchema.Properties.Slice() {
-							params[prop[0]] = prop[1]
-						}
-						toolJSON["parameters"] = params
-					}
-
-					toolBytes, _ := json.Marshal(toolJSON)
-					builder.WriteString(fmt.Sprintf("\n%s", string(toolBytes)))
+					builder.WriteString(fmt.Sprintf("\n%s", schema.Tool(tool)))
 				}
 
 				builder.WriteString("\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call>")
+
+				if instruction := choice.Instruction(); instruction != "" {
+					builder.WriteString(fmt.Sprintf("\n\n%s", instruction))
+				}
 			}
 
 			builder.WriteString(fmt.Sprintf("%s\n", imEnd))
@@ -178,9 +181,14 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 			builder.WriteString(fmt.Sprintf("%suser\n", imStart))
 
 			for _, content := range msg.Content.Slice() {
-				if content.String() == "text" {
+				switch content.String() {
+				case "text":
 					c := content.Text()
 					builder.WriteString(*c)
+				case "blob":
+					if m.vision {
+						builder.WriteString(imagePlaceholder)
+					}
 				}
 			}
 
@@ -197,20 +205,9 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 				case "tool-input":
 					c := content.ToolInput()
 
-					// Convert arguments to JSON object
-					argsMap := make(map[string]interface{})
-					for _, arg := range c.Arguments.Slice() {
-						// Try to parse as JSON, otherwise use as string
-						var value interface{}
-						if err := json.Unmarshal([]byte(arg[1]), &value); err != nil {
-							value = arg[1]
-						}
-						argsMap[arg[0]] = value
-					}
-
 					toolCallJSON := map[string]interface{}{
 						"name":      c.Name,
-						"arguments": argsMap,
+						"arguments": arguments.EncodeCall(tools, *c),
 					}
 
 					toolCallBytes, _ := json.Marshal(toolCallJSON)
@@ -226,38 +223,8 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 				builder.WriteString(fmt.Sprintf("%suser", imStart))
 			}
 
-			builder.WriteString(fmt.Sprintf("\n%s\n", toolResponse))
-
-			for _, content := range msg.Content.Slice() {
-				if content.String() == "tool-output" {
-					output := content.ToolOutput()
-					for _, c := range output.Content.Slice() {
-						switch c.String() {
-						case "text":
-							builder.WriteString(c.Text().Text)
-						case "image":
-							image := c.Image()
-							builder.WriteString(fmt.Sprintf("Image Data: %v", image.Data))
-						case "audio":
-							audio := c.Audio()
-							builder.WriteString(fmt.Sprintf("Audio Data: %v", audio.Data))
-						case "resource-link":
-							resource := c.ResourceLink()
-							builder.WriteString(fmt.Sprintf("Resource Link: %s", resource.URI))
-						case "resource-content":
-							content := c.ResourceContent()
-							switch content.ResourceContents.String() {
-							case "text":
-								builder.WriteString(fmt.Sprintf("Resource Content (Text): %s", content.ResourceContents.Text()))
-							case "blob":
-								builder.WriteString(fmt.Sprintf("Resource Content (Blob): %v", content.ResourceContents.Blob()))
-							}
-						}
-					}
-				}
-			}
-
-			builder.WriteString(fmt.Sprintf("\n%s", toolResponseEnd))
+			// Each tool result gets its own <tool_response> block inside the grouped user turn
+			writeToolResponses(builder, msg, m.vision)
 
 			// Check if this is the last tool message or if the next message is not a tool
 			if i == len(messages)-1 || messages[i+1].Role != ai.RoleTool {
@@ -272,7 +239,13 @@ func (m *qwen25) Encode(messages ...ai.Message) ([]byte, error) {
 	// Add generation prompt if the last message is not from assistant
 	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
 		builder.WriteString(fmt.Sprintf("%sassistant\n", imStart))
+		prefill = toolCallPrefill(choice)
+		builder.WriteString(prefill)
 	}
 
-	return []byte(builder.String()), nil
+	prompt := &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}
+	if m.vision {
+		prompt.Images = vision.Images(messages...)
+	}
+	return prompt, nil
 }
@@ -0,0 +1,254 @@
+package qwen
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestDecode_MultipleToolCalls(t *testing.T) {
+	model := newQwen25(false)
+
+	msg, err := model.Decode([]byte("I'll check both cities.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"London\"}}\n</tool_call>"))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	contents := msg.Content.Slice()
+	if len(contents) != 3 {
+		t.Fatalf("Expected 3 content items, got %d", len(contents))
+	}
+	if contents[0].String() != "text" || *contents[0].Text() != "I'll check both cities." {
+		t.Errorf("Expected leading text, got %s", contents[0].String())
+	}
+	for i, city := range []string{"Paris", "London"} {
+		call := contents[i+1].ToolInput()
+		if call == nil {
+			t.Fatalf("Expected tool input at %d, got %s", i+1, contents[i+1].String())
+		}
+		if call.Name != "get_weather" || call.Arguments.Slice()[0] != [2]string{"city", city} {
+			t.Errorf("Unexpected tool call %d: %s %v", i, call.Name, call.Arguments.Slice())
+		}
+	}
+}
+
+func TestEncode_MultipleToolCallsRoundTrip(t *testing.T) {
+	model := newQwen25(false)
+
+	assistant := ai.Message{
+		Role: ai.RoleAssistant,
+		Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(ai.Text("I'll check both cities.")),
+			ai.NewMessageContent(mcp.CallToolParams{
+				Name:      "get_weather",
+				Arguments: cm.ToList([][2]string{{"city", "Paris"}}),
+			}),
+			ai.NewMessageContent(mcp.CallToolParams{
+				Name:      "get_weather",
+				Arguments: cm.ToList([][2]string{{"city", "London"}}),
+			}),
+		}),
+	}
+
+	toolOutput := func(text string) ai.MessageContent {
+		return ai.NewMessageContent(mcp.CallToolResult{
+			Content: cm.ToList([]mcp.Content{
+				mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text}),
+			}),
+		})
+	}
+
+	messages := []ai.Message{
+		{
+			Role:    ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Paris and London?"))}),
+		},
+		assistant,
+		{
+			Role:    ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{toolOutput("Sunny"), toolOutput("Rainy")}),
+		},
+	}
+
+	encoded, err := model.Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	result := string(encoded)
+	t.Logf("Encoded output:\n%s", result)
+
+	expected := "<|im_start|>user\nWeather in Paris and London?<|im_end|>\n" +
+		"<|im_start|>assistant\nI'll check both cities." +
+		"\n<tool_call>\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n</tool_call>" +
+		"\n<tool_call>\n{\"arguments\":{\"city\":\"London\"},\"name\":\"get_weather\"}\n</tool_call><|im_end|>\n" +
+		"<|im_start|>user\n<tool_response>\nSunny\n</tool_response>\n<tool_response>\nRainy\n</tool_response><|im_end|>\n" +
+		"<|im_start|>assistant\n"
+
+	if result != expected {
+		t.Errorf("Unexpected encoding\nwant: %q\ngot:  %q", expected, result)
+	}
+
+	// Decoding the rendered assistant turn must give back the same content items
+	start := len("<|im_start|>user\nWeather in Paris and London?<|im_end|>\n<|im_start|>assistant\n")
+	end := start + len("I'll check both cities.\n<tool_call>\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n</tool_call>\n<tool_call>\n{\"arguments\":{\"city\":\"London\"},\"name\":\"get_weather\"}\n</tool_call>")
+	decoded, err := model.Decode(encoded[start:end])
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	want := assistant.Content.Slice()
+	got := decoded.Content.Slice()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d content items, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].String() != want[i].String() {
+			t.Errorf("Item %d: expected %s, got %s", i, want[i].String(), got[i].String())
+			continue
+		}
+		switch want[i].String() {
+		case "text":
+			if *got[i].Text() != *want[i].Text() {
+				t.Errorf("Item %d: expected %q, got %q", i, *want[i].Text(), *got[i].Text())
+			}
+		case "tool-input":
+			w, g := want[i].ToolInput(), got[i].ToolInput()
+			if g.Name != w.Name || g.Arguments.Slice()[0] != w.Arguments.Slice()[0] {
+				t.Errorf("Item %d: expected %v, got %v", i, *w, *g)
+			}
+		}
+	}
+}
+
+func TestDecode_Streaming(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantText    string
+		wantPartial bool
+		wantErr     bool
+	}{
+		{
+			name:     "complete text",
+			input:    "Hello there.<|im_end|>",
+			wantText: "Hello there.",
+		},
+		{
+			name:        "half emitted end of turn",
+			input:       "Hello there.<|im_",
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:        "half emitted tool call tag",
+			input:       "Let me check.\n<tool_",
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:        "incomplete tool call",
+			input:       "<tool_call>\n{\"name\": \"get_weather\", \"argu",
+			wantPartial: true,
+			wantErr:     true,
+		},
+		{
+			name:    "unterminated tool call at end of turn",
+			input:   "<tool_call>\n{\"name\": \"get_weather\"}<|im_end|>",
+			wantErr: true,
+		},
+	}
+
+	model := newQwen25(false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var partial *models.PartialDecodeError
+				if errors.As(err, &partial) != tt.wantPartial {
+					t.Errorf("Decode() error = %v, wantPartial %v", err, tt.wantPartial)
+				}
+				return
+			}
+			if text := msg.Content.Slice()[0].Text(); text == nil || *text != tt.wantText {
+				t.Errorf("Expected text %q, got %v", tt.wantText, msg.Content.Slice()[0].String())
+			}
+		})
+	}
+}
+
+func TestEncode_ToolChoice(t *testing.T) {
+	weather := mcp.Tool{Name: "get_weather", Description: "Get the weather", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	messages := []ai.Message{
+		{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(ai.Text("You are helpful.")),
+			ai.NewMessageContent(cm.ToList([]mcp.Tool{weather})),
+		})},
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Weather in Paris?"))})},
+	}
+
+	t.Run("none omits the tools", func(t *testing.T) {
+		model := newQwen25(false)
+		prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.None}}, messages...)
+		if err != nil {
+			t.Fatalf("EncodePrompt failed: %v", err)
+		}
+		if strings.Contains(string(prompt.Data), "<tools>") {
+			t.Errorf("Expected no tools in the prompt, got %q", prompt.Data)
+		}
+	})
+
+	t.Run("named tool is prefilled", func(t *testing.T) {
+		model := newQwen25(false)
+		prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.Function, Name: "get_weather"}}, messages...)
+		if err != nil {
+			t.Fatalf("EncodePrompt failed: %v", err)
+		}
+		encoded := string(prompt.Data)
+		if !strings.Contains(encoded, "You must call the function get_weather") {
+			t.Errorf("Expected the instruction in the system prompt, got %q", encoded)
+		}
+		wantSuffix := "<|im_start|>assistant\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": "
+		if !strings.HasSuffix(encoded, wantSuffix) || !strings.HasSuffix(encoded, prompt.Prefill) {
+			t.Errorf("Expected the prompt to end with %q, got %q", wantSuffix, encoded)
+		}
+
+		// The model continues after the prefill
+		msg, err := model.DecodePrompt(prompt, []byte("{\"city\": \"Paris\"}}\n</tool_call><|im_end|>"))
+		if err != nil {
+			t.Fatalf("DecodePrompt failed: %v", err)
+		}
+		call := msg.Content.Slice()[0].ToolInput()
+		if call == nil || call.Name != "get_weather" || call.Arguments.Slice()[0] != [2]string{"city", "Paris"} {
+			t.Errorf("Expected the get_weather call, got %v", msg.Content.Slice())
+		}
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		model := newQwen25(false)
+		if _, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.Function, Name: "search"}}, messages...); err == nil {
+			t.Error("Expected an error for a tool choice naming an unknown tool")
+		}
+	})
+
+	t.Run("calls are rejected under none", func(t *testing.T) {
+		model := newQwen25(false)
+		prompt, err := model.EncodePrompt(format.Options{ToolChoice: toolchoice.Choice{Mode: toolchoice.None}}, messages...)
+		if err != nil {
+			t.Fatalf("EncodePrompt failed: %v", err)
+		}
+		if _, err := model.DecodePrompt(prompt, []byte("<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {}}\n</tool_call><|im_end|>")); err == nil {
+			t.Error("Expected an error for a tool call when tools are disabled")
+		}
+	})
+}
@@ -9,13 +9,19 @@ import (
 	"github.com/hayride-dev/bindings/go/hayride/ai"
 	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"github.com/hayride-dev/morphs/components/ai/models/schema"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
 	"go.bytecodealliance.org/cm"
 )
 
 var (
 	// Regular expression to match tool calls in the format:
 	// <tool_call>\n{"name": "function_name", "arguments": {...}}\n</tool_call>
-	qwen3ToolCallRegex = regexp.MustCompile(`<tool_call>\s*(\{.*?\})\s*</tool_call>`)
+	qwen3ToolCallRegex = regexp.MustCompile(`(?s)<tool_call>\s*(\{.*?\})\s*</tool_call>`)
 )
 
 const (
@@ -34,90 +40,226 @@ const (
 	qwen3ThinkEnd = "</think>"
 )
 
+// Thinking modes of Qwen 3
+const (
+	// ThinkingEnabled lets the model reason before every answer
+	ThinkingEnabled = "enabled"
+	// ThinkingDisabled closes the reasoning block in the generation prompt so the model answers
+	// directly, as enable_thinking=false does in the official template
+	ThinkingDisabled = "disabled"
+	// ThinkingSoftSwitch follows the most recent /think or /no_think in the user messages and
+	// reasons when neither is present
+	ThinkingSoftSwitch = "soft"
+
+	// Soft switches users can add to their messages
+	softThink   = "/think"
+	softNoThink = "/no_think"
+)
+
 var _ models.Format = (*qwen3)(nil)
+var _ format.Format = (*qwen3)(nil)
+
+// Qwen3Config configures the Qwen 3 format
+type Qwen3Config struct {
+	// Thinking is one of enabled, disabled or soft, soft when empty
+	Thinking string
+}
+
+// NewQwen3 returns a Qwen 3 format for the given config
+func NewQwen3(config Qwen3Config) (models.Format, error) {
+	switch config.Thinking {
+	case "":
+		config.Thinking = ThinkingSoftSwitch
+	case ThinkingEnabled, ThinkingDisabled, ThinkingSoftSwitch:
+	default:
+		return nil, fmt.Errorf("unsupported thinking mode: %s", config.Thinking)
+	}
+	return newQwen3(config), nil
+}
 
 func ConstructorQwen_3() (models.Format, error) {
-	return &qwen3{}, nil
+	return NewQwen3(Qwen3Config{})
 }
 
-type qwen3 struct{}
+type qwen3 struct {
+	format.Codec
+	config Qwen3Config
+}
 
-func (m *qwen3) Decode(data []byte) (*ai.Message, error) {
-	content := string(data)
+func newQwen3(config Qwen3Config) *qwen3 {
+	m := &qwen3{config: config}
+	m.Codec = format.Codec{
+		EncodeMessages: m.encodePrompt,
+		DecodeOutput:   m.decode,
+		Stream:         stream.ChatML,
+		Stops:          StopSequences(),
+		Calls:          grammar.Hermes,
+	}
+	return m
+}
 
-	// Remove thinking tags if present - these are for internal reasoning
-	if strings.Contains(content, qwen3Think) && strings.Contains(content, qwen3ThinkEnd) {
-		start := strings.Index(content, qwen3Think)
-		end := strings.Index(content, qwen3ThinkEnd) + len(qwen3ThinkEnd)
-		if start != -1 && end != -1 && end > start {
-			content = content[:start] + content[end:]
-			content = strings.TrimSpace(content)
-		}
+// decode returns the reasoning in the <think> block as a reasoning item, see reasoning.Text,
+// followed by the answer or the tool calls. While the model is still thinking only the reasoning
+// is returned and the message is not final.
+func (m *qwen3) decode(data string) (*ai.Message, error) {
+	content, complete := trimEndOfTurn(data)
+
+	// While streaming, wait for tags that are only partially generated
+	if !complete && hasPartialTag(content, qwen3ImEnd, endOfText, qwen3ToolCall, qwen3ToolCallEnd, qwen3Think, qwen3ThinkEnd) {
+		return nil, &models.PartialDecodeError{}
 	}
 
-	// Check if this is a tool call response
-	if strings.Contains(content, qwen3ToolCall) {
-		matches := qwen3ToolCallRegex.FindStringSubmatch(content)
-		if len(matches) != 2 {
-			return nil, fmt.Errorf("failed to parse tool call, invalid format")
+	text, content, open := splitReasoning(content)
+	if open {
+		if complete {
+			// The turn ended inside the <think> block, keep what was generated as the answer
+			text, content = "", text
+		} else {
+			// Reasoning is still being generated until </think> is seen
+			text := strings.TrimSpace(text)
+			if text == "" {
+				return nil, &models.PartialDecodeError{}
+			}
+			return &ai.Message{
+				Role: ai.RoleAssistant,
+				Content: cm.ToList([]ai.MessageContent{
+					reasoning.Text(text),
+				}),
+				Final: false,
+			}, nil
 		}
+	}
 
-		// Parse the JSON tool call
-		var toolCallData struct {
-			Name      string          `json:"name"`
-			Arguments json.RawMessage `json:"arguments"`
-		}
+	var contents []ai.MessageContent
+	if text = strings.TrimSpace(text); text != "" {
+		contents = append(contents, reasoning.Text(text))
+	}
+	content = strings.TrimSpace(content)
 
-		if err := json.Unmarshal([]byte(matches[1]), &toolCallData); err != nil {
-			return nil, fmt.Errorf("failed to parse tool call JSON: %v", err)
+	// Check if this is a tool call response, every <tool_call> block is its own tool-input
+	if strings.Contains(content, qwen3ToolCall) {
+		if hasOpenBlock(content, qwen3ToolCall, qwen3ToolCallEnd) {
+			if !complete {
+				return nil, &models.PartialDecodeError{}
+			}
+			return nil, fmt.Errorf("failed to parse tool call, missing %s", qwen3ToolCallEnd)
 		}
 
-		// Convert arguments to [][2]string format
-		var argsMap map[string]interface{}
-		if err := json.Unmarshal(toolCallData.Arguments, &argsMap); err != nil {
-			return nil, fmt.Errorf("failed to parse tool arguments: %v", err)
+		calls, err := parseToolCalls(content, qwen3ToolCallRegex)
+		if err != nil {
+			return nil, err
 		}
 
-		var args [][2]string
-		for k, v := range argsMap {
-			// Convert value to string
-			var valueStr string
-			switch val := v.(type) {
-			case string:
-				valueStr = val
-			case nil:
-				valueStr = ""
-			default:
-				// Convert other types to JSON string
-				jsonBytes, _ := json.Marshal(val)
-				valueStr = string(jsonBytes)
-			}
-			args = append(args, [2]string{k, valueStr})
-		}
+		return &ai.Message{
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList(append(contents, calls...)),
+		}, nil
+	}
 
+	// The reasoning is done but the answer has not started yet
+	if content == "" && !complete {
+		if len(contents) == 0 {
+			return nil, &models.PartialDecodeError{}
+		}
 		return &ai.Message{
-			Role: ai.RoleAssistant,
-			Content: cm.ToList([]ai.MessageContent{
-				ai.NewMessageContent(mcp.CallToolParams{
-					Name:      toolCallData.Name,
-					Arguments: cm.ToList(args),
-				}),
-			}),
+			Role:    ai.RoleAssistant,
+			Content: cm.ToList(contents),
+			Final:   false,
 		}, nil
 	}
 
 	// Regular text message
 	return &ai.Message{
-		Role: ai.RoleAssistant,
-		Content: cm.ToList([]ai.MessageContent{
-			ai.NewMessageContent(ai.Text(content)),
-		}),
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList(append(contents, ai.NewMessageContent(ai.Text(content)))),
+		Final:   true,
 	}, nil
 }
 
-func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
+// splitReasoning separates the <think> block from the rest of the output, open reports a block
+// that has not been closed yet. The opening tag may be missing when the prompt already holds it.
+func splitReasoning(content string) (reasoning, answer string, open bool) {
+	if end := strings.Index(content, qwen3ThinkEnd); end != -1 {
+		reasoning = content[:end]
+		if start := strings.Index(reasoning, qwen3Think); start != -1 {
+			reasoning = reasoning[start+len(qwen3Think):]
+		}
+		return reasoning, content[end+len(qwen3ThinkEnd):], false
+	}
+	if start := strings.Index(content, qwen3Think); start != -1 {
+		return content[start+len(qwen3Think):], "", true
+	}
+	return "", content, false
+}
+
+// assistantParts splits an assistant message into its reasoning, answer and tool calls. Reasoning
+// items are told apart with reasoning.Cut, every other text item is part of the answer. A <think>
+// block inside the answer is split off the way the official template does.
+func assistantParts(msg ai.Message) (thought, content string, calls []mcp.CallToolParams) {
+	var thoughts, texts []string
+	for _, c := range msg.Content.Slice() {
+		switch c.String() {
+		case "text":
+			if text, ok := reasoning.Cut(*c.Text()); ok {
+				thoughts = append(thoughts, text)
+			} else {
+				texts = append(texts, *c.Text())
+			}
+		case "tool-input":
+			calls = append(calls, *c.ToolInput())
+		}
+	}
+	thought = strings.Join(thoughts, "\n")
+	content = strings.Join(texts, "\n")
+
+	if strings.Contains(content, qwen3ThinkEnd) {
+		inline := strings.TrimRight(content[:strings.Index(content, qwen3ThinkEnd)], "\n")
+		if start := strings.LastIndex(inline, qwen3Think); start != -1 {
+			inline = inline[start+len(qwen3Think):]
+		}
+		if thought == "" {
+			thought = strings.TrimLeft(inline, "\n")
+		}
+		content = strings.TrimLeft(content[strings.LastIndex(content, qwen3ThinkEnd)+len(qwen3ThinkEnd):], "\n")
+	}
+	return thought, content, calls
+}
+
+// thinking reports whether the model reasons before its next answer
+func (m *qwen3) thinking(messages []ai.Message) bool {
+	switch m.config.Thinking {
+	case ThinkingEnabled:
+		return true
+	case ThinkingDisabled:
+		return false
+	}
+
+	// The most recent soft switch wins
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != ai.RoleUser {
+			continue
+		}
+		contents := messages[i].Content.Slice()
+		for j := len(contents) - 1; j >= 0; j-- {
+			if contents[j].String() != "text" {
+				continue
+			}
+			text := *contents[j].Text()
+			think, noThink := strings.LastIndex(text, softThink), strings.LastIndex(text, softNoThink)
+			if think != -1 || noThink != -1 {
+				return think > noThink
+			}
+		}
+	}
+	return true
+}
+
+// encodePrompt encodes the messages with the tool choice of the options
+func (m *qwen3) encodePrompt(opts format.Options, messages ...ai.Message) (*format.Prompt, error) {
 	builder := &strings.Builder{}
 
+	choice, prefill := opts.ToolChoice, ""
+
 	// Track if we have tools available
 	var tools []mcp.Tool
 	hasTools := false
@@ -136,6 +278,12 @@ func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
 		}
 	}
 
+	tools, err := choice.Tools(tools)
+	if err != nil {
+		return nil, err
+	}
+	hasTools = len(tools) > 0
+
 	// Find the last query index for multi-step tool detection
 	lastQueryIndex := len(messages) - 1
 
@@ -187,26 +335,14 @@ func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
 				builder.WriteString("# Tools\n\nYou may call one or more functions to assist with the user query.\n\nYou are provided with function signatures within <tools></tools> XML tags:\n<tools>")
 
 				for _, tool := range tools {
-					toolJSON := map[string]interface{}{
-						"name":        tool.Name,
-						"description": tool.Description,
-						"parameters":  map[string]interface{}{},
-					}
-
-					// Add parameters if available
-					if len(tool.InputSchema.Properties.Slice()) > 0 {
-						params := make(map[string]interface{})
-						for _, prop := range tool.InputSchema.Properties.Slice() {
-							params[prop[0]] = prop[1]
-						}
-						toolJSON["parameters"] = params
-					}
-
-					toolBytes, _ := json.Marshal(toolJSON)
-					builder.WriteString(fmt.Sprintf("\n%s", string(toolBytes)))
+					builder.WriteString(fmt.Sprintf("\n%s", schema.Tool(tool)))
 				}
 
 				builder.WriteString("\n</tools>\n\nFor each function call, return a json object with function name and arguments within <tool_call></tool_call> XML tags:\n<tool_call>\n{\"name\": <function-name>, \"arguments\": <args-json-object>}\n</tool_call>")
+
+				if instruction := choice.Instruction(); instruction != "" {
+					builder.WriteString(fmt.Sprintf("\n\n%s", instruction))
+				}
 				builder.WriteString(fmt.Sprintf("%s\n", qwen3ImEnd))
 			} else {
 				// Regular system message without tools
@@ -233,42 +369,30 @@ func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
 			builder.WriteString(fmt.Sprintf("%s\n", qwen3ImEnd))
 
 		case ai.RoleAssistant:
-			isLastMessage := i == len(messages)-1
-			shouldAddThinking := i > lastQueryIndex && (isLastMessage || !isLastMessage)
+			thought, content, calls := assistantParts(msg)
 
-			builder.WriteString(fmt.Sprintf("%sassistant", qwen3ImStart))
+			builder.WriteString(fmt.Sprintf("%sassistant\n", qwen3ImStart))
 
-			if shouldAddThinking {
-				builder.WriteString(fmt.Sprintf("\n%s\n\n%s\n\n", qwen3Think, qwen3ThinkEnd))
+			// Reasoning is only kept for the turns answering the last user query, earlier
+			// reasoning is dropped from the history
+			if i > lastQueryIndex && (i == len(messages)-1 || thought != "") {
+				builder.WriteString(fmt.Sprintf("%s\n%s\n%s\n\n", qwen3Think, strings.Trim(thought, "\n"), qwen3ThinkEnd))
+				content = strings.TrimLeft(content, "\n")
 			}
+			builder.WriteString(content)
 
-			for _, content := range msg.Content.Slice() {
-				switch content.String() {
-				case "text":
-					c := content.Text()
-					builder.WriteString(fmt.Sprintf("\n%s", *c))
-				case "tool-input":
-					c := content.ToolInput()
-
-					// Convert arguments to JSON object
-					argsMap := make(map[string]interface{})
-					for _, arg := range c.Arguments.Slice() {
-						// Try to parse as JSON, otherwise use as string
-						var value interface{}
-						if err := json.Unmarshal([]byte(arg[1]), &value); err != nil {
-							value = arg[1]
-						}
-						argsMap[arg[0]] = value
-					}
-
-					toolCallJSON := map[string]interface{}{
-						"name":      c.Name,
-						"arguments": argsMap,
-					}
+			for j, c := range calls {
+				if j > 0 || content != "" {
+					builder.WriteString("\n")
+				}
 
-					toolCallBytes, _ := json.Marshal(toolCallJSON)
-					builder.WriteString(fmt.Sprintf("\n%s\n%s\n%s", qwen3ToolCall, string(toolCallBytes), qwen3ToolCallEnd))
+				toolCallJSON := map[string]interface{}{
+					"name":      c.Name,
+					"arguments": arguments.EncodeCall(tools, c),
 				}
+
+				toolCallBytes, _ := json.Marshal(toolCallJSON)
+				builder.WriteString(fmt.Sprintf("%s\n%s\n%s", qwen3ToolCall, string(toolCallBytes), qwen3ToolCallEnd))
 			}
 
 			builder.WriteString(fmt.Sprintf("%s\n", qwen3ImEnd))
@@ -279,38 +403,8 @@ func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
 				builder.WriteString(fmt.Sprintf("%suser", qwen3ImStart))
 			}
 
-			builder.WriteString(fmt.Sprintf("\n%s\n", qwen3ToolResponse))
-
-			for _, content := range msg.Content.Slice() {
-				if content.String() == "tool-output" {
-					output := content.ToolOutput()
-					for _, c := range output.Content.Slice() {
-						switch c.String() {
-						case "text":
-							builder.WriteString(c.Text().Text)
-						case "image":
-							image := c.Image()
-							builder.WriteString(fmt.Sprintf("Image Data: %v", image.Data))
-						case "audio":
-							audio := c.Audio()
-							builder.WriteString(fmt.Sprintf("Audio Data: %v", audio.Data))
-						case "resource-link":
-							resource := c.ResourceLink()
-							builder.WriteString(fmt.Sprintf("Resource Link: %s", resource.URI))
-						case "resource-content":
-							content := c.ResourceContent()
-							switch content.ResourceContents.String() {
-							case "text":
-								builder.WriteString(fmt.Sprintf("Resource Content (Text): %s", content.ResourceContents.Text()))
-							case "blob":
-								builder.WriteString(fmt.Sprintf("Resource Content (Blob): %v", content.ResourceContents.Blob()))
-							}
-						}
-					}
-				}
-			}
-
-			builder.WriteString(fmt.Sprintf("\n%s", qwen3ToolResponseEnd))
+			// Each tool result gets its own <tool_response> block inside the grouped user turn
+			writeToolResponses(builder, msg, false)
 
 			// Check if this is the last tool message or if the next message is not a tool
 			if i == len(messages)-1 || messages[i+1].Role != ai.RoleTool {
@@ -325,9 +419,14 @@ func (m *qwen3) Encode(messages ...ai.Message) ([]byte, error) {
 	// Add generation prompt if the last message is not from assistant
 	if len(messages) > 0 && messages[len(messages)-1].Role != ai.RoleAssistant {
 		builder.WriteString(fmt.Sprintf("%sassistant\n", qwen3ImStart))
-		// Add thinking tags for new responses
-		builder.WriteString(fmt.Sprintf("%s\n\n%s\n\n", qwen3Think, qwen3ThinkEnd))
+		// An empty reasoning block makes the model answer directly, a required tool call is
+		// prefilled after it
+		prefill = toolCallPrefill(choice)
+		if !m.thinking(messages) || prefill != "" {
+			builder.WriteString(fmt.Sprintf("%s\n\n%s\n\n", qwen3Think, qwen3ThinkEnd))
+		}
+		builder.WriteString(prefill)
 	}
 
-	return []byte(builder.String()), nil
+	return &format.Prompt{Data: []byte(builder.String()), Prefill: prefill, Choice: choice}, nil
 }
@@ -0,0 +1,213 @@
+package qwen
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestQwen3Decode_MultipleToolCalls(t *testing.T) {
+	model := newQwen3(Qwen3Config{})
+
+	msg, err := model.Decode([]byte("<think>\nTwo lookups are needed.\n</think>\n\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call>\nand\n<tool_call>\n{\"name\": \"get_time\", \"arguments\": {\"timezone\": \"Europe/Paris\"}}\n</tool_call>"))
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	var kinds []string
+	var names []string
+	for _, content := range msg.Content.Slice() {
+		kinds = append(kinds, content.String())
+		if call := content.ToolInput(); call != nil {
+			names = append(names, call.Name)
+		}
+	}
+
+	wantKinds := []string{"text", "tool-input", "text", "tool-input"}
+	if len(kinds) != len(wantKinds) {
+		t.Fatalf("Expected content %v, got %v", wantKinds, kinds)
+	}
+	for i := range wantKinds {
+		if kinds[i] != wantKinds[i] {
+			t.Errorf("Expected content %v, got %v", wantKinds, kinds)
+			break
+		}
+	}
+	if len(names) != 2 || names[0] != "get_weather" || names[1] != "get_time" {
+		t.Errorf("Expected get_weather and get_time, got %v", names)
+	}
+	if reasoning := msg.Content.Slice()[0].Text(); *reasoning != "<think>\nTwo lookups are needed.\n</think>" || msg.Final {
+		t.Errorf("Expected the reasoning as a leading item of a non final message, got %q", *reasoning)
+	}
+}
+
+func TestQwen3Decode_Streaming(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantTexts   []string
+		wantFinal   bool
+		wantPartial bool
+	}{
+		{
+			name:      "answer after reasoning",
+			input:     "<think>\nEasy.\n</think>\n\nHi!<|im_end|>",
+			wantTexts: []string{"<think>\nEasy.\n</think>", "Hi!"},
+			wantFinal: true,
+		},
+		{
+			name:      "answer without reasoning",
+			input:     "<think>\n\n</think>\n\nHi!<|im_end|>",
+			wantTexts: []string{"Hi!"},
+			wantFinal: true,
+		},
+		{
+			name:      "incomplete think block",
+			input:     "<think>\nThe user wants",
+			wantTexts: []string{"<think>\nThe user wants\n</think>"},
+		},
+		{
+			name:      "reasoning done before the answer",
+			input:     "<think>\nEasy.\n</think>\n\n",
+			wantTexts: []string{"<think>\nEasy.\n</think>"},
+		},
+		{
+			name:        "empty think block",
+			input:       "<think>\n",
+			wantPartial: true,
+		},
+		{
+			name:        "half emitted think tag",
+			input:       "<thi",
+			wantPartial: true,
+		},
+		{
+			name:        "half emitted end of turn",
+			input:       "<think>\n</think>\n\nHi!<|im_e",
+			wantPartial: true,
+		},
+		{
+			name:        "incomplete tool call after reasoning",
+			input:       "<think>\nCall it.\n</think>\n\n<tool_call>\n{\"name\": ",
+			wantPartial: true,
+		},
+	}
+
+	model := newQwen3(Qwen3Config{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := model.Decode([]byte(tt.input))
+			if tt.wantPartial {
+				var partial *models.PartialDecodeError
+				if !errors.As(err, &partial) {
+					t.Fatalf("Expected a partial decode error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			var texts []string
+			for _, content := range msg.Content.Slice() {
+				texts = append(texts, *content.Text())
+			}
+			if !reflect.DeepEqual(texts, tt.wantTexts) || msg.Final != tt.wantFinal {
+				t.Errorf("Expected %q (final %v), got %q (final %v)", tt.wantTexts, tt.wantFinal, texts, msg.Final)
+			}
+		})
+	}
+}
+
+func TestQwen3Encode_ThinkingModes(t *testing.T) {
+	user := func(text string) ai.Message {
+		return ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text(text))})}
+	}
+	assistant := func(texts ...string) ai.Message {
+		var contents []ai.MessageContent
+		for _, text := range texts {
+			contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+		}
+		return ai.Message{Role: ai.RoleAssistant, Content: cm.ToList(contents)}
+	}
+
+	const thinking = "<|im_start|>assistant\n"
+	const direct = "<|im_start|>assistant\n<think>\n\n</think>\n\n"
+
+	tests := []struct {
+		name     string
+		mode     string
+		messages []ai.Message
+		want     string
+	}{
+		{name: "soft switch default", messages: []ai.Message{user("Hi")}, want: thinking},
+		{name: "soft switch off", messages: []ai.Message{user("Hi /no_think")}, want: direct},
+		{
+			name:     "most recent soft switch wins",
+			messages: []ai.Message{user("Hi /no_think"), assistant("Hello"), user("Explain /think")},
+			want:     thinking,
+		},
+		{name: "enabled ignores the soft switch", mode: ThinkingEnabled, messages: []ai.Message{user("Hi /no_think")}, want: thinking},
+		{name: "disabled", mode: ThinkingDisabled, messages: []ai.Message{user("Hi /think")}, want: direct},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := NewQwen3(Qwen3Config{Thinking: tt.mode})
+			if err != nil {
+				t.Fatalf("NewQwen3 failed: %v", err)
+			}
+			encoded, err := model.Encode(tt.messages...)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if !strings.HasSuffix(string(encoded), "<|im_end|>\n"+tt.want) {
+				t.Errorf("Expected the prompt to end with %q, got %q", tt.want, encoded)
+			}
+		})
+	}
+
+	if _, err := NewQwen3(Qwen3Config{Thinking: "sometimes"}); err == nil {
+		t.Error("Expected an error for an unknown thinking mode")
+	}
+}
+
+func TestQwen3Encode_ReasoningHistory(t *testing.T) {
+	text := func(text string) ai.MessageContent { return ai.NewMessageContent(ai.Text(text)) }
+
+	messages := []ai.Message{
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{text("Hi")})},
+		// Reasoning of earlier turns is stripped, also when it is inlined in the answer
+		{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{text("<think>\nGreet back.\n</think>\n\nHello!")})},
+		{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{text("Weather in Paris?")})},
+		// Reasoning of the turns answering the last query is kept
+		{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{
+			reasoning.Text("Paris needs a lookup."),
+			text("Look it up."),
+			ai.NewMessageContent(mcp.CallToolParams{Name: "get_weather", Arguments: cm.ToList([][2]string{{"city", "Paris"}})}),
+		})},
+		{Role: ai.RoleTool, Content: cm.ToList([]ai.MessageContent{text("Sunny")})},
+		{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{text("It is sunny.")})},
+	}
+
+	encoded, err := newQwen3(Qwen3Config{Thinking: ThinkingSoftSwitch}).Encode(messages...)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	expected := "<|im_start|>user\nHi<|im_end|>\n" +
+		"<|im_start|>assistant\nHello!<|im_end|>\n" +
+		"<|im_start|>user\nWeather in Paris?<|im_end|>\n" +
+		"<|im_start|>assistant\n<think>\nParis needs a lookup.\n</think>\n\nLook it up.\n<tool_call>\n{\"arguments\":{\"city\":\"Paris\"},\"name\":\"get_weather\"}\n</tool_call><|im_end|>\n" +
+		"<|im_start|>user\n<tool_response>\nSunny\n</tool_response><|im_end|>\n" +
+		"<|im_start|>assistant\n<think>\n\n</think>\n\nIt is sunny.<|im_end|>\n"
+	if string(encoded) != expected {
+		t.Errorf("Encode() =\n%q\nwant\n%q", encoded, expected)
+	}
+}
@@ -0,0 +1,44 @@
+package qwen
+
+import (
+	"strings"
+)
+
+// Qwen end of text token, emitted by base checkpoints instead of <|im_end|>
+const endOfText = "<|endoftext|>"
+
+// StopSequences returns the tokens that end the output of the Qwen formats
+func StopSequences() []string {
+	return []string{imEnd, endOfText}
+}
+
+// trimEndOfTurn cuts the content at the first end of turn token and reports whether one was found.
+// Once the end of turn has been generated the response is complete.
+func trimEndOfTurn(content string) (string, bool) {
+	complete := false
+	for _, token := range []string{imEnd, endOfText} {
+		if idx := strings.Index(content, token); idx != -1 {
+			content = content[:idx]
+			complete = true
+		}
+	}
+	return content, complete
+}
+
+// hasPartialTag reports whether content ends with the beginning of one of the tags,
+// e.g. "<|im" or "<tool_", which means the tag is still being generated
+func hasPartialTag(content string, tags ...string) bool {
+	for _, tag := range tags {
+		for i := len(tag) - 1; i > 0; i-- {
+			if strings.HasSuffix(content, tag[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasOpenBlock reports whether a block opened with start has not been closed with end yet
+func hasOpenBlock(content, start, end string) bool {
+	return strings.Count(content, start) > strings.Count(content, end)
+}
@@ -0,0 +1,100 @@
+package qwen
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/toolresult"
+	"go.bytecodealliance.org/cm"
+)
+
+// parseToolCalls splits an assistant response into content items. Every <tool_call> block
+// matched by re becomes its own tool-input item and the text around the blocks is kept as
+// text items, in the order they were generated.
+func parseToolCalls(content string, re *regexp.Regexp) ([]ai.MessageContent, error) {
+	matches := re.FindAllStringSubmatchIndex(content, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("failed to parse tool call, invalid format")
+	}
+
+	var contents []ai.MessageContent
+	last := 0
+	for _, match := range matches {
+		if text := strings.TrimSpace(content[last:match[0]]); text != "" {
+			contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+		}
+		last = match[1]
+
+		call, err := parseToolCall(content[match[2]:match[3]])
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, ai.NewMessageContent(call))
+	}
+
+	if text := strings.TrimSpace(content[last:]); text != "" {
+		contents = append(contents, ai.NewMessageContent(ai.Text(text)))
+	}
+
+	return contents, nil
+}
+
+// toolCallPrefill returns the start of the tool call written after the generation prompt when
+// the choice requires a call, with the name filled in when a specific tool is required
+func toolCallPrefill(choice toolchoice.Choice) string {
+	switch choice.Mode {
+	case toolchoice.Required:
+		return toolCall + "\n"
+	case toolchoice.Function:
+		name, _ := json.Marshal(choice.Name)
+		return fmt.Sprintf("%s\n{\"name\": %s, \"arguments\": ", toolCall, name)
+	}
+	return ""
+}
+
+// parseToolCall parses the JSON body of a single <tool_call> block
+func parseToolCall(data string) (mcp.CallToolParams, error) {
+	var toolCallData struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+
+	if err := json.Unmarshal([]byte(data), &toolCallData); err != nil {
+		return mcp.CallToolParams{}, fmt.Errorf("failed to parse tool call JSON: %v", err)
+	}
+
+	args, err := arguments.Decode(toolCallData.Arguments)
+	if err != nil {
+		return mcp.CallToolParams{}, err
+	}
+
+	return mcp.CallToolParams{
+		Name:      toolCallData.Name,
+		Arguments: cm.ToList(args),
+	}, nil
+}
+
+// writeToolResponses writes one <tool_response> block per tool result in a tool message, images
+// are written as vision placeholders when vision is set
+func writeToolResponses(builder *strings.Builder, msg ai.Message, vision bool) {
+	image := ""
+	if vision {
+		image = imagePlaceholder
+	}
+	for _, content := range msg.Content.Slice() {
+		switch content.String() {
+		case "tool-output":
+			builder.WriteString(fmt.Sprintf("\n%s\n", toolResponse))
+			builder.WriteString(toolresult.Text(content.ToolOutput(), image))
+			builder.WriteString(fmt.Sprintf("\n%s", toolResponseEnd))
+		case "text":
+			builder.WriteString(fmt.Sprintf("\n%s\n%s\n%s", toolResponse, *content.Text(), toolResponseEnd))
+		}
+	}
+}
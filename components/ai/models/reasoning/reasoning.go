@@ -0,0 +1,43 @@
+// Package reasoning marks the text items of a message that hold the reasoning of the model.
+//
+// Message content has no variant for reasoning. Formats that decode reasoning return it as a text
+// item wrapped in <think> tags, see Text, next to the answer and the tool calls in the order the
+// model wrote them. Formats and clients tell reasoning from other text with Cut, never by the
+// position of an item, so text written before a tool call stays part of the answer.
+package reasoning
+
+import (
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+)
+
+const (
+	// Open starts a reasoning item
+	Open = "<think>"
+	// Close ends a reasoning item
+	Close = "</think>"
+)
+
+// Text returns the text item holding reasoning
+func Text(reasoning string) ai.MessageContent {
+	return ai.NewMessageContent(ai.Text(Open + "\n" + reasoning + "\n" + Close))
+}
+
+// Cut returns the reasoning held by the text of an item, ok is false for text that is not
+// reasoning
+func Cut(text string) (reasoning string, ok bool) {
+	if !strings.HasPrefix(text, Open) || !strings.HasSuffix(text, Close) || len(text) < len(Open)+len(Close) {
+		return "", false
+	}
+	return strings.Trim(text[len(Open):len(text)-len(Close)], "\n"), true
+}
+
+// Is reports whether a content item holds reasoning
+func Is(content ai.MessageContent) bool {
+	if content.String() != "text" {
+		return false
+	}
+	_, ok := Cut(*content.Text())
+	return ok
+}
@@ -0,0 +1,23 @@
+package reasoning
+
+import (
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+)
+
+func TestCut(t *testing.T) {
+	item := Text("The user wants the weather.")
+	if !Is(item) {
+		t.Fatal("Expected the item to hold reasoning")
+	}
+	if got, ok := Cut(*item.Text()); !ok || got != "The user wants the weather." {
+		t.Errorf("Cut() = %q, %v", got, ok)
+	}
+
+	for _, text := range []string{"Sure, let me check.", "<think> is a tag", "</think>", ""} {
+		if Is(ai.NewMessageContent(ai.Text(text))) {
+			t.Errorf("Expected %q not to be reasoning", text)
+		}
+	}
+}
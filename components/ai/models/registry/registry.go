@@ -0,0 +1,240 @@
+// Package registry bundles every format and selects one at runtime from the model in use.
+//
+// A format is chosen from the metadata of the GGUF file when it can be read, otherwise from the
+// model identifier, the name passed to the model repository (e.g.
+// bartowski/Meta-Llama-3.1-8B-Instruct-GGUF/Meta-Llama-3.1-8B-Instruct-Q5_K_M.gguf) or the
+// path it returned. A format can also be requested explicitly by name, which takes precedence
+// over the model. Format names match the build tags of the models component.
+//
+// Default returns a new registry on every call, so a component replacing the constructor of a
+// format never changes the formats seen by another.
+package registry
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/morphs/components/ai/models/chattemplate"
+	"github.com/hayride-dev/morphs/components/ai/models/deepseek"
+	"github.com/hayride-dev/morphs/components/ai/models/gemma"
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+	"github.com/hayride-dev/morphs/components/ai/models/harmony"
+	"github.com/hayride-dev/morphs/components/ai/models/llama3"
+	"github.com/hayride-dev/morphs/components/ai/models/mistral"
+	"github.com/hayride-dev/morphs/components/ai/models/qwen"
+)
+
+const (
+	// ModelEnv holds the identifier of the model the format is used with
+	ModelEnv = "MODEL"
+	// FormatEnv holds the name of a format to use regardless of the model
+	FormatEnv = "MODEL_FORMAT"
+)
+
+// Constructor creates a format
+type Constructor func() (models.Format, error)
+
+type entry struct {
+	name        string
+	constructor Constructor
+	// match is applied to the normalized model identifier, nil for formats that can only be
+	// selected by name
+	match *regexp.Regexp
+}
+
+// Registry holds the formats a model can be rendered with
+type Registry struct {
+	formats []entry
+}
+
+// bundled is ordered so that more specific model names are matched first
+var bundled = []entry{
+	{name: "gpt_oss", constructor: harmony.ConstructorGptOss, match: regexp.MustCompile(`gpt-?oss`)},
+	{name: "deepseek_r1", constructor: deepseek.ConstructorDeepSeek_R1, match: regexp.MustCompile(`deepseek-r1`)},
+	{name: "hermes", constructor: qwen.ConstructorHermes, match: regexp.MustCompile(`hermes`)},
+	{name: "qwen_3", constructor: qwen.ConstructorQwen_3, match: regexp.MustCompile(`qwen-?3`)},
+	{name: "qwen_2_vl", constructor: qwen.ConstructorQwen_2_VL, match: regexp.MustCompile(`qwen-?2(\.5|-5)?-vl`)},
+	{name: "qwen_2_5", constructor: qwen.ConstructorQwen_2_5, match: regexp.MustCompile(`qwen-?2\.5|qwen-?2-5|qwq`)},
+	{name: "llama_3_2_vision", constructor: llama3.Constructor_3_2_Vision, match: regexp.MustCompile(`llama-?3[.-]2-.*vision`)},
+	{name: "llama_3_1", constructor: llama3.Constructor_3_1, match: regexp.MustCompile(`llama-?3`)},
+	{name: "mistral_tekken", constructor: mistral.ConstructorTekken, match: regexp.MustCompile(`mistral-nemo|mistral-small-(24b|3)|ministral|tekken`)},
+	{name: "mistral_v3", constructor: mistral.Constructor_v3, match: regexp.MustCompile(`mistral|mixtral`)},
+	{name: "gemma", constructor: gemma.ConstructorGemma, match: regexp.MustCompile(`gemma`)},
+	{name: "chat_template", constructor: chattemplate.Constructor},
+}
+
+// architectures maps general.architecture to a format, for GGUF files whose model name does
+// not identify the format
+var architectures = map[string]string{
+	"gpt-oss":  "gpt_oss",
+	"qwen3":    "qwen_3",
+	"qwen3moe": "qwen_3",
+	"qwen2":    "qwen_2_5",
+	"qwen2vl":  "qwen_2_vl",
+	"mllama":   "llama_3_2_vision",
+	"gemma":    "gemma",
+	"gemma2":   "gemma",
+	"gemma3":   "gemma",
+}
+
+// Default returns a registry holding every bundled format
+func Default() *Registry {
+	return &Registry{formats: slices.Clone(bundled)}
+}
+
+// Names returns the names of all registered formats
+func (r *Registry) Names() []string {
+	names := make([]string, len(r.formats))
+	for i, e := range r.formats {
+		names[i] = e.name
+	}
+	return names
+}
+
+// Lookup returns the constructor of the format with the given name
+func (r *Registry) Lookup(name string) (Constructor, error) {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_")
+	for _, e := range r.formats {
+		if e.name == name {
+			return e.constructor, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown format %q, available formats: %s", name, strings.Join(r.Names(), ", "))
+}
+
+// Replace sets the constructor of a registered format, components use it to configure formats
+// with values that come from their imports
+func (r *Registry) Replace(name string, constructor Constructor) error {
+	for i, e := range r.formats {
+		if e.name == name {
+			r.formats[i].constructor = constructor
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, available formats: %s", name, strings.Join(r.Names(), ", "))
+}
+
+// Match returns the name and constructor of the format used by the given model
+func (r *Registry) Match(model string) (string, Constructor, error) {
+	normalized := normalize(model)
+	for _, e := range r.formats {
+		if e.match != nil && e.match.MatchString(normalized) {
+			return e.name, e.constructor, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no format matches model %q, set %s to one of: %s", model, FormatEnv, strings.Join(r.Names(), ", "))
+}
+
+// ForFile returns the name and constructor of the format used by a GGUF model. The model
+// name is matched first, then the architecture. Models that match neither but ship a chat
+// template are rendered with that template.
+func (r *Registry) ForFile(file *gguf.File) (string, Constructor, error) {
+	basename, _ := file.String("general.basename")
+	for _, name := range []string{file.Name(), basename} {
+		if name == "" {
+			continue
+		}
+		if name, constructor, err := r.Match(name); err == nil {
+			return name, constructor, nil
+		}
+	}
+
+	if name, ok := architectures[file.Architecture()]; ok {
+		constructor, err := r.Lookup(name)
+		return name, constructor, err
+	}
+
+	if template := file.ChatTemplate(); template != "" {
+		config := chattemplate.Config{ChatTemplate: template}
+		special := file.SpecialTokens()
+		if id, ok := special["bos"]; ok {
+			config.BosToken, _ = file.Token(id)
+		}
+		for _, name := range []string{"eos", "eot", "eom"} {
+			if id, ok := special[name]; ok {
+				if token, ok := file.Token(id); ok {
+					config.StopTokens = append(config.StopTokens, token)
+				}
+			}
+		}
+		if len(config.StopTokens) > 0 {
+			config.EosToken = config.StopTokens[0]
+		}
+
+		return "chat_template", func() (models.Format, error) {
+			return chattemplate.New(config)
+		}, nil
+	}
+
+	return "", nil, fmt.Errorf("no format matches model %q (architecture %q), set %s to one of: %s",
+		file.Name(), file.Architecture(), FormatEnv, strings.Join(r.Names(), ", "))
+}
+
+// New creates the format named by override, or the format matching model when override is
+// empty
+func (r *Registry) New(model string, override string) (models.Format, error) {
+	var constructor Constructor
+	var err error
+	switch {
+	case override != "":
+		constructor, err = r.Lookup(override)
+	case model != "":
+		_, constructor, err = r.Match(model)
+	default:
+		err = fmt.Errorf("no model identifier, set %s or %s", ModelEnv, FormatEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return create(constructor)
+}
+
+// Detect returns a constructor that selects the format from the MODEL_FORMAT and MODEL
+// environment variables. MODEL may be the path of a GGUF file or the name of a model in the
+// repository, which resolve turns into a path. The GGUF metadata decides the format when the
+// file can be read, otherwise the model name is matched.
+func (r *Registry) Detect(resolve func(name string) (string, error)) func() (models.Format, error) {
+	return func() (models.Format, error) {
+		model, override := os.Getenv(ModelEnv), os.Getenv(FormatEnv)
+		if override != "" || model == "" {
+			return r.New(model, override)
+		}
+
+		path := model
+		if _, err := os.Stat(path); err != nil && resolve != nil {
+			if resolved, err := resolve(model); err == nil {
+				path = resolved
+			}
+		}
+
+		if file, err := gguf.Open(path); err == nil {
+			_, constructor, err := r.ForFile(file)
+			if err != nil {
+				return nil, err
+			}
+			return create(constructor)
+		}
+
+		return r.New(model, "")
+	}
+}
+
+func create(constructor Constructor) (models.Format, error) {
+	format, err := constructor()
+	if err != nil {
+		return nil, err
+	}
+	if format == nil {
+		return nil, fmt.Errorf("format constructor returned no format")
+	}
+	return format, nil
+}
+
+// normalize lower cases the identifier and unifies the separators used in model names
+func normalize(model string) string {
+	return strings.NewReplacer("_", "-", " ", "-").Replace(strings.ToLower(model))
+}
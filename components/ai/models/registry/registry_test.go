@@ -0,0 +1,305 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai/models"
+	"github.com/hayride-dev/morphs/components/ai/models/chattemplate"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{model: "bartowski/Meta-Llama-3.1-8B-Instruct-GGUF/Meta-Llama-3.1-8B-Instruct-Q5_K_M.gguf", want: "llama_3_1"},
+		{model: "/models/Llama-3.2-3B-Instruct-Q4_K_M.gguf", want: "llama_3_1"},
+		{model: "unsloth/gpt-oss-20b-GGUF/gpt-oss-20b-Q2_K.gguf", want: "gpt_oss"},
+		{model: "Qwen/Qwen3-8B-GGUF/Qwen3-8B-Q4_K_M.gguf", want: "qwen_3"},
+		{model: "Qwen/Qwen2.5-7B-Instruct-GGUF/qwen2.5-7b-instruct-q4_k_m.gguf", want: "qwen_2_5"},
+		{model: "bartowski/Qwen2-VL-7B-Instruct-GGUF/Qwen2-VL-7B-Instruct-Q4_K_M.gguf", want: "qwen_2_vl"},
+		{model: "unsloth/Qwen2.5-VL-7B-Instruct-GGUF/Qwen2.5-VL-7B-Instruct-Q4_K_M.gguf", want: "qwen_2_vl"},
+		{model: "meta-llama/Llama-3.2-11B-Vision-Instruct", want: "llama_3_2_vision"},
+		{model: "unsloth/DeepSeek-R1-Distill-Qwen-7B-GGUF/DeepSeek-R1-Distill-Qwen-7B-Q4_K_M.gguf", want: "deepseek_r1"},
+		{model: "bartowski/Mistral-Nemo-Instruct-2407-GGUF/Mistral-Nemo-Instruct-2407-Q4_K_M.gguf", want: "mistral_tekken"},
+		{model: "MaziyarPanahi/Mistral-7B-Instruct-v0.3-GGUF/Mistral-7B-Instruct-v0.3.Q4_K_M.gguf", want: "mistral_v3"},
+		{model: "TheBloke/Mixtral-8x7B-Instruct-v0.1-GGUF/mixtral-8x7b-instruct-v0.1.Q4_K_M.gguf", want: "mistral_v3"},
+		{model: "NousResearch/Hermes-3-Llama-3.1-8B-GGUF/Hermes-3-Llama-3.1-8B.Q4_K_M.gguf", want: "hermes"},
+		{model: "NousResearch/Hermes-2-Pro-Mistral-7B-GGUF/Hermes-2-Pro-Mistral-7B.Q4_K_M.gguf", want: "hermes"},
+		{model: "google/gemma-3-4b-it-GGUF/gemma-3-4b-it-Q4_K_M.gguf", want: "gemma"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			name, constructor, err := Default().Match(tt.model)
+			if err != nil {
+				t.Fatalf("Match failed: %v", err)
+			}
+			if name != tt.want {
+				t.Errorf("Match() = %s, want %s", name, tt.want)
+			}
+
+			f, err := constructor()
+			if err != nil || f == nil {
+				t.Fatalf("constructor returned %v, %v", f, err)
+			}
+			// Runners use the formats in-process, beyond encoding and decoding
+			local, ok := f.(format.Format)
+			if !ok {
+				t.Fatalf("%s does not implement format.Format", name)
+			}
+			if len(local.StopSequences()) == 0 {
+				t.Errorf("%s declares no stop sequences", name)
+			}
+			if _, ok := local.ToolSyntax(); !ok {
+				t.Errorf("%s declares no tool call syntax", name)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	// The override wins over the model identifier
+	if _, err := Default().New("Meta-Llama-3.1-8B-Instruct", "gpt-oss"); err != nil {
+		t.Errorf("New with override failed: %v", err)
+	}
+
+	_, err := Default().New("unknown-model-7b.gguf", "")
+	if err == nil || !strings.Contains(err.Error(), FormatEnv) {
+		t.Errorf("expected an error naming %s, got %v", FormatEnv, err)
+	}
+
+	if _, err := Default().New("Meta-Llama-3.1-8B-Instruct", "llama_4"); err == nil {
+		t.Error("expected an error for an unknown format name")
+	}
+
+	if _, err := Default().New("", ""); err == nil {
+		t.Error("expected an error without a model identifier")
+	}
+}
+
+func TestReplace(t *testing.T) {
+	r := Default()
+	original, err := r.Lookup("gemma")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+
+	called := false
+	if err := r.Replace("gemma", func() (models.Format, error) {
+		called = true
+		return original()
+	}); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	if _, err := r.New("gemma-3-4b-it", ""); err != nil || !called {
+		t.Errorf("expected the replaced constructor to be used, got %v", err)
+	}
+	if err := r.Replace("llama_4", original); err == nil {
+		t.Error("expected an error for an unknown format name")
+	}
+
+	// Other registries keep the bundled constructor
+	called = false
+	if _, err := Default().New("gemma-3-4b-it", ""); err != nil || called {
+		t.Errorf("expected the bundled constructor to be used, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	r := Default()
+	for _, name := range r.Names() {
+		if name == "chat_template" {
+			continue
+		}
+		constructor, _ := r.Lookup(name)
+		f, err := constructor()
+		if err != nil {
+			t.Fatalf("%s: constructor failed: %v", name, err)
+		}
+		if err := format.Verify(f.(format.Format), f); err != nil {
+			t.Errorf("%s: expected a format to verify against itself, got %v", name, err)
+		}
+	}
+
+	qwen3, _ := r.New("", "qwen_3")
+	llama, _ := r.New("", "llama_3_1")
+	if err := format.Verify(qwen3.(format.Format), llama); err == nil {
+		t.Error("expected an error for formats that encode differently")
+	}
+}
+
+func TestDecodePrompt_Required(t *testing.T) {
+	// Answers without a tool call, as written by a model ignoring the tool choice
+	answers := map[string]string{
+		"gpt_oss":          "<|channel|>final<|message|>It is sunny.<|return|>",
+		"deepseek_r1":      "The user asks about the weather.\n</think>\n\nIt is sunny.<｜end▁of▁sentence｜>",
+		"hermes":           "It is sunny.<|im_end|>",
+		"qwen_3":           "It is sunny.<|im_end|>",
+		"qwen_2_vl":        "It is sunny.<|im_end|>",
+		"qwen_2_5":         "It is sunny.<|im_end|>",
+		"llama_3_2_vision": "It is sunny.<|eot_id|>",
+		"llama_3_1":        "It is sunny.<|eot_id|>",
+		"mistral_tekken":   "It is sunny.</s>",
+		"mistral_v3":       "It is sunny.</s>",
+		"gemma":            "It is sunny.<end_of_turn>",
+		"chat_template":    "It is sunny.<|im_end|>",
+	}
+
+	r := Default()
+	// The chat template format is created from a template, see ForFile
+	if err := r.Replace("chat_template", func() (models.Format, error) {
+		return chattemplate.New(chattemplate.Config{ChatTemplate: "{% for m in messages %}{{ m.content }}{% endfor %}", EosToken: "<|im_end|>"})
+	}); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+	for _, name := range r.Names() {
+		t.Run(name, func(t *testing.T) {
+			answer, ok := answers[name]
+			if !ok {
+				t.Fatalf("no answer for %s", name)
+			}
+			f, err := r.New("", name)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			local := f.(format.Format)
+
+			if _, err := local.DecodePrompt(&format.Prompt{}, []byte(answer)); err != nil {
+				t.Fatalf("Expected the answer to decode without a tool choice, got %v", err)
+			}
+			prompt := &format.Prompt{Choice: toolchoice.Choice{Mode: toolchoice.Required}}
+			if _, err := local.DecodePrompt(prompt, []byte(answer)); err == nil {
+				t.Error("Expected an error for an answer without a tool call when one is required")
+			}
+		})
+	}
+}
+
+// writeGGUF writes a GGUF file holding only string metadata
+func writeGGUF(t *testing.T, metadata [][2]string) string {
+	buf := &bytes.Buffer{}
+	put := func(v interface{}) { binary.Write(buf, binary.LittleEndian, v) }
+	putString := func(s string) {
+		put(uint64(len(s)))
+		buf.WriteString(s)
+	}
+
+	buf.WriteString("GGUF")
+	put(uint32(3))
+	put(uint64(0))
+	put(uint64(len(metadata)))
+	for _, kv := range metadata {
+		putString(kv[0])
+		put(uint32(8)) // string
+		putString(kv[1])
+	}
+
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write gguf: %v", err)
+	}
+	return path
+}
+
+func TestDetect(t *testing.T) {
+	// The architecture decides when the file name says nothing about the model
+	qwen := writeGGUF(t, [][2]string{{"general.architecture", "qwen3"}})
+	resolve := func(name string) (string, error) {
+		if name == "my-model" {
+			return qwen, nil
+		}
+		return "", fmt.Errorf("model %s not found", name)
+	}
+
+	tests := []struct {
+		name     string
+		model    string
+		override string
+		wantErr  bool
+	}{
+		{name: "gguf path", model: qwen},
+		{name: "repository name resolved to a gguf file", model: "my-model"},
+		{name: "model name without a file", model: "Qwen3-8B-Q4_K_M.gguf"},
+		{name: "override", model: "my-model", override: "gpt_oss"},
+		{name: "unknown model", model: "unknown-model", wantErr: true},
+		{name: "no model", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(ModelEnv, tt.model)
+			t.Setenv(FormatEnv, tt.override)
+
+			format, err := Default().Detect(resolve)()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && format == nil {
+				t.Error("Detect() returned no format")
+			}
+		})
+	}
+}
+
+func TestForFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata [][2]string
+		want     string
+	}{
+		{
+			name:     "model name",
+			metadata: [][2]string{{"general.architecture", "llama"}, {"general.name", "Meta Llama 3.1 8B Instruct"}},
+			want:     "llama_3_1",
+		},
+		{
+			name:     "architecture",
+			metadata: [][2]string{{"general.architecture", "gpt-oss"}, {"general.name", "Openai_Gpt Oss 20b"}},
+			want:     "gpt_oss",
+		},
+		{
+			name:     "chat template",
+			metadata: [][2]string{{"general.architecture", "phi3"}, {"tokenizer.chat_template", "{% for m in messages %}{{ m.content }}{% endfor %}"}},
+			want:     "chat_template",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := gguf.Open(writeGGUF(t, tt.metadata))
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+
+			name, constructor, err := Default().ForFile(file)
+			if err != nil {
+				t.Fatalf("ForFile failed: %v", err)
+			}
+			if name != tt.want {
+				t.Errorf("ForFile() = %s, want %s", name, tt.want)
+			}
+			if format, err := constructor(); err != nil || format == nil {
+				t.Errorf("constructor returned %v, %v", format, err)
+			}
+		})
+	}
+
+	file, err := gguf.Open(writeGGUF(t, [][2]string{{"general.architecture", "phi3"}}))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, _, err := Default().ForFile(file); err == nil || !strings.Contains(err.Error(), "phi3") {
+		t.Errorf("expected an error naming the architecture, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Package schema renders mcp.Tool definitions as JSON Schema for prompt formats.
+//
+// The properties of an mcp.Tool input schema are carried as list<tuple<string, string>> where
+// every value holds the JSON schema of one parameter. Values that are not a JSON object are
+// treated as the description of a string parameter. Rendering keeps the property order of the
+// tool and never escapes HTML characters, so descriptions reach the model as they were written.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+)
+
+// Schema is a parsed JSON Schema. Only the keywords used to describe tool parameters are
+// broken out, the full schema is always available in Raw.
+type Schema struct {
+	// Types holds the type keyword, which may be a single type or a list of types
+	Types       []string
+	Description string
+	Enum        []json.RawMessage
+	Default     json.RawMessage
+	Properties  []Property
+	Items       *Schema
+	// Raw is the compact JSON text of the whole schema
+	Raw json.RawMessage
+}
+
+// Property is a named object property
+type Property struct {
+	Name     string
+	Required bool
+	Schema   *Schema
+}
+
+// Type returns the first non-null type of the schema, or an empty string when the schema
+// does not declare one
+func (s *Schema) Type() string {
+	for _, t := range s.Types {
+		if t != "null" {
+			return t
+		}
+	}
+	return ""
+}
+
+// Nullable reports whether the schema accepts null
+func (s *Schema) Nullable() bool {
+	for _, t := range s.Types {
+		if t == "null" {
+			return true
+		}
+	}
+	return false
+}
+
+// Property returns the schema of the named object property, nil when the schema is nil or does
+// not declare it
+func (s *Schema) Property(name string) *Schema {
+	if s == nil {
+		return nil
+	}
+	for _, property := range s.Properties {
+		if property.Name == name {
+			return property.Schema
+		}
+	}
+	return nil
+}
+
+// Parse parses a JSON Schema, keeping the order of its properties
+func Parse(raw []byte) (*Schema, error) {
+	raw = bytes.TrimSpace(raw)
+	compact := &bytes.Buffer{}
+	if err := json.Compact(compact, raw); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %v", err)
+	}
+
+	schema := &Schema{Raw: compact.Bytes()}
+	if len(raw) == 0 || raw[0] != '{' {
+		// Boolean schemas have no keywords
+		return schema, nil
+	}
+
+	var required []string
+	err := walkObject(raw, func(key string, value json.RawMessage) error {
+		switch key {
+		case "type":
+			types, err := parseTypes(value)
+			if err != nil {
+				return err
+			}
+			schema.Types = types
+		case "description":
+			return json.Unmarshal(value, &schema.Description)
+		case "enum":
+			return json.Unmarshal(value, &schema.Enum)
+		case "default":
+			schema.Default = value
+		case "required":
+			return json.Unmarshal(value, &required)
+		case "items":
+			items, err := Parse(value)
+			if err != nil {
+				return err
+			}
+			schema.Items = items
+		case "properties":
+			return walkObject(value, func(name string, value json.RawMessage) error {
+				property, err := Parse(value)
+				if err != nil {
+					return err
+				}
+				schema.Properties = append(schema.Properties, Property{Name: name, Schema: property})
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %v", err)
+	}
+
+	markRequired(schema.Properties, required)
+	return schema, nil
+}
+
+// FromTool returns the input schema of a tool as an object schema
+func FromTool(tool mcp.Tool) *Schema {
+	schemaType := tool.InputSchema.SchemaType
+	if schemaType == "" {
+		schemaType = "object"
+	}
+
+	schema := &Schema{Types: []string{schemaType}}
+	for _, prop := range tool.InputSchema.Properties.Slice() {
+		schema.Properties = append(schema.Properties, Property{Name: prop[0], Schema: parseProperty(prop[1])})
+	}
+	required := tool.InputSchema.Required.Slice()
+	markRequired(schema.Properties, required)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"type":`)
+	buf.Write(quote(schemaType))
+	buf.WriteString(`,"properties":{`)
+	for i, property := range schema.Properties {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(quote(property.Name))
+		buf.WriteByte(':')
+		buf.Write(property.Schema.Raw)
+	}
+	buf.WriteByte('}')
+	if len(required) > 0 {
+		buf.WriteString(`,"required":[`)
+		for i, name := range required {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(quote(name))
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('}')
+	schema.Raw = buf.Bytes()
+
+	return schema
+}
+
+// Find returns the input schema of the named tool, nil when no tool has that name
+func Find(tools []mcp.Tool, name string) *Schema {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return FromTool(tool)
+		}
+	}
+	return nil
+}
+
+// Parameters renders the input schema of a tool as a JSON Schema object
+func Parameters(tool mcp.Tool) json.RawMessage {
+	return FromTool(tool).Raw
+}
+
+// Function renders a tool as {"name": ..., "description": ..., "parameters": {...}}
+func Function(tool mcp.Tool) json.RawMessage {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"name":`)
+	buf.Write(quote(tool.Name))
+	buf.WriteString(`,"description":`)
+	buf.Write(quote(tool.Description))
+	buf.WriteString(`,"parameters":`)
+	buf.Write(Parameters(tool))
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// Tool renders a tool in the OpenAI format {"type": "function", "function": {...}}
+func Tool(tool mcp.Tool) json.RawMessage {
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"type":"function","function":`)
+	buf.Write(Function(tool))
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// Functions renders a list of tools as a JSON array of functions
+func Functions(tools []mcp.Tool) json.RawMessage {
+	return array(tools, Function)
+}
+
+// Tools renders a list of tools as a JSON array in the OpenAI format
+func Tools(tools []mcp.Tool) json.RawMessage {
+	return array(tools, Tool)
+}
+
+// parseProperty parses the schema of a single tool parameter
+func parseProperty(value string) *Schema {
+	trimmed := bytes.TrimSpace([]byte(value))
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if schema, err := Parse(trimmed); err == nil {
+			return schema
+		}
+	}
+
+	// A JSON string is the description itself, anything else is kept as written
+	description := value
+	if len(trimmed) > 0 && trimmed[0] == '"' {
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err == nil {
+			description = s
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"type":"string","description":`)
+	buf.Write(quote(description))
+	buf.WriteByte('}')
+
+	return &Schema{
+		Types:       []string{"string"},
+		Description: description,
+		Raw:         buf.Bytes(),
+	}
+}
+
+func parseTypes(value json.RawMessage) ([]string, error) {
+	var single string
+	if err := json.Unmarshal(value, &single); err == nil {
+		return []string{single}, nil
+	}
+
+	var types []string
+	if err := json.Unmarshal(value, &types); err != nil {
+		return nil, fmt.Errorf("invalid type: %s", value)
+	}
+	return types, nil
+}
+
+func markRequired(properties []Property, required []string) {
+	for _, name := range required {
+		for i := range properties {
+			if properties[i].Name == name {
+				properties[i].Required = true
+			}
+		}
+	}
+}
+
+// walkObject calls fn for every member of a JSON object in the order they appear
+func walkObject(raw []byte, fn func(key string, value json.RawMessage) error) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object")
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return fmt.Errorf("invalid key %v", token)
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+func array(tools []mcp.Tool, render func(mcp.Tool) json.RawMessage) json.RawMessage {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('[')
+	for i, tool := range tools {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(render(tool))
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// quote encodes a string as JSON without escaping HTML characters
+func quote(s string) []byte {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
@@ -0,0 +1,133 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestParameters(t *testing.T) {
+	tests := []struct {
+		name     string
+		tool     mcp.Tool
+		expected string
+	}{
+		{
+			name: "properties keep their order and required is rendered",
+			tool: mcp.Tool{
+				InputSchema: mcp.ToolSchema{
+					SchemaType: "object",
+					Properties: cm.ToList([][2]string{
+						{"unit", `{"type": "string", "enum": ["celsius", "fahrenheit"]}`},
+						{"city", `{"type": "string", "description": "The city name"}`},
+					}),
+					Required: cm.ToList([]string{"city"}),
+				},
+			},
+			expected: `{"type":"object","properties":{"unit":{"type":"string","enum":["celsius","fahrenheit"]},"city":{"type":"string","description":"The city name"}},"required":["city"]}`,
+		},
+		{
+			name: "nested schemas are kept",
+			tool: mcp.Tool{
+				InputSchema: mcp.ToolSchema{
+					Properties: cm.ToList([][2]string{
+						{"filter", `{"type": "object", "properties": {"tags": {"type": "array", "items": {"type": "string"}}, "min": {"type": "number"}}, "required": ["tags"]}`},
+					}),
+				},
+			},
+			expected: `{"type":"object","properties":{"filter":{"type":"object","properties":{"tags":{"type":"array","items":{"type":"string"}},"min":{"type":"number"}},"required":["tags"]}}}`,
+		},
+		{
+			name: "plain descriptions are quoted",
+			tool: mcp.Tool{
+				InputSchema: mcp.ToolSchema{
+					Properties: cm.ToList([][2]string{
+						{"query", `The "exact" <query>\ to run` + "\n"},
+						{"lang", `"language code"`},
+					}),
+				},
+			},
+			expected: `{"type":"object","properties":{"query":{"type":"string","description":"The \"exact\" <query>\\ to run\n"},"lang":{"type":"string","description":"language code"}}}`,
+		},
+		{
+			name:     "no properties",
+			tool:     mcp.Tool{},
+			expected: `{"type":"object","properties":{}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Parameters(tt.tool)
+			if string(got) != tt.expected {
+				t.Errorf("Parameters() =\n%s\nexpected:\n%s", got, tt.expected)
+			}
+			if !json.Valid(got) {
+				t.Errorf("Parameters() is not valid JSON: %s", got)
+			}
+		})
+	}
+}
+
+func TestTool(t *testing.T) {
+	tool := mcp.Tool{
+		Name:        "search",
+		Description: "Search the \"web\" for <b>results</b> & more",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{{"query", `{"type": "string"}`}}),
+			Required:   cm.ToList([]string{"query"}),
+		},
+	}
+
+	expected := `{"type":"function","function":{"name":"search","description":"Search the \"web\" for <b>results</b> & more","parameters":{"type":"object","properties":{"query":{"type":"string"}},"required":["query"]}}}`
+	if got := string(Tool(tool)); got != expected {
+		t.Errorf("Tool() =\n%s\nexpected:\n%s", got, expected)
+	}
+
+	expected = "[" + expected + "," + expected + "]"
+	if got := string(Tools([]mcp.Tool{tool, tool})); got != expected {
+		t.Errorf("Tools() =\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestParse(t *testing.T) {
+	schema, err := Parse([]byte(`{
+		"type": "object",
+		"description": "A filter",
+		"properties": {
+			"tags": {"type": "array", "items": {"type": "string", "enum": ["a", "b"]}},
+			"limit": {"type": ["integer", "null"]}
+		},
+		"required": ["tags"]
+	}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if schema.Type() != "object" || schema.Description != "A filter" {
+		t.Errorf("unexpected schema: type %q, description %q", schema.Type(), schema.Description)
+	}
+	if len(schema.Properties) != 2 {
+		t.Fatalf("expected 2 properties, got %d", len(schema.Properties))
+	}
+
+	tags := schema.Properties[0]
+	if tags.Name != "tags" || !tags.Required || tags.Schema.Type() != "array" {
+		t.Errorf("unexpected first property: %+v", tags)
+	}
+	if tags.Schema.Items == nil || len(tags.Schema.Items.Enum) != 2 || string(tags.Schema.Items.Enum[1]) != `"b"` {
+		t.Errorf("unexpected items schema: %+v", tags.Schema.Items)
+	}
+
+	limit := schema.Properties[1]
+	if limit.Name != "limit" || limit.Required || limit.Schema.Type() != "integer" || !limit.Schema.Nullable() {
+		t.Errorf("unexpected second property: %+v", limit)
+	}
+
+	if _, err := Parse([]byte(`{"type": `)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
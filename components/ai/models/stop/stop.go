@@ -0,0 +1,44 @@
+// Package stop cuts model output at the first of the stop sequences of its format and passes
+// them to backends that can stop generating there. Every format declares its own sequences, see
+// the format package.
+package stop
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// TensorName names the graph input holding the stop sequences as a JSON array of strings
+const TensorName = "stop"
+
+// Find returns the end of the first stop sequence in text, -1 when there is none. Text before
+// from has already been searched, only sequences that end after it are looked for so output
+// can be checked as it streams in.
+func Find(text []byte, from int, stops []string) int {
+	end := -1
+	for _, stop := range stops {
+		start := from - len(stop) + 1
+		if start < 0 {
+			start = 0
+		}
+		if start > len(text) {
+			continue
+		}
+		if i := strings.Index(string(text[start:]), stop); i != -1 {
+			if e := start + i + len(stop); end == -1 || e < end {
+				end = e
+			}
+		}
+	}
+	return end
+}
+
+// Tensor returns the stop sequences in the layout of the stop tensor
+func Tensor(stops []string) []byte {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(stops)
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
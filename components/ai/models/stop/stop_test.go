@@ -0,0 +1,35 @@
+package stop
+
+import (
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	stops := []string{"<|eot_id|>", "<|eom_id|>"}
+
+	text := []byte("Hello<|eot_id|> and more<|eom_id|>")
+	if got := Find(text, 0, stops); got != len("Hello<|eot_id|>") {
+		t.Errorf("Expected the end of the first stop sequence, got %d", got)
+	}
+
+	// A stop sequence split across two reads is found once its end arrives
+	text = []byte("Hello<|eot")
+	if got := Find(text, 0, stops); got != -1 {
+		t.Errorf("Expected no stop sequence yet, got %d", got)
+	}
+	from := len(text)
+	text = append(text, "_id|> more"...)
+	if got := Find(text, from, stops); got != len("Hello<|eot_id|>") {
+		t.Errorf("Expected the split stop sequence to be found, got %d", got)
+	}
+
+	if got := Find([]byte("Hello"), 0, nil); got != -1 {
+		t.Errorf("Expected -1 without stop sequences, got %d", got)
+	}
+}
+
+func TestTensor(t *testing.T) {
+	if got := string(Tensor([]string{"<|im_end|>", "</s>"})); got != `["<|im_end|>","</s>"]` {
+		t.Errorf("Tensor() = %s", got)
+	}
+}
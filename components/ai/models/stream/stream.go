@@ -0,0 +1,392 @@
+// Package stream decodes model output incrementally as it is generated.
+//
+// Format.Decode turns a whole output into a message, which is what the runner keeps, but
+// decoding the accumulated output again for every chunk makes streaming quadratic in the length
+// of the output. A Decoder is fed the chunks as they arrive and returns typed deltas: text,
+// reasoning, the start, arguments and end of every tool call, and done once the output ends.
+// Every byte is looked at a bounded number of times, so streaming costs time linear in the
+// output.
+//
+// Formats return the decoder for the output of their prompts, see the format package.
+package stream
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kind is the type of a delta
+type Kind string
+
+const (
+	// Text is a part of the answer
+	Text Kind = "text"
+	// Reasoning is a part of the thinking or analysis of the model
+	Reasoning Kind = "reasoning"
+	// ToolCallStart opens a tool call once its name is known
+	ToolCallStart Kind = "tool-call-start"
+	// ToolCallArgs is a part of the JSON arguments of the open tool call
+	ToolCallArgs Kind = "tool-call-args"
+	// ToolCallEnd closes a tool call
+	ToolCallEnd Kind = "tool-call-end"
+	// Done ends the output, nothing is decoded after it
+	Done Kind = "done"
+)
+
+// Delta is a piece of decoded output
+type Delta struct {
+	Kind Kind
+	// Text holds the new text, reasoning or arguments. On ToolCallEnd it holds the complete
+	// arguments of the call.
+	Text string
+	// Index is the position of the tool call among the calls of the output, starting at 0
+	Index int
+	// Name is the tool called, set on every tool call delta
+	Name string
+}
+
+// Decoder decodes output fed to it in chunks of any size
+type Decoder interface {
+	// Feed decodes the next chunk of output and returns the deltas it completes. Text that may
+	// be the start of a tag is held back until the following chunks tell.
+	Feed(data []byte) []Delta
+	// Close flushes what is held back and ends the output with Done, unless it has ended
+	// already
+	Close() []Delta
+}
+
+// NewDecoder returns a decoder for output written in syntax. Prefill is the text written after
+// the generation prompt, which the output continues, its deltas are returned by the first Feed.
+func NewDecoder(syntax Syntax, prefill string) Decoder {
+	d := &decoder{syntax: syntax}
+	for _, b := range syntax.blocks {
+		d.tags = append(d.tags, b.open)
+		if b.close != "" {
+			d.tags = append(d.tags, b.close)
+		}
+	}
+	d.tags = append(d.tags, syntax.ignore...)
+	d.tags = append(d.tags, syntax.end...)
+	d.pending = prefill
+	return d
+}
+
+// decoder scans output for the tags of a syntax. Text outside of blocks is the answer, the
+// content of reasoning blocks is reasoning and the content of call blocks is kept until the
+// block closes, with the name and arguments streamed as soon as they can be told apart.
+type decoder struct {
+	syntax Syntax
+	// tags are all the tags of the syntax, used to hold back partial ones
+	tags []string
+	// pending is the text that has not been decoded yet
+	pending string
+	// block is the open block, nil outside of blocks
+	block *block
+	// content of the open call block, searched up to searched for its end
+	content  []byte
+	searched int
+	// the open call once started, with the offset of its arguments in content and the number
+	// of bytes of them already emitted
+	started bool
+	name    string
+	args    int
+	emitted int
+	// calls is the number of calls ended
+	calls  int
+	done   bool
+	deltas []Delta
+}
+
+func (d *decoder) Feed(data []byte) []Delta {
+	if d.done {
+		return nil
+	}
+	d.pending += string(data)
+	d.scan()
+	return d.flush()
+}
+
+func (d *decoder) Close() []Delta {
+	if d.done {
+		return nil
+	}
+	switch {
+	case d.block == nil:
+		d.emit(Delta{Kind: Text, Text: d.pending})
+	case d.block.body == nil:
+		d.emit(Delta{Kind: Reasoning, Text: d.pending})
+	default:
+		d.progress(nil)
+		d.finish(d.content)
+	}
+	d.pending = ""
+	d.end()
+	return d.flush()
+}
+
+// scan decodes as much of the pending text as it can
+func (d *decoder) scan() {
+	for !d.done {
+		switch {
+		case d.block == nil:
+			if !d.scanText() {
+				return
+			}
+		case d.block.body == nil:
+			if !d.scanReasoning() {
+				return
+			}
+		default:
+			if !d.scanCall() {
+				return
+			}
+		}
+	}
+}
+
+// scanText emits the answer up to the next tag, it returns false when more output is needed
+func (d *decoder) scanText() bool {
+	i, tag := earliest(d.pending, d.tags)
+	if i == -1 {
+		keep := held(d.pending, d.tags)
+		d.emit(Delta{Kind: Text, Text: d.pending[:len(d.pending)-keep]})
+		d.pending = d.pending[len(d.pending)-keep:]
+		return false
+	}
+
+	d.emit(Delta{Kind: Text, Text: d.pending[:i]})
+	d.pending = d.pending[i:]
+
+	// The tag may still turn out to be the start of a longer one, e.g. ``` of ```tool_code
+	for _, t := range d.tags {
+		if len(t) > len(d.pending) && strings.HasPrefix(t, d.pending) {
+			return false
+		}
+	}
+	d.pending = d.pending[len(tag):]
+
+	if contains(d.syntax.end, tag) {
+		d.end()
+		return false
+	}
+	if contains(d.syntax.ignore, tag) {
+		return true
+	}
+	for n := range d.syntax.blocks {
+		if b := &d.syntax.blocks[n]; b.open == tag {
+			d.open(b)
+			return true
+		}
+	}
+
+	// A close tag outside of its block is part of the answer, e.g. the end of a code fence
+	d.emit(Delta{Kind: Text, Text: tag})
+	return true
+}
+
+// scanReasoning emits reasoning up to the end of the block, it returns false when more output is
+// needed
+func (d *decoder) scanReasoning() bool {
+	tags := append([]string{d.block.close}, d.syntax.end...)
+	i, tag := earliest(d.pending, tags)
+	if i == -1 {
+		keep := held(d.pending, tags)
+		d.emit(Delta{Kind: Reasoning, Text: d.pending[:len(d.pending)-keep]})
+		d.pending = d.pending[len(d.pending)-keep:]
+		return false
+	}
+
+	d.emit(Delta{Kind: Reasoning, Text: d.pending[:i]})
+	d.pending = d.pending[i+len(tag):]
+	d.block = nil
+
+	if contains(d.syntax.end, tag) {
+		d.end()
+		return false
+	}
+	return true
+}
+
+// scanCall collects the content of a call block and streams its name and arguments, it returns
+// false when more output is needed
+func (d *decoder) scanCall() bool {
+	d.content = append(d.content, d.pending...)
+	d.pending = ""
+
+	var tags []string
+	if d.block.close != "" {
+		tags = append(tags, d.block.close)
+	}
+	tags = append(tags, d.syntax.end...)
+
+	// Only tags ending after what has already been searched can be new
+	from := d.searched
+	for _, tag := range tags {
+		from = max(0, min(from, d.searched-len(tag)+1))
+	}
+	i, tag := earliestBytes(d.content[from:], tags)
+	if i == -1 {
+		d.searched = len(d.content)
+		d.progress(tags)
+		return false
+	}
+
+	i += from
+	d.pending = string(d.content[i+len(tag):])
+	d.content = d.content[:i]
+	d.progress(nil)
+	d.finish(d.content)
+	d.block = nil
+
+	if contains(d.syntax.end, tag) {
+		d.end()
+		return false
+	}
+	return true
+}
+
+// open starts a block
+func (d *decoder) open(b *block) {
+	d.block = b
+	d.content = d.content[:0]
+	d.searched = 0
+	d.started, d.name, d.args, d.emitted = false, "", 0, 0
+}
+
+// progress streams the name and arguments of the open call as far as they are known, the start
+// of the tags that may follow is held back
+func (d *decoder) progress(tags []string) {
+	body := d.block.body
+	if !d.started {
+		name, args, ok := body.head(d.content)
+		if !ok {
+			return
+		}
+		d.started, d.name, d.args = true, name, args
+		d.emit(Delta{Kind: ToolCallStart, Index: d.calls, Name: d.name})
+	}
+
+	args := d.content[d.args:]
+	tail := string(args[d.emitted:])
+	settled := body.settled(tail[:len(tail)-held(tail, tags)])
+	if settled > 0 {
+		d.emit(Delta{Kind: ToolCallArgs, Text: string(args[d.emitted : d.emitted+settled]), Index: d.calls, Name: d.name})
+		d.emitted += settled
+	}
+}
+
+// finish ends the calls of a closed call block. The arguments streamed so far are completed from
+// the parsed calls, a call that cannot be parsed ends with what was streamed of it and is left to
+// Format.Decode to report.
+func (d *decoder) finish(content []byte) {
+	body := d.block.body
+	calls, err := body.parse(string(content))
+	if err != nil {
+		if d.started {
+			args := string(content[min(d.args, len(content)):])
+			d.emit(Delta{Kind: ToolCallEnd, Text: args[:min(d.emitted, len(args))], Index: d.calls, Name: d.name})
+			d.calls++
+		}
+		d.started = false
+		return
+	}
+
+	for n, c := range calls {
+		if n == 0 && d.started {
+			streamed := string(content[d.args : d.args+d.emitted])
+			if rest, ok := strings.CutPrefix(c.args, streamed); ok && rest != "" {
+				d.emit(Delta{Kind: ToolCallArgs, Text: rest, Index: d.calls, Name: d.name})
+			}
+		} else {
+			d.emit(Delta{Kind: ToolCallStart, Index: d.calls, Name: c.name})
+			d.emit(Delta{Kind: ToolCallArgs, Text: c.args, Index: d.calls, Name: c.name})
+		}
+		d.emit(Delta{Kind: ToolCallEnd, Text: c.args, Index: d.calls, Name: c.name})
+		d.calls++
+	}
+	d.started = false
+}
+
+// end ends the output
+func (d *decoder) end() {
+	d.done = true
+	d.emit(Delta{Kind: Done})
+}
+
+// emit queues a delta, empty text deltas are dropped
+func (d *decoder) emit(delta Delta) {
+	if delta.Text == "" && (delta.Kind == Text || delta.Kind == Reasoning || delta.Kind == ToolCallArgs) {
+		return
+	}
+	d.deltas = append(d.deltas, delta)
+}
+
+// flush returns the queued deltas
+func (d *decoder) flush() []Delta {
+	deltas := d.deltas
+	d.deltas = nil
+	return deltas
+}
+
+// earliest returns the index and the tag found first in s, the longest tag wins a tie
+func earliest(s string, tags []string) (int, string) {
+	index, found := -1, ""
+	for _, tag := range tags {
+		if i := strings.Index(s, tag); i != -1 && (index == -1 || i < index || i == index && len(tag) > len(found)) {
+			index, found = i, tag
+		}
+	}
+	return index, found
+}
+
+// earliestBytes is earliest over bytes
+func earliestBytes(s []byte, tags []string) (int, string) {
+	index, found := -1, ""
+	for _, tag := range tags {
+		if i := bytes.Index(s, []byte(tag)); i != -1 && (index == -1 || i < index || i == index && len(tag) > len(found)) {
+			index, found = i, tag
+		}
+	}
+	return index, found
+}
+
+// partial returns the length of the longest end of s that starts one of the tags, e.g. "<|im"
+// for "<|im_end|>", which is held back until the tag is complete or ruled out
+func partial(s string, tags []string) int {
+	keep := 0
+	for _, tag := range tags {
+		for i := min(len(tag)-1, len(s)); i > keep; i-- {
+			if strings.HasSuffix(s, tag[:i]) {
+				keep = i
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// held returns the length of the end of s to hold back, the start of one of the tags or of a
+// UTF-8 sequence cut by the chunk boundary
+func held(s string, tags []string) int {
+	keep := partial(s, tags)
+	for i := 1; i <= min(utf8.UTFMax-1, len(s)); i++ {
+		if utf8.RuneStart(s[len(s)-i]) {
+			if !utf8.FullRuneInString(s[len(s)-i:]) {
+				keep = max(keep, i)
+			}
+			break
+		}
+	}
+	return keep
+}
+
+// contains reports whether tag is one of tags
+func contains(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
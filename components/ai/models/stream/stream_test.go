@@ -0,0 +1,213 @@
+package stream
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decode feeds output to a new decoder in chunks of size bytes, all at once when size is 0, and
+// returns the deltas with consecutive text, reasoning and arguments merged
+func decode(syntax Syntax, prefill, output string, size int) []Delta {
+	d := NewDecoder(syntax, prefill)
+
+	var deltas []Delta
+	if size == 0 {
+		size = len(output)
+	}
+	for start := 0; start < len(output); start += size {
+		deltas = append(deltas, d.Feed([]byte(output[start:min(start+size, len(output))]))...)
+	}
+	deltas = append(deltas, d.Close()...)
+
+	var merged []Delta
+	for _, delta := range deltas {
+		if n := len(merged); n > 0 && merged[n-1].Kind == delta.Kind && merged[n-1].Index == delta.Index &&
+			(delta.Kind == Text || delta.Kind == Reasoning || delta.Kind == ToolCallArgs) {
+			merged[n-1].Text += delta.Text
+			continue
+		}
+		merged = append(merged, delta)
+	}
+	return merged
+}
+
+func weatherCall(index int, args string) []Delta {
+	return []Delta{
+		{Kind: ToolCallStart, Index: index, Name: "get_weather"},
+		{Kind: ToolCallArgs, Text: args, Index: index, Name: "get_weather"},
+		{Kind: ToolCallEnd, Text: args, Index: index, Name: "get_weather"},
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	paris := `{"city": "Paris"}`
+	done := Delta{Kind: Done}
+
+	tests := []struct {
+		name    string
+		syntax  Syntax
+		prefill string
+		output  string
+		want    []Delta
+	}{
+		{
+			name:   "chatml",
+			syntax: ChatML,
+			output: "<think>\nLet me see.\n</think>\n\nI'll check.\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": " + paris + "}\n</tool_call><|im_end|>",
+			want: append(append([]Delta{{Kind: Reasoning, Text: "\nLet me see.\n"}, {Kind: Text, Text: "\n\nI'll check.\n"}},
+				weatherCall(0, paris)...), done),
+		},
+		{
+			name:    "chatml prefilled call",
+			syntax:  ChatML,
+			prefill: "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": ",
+			output:  paris + "}\n</tool_call>\n<tool_call>\n{\"arguments\": {}, \"name\": \"get_time\"}\n</tool_call><|im_end|>",
+			want: append(weatherCall(0, paris),
+				Delta{Kind: Text, Text: "\n"},
+				Delta{Kind: ToolCallStart, Index: 1, Name: "get_time"},
+				Delta{Kind: ToolCallArgs, Text: "{}", Index: 1, Name: "get_time"},
+				Delta{Kind: ToolCallEnd, Text: "{}", Index: 1, Name: "get_time"},
+				done),
+		},
+		{
+			name:    "deepseek",
+			syntax:  DeepSeek,
+			prefill: "<think>\n",
+			output:  "Hmm, weather. ☀️\n</think>\n\n<｜tool▁calls▁begin｜><｜tool▁call▁begin｜>function<｜tool▁sep｜>get_weather\n```json\n" + paris + "\n```<｜tool▁call▁end｜><｜tool▁calls▁end｜><｜end▁of▁sentence｜>",
+			want:    append(append([]Delta{{Kind: Reasoning, Text: "\nHmm, weather. ☀️\n"}, {Kind: Text, Text: "\n\n"}}, weatherCall(0, paris)...), done),
+		},
+		{
+			name:   "llama3",
+			syntax: Llama3,
+			output: "<function=get_weather>" + paris + "</function><|eom_id|>",
+			want:   append(weatherCall(0, paris), done),
+		},
+		{
+			name:   "mistral",
+			syntax: Mistral,
+			output: "[TOOL_CALLS] [{\"name\": \"get_weather\", \"arguments\": " + paris + "}, {\"name\": \"get_weather\", \"arguments\": {\"city\": \"Oslo\"}}]</s>",
+			want:   append(append(weatherCall(0, paris), weatherCall(1, `{"city": "Oslo"}`)...), done),
+		},
+		{
+			name:   "gemma",
+			syntax: Gemma,
+			output: "Sure.\n```tool_code\n{\"name\": \"get_weather\", \"arguments\": " + paris + "}\n```<end_of_turn>",
+			want:   append(append([]Delta{{Kind: Text, Text: "Sure.\n"}}, weatherCall(0, paris)...), done),
+		},
+		{
+			name:   "gemma code fence",
+			syntax: Gemma,
+			output: "Run:\n```sh\nls\n```<end_of_turn>",
+			want:   []Delta{{Kind: Text, Text: "Run:\n```sh\nls\n```"}, done},
+		},
+		{
+			name:   "harmony call",
+			syntax: Harmony,
+			output: "<|channel|>analysis<|message|>Need the weather.<|end|><|start|>assistant<|channel|>commentary to=functions.get_weather <|constrain|>json<|message|>" + paris + "<|call|>",
+			want:   append(append([]Delta{{Kind: Reasoning, Text: "Need the weather."}}, weatherCall(0, paris)...), done),
+		},
+		{
+			name:   "harmony answer",
+			syntax: Harmony,
+			output: "<|channel|>analysis<|message|>Easy.<|end|><|start|>assistant<|channel|>final<|message|>Sunny.<|return|>ignored",
+			want:   []Delta{{Kind: Reasoning, Text: "Easy."}, {Kind: Text, Text: "Sunny."}, done},
+		},
+		{
+			name:   "plain",
+			syntax: Plain,
+			output: "<|im_end|> is text here",
+			want:   []Delta{{Kind: Text, Text: "<|im_end|> is text here"}, done},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, size := range []int{0, 1, 7} {
+			t.Run(fmt.Sprintf("%s/%d", tt.name, size), func(t *testing.T) {
+				got := decode(tt.syntax, tt.prefill, tt.output, size)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("decode() =\n%+v\nwant\n%+v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecoder_StreamsArguments(t *testing.T) {
+	d := NewDecoder(ChatML, "")
+
+	d.Feed([]byte("<tool_call>\n{\"name\": \"get_weather\", "))
+	deltas := d.Feed([]byte("\"arguments\": {\"city\": \"Pa"))
+	want := []Delta{
+		{Kind: ToolCallStart, Name: "get_weather"},
+		{Kind: ToolCallArgs, Text: "{\"city\": \"Pa", Name: "get_weather"},
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Fatalf("Expected the call to start with the arguments so far, got %+v", deltas)
+	}
+
+	// The last brace may close the call rather than the arguments, it is held back with the
+	// partial close tag
+	deltas = d.Feed([]byte("ris\"}}\n</tool"))
+	if len(deltas) != 1 || deltas[0].Text != "ris\"}" {
+		t.Fatalf("Expected the last brace and the partial tag to be held back, got %+v", deltas)
+	}
+
+	deltas = d.Feed([]byte("_call>"))
+	want = []Delta{
+		{Kind: ToolCallEnd, Text: "{\"city\": \"Paris\"}", Name: "get_weather"},
+	}
+	if !reflect.DeepEqual(deltas, want) {
+		t.Errorf("Expected the call to end, got %+v", deltas)
+	}
+}
+
+func TestDecoder_Unterminated(t *testing.T) {
+	// A call cut off by the end of the output ends with what was streamed, Format.Decode reports
+	// the error
+	got := decode(ChatML, "", "<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\"<|im_end|>", 0)
+	want := []Delta{
+		{Kind: ToolCallStart, Name: "get_weather"},
+		{Kind: ToolCallArgs, Text: "{\"city\"", Name: "get_weather"},
+		{Kind: ToolCallEnd, Text: "{\"city\"", Name: "get_weather"},
+		{Kind: Done},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decode() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := decode(ChatML, "", "Hello <|im", 0); !reflect.DeepEqual(got, []Delta{{Kind: Text, Text: "Hello <|im"}, {Kind: Done}}) {
+		t.Errorf("Expected held back text to be flushed on close, got %+v", got)
+	}
+}
+
+// BenchmarkDecoder feeds outputs of growing length in the 256 byte chunks the runner reads. The
+// throughput stays the same for every length, decoding is linear in the output.
+func BenchmarkDecoder(b *testing.B) {
+	sentence := "The weather in Paris is sunny with a light breeze from the west. "
+	call := "<tool_call>\n{\"name\": \"write_file\", \"arguments\": {\"content\": \"" + strings.Repeat("lorem ipsum ", 20) + "\"}}\n</tool_call>\n"
+
+	for _, size := range []int{1 << 10, 1 << 14, 1 << 18} {
+		outputs := map[string]string{
+			"text":  strings.Repeat(sentence, size/len(sentence)),
+			"think": "<think>\n" + strings.Repeat(sentence, size/len(sentence)) + "</think>\n\nDone.",
+			"calls": strings.Repeat(call, size/len(call)),
+			// A single call whose arguments hold the whole output
+			"arguments": "<tool_call>\n{\"name\": \"write_file\", \"arguments\": {\"content\": \"" + strings.Repeat(sentence, size/len(sentence)) + "\"}}\n</tool_call>",
+		}
+		for _, name := range []string{"text", "think", "calls", "arguments"} {
+			output := []byte(outputs[name] + "<|im_end|>")
+			b.Run(fmt.Sprintf("%s/%d", name, size), func(b *testing.B) {
+				b.SetBytes(int64(len(output)))
+				for i := 0; i < b.N; i++ {
+					d := NewDecoder(ChatML, "")
+					for start := 0; start < len(output); start += 256 {
+						d.Feed(output[start:min(start+256, len(output))])
+					}
+					d.Close()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Syntax is the layout of the output of a prompt format
+type Syntax struct {
+	// Name identifies the syntax
+	Name string
+	// blocks are the reasoning and tool call blocks of the output
+	blocks []block
+	// ignore are tags dropped from the output
+	ignore []string
+	// end are the tags that end the output
+	end []string
+}
+
+// block is a part of the output between an open and a close tag. Reasoning blocks have no body,
+// call blocks without a close tag run to the end of the output.
+type block struct {
+	open  string
+	close string
+	body  body
+}
+
+// body reads the tool calls of a call block
+type body interface {
+	// head returns the name of the call and the offset of its arguments once the content
+	// collected so far tells them
+	head(content []byte) (name string, args int, ok bool)
+	// settled returns how many bytes of the arguments collected so far cannot be the start of
+	// what follows the arguments in the block
+	settled(args string) int
+	// parse returns the calls of the complete content of the block
+	parse(content string) ([]call, error)
+}
+
+// call is a parsed tool call with its arguments as JSON
+type call struct {
+	name string
+	args string
+}
+
+var (
+	// ChatML is the output of the Qwen and Hermes formats, with <think> reasoning and
+	// <tool_call> blocks holding {"name": ..., "arguments": {...}}
+	ChatML = Syntax{
+		Name: "chatml",
+		blocks: []block{
+			{open: "<think>", close: "</think>"},
+			{open: "<tool_call>", close: "</tool_call>", body: jsonBody{}},
+		},
+		end: []string{"<|im_end|>", "<|endoftext|>"},
+	}
+
+	// Llama3 writes custom calls as <function=name>{...}</function> and other calls after
+	// <|python_tag|>
+	Llama3 = Syntax{
+		Name: "llama3",
+		blocks: []block{
+			{open: "<function=", close: "</function>", body: namedBody{separator: ">"}},
+			{open: "<|python_tag|>", body: jsonBody{}},
+		},
+		end: []string{"<|eot_id|>", "<|eom_id|>", "<|end_of_text|>"},
+	}
+
+	// Mistral writes a JSON array of calls after [TOOL_CALLS]
+	Mistral = Syntax{
+		Name: "mistral",
+		blocks: []block{
+			{open: "[TOOL_CALLS]", body: arrayBody{}},
+		},
+		end: []string{"</s>"},
+	}
+
+	// Gemma writes every call as a ```tool_code fenced JSON object
+	Gemma = Syntax{
+		Name: "gemma",
+		blocks: []block{
+			{open: "```tool_code", close: "```", body: jsonBody{}},
+		},
+		end: []string{"<end_of_turn>", "<eos>"},
+	}
+
+	// DeepSeek starts with <think> reasoning and writes the calls between tool call tokens with
+	// the arguments in a json fence
+	DeepSeek = Syntax{
+		Name: "deepseek",
+		blocks: []block{
+			{open: "<think>", close: "</think>"},
+			{open: "<｜tool▁call▁begin｜>function<｜tool▁sep｜>", close: "<｜tool▁call▁end｜>", body: namedBody{separator: "```json"}},
+		},
+		ignore: []string{"<｜tool▁calls▁begin｜>", "<｜tool▁calls▁end｜>"},
+		end:    []string{"<｜end▁of▁sentence｜>"},
+	}
+
+	// Harmony writes analysis and commentary preambles as reasoning, the final channel as the
+	// answer and every call as a commentary message to the functions namespace
+	Harmony = Syntax{
+		Name: "harmony",
+		blocks: []block{
+			{open: "<|channel|>analysis<|message|>", close: "<|end|>"},
+			{open: "<|channel|>commentary<|message|>", close: "<|end|>"},
+			{open: "<|channel|>commentary to=functions.", close: "<|call|>", body: namedBody{separator: "<|message|>"}},
+		},
+		ignore: []string{"<|start|>assistant", "<|channel|>final<|message|>", "<|end|>"},
+		end:    []string{"<|return|>"},
+	}
+
+	// Plain streams the whole output as text, for formats that do not declare their syntax
+	Plain = Syntax{Name: "text"}
+)
+
+// ToolCall is the output of chat templates that teach the <tool_call> layout of ChatML without
+// its reasoning, it ends at any of the given end tags
+func ToolCall(end []string) Syntax {
+	return Syntax{
+		Name: "tool-call",
+		blocks: []block{
+			{open: "<tool_call>", close: "</tool_call>", body: jsonBody{}},
+		},
+		end: end,
+	}
+}
+
+// jsonHead matches the start of a {"name": ..., "arguments": ...} object up to the arguments
+var jsonHead = regexp.MustCompile(`^\s*\{\s*"name"\s*:\s*("(?:[^"\\]|\\.)*")\s*,\s*"(?:arguments|parameters)"\s*:\s*`)
+
+// jsonBody is a single {"name": ..., "arguments": {...}} object, Llama 3 names the arguments
+// parameters. The arguments are streamed when the name comes first.
+type jsonBody struct{}
+
+func (jsonBody) head(content []byte) (string, int, bool) {
+	match := jsonHead.FindSubmatchIndex(content)
+	// The arguments start once something other than white space follows the key
+	if match == nil || match[1] == len(content) {
+		return "", 0, false
+	}
+	var name string
+	if err := json.Unmarshal(content[match[2]:match[3]], &name); err != nil {
+		return "", 0, false
+	}
+	return name, match[1], true
+}
+
+// settled holds back trailing white space and a closing brace, which may close the call object
+func (jsonBody) settled(args string) int {
+	trimmed := strings.TrimRight(args, " \t\r\n")
+	return len(strings.TrimSuffix(trimmed, "}"))
+}
+
+func (jsonBody) parse(content string) ([]call, error) {
+	var data struct {
+		Name       string          `json:"name"`
+		Arguments  json.RawMessage `json:"arguments"`
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &data); err != nil {
+		return nil, err
+	}
+	if data.Name == "" {
+		return nil, fmt.Errorf("tool call is missing a name")
+	}
+
+	args := data.Arguments
+	if len(args) == 0 {
+		args = data.Parameters
+	}
+	return []call{{name: data.Name, args: jsonArgs(args)}}, nil
+}
+
+// namedBody is the tool name followed by separator and the JSON arguments. The name ends at the
+// first space, which Harmony follows with the content type, and the arguments may be closed by a
+// ``` fence.
+type namedBody struct {
+	separator string
+}
+
+func (b namedBody) head(content []byte) (string, int, bool) {
+	i := bytes.Index(content, []byte(b.separator))
+	if i == -1 {
+		return "", 0, false
+	}
+	args := i + len(b.separator)
+	for args < len(content) && strings.ContainsRune(" \t\r\n", rune(content[args])) {
+		args++
+	}
+	if args == len(content) {
+		return "", 0, false
+	}
+	return b.name(string(content[:i])), args, true
+}
+
+// settled holds back trailing white space and backticks, which may start the closing fence
+func (namedBody) settled(args string) int {
+	return len(strings.TrimRight(args, " \t\r\n`"))
+}
+
+func (b namedBody) parse(content string) ([]call, error) {
+	head, args, ok := strings.Cut(content, b.separator)
+	if !ok {
+		return nil, fmt.Errorf("tool call is missing %s", b.separator)
+	}
+	name := b.name(head)
+	if name == "" {
+		return nil, fmt.Errorf("tool call is missing a name")
+	}
+	args = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(args), "```"))
+	if args != "" && !json.Valid([]byte(args)) {
+		return nil, fmt.Errorf("invalid arguments for %s", name)
+	}
+	return []call{{name: name, args: jsonArgs([]byte(args))}}, nil
+}
+
+// name returns the tool name written before the separator
+func (namedBody) name(head string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(head), " ")
+	return name
+}
+
+// arrayBody is a JSON array of {"name": ..., "arguments": {...}} objects, or a single object,
+// which is only complete at the end of the output so the calls are not streamed
+type arrayBody struct{}
+
+func (arrayBody) head([]byte) (string, int, bool) {
+	return "", 0, false
+}
+
+func (arrayBody) settled(string) int {
+	return 0
+}
+
+func (arrayBody) parse(content string) ([]call, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "{") {
+		return jsonBody{}.parse(content)
+	}
+
+	var data []json.RawMessage
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return nil, err
+	}
+	var calls []call
+	for _, raw := range data {
+		c, err := jsonBody{}.parse(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, c...)
+	}
+	return calls, nil
+}
+
+// jsonArgs returns the arguments as written, an empty object when there are none
+func jsonArgs(args []byte) string {
+	if len(args) == 0 || string(args) == "null" {
+		return "{}"
+	}
+	return string(args)
+}
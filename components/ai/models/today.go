@@ -0,0 +1,16 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hayride-dev/morphs/components/util/datetime/pkg/datetime"
+)
+
+// today returns the current date from the hayride:datetime import, falling back to the clock of
+// the runtime when the answer cannot be parsed
+func today() time.Time {
+	if t, err := datetime.Today(); err == nil {
+		return t
+	}
+	return time.Now()
+}
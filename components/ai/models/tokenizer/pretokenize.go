@@ -0,0 +1,140 @@
+package tokenizer
+
+import "unicode"
+
+// pretokenizer splits text into the pieces that are encoded independently
+type pretokenizer func(text string) []string
+
+// Split patterns of the Hugging Face pre-tokenizers. They rely on a negative lookahead, which
+// Go regular expressions do not support, so they are implemented by splitDigits.
+const (
+	llama3Pattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	qwen2Pattern  = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+)
+
+var (
+	// llama3 groups up to three digits
+	llama3 = splitDigits(3)
+	// qwen2 splits every digit
+	qwen2 = splitDigits(1)
+)
+
+// pretokenizers maps the Split patterns found in tokenizer.json to their implementation
+var pretokenizers = map[string]pretokenizer{
+	llama3Pattern: llama3,
+	qwen2Pattern:  qwen2,
+}
+
+// splitDigits returns a pretokenizer matching the Llama 3 and Qwen 2 patterns, which only
+// differ in the number of digits kept together
+func splitDigits(digits int) pretokenizer {
+	return func(text string) []string {
+		runes := []rune(text)
+		var pieces []string
+		for i := 0; i < len(runes); {
+			n := match(runes[i:], digits)
+			pieces = append(pieces, string(runes[i:i+n]))
+			i += n
+		}
+		return pieces
+	}
+}
+
+// match returns the length of the piece at the start of r, trying the alternatives of the
+// pattern in order
+func match(r []rune, digits int) int {
+	// (?i:'s|'t|'re|'ve|'m|'ll|'d)
+	if r[0] == '\'' && len(r) > 1 {
+		switch unicode.ToLower(r[1]) {
+		case 's', 't', 'm', 'd':
+			return 2
+		}
+		if len(r) > 2 {
+			switch string(unicode.ToLower(r[1])) + string(unicode.ToLower(r[2])) {
+			case "re", "ve", "ll":
+				return 3
+			}
+		}
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	start := 0
+	if !isNewline(r[0]) && !unicode.IsLetter(r[0]) && !unicode.IsNumber(r[0]) {
+		start = 1
+	}
+	if n := count(r, start, len(r), unicode.IsLetter); n > 0 {
+		return start + n
+	}
+
+	// \p{N}{1,digits}
+	if n := count(r, 0, digits, unicode.IsNumber); n > 0 {
+		return n
+	}
+
+	// ` ?[^\s\p{L}\p{N}]+[\r\n]*`
+	start = 0
+	if r[0] == ' ' {
+		start = 1
+	}
+	if n := count(r, start, len(r), isSymbol); n > 0 {
+		end := start + n
+		return end + count(r, end, len(r), isNewline)
+	}
+
+	// What remains starts with whitespace
+	space := count(r, 0, len(r), unicode.IsSpace)
+
+	// \s*[\r\n]+ ends after the last line break of the whitespace
+	for i := space - 1; i >= 0; i-- {
+		if isNewline(r[i]) {
+			return i + 1
+		}
+	}
+
+	// \s+(?!\S) leaves the last whitespace to the following piece
+	if space > 1 && space < len(r) {
+		return space - 1
+	}
+
+	// \s+
+	if space == 0 {
+		return 1
+	}
+	return space
+}
+
+// count returns how many runes from r[start:] satisfy f, at most limit
+func count(r []rune, start, limit int, f func(rune) bool) int {
+	n := 0
+	for start+n < len(r) && n < limit && f(r[start+n]) {
+		n++
+	}
+	return n
+}
+
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+
+// isSymbol matches [^\s\p{L}\p{N}]
+func isSymbol(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// byteToUnicode maps every byte to a printable rune as done by GPT-2 byte level BPE, bytes that
+// are printable map to themselves and the others to runes from 256 upwards
+var byteToUnicode, unicodeToByte = func() ([256]rune, map[rune]byte) {
+	var forward [256]rune
+	reverse := make(map[rune]byte, 256)
+	next := rune(256)
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		if !(b >= '!' && b <= '~' || b >= 0xA1 && b <= 0xAC || b >= 0xAE && b <= 0xFF) {
+			r = next
+			next++
+		}
+		forward[b] = r
+		reverse[r] = byte(b)
+	}
+	return forward, reverse
+}()
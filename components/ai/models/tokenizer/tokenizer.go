@@ -0,0 +1,440 @@
+// Package tokenizer counts, encodes and decodes text with the byte level BPE vocabularies of
+// Llama 3 and Qwen.
+//
+// A tokenizer is loaded from a Hugging Face tokenizer.json or from the vocabulary embedded in a
+// GGUF file. Added tokens such as <|eot_id|> or <|im_start|> are matched verbatim before the
+// text is split, so prompts rendered by a format can be counted as the model will see them and
+// checked against the context window. Unicode normalization is not applied, text is expected to
+// be NFC already.
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+)
+
+// maxCache bounds the number of pre-tokens whose encoding is remembered
+const maxCache = 1 << 16
+
+// Tokenizer is a byte level BPE tokenizer, it is safe for concurrent use
+type Tokenizer struct {
+	vocab  map[string]uint32
+	tokens []string
+	ranks  map[[2]string]int
+
+	// added holds tokens that are matched verbatim instead of being split, special reports
+	// those that are control tokens
+	added   map[string]uint32
+	special map[uint32]bool
+	addedRe *regexp.Regexp
+
+	split pretokenizer
+	// ignoreMerges encodes pre-tokens found in the vocabulary as a single token without
+	// applying the merges, as Llama 3 does
+	ignoreMerges bool
+
+	mu    sync.Mutex
+	cache map[string][]uint32
+}
+
+// Open loads a tokenizer from a tokenizer.json or a .gguf file
+func Open(path string) (*Tokenizer, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".gguf") {
+		file, err := gguf.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := file.LoadVocabulary(); err != nil {
+			return nil, err
+		}
+		return FromGGUF(file)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokenizer: %w", err)
+	}
+	return FromJSON(data)
+}
+
+// tokenizerJSON is the subset of the Hugging Face tokenizer.json layout used here
+type tokenizerJSON struct {
+	AddedTokens []struct {
+		ID      uint32 `json:"id"`
+		Content string `json:"content"`
+		Special bool   `json:"special"`
+	} `json:"added_tokens"`
+	PreTokenizer *preTokenizerJSON `json:"pre_tokenizer"`
+	Model        struct {
+		Type         string            `json:"type"`
+		Vocab        map[string]uint32 `json:"vocab"`
+		Merges       json.RawMessage   `json:"merges"`
+		IgnoreMerges bool              `json:"ignore_merges"`
+	} `json:"model"`
+}
+
+type preTokenizerJSON struct {
+	Type    string `json:"type"`
+	Pattern struct {
+		Regex string `json:"Regex"`
+	} `json:"pattern"`
+	PreTokenizers []*preTokenizerJSON `json:"pretokenizers"`
+}
+
+// regex returns the pattern of the first Split pre-tokenizer
+func (p *preTokenizerJSON) regex() string {
+	if p == nil {
+		return ""
+	}
+	if p.Type == "Split" && p.Pattern.Regex != "" {
+		return p.Pattern.Regex
+	}
+	for _, child := range p.PreTokenizers {
+		if r := child.regex(); r != "" {
+			return r
+		}
+	}
+	return ""
+}
+
+// FromJSON loads a tokenizer from the contents of a Hugging Face tokenizer.json
+func FromJSON(data []byte) (*Tokenizer, error) {
+	var config tokenizerJSON
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse tokenizer.json: %w", err)
+	}
+	if config.Model.Type != "BPE" {
+		return nil, fmt.Errorf("unsupported tokenizer model %q, only BPE is supported", config.Model.Type)
+	}
+
+	pattern := config.PreTokenizer.regex()
+	split, ok := pretokenizers[pattern]
+	if !ok {
+		return nil, fmt.Errorf("unsupported pre-tokenizer pattern %q", pattern)
+	}
+
+	merges, err := parseMerges(config.Model.Merges)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newTokenizer(config.Model.Vocab, merges, split)
+	t.ignoreMerges = config.Model.IgnoreMerges
+	for _, token := range config.AddedTokens {
+		t.addToken(token.Content, token.ID, token.Special)
+	}
+	return t.build(), nil
+}
+
+// parseMerges accepts merges written as "a b" strings or as ["a", "b"] pairs
+func parseMerges(raw json.RawMessage) ([][2]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	var pairs [][2]string
+	if err := json.Unmarshal(raw, &pairs); err == nil {
+		return pairs, nil
+	}
+
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err != nil {
+		return nil, fmt.Errorf("failed to parse merges: %w", err)
+	}
+	return splitMerges(lines)
+}
+
+func splitMerges(lines []string) ([][2]string, error) {
+	pairs := make([][2]string, len(lines))
+	for i, line := range lines {
+		a, b, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid merge %q", line)
+		}
+		pairs[i] = [2]string{a, b}
+	}
+	return pairs, nil
+}
+
+// GGUF token types that mark tokens matched verbatim
+const (
+	tokenTypeControl     = 3
+	tokenTypeUserDefined = 4
+)
+
+// ggufPretokenizers maps tokenizer.ggml.pre to the pre-tokenizer and whether merges are
+// ignored for pre-tokens found in the vocabulary
+var ggufPretokenizers = map[string]struct {
+	split        pretokenizer
+	ignoreMerges bool
+}{
+	"llama-bpe": {split: llama3, ignoreMerges: true},
+	"llama3":    {split: llama3, ignoreMerges: true},
+	"llama-v3":  {split: llama3, ignoreMerges: true},
+	"qwen2":     {split: qwen2},
+}
+
+// FromGGUF loads the tokenizer embedded in the metadata of a GGUF file
+func FromGGUF(file *gguf.File) (*Tokenizer, error) {
+	if model, _ := file.String("tokenizer.ggml.model"); model != "gpt2" {
+		return nil, fmt.Errorf("unsupported tokenizer model %q, only gpt2 (byte level BPE) is supported", model)
+	}
+
+	pre, _ := file.String("tokenizer.ggml.pre")
+	config, ok := ggufPretokenizers[pre]
+	if !ok {
+		return nil, fmt.Errorf("unsupported pre-tokenizer %q", pre)
+	}
+
+	tokens, ok := file.Strings("tokenizer.ggml.tokens")
+	if !ok {
+		return nil, fmt.Errorf("gguf file has no tokenizer.ggml.tokens")
+	}
+	lines, _ := file.Strings("tokenizer.ggml.merges")
+	merges, err := splitMerges(lines)
+	if err != nil {
+		return nil, err
+	}
+	types, _ := file.Metadata["tokenizer.ggml.token_type"].([]int32)
+
+	vocab := make(map[string]uint32, len(tokens))
+	for id, token := range tokens {
+		vocab[token] = uint32(id)
+	}
+
+	t := newTokenizer(vocab, merges, config.split)
+	t.ignoreMerges = config.ignoreMerges
+	for id, tokenType := range types {
+		if id < len(tokens) && (tokenType == tokenTypeControl || tokenType == tokenTypeUserDefined) {
+			t.addToken(tokens[id], uint32(id), tokenType == tokenTypeControl)
+		}
+	}
+	return t.build(), nil
+}
+
+func newTokenizer(vocab map[string]uint32, merges [][2]string, split pretokenizer) *Tokenizer {
+	t := &Tokenizer{
+		vocab:   vocab,
+		ranks:   make(map[[2]string]int, len(merges)),
+		added:   make(map[string]uint32),
+		special: make(map[uint32]bool),
+		split:   split,
+		cache:   make(map[string][]uint32),
+	}
+	for rank, pair := range merges {
+		if _, ok := t.ranks[pair]; !ok {
+			t.ranks[pair] = rank
+		}
+	}
+	for token, id := range vocab {
+		t.setToken(token, id)
+	}
+	return t
+}
+
+func (t *Tokenizer) setToken(token string, id uint32) {
+	if int(id) >= len(t.tokens) {
+		t.tokens = append(t.tokens, make([]string, int(id)+1-len(t.tokens))...)
+	}
+	t.tokens[id] = token
+}
+
+func (t *Tokenizer) addToken(token string, id uint32, special bool) {
+	if token == "" {
+		return
+	}
+	t.added[token] = id
+	t.vocab[token] = id
+	t.setToken(token, id)
+	if special {
+		t.special[id] = true
+	}
+}
+
+// build compiles the matcher for added tokens, longer tokens are preferred so that a token is
+// never shadowed by one of its prefixes
+func (t *Tokenizer) build() *Tokenizer {
+	if len(t.added) == 0 {
+		return t
+	}
+
+	tokens := make([]string, 0, len(t.added))
+	for token := range t.added {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if len(tokens[i]) != len(tokens[j]) {
+			return len(tokens[i]) > len(tokens[j])
+		}
+		return tokens[i] < tokens[j]
+	})
+	for i, token := range tokens {
+		tokens[i] = regexp.QuoteMeta(token)
+	}
+	t.addedRe = regexp.MustCompile(strings.Join(tokens, "|"))
+	return t
+}
+
+// Encode returns the token ids of text. Added tokens in text are encoded as themselves, no
+// begin of text token is prepended.
+func (t *Tokenizer) Encode(text string) []uint32 {
+	var ids []uint32
+	start := 0
+	if t.addedRe != nil {
+		for _, loc := range t.addedRe.FindAllStringIndex(text, -1) {
+			ids = t.encodeText(ids, text[start:loc[0]])
+			ids = append(ids, t.added[text[loc[0]:loc[1]]])
+			start = loc[1]
+		}
+	}
+	return t.encodeText(ids, text[start:])
+}
+
+// Count returns the number of tokens in text
+func (t *Tokenizer) Count(text string) int {
+	return len(t.Encode(text))
+}
+
+// Decode returns the text of the token ids, unknown ids are skipped
+func (t *Tokenizer) Decode(ids []uint32) string {
+	var b strings.Builder
+	var pending []byte
+	flush := func() {
+		b.Write(pending)
+		pending = pending[:0]
+	}
+
+	for _, id := range ids {
+		token, ok := t.Token(id)
+		if !ok {
+			continue
+		}
+		if _, ok := t.added[token]; ok {
+			flush()
+			b.WriteString(token)
+			continue
+		}
+		// Byte level tokens are collected first since a character may span several tokens
+		for _, r := range token {
+			if c, ok := unicodeToByte[r]; ok {
+				pending = append(pending, c)
+			}
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// Token returns the text of a token
+func (t *Tokenizer) Token(id uint32) (string, bool) {
+	if int(id) >= len(t.tokens) || t.tokens[id] == "" {
+		return "", false
+	}
+	return t.tokens[id], true
+}
+
+// ID returns the id of a token, e.g. <|eot_id|>
+func (t *Tokenizer) ID(token string) (uint32, bool) {
+	id, ok := t.vocab[token]
+	return id, ok
+}
+
+// IsSpecial reports whether a token is a control token such as <|eot_id|> or <|im_end|>
+func (t *Tokenizer) IsSpecial(id uint32) bool {
+	return t.special[id]
+}
+
+// VocabSize returns the number of token ids, including added tokens
+func (t *Tokenizer) VocabSize() int {
+	return len(t.tokens)
+}
+
+// encodeText appends the ids of text that holds no added tokens
+func (t *Tokenizer) encodeText(ids []uint32, text string) []uint32 {
+	for _, piece := range t.split(text) {
+		ids = append(ids, t.encodePiece(piece)...)
+	}
+	return ids
+}
+
+// encodePiece encodes a single pre-token
+func (t *Tokenizer) encodePiece(piece string) []uint32 {
+	t.mu.Lock()
+	cached, ok := t.cache[piece]
+	t.mu.Unlock()
+	if ok {
+		return cached
+	}
+
+	var encoded strings.Builder
+	for i := 0; i < len(piece); i++ {
+		encoded.WriteRune(byteToUnicode[piece[i]])
+	}
+	word := encoded.String()
+
+	var ids []uint32
+	if id, ok := t.vocab[word]; ok && t.ignoreMerges {
+		ids = []uint32{id}
+	} else {
+		for _, symbol := range t.bpe(word) {
+			if id, ok := t.vocab[symbol]; ok {
+				ids = append(ids, id)
+				continue
+			}
+			// Symbols missing from the vocabulary fall back to their characters
+			for _, r := range symbol {
+				if id, ok := t.vocab[string(r)]; ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	t.mu.Lock()
+	if len(t.cache) < maxCache {
+		t.cache[piece] = ids
+	}
+	t.mu.Unlock()
+	return ids
+}
+
+// bpe applies the merges to a byte level word, the pair with the lowest rank is merged until
+// no pair can be merged
+func (t *Tokenizer) bpe(word string) []string {
+	symbols := make([]string, 0, len(word))
+	for _, r := range word {
+		symbols = append(symbols, string(r))
+	}
+
+	for len(symbols) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(symbols)-1; i++ {
+			if rank, ok := t.ranks[[2]string{symbols[i], symbols[i+1]}]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		first, second := symbols[best], symbols[best+1]
+		merged := symbols[:0]
+		for i := 0; i < len(symbols); i++ {
+			if i < len(symbols)-1 && symbols[i] == first && symbols[i+1] == second {
+				merged = append(merged, first+second)
+				i++
+				continue
+			}
+			merged = append(merged, symbols[i])
+		}
+		symbols = merged
+	}
+	return symbols
+}
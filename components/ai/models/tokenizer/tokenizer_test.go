@@ -0,0 +1,182 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+)
+
+// testMerges build " world" and "Hello" on top of the 256 byte tokens
+var testMerges = []string{"Ġ w", "o r", "Ġw or", "l d", "Ġwor ld", "H e", "l l", "ll o", "He llo"}
+
+// testVocab returns the byte tokens with ids 0-255 followed by the merged tokens
+func testVocab() []string {
+	tokens := make([]string, 0, 256+len(testMerges))
+	for b := 0; b < 256; b++ {
+		tokens = append(tokens, string(byteToUnicode[b]))
+	}
+	for _, merge := range testMerges {
+		pair, _ := splitMerges([]string{merge})
+		tokens = append(tokens, pair[0][0]+pair[0][1])
+	}
+	return tokens
+}
+
+const (
+	idWorld = 260
+	idHello = 264
+	idEot   = 265
+	idStart = 266
+	idThink = 267
+)
+
+func testTokenizerJSON(t *testing.T, pattern string) []byte {
+	vocab := make(map[string]int)
+	for id, token := range testVocab() {
+		vocab[token] = id
+	}
+
+	config := map[string]interface{}{
+		"added_tokens": []map[string]interface{}{
+			{"id": idEot, "content": "<|eot_id|>", "special": true},
+			{"id": idStart, "content": "<|im_start|>", "special": true},
+			{"id": idThink, "content": "<think>", "special": false},
+		},
+		"pre_tokenizer": map[string]interface{}{
+			"type": "Sequence",
+			"pretokenizers": []map[string]interface{}{
+				{"type": "Split", "pattern": map[string]string{"Regex": pattern}, "behavior": "Isolated"},
+				{"type": "ByteLevel", "add_prefix_space": false, "use_regex": false},
+			},
+		},
+		"model": map[string]interface{}{
+			"type":   "BPE",
+			"vocab":  vocab,
+			"merges": testMerges,
+		},
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("failed to marshal tokenizer.json: %v", err)
+	}
+	return data
+}
+
+func TestSplit(t *testing.T) {
+	text := "Hello world's 2024!\n\n  ok  "
+
+	tests := []struct {
+		name  string
+		split pretokenizer
+		want  []string
+	}{
+		{
+			name:  "llama3",
+			split: llama3,
+			want:  []string{"Hello", " world", "'s", " ", "202", "4", "!\n\n", " ", " ok", "  "},
+		},
+		{
+			name:  "qwen2",
+			split: qwen2,
+			want:  []string{"Hello", " world", "'s", " ", "2", "0", "2", "4", "!\n\n", " ", " ok", "  "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.split(text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("split() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := llama3("a\n \n b"); !reflect.DeepEqual(got, []string{"a", "\n \n", " b"}) {
+		t.Errorf("split() = %q", got)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tok, err := FromJSON(testTokenizerJSON(t, llama3Pattern))
+	if err != nil {
+		t.Fatalf("FromJSON failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []uint32
+	}{
+		{name: "merges", text: "Hello world", want: []uint32{idHello, idWorld}},
+		{name: "special tokens", text: "<|im_start|>Hello world<|eot_id|>", want: []uint32{idStart, idHello, idWorld, idEot}},
+		{name: "added token", text: "<think>Hi", want: []uint32{idThink, 'H', 'i'}},
+		{name: "multibyte", text: "é", want: []uint32{0xC3, 0xA9}},
+		{name: "empty", text: "", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tok.Encode(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Encode() = %v, want %v", got, tt.want)
+			}
+			if tok.Count(tt.text) != len(tt.want) {
+				t.Errorf("Count() = %d, want %d", tok.Count(tt.text), len(tt.want))
+			}
+			if decoded := tok.Decode(got); decoded != tt.text {
+				t.Errorf("Decode() = %q, want %q", decoded, tt.text)
+			}
+		})
+	}
+
+	if !tok.IsSpecial(idEot) || tok.IsSpecial(idThink) {
+		t.Error("expected <|eot_id|> to be special and <think> not")
+	}
+	if id, ok := tok.ID("<|eot_id|>"); !ok || id != idEot {
+		t.Errorf("ID() = %d, %v", id, ok)
+	}
+}
+
+func TestFromJSON_Errors(t *testing.T) {
+	if _, err := FromJSON(testTokenizerJSON(t, `\s+`)); err == nil {
+		t.Error("expected an error for an unsupported pre-tokenizer")
+	}
+	if _, err := FromJSON([]byte(`{"model": {"type": "Unigram"}}`)); err == nil {
+		t.Error("expected an error for an unsupported model")
+	}
+}
+
+func TestFromGGUF(t *testing.T) {
+	tokens := append(testVocab(), "<|eot_id|>", "<|im_start|>")
+	types := make([]int32, len(tokens))
+	for i := range types {
+		types[i] = 1
+	}
+	types[idEot], types[idStart] = tokenTypeControl, tokenTypeControl
+
+	file := &gguf.File{Metadata: map[string]interface{}{
+		"tokenizer.ggml.model":      "gpt2",
+		"tokenizer.ggml.pre":        "qwen2",
+		"tokenizer.ggml.tokens":     tokens,
+		"tokenizer.ggml.merges":     testMerges,
+		"tokenizer.ggml.token_type": types,
+	}}
+
+	tok, err := FromGGUF(file)
+	if err != nil {
+		t.Fatalf("FromGGUF failed: %v", err)
+	}
+
+	got := tok.Encode("<|im_start|>Hello world 42<|eot_id|>")
+	want := []uint32{idStart, idHello, idWorld, ' ', '4', '2', idEot}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Encode() = %v, want %v", got, want)
+	}
+
+	file.Metadata["tokenizer.ggml.pre"] = "gpt-4o"
+	if _, err := FromGGUF(file); err == nil {
+		t.Error("expected an error for an unsupported pre-tokenizer")
+	}
+}
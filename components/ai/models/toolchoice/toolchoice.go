@@ -0,0 +1,179 @@
+// Package toolchoice controls whether and which tools the model may call in a turn.
+//
+// A choice is auto (the model decides), none (tools are not offered), required (some tool must
+// be called) or the name of the tool that must be called. The runner passes the choice to the
+// format with the options of a prompt, see the format package. Formats then omit the tools, add
+// an instruction to the system prompt and prefill the start of a tool call in the assistant
+// turn, and check the decoded output with Validate.
+package toolchoice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"go.bytecodealliance.org/cm"
+)
+
+// Tool choice modes
+const (
+	Auto     = "auto"
+	None     = "none"
+	Required = "required"
+	// Function requires a call to the tool named by the choice
+	Function = "function"
+)
+
+// Directive starts a line of a user message that sets the tool choice of a request, e.g.
+// "/tool_choice search"
+const Directive = "/tool_choice"
+
+// Choice is the tool choice of a turn
+type Choice struct {
+	// Mode is one of auto, none, required or function
+	Mode string
+	// Name is the tool that must be called when Mode is function
+	Name string
+}
+
+// Parse reads auto, none, required or the name of a tool, an empty string is auto
+func Parse(s string) (Choice, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", Auto:
+		return Choice{Mode: Auto}, nil
+	case None, Required:
+		return Choice{Mode: s}, nil
+	}
+	if strings.ContainsAny(s, " \t\r\n") {
+		return Choice{}, fmt.Errorf("invalid tool choice %q, expected auto, none, required or a tool name", s)
+	}
+	return Choice{Mode: Function, Name: s}, nil
+}
+
+// String returns the choice in the form read by Parse
+func (c Choice) String() string {
+	if c.Mode == Function {
+		return c.Name
+	}
+	if c.Mode == "" {
+		return Auto
+	}
+	return c.Mode
+}
+
+// IsAuto reports whether the model decides on its own
+func (c Choice) IsAuto() bool {
+	return c.Mode == "" || c.Mode == Auto
+}
+
+// Forced reports whether the model must call a tool
+func (c Choice) Forced() bool {
+	return c.Mode == Required || c.Mode == Function
+}
+
+// FromRequest removes the /tool_choice lines from the text of a user message and returns the
+// choice set by the last one, ok is false when the message sets no choice
+func FromRequest(msg ai.Message) (ai.Message, Choice, bool, error) {
+	if msg.Role != ai.RoleUser {
+		return msg, Choice{}, false, nil
+	}
+
+	var choice Choice
+	found := false
+	contents := msg.Content.Slice()
+	cleaned := make([]ai.MessageContent, 0, len(contents))
+	for _, content := range contents {
+		if content.String() != "text" {
+			cleaned = append(cleaned, content)
+			continue
+		}
+
+		var lines []string
+		for _, line := range strings.Split(*content.Text(), "\n") {
+			value, ok := strings.CutPrefix(strings.TrimSpace(line), Directive)
+			if !ok || (value != "" && value[0] != ' ' && value[0] != '\t') {
+				lines = append(lines, line)
+				continue
+			}
+			c, err := Parse(value)
+			if err != nil {
+				return msg, Choice{}, false, err
+			}
+			choice, found = c, true
+		}
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			cleaned = append(cleaned, ai.NewMessageContent(ai.Text(text)))
+		}
+	}
+
+	if !found {
+		return msg, Choice{}, false, nil
+	}
+	msg.Content = cm.ToList(cleaned)
+	return msg, choice, true, nil
+}
+
+// Tools returns the tools offered to the model, none when the choice forbids tool calls. It
+// fails when the choice requires a call that none of the tools can satisfy.
+func (c Choice) Tools(tools []mcp.Tool) ([]mcp.Tool, error) {
+	switch c.Mode {
+	case None:
+		return nil, nil
+	case Required:
+		if len(tools) == 0 {
+			return nil, fmt.Errorf("tool choice %s requires tools", Required)
+		}
+	case Function:
+		for _, tool := range tools {
+			if tool.Name == c.Name {
+				return tools, nil
+			}
+		}
+		return nil, fmt.Errorf("tool choice names unknown tool %q", c.Name)
+	}
+	return tools, nil
+}
+
+// Instruction returns the sentence added to the system prompt when a tool call is required
+func (c Choice) Instruction() string {
+	switch c.Mode {
+	case Required:
+		return "You must call at least one of the provided functions in your next response."
+	case Function:
+		return fmt.Sprintf("You must call the function %s in your next response.", c.Name)
+	}
+	return ""
+}
+
+// Validate checks the tool calls of a decoded message. Calls are rejected when the choice is
+// none and must match the named tool, a message without calls is rejected when a call is
+// required unless it only holds reasoning, after which the model may still call a tool.
+func (c Choice) Validate(msg *ai.Message) error {
+	if msg == nil {
+		return nil
+	}
+
+	calls, answered := 0, false
+	for _, content := range msg.Content.Slice() {
+		if content.String() != "tool-input" {
+			answered = answered || !reasoning.Is(content)
+			continue
+		}
+		calls++
+		name := content.ToolInput().Name
+		switch {
+		case c.Mode == None:
+			return fmt.Errorf("tool choice %s forbids tool calls, the model called %s", None, name)
+		case c.Mode == Function && name != c.Name:
+			return fmt.Errorf("tool choice requires a call to %s, the model called %s", c.Name, name)
+		}
+	}
+
+	if calls == 0 && answered && c.Forced() {
+		return fmt.Errorf("tool choice %s requires a tool call, the model answered without one", c.String())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package toolchoice
+
+import (
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/reasoning"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Choice
+		wantErr bool
+	}{
+		{input: "", want: Choice{Mode: Auto}},
+		{input: "auto", want: Choice{Mode: Auto}},
+		{input: " none ", want: Choice{Mode: None}},
+		{input: "required", want: Choice{Mode: Required}},
+		{input: "get_weather", want: Choice{Mode: Function, Name: "get_weather"}},
+		{input: "get weather", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error for %q", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	msg := ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{
+		ai.NewMessageContent(ai.Text("Weather in Paris?\n/tool_choice get_weather")),
+	})}
+
+	cleaned, choice, ok, err := FromRequest(msg)
+	if err != nil {
+		t.Fatalf("FromRequest failed: %v", err)
+	}
+	if !ok || choice != (Choice{Mode: Function, Name: "get_weather"}) {
+		t.Fatalf("Expected the get_weather choice, got %+v (ok %v)", choice, ok)
+	}
+	if text := *cleaned.Content.Slice()[0].Text(); text != "Weather in Paris?" {
+		t.Errorf("Expected the directive to be removed, got %q", text)
+	}
+
+	// Lines that merely start with the directive name are left alone
+	msg = ai.Message{Role: ai.RoleUser, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("/tool_choices are fun"))})}
+	if _, _, ok, _ := FromRequest(msg); ok {
+		t.Error("Expected no choice for text without the directive")
+	}
+}
+
+func TestTools(t *testing.T) {
+	tools := []mcp.Tool{{Name: "get_weather"}, {Name: "get_time"}}
+
+	if got, _ := (Choice{Mode: None}).Tools(tools); len(got) != 0 {
+		t.Errorf("Expected no tools for none, got %d", len(got))
+	}
+	if got, err := (Choice{Mode: Function, Name: "get_time"}).Tools(tools); err != nil || len(got) != 2 {
+		t.Errorf("Expected all tools for a known name, got %d (%v)", len(got), err)
+	}
+	if _, err := (Choice{Mode: Function, Name: "search"}).Tools(tools); err == nil {
+		t.Error("Expected an error for an unknown tool")
+	}
+	if _, err := (Choice{Mode: Required}).Tools(nil); err == nil {
+		t.Error("Expected an error for a required call without tools")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	call := func(name string) ai.MessageContent {
+		return ai.NewMessageContent(mcp.CallToolParams{Name: name, Arguments: cm.ToList([][2]string{})})
+	}
+	answer := &ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))}), Final: true}
+	// Formats without reasoning leave their answers unmarked
+	unmarked := &ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text("Hi"))})}
+	thinking := &ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{reasoning.Text("Which tool?")})}
+	weather := &ai.Message{Role: ai.RoleAssistant, Content: cm.ToList([]ai.MessageContent{call("get_weather")})}
+
+	tests := []struct {
+		name    string
+		choice  Choice
+		msg     *ai.Message
+		wantErr bool
+	}{
+		{name: "auto answer", choice: Choice{Mode: Auto}, msg: answer},
+		{name: "auto call", choice: Choice{Mode: Auto}, msg: weather},
+		{name: "none answer", choice: Choice{Mode: None}, msg: answer},
+		{name: "none call", choice: Choice{Mode: None}, msg: weather, wantErr: true},
+		{name: "required call", choice: Choice{Mode: Required}, msg: weather},
+		{name: "required answer", choice: Choice{Mode: Required}, msg: answer, wantErr: true},
+		{name: "required unmarked answer", choice: Choice{Mode: Required}, msg: unmarked, wantErr: true},
+		{name: "required reasoning", choice: Choice{Mode: Required}, msg: thinking},
+		{name: "named answer", choice: Choice{Mode: Function, Name: "get_weather"}, msg: unmarked, wantErr: true},
+		{name: "named call", choice: Choice{Mode: Function, Name: "get_weather"}, msg: weather},
+		{name: "other call", choice: Choice{Mode: Function, Name: "get_time"}, msg: weather, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.choice.Validate(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
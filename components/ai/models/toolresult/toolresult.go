@@ -0,0 +1,95 @@
+// Package toolresult flattens tool results into the text formats send back to the model, and
+// derives the ids that pair tool calls with their results.
+package toolresult
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+)
+
+// Message flattens the content of a tool message into the string sent back to the model
+func Message(msg ai.Message) string {
+	builder := &strings.Builder{}
+	for _, content := range msg.Content.Slice() {
+		switch content.String() {
+		case "text":
+			builder.WriteString(*content.Text())
+		case "tool-output":
+			builder.WriteString(Text(content.ToolOutput(), ""))
+		}
+	}
+	return builder.String()
+}
+
+// Text flattens the content of a tool result. Vision formats pass their image token as image,
+// which refers to the image tensor vision.Images passes for it. Binary content is otherwise
+// described by a placeholder, its data is never written into the prompt.
+func Text(output *mcp.CallToolResult, image string) string {
+	builder := &strings.Builder{}
+	for _, c := range output.Content.Slice() {
+		switch c.String() {
+		case "text":
+			builder.WriteString(c.Text().Text)
+		case "image":
+			if image != "" {
+				builder.WriteString(image)
+				continue
+			}
+			builder.WriteString(Placeholder("image", c.Image().MIMEType))
+		case "audio":
+			builder.WriteString(Placeholder("audio", c.Audio().MIMEType))
+		case "resource-link":
+			resource := c.ResourceLink()
+			builder.WriteString(fmt.Sprintf("Resource Link: %s", resource.URI))
+		case "resource-content":
+			content := c.ResourceContent()
+			switch content.ResourceContents.String() {
+			case "text":
+				builder.WriteString(fmt.Sprintf("Resource Content (Text): %s", content.ResourceContents.Text().Text))
+			case "blob":
+				blob := content.ResourceContents.Blob()
+				builder.WriteString(fmt.Sprintf("Resource Content (Blob): %s %s", blob.URI, Placeholder("blob", blob.MIMEType)))
+			}
+		}
+	}
+	return builder.String()
+}
+
+// Placeholder returns the text written in place of binary content of a kind, e.g.
+// "[image: image/png]"
+func Placeholder(kind string, mimeType string) string {
+	if mimeType == "" {
+		return fmt.Sprintf("[%s]", kind)
+	}
+	return fmt.Sprintf("[%s: %s]", kind, mimeType)
+}
+
+const (
+	// Mistral requires tool call ids to be exactly 9 alphanumeric characters, chat templates
+	// render the same ids
+	callIDLength = 9
+	callIDChars  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// CallID derives the 9 character alphanumeric id of a tool call.
+//
+// mcp.CallToolParams does not carry an id, so the id is derived from the position of
+// the call in the conversation and its name. The same id is rendered for the call
+// and for the result answering it, so the pairing survives every re-encode of the
+// history.
+func CallID(messageIndex int, callIndex int, name string) string {
+	h := fnv.New64a()
+	h.Write([]byte(fmt.Sprintf("%d:%d:%s", messageIndex, callIndex, name)))
+	sum := h.Sum64()
+
+	id := make([]byte, callIDLength)
+	for i := range id {
+		id[i] = callIDChars[sum%uint64(len(callIDChars))]
+		sum /= uint64(len(callIDChars))
+	}
+	return string(id)
+}
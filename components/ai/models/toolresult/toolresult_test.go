@@ -0,0 +1,64 @@
+package toolresult
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestMessage(t *testing.T) {
+	msg := ai.Message{
+		Role: ai.RoleTool,
+		Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(mcp.CallToolResult{
+				Content: cm.ToList([]mcp.Content{
+					mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Sunny, "}),
+					mcp.NewContent(mcp.ResourceLinkContent{ContentType: "resource-link", URI: "https://weather.example/paris"}),
+				}),
+			}),
+		}),
+	}
+
+	if got, want := Message(msg), "Sunny, Resource Link: https://weather.example/paris"; got != want {
+		t.Errorf("Message() = %q, want %q", got, want)
+	}
+}
+
+func TestText(t *testing.T) {
+	output := &mcp.CallToolResult{
+		Content: cm.ToList([]mcp.Content{
+			mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "Screenshot "}),
+			mcp.NewContent(mcp.ImageContent{ContentType: "image", Data: cm.ToList([]byte("iVBORw0KGgo=")), MIMEType: "image/png"}),
+			mcp.NewContent(mcp.AudioContent{ContentType: "audio", Data: cm.ToList([]byte("UklGRg==")), MIMEType: "audio/wav"}),
+		}),
+	}
+
+	// The data of binary content is never written into the prompt
+	if got, want := Text(output, ""), "Screenshot [image: image/png][audio: audio/wav]"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+	if got, want := Text(output, "<image>"), "Screenshot <image>[audio: audio/wav]"; got != want {
+		t.Errorf("Text() with an image token = %q, want %q", got, want)
+	}
+}
+
+func TestCallID(t *testing.T) {
+	id := CallID(2, 0, "get_weather")
+	if len(id) != 9 {
+		t.Fatalf("Expected a 9 character id, got %q", id)
+	}
+	for _, c := range id {
+		if !strings.ContainsRune(callIDChars, c) {
+			t.Errorf("Unexpected character %q in id %q", c, id)
+		}
+	}
+	if id != CallID(2, 0, "get_weather") {
+		t.Error("Expected tool call ids to be stable")
+	}
+	if id == CallID(2, 1, "get_weather") {
+		t.Error("Expected distinct ids for distinct calls")
+	}
+}
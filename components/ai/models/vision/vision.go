@@ -0,0 +1,54 @@
+// Package vision defines how images in a conversation are handed to vision models.
+//
+// Vision formats write a placeholder into the prompt for every image and the runner passes the
+// image bytes to the graph as additional tensors. Both sides walk the messages with Images, so
+// the n-th placeholder in the prompt always refers to the n-th image tensor.
+package vision
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+)
+
+// TensorPrefix names the image tensors passed next to the "user" prompt tensor, the n-th image
+// is named image-n
+const TensorPrefix = "image"
+
+// TensorName returns the name of the tensor holding the n-th image of a conversation
+func TensorName(n int) string {
+	return fmt.Sprintf("%s-%d", TensorPrefix, n)
+}
+
+// Images returns the images of the messages in prompt order, the blob content of user messages
+// and the image content of tool results. MCP tool results carry base64 encoded images, which
+// are decoded.
+func Images(messages ...ai.Message) [][]byte {
+	var images [][]byte
+	for _, msg := range messages {
+		for _, content := range msg.Content.Slice() {
+			switch {
+			case msg.Role == ai.RoleUser && content.String() == "blob":
+				images = append(images, content.Blob().Slice())
+			case msg.Role == ai.RoleTool && content.String() == "tool-output":
+				for _, c := range content.ToolOutput().Content.Slice() {
+					if c.String() == "image" {
+						images = append(images, decode(c.Image().Data.Slice()))
+					}
+				}
+			}
+		}
+	}
+	return images
+}
+
+// decode returns the image bytes of base64 encoded data, data that is not base64 is returned as
+// is since binary image headers are never valid base64
+func decode(data []byte) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return data
+	}
+	return decoded
+}
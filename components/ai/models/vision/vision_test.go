@@ -0,0 +1,56 @@
+package vision
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestImages(t *testing.T) {
+	png := []byte{0x89, 'P', 'N', 'G'}
+	toolImage := func(data []byte) ai.MessageContent {
+		return ai.NewMessageContent(mcp.CallToolResult{
+			Content: cm.ToList([]mcp.Content{
+				mcp.NewContent(mcp.TextContent{ContentType: "text", Text: "screenshot taken"}),
+				mcp.NewContent(mcp.ImageContent{ContentType: "image", Data: cm.ToList(data), MIMEType: "image/png"}),
+			}),
+		})
+	}
+
+	messages := []ai.Message{
+		{
+			// Blobs outside of user messages are not rendered as images
+			Role:    ai.RoleSystem,
+			Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(cm.ToList([]uint8{1}))}),
+		},
+		{
+			Role: ai.RoleUser,
+			Content: cm.ToList([]ai.MessageContent{
+				ai.NewMessageContent(ai.Text("Compare these")),
+				ai.NewMessageContent(cm.ToList([]uint8{1, 2, 3})),
+			}),
+		},
+		{
+			Role:    ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{toolImage([]byte("iVBORw==")), toolImage(png)}),
+		},
+	}
+
+	images := Images(messages...)
+	want := [][]byte{{1, 2, 3}, {0x89, 'P', 'N', 'G'}, png}
+	if len(images) != len(want) {
+		t.Fatalf("expected %d images, got %d", len(want), len(images))
+	}
+	for i := range want {
+		if !bytes.Equal(images[i], want[i]) {
+			t.Errorf("image %d = %v, want %v", i, images[i], want[i])
+		}
+	}
+
+	if TensorName(1) != "image-1" {
+		t.Errorf("unexpected tensor name %s", TensorName(1))
+	}
+}
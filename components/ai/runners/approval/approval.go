@@ -0,0 +1,250 @@
+// Package approval pauses runs on tool calls a person must approve before they run.
+//
+// A Policy names the calls that need approval: calls to tools annotated as destructive when
+// Destructive is set, and calls to the tools it lists. A turn with such a call runs none of its
+// calls. The run pauses instead and returns a pending result for every call awaiting a decision,
+// see PendingResult, while the calls stay the last message of the agent context. A user message
+// holding decisions, one per line, resumes the run:
+//
+//	/approve 0
+//	/deny 1 keep the backups
+//	/edit 2 {"path": "/tmp/scratch"}
+//
+// The number is the index of the call among the calls of the turn. Approve and deny apply to
+// every call of the turn when the index is left out. Edit runs the call with the given arguments,
+// the runner restates the edited calls in the context. Denied calls are returned to the model as
+// error results holding the reason. A paused run fails requests that decide nothing and goes on
+// with the turns it has left once resumed.
+package approval
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"go.bytecodealliance.org/cm"
+)
+
+// Approval modes
+const (
+	// None only requires approval for the listed tools
+	None = "none"
+	// Destructive also requires approval for calls to tools annotated as destructive
+	Destructive = "destructive"
+)
+
+// Decision actions, each starts a line of a user message that resumes a run
+const (
+	Approve = "/approve"
+	Deny    = "/deny"
+	Edit    = "/edit"
+)
+
+// Status is the meta entry marking pending results, see PendingResult
+const Status = "status"
+
+// PendingStatus is the status of calls awaiting a decision
+const PendingStatus = "pending-approval"
+
+// Policy names the tool calls that need approval
+type Policy struct {
+	// Destructive requires approval for tools annotated as destructive and not read-only
+	Destructive bool
+	// Tools always require approval
+	Tools []string
+}
+
+// ParsePolicy reads an approval mode, none or destructive, and a comma separated list of tools.
+// An empty mode is none.
+func ParsePolicy(mode, tools string) (Policy, error) {
+	var policy Policy
+	switch strings.TrimSpace(mode) {
+	case "", None:
+	case Destructive:
+		policy.Destructive = true
+	default:
+		return Policy{}, fmt.Errorf("invalid approval mode %q, expected %s or %s", mode, None, Destructive)
+	}
+
+	for _, name := range strings.Split(tools, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			policy.Tools = append(policy.Tools, name)
+		}
+	}
+	return policy, nil
+}
+
+// Required reports whether a call needs approval
+func (p Policy) Required(call mcp.CallToolParams, offered []mcp.Tool) bool {
+	if slices.Contains(p.Tools, call.Name) {
+		return true
+	}
+	if !p.Destructive {
+		return false
+	}
+	for _, tool := range offered {
+		if tool.Name == call.Name {
+			return tool.Annotations.DestructiveHint && !tool.Annotations.ReadOnlyHint
+		}
+	}
+	return false
+}
+
+// Decision is the decision on a call awaiting approval
+type Decision struct {
+	// Action is one of Approve, Deny or Edit
+	Action string
+	// Index is the index of the call, -1 for every call of the turn
+	Index int
+	// Reason tells the model why a call was denied
+	Reason string
+	// Arguments replace the arguments of an edited call
+	Arguments [][2]string
+}
+
+// ParseDecision reads a decision line
+func ParseDecision(line string) (Decision, error) {
+	action, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+	decision := Decision{Action: action, Index: -1}
+
+	// The index is optional but for edits
+	if value, after, _ := strings.Cut(rest, " "); value != "" {
+		if index, err := strconv.Atoi(value); err == nil {
+			if index < 0 {
+				return Decision{}, fmt.Errorf("invalid call index %d", index)
+			}
+			decision.Index, rest = index, strings.TrimSpace(after)
+		}
+	}
+
+	switch action {
+	case Approve:
+		if rest != "" {
+			return Decision{}, fmt.Errorf("invalid decision %q, expected %s [index]", line, Approve)
+		}
+	case Deny:
+		decision.Reason = rest
+	case Edit:
+		if decision.Index == -1 {
+			return Decision{}, fmt.Errorf("invalid decision %q, expected %s index arguments", line, Edit)
+		}
+		args, err := arguments.Decode([]byte(rest))
+		if err != nil {
+			return Decision{}, fmt.Errorf("invalid arguments for call %d: %w", decision.Index, err)
+		}
+		decision.Arguments = args
+	default:
+		return Decision{}, fmt.Errorf("unknown decision %q", action)
+	}
+	return decision, nil
+}
+
+// FromRequest removes the decision lines from the text of a user message and returns the
+// decisions in their order
+func FromRequest(msg ai.Message) (ai.Message, []Decision, error) {
+	if msg.Role != ai.RoleUser {
+		return msg, nil, nil
+	}
+
+	var decisions []Decision
+	contents := msg.Content.Slice()
+	cleaned := make([]ai.MessageContent, 0, len(contents))
+	for _, content := range contents {
+		if content.String() != "text" {
+			cleaned = append(cleaned, content)
+			continue
+		}
+
+		var lines []string
+		for _, line := range strings.Split(*content.Text(), "\n") {
+			if !isDecision(line) {
+				lines = append(lines, line)
+				continue
+			}
+			decision, err := ParseDecision(line)
+			if err != nil {
+				return msg, nil, err
+			}
+			decisions = append(decisions, decision)
+		}
+		if text := strings.TrimSpace(strings.Join(lines, "\n")); text != "" {
+			cleaned = append(cleaned, ai.NewMessageContent(ai.Text(text)))
+		}
+	}
+
+	if len(decisions) == 0 {
+		return msg, nil, nil
+	}
+	msg.Content = cm.ToList(cleaned)
+	return msg, decisions, nil
+}
+
+// isDecision reports whether a line starts with a decision action
+func isDecision(line string) bool {
+	action, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	return action == Approve || action == Deny || action == Edit
+}
+
+// Decide returns the decision on a call, the last one naming its index or applying to every
+// call. ok is false when there is none.
+func Decide(decisions []Decision, index int) (decision Decision, ok bool) {
+	for _, d := range decisions {
+		if d.Index == index || d.Index == -1 {
+			decision, ok = d, true
+		}
+	}
+	return decision, ok
+}
+
+// Pending returns the calls of the last message of the context, which hold the calls of a
+// paused run. It returns none when the last message is not an assistant message calling tools.
+func Pending(history []ai.Message) []mcp.CallToolParams {
+	if len(history) == 0 || history[len(history)-1].Role != ai.RoleAssistant {
+		return nil
+	}
+
+	var calls []mcp.CallToolParams
+	for _, content := range history[len(history)-1].Content.Slice() {
+		if content.String() == "tool-input" {
+			calls = append(calls, *content.ToolInput())
+		}
+	}
+	return calls
+}
+
+// PendingResult returns the result of a call awaiting a decision. Its structured content holds
+// the index, name and arguments of the call, its meta the pending status.
+func PendingResult(index int, call mcp.CallToolParams) *mcp.CallToolResult {
+	text := fmt.Sprintf("Call %d to %s is waiting for approval", index, call.Name)
+	return &mcp.CallToolResult{
+		Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text})}),
+		StructuredContent: cm.ToList([][2]string{
+			{"index", strconv.Itoa(index)},
+			{"name", call.Name},
+			{"arguments", string(arguments.Encode(call.Arguments.Slice()))},
+		}),
+		Meta: cm.ToList([][2]string{{Status, PendingStatus}}),
+	}
+}
+
+// IsPending reports whether a result is a pending result
+func IsPending(result *mcp.CallToolResult) bool {
+	return result != nil && slices.Contains(result.Meta.Slice(), [2]string{Status, PendingStatus})
+}
+
+// DeniedResult returns the error result of a denied call
+func DeniedResult(call mcp.CallToolParams, reason string) *mcp.CallToolResult {
+	text := fmt.Sprintf("The user denied the call to %s", call.Name)
+	if reason != "" {
+		text += ": " + reason
+	}
+	return &mcp.CallToolResult{
+		Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text})}),
+		IsError: true,
+	}
+}
@@ -0,0 +1,125 @@
+package approval
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"go.bytecodealliance.org/cm"
+)
+
+func TestPolicy_Required(t *testing.T) {
+	offered := []mcp.Tool{{Name: "delete_file"}, {Name: "read_file"}, {Name: "send_email"}}
+	offered[0].Annotations.DestructiveHint = true
+	offered[1].Annotations.DestructiveHint = true
+	offered[1].Annotations.ReadOnlyHint = true
+
+	policy, err := ParsePolicy("destructive", " send_email, ")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+
+	for name, want := range map[string]bool{
+		"delete_file": true,
+		"read_file":   false,
+		"send_email":  true,
+		"get_time":    false,
+	} {
+		if got := policy.Required(mcp.CallToolParams{Name: name}, offered); got != want {
+			t.Errorf("Required(%s) = %v, want %v", name, got, want)
+		}
+	}
+
+	if policy, _ := ParsePolicy("", ""); policy.Required(mcp.CallToolParams{Name: "delete_file"}, offered) {
+		t.Error("Expected no approval without a mode or tools")
+	}
+	if _, err := ParsePolicy("always", ""); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestParseDecision(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Decision
+		wantErr bool
+	}{
+		{line: "/approve", want: Decision{Action: Approve, Index: -1}},
+		{line: "/approve 2", want: Decision{Action: Approve, Index: 2}},
+		{line: "/deny 1 keep the backups", want: Decision{Action: Deny, Index: 1, Reason: "keep the backups"}},
+		{line: "/deny not now", want: Decision{Action: Deny, Index: -1, Reason: "not now"}},
+		{line: `/edit 0 {"path": "/tmp", "force": false}`, want: Decision{Action: Edit, Index: 0, Arguments: [][2]string{{"path", "/tmp"}, {"force", "false"}}}},
+		{line: `/edit {"path": "/tmp"}`, wantErr: true},
+		{line: "/edit 0 path", wantErr: true},
+		{line: "/approve -1", wantErr: true},
+		{line: "/approve all", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := ParseDecision(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error for %q, got %+v", tt.line, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDecision failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	msg := ai.Message{
+		Role: ai.RoleUser,
+		Content: cm.ToList([]ai.MessageContent{
+			ai.NewMessageContent(ai.Text("/approve 0\n/deny 1 wrong folder\nThen summarize what changed.")),
+		}),
+	}
+
+	cleaned, decisions, err := FromRequest(msg)
+	if err != nil {
+		t.Fatalf("FromRequest failed: %v", err)
+	}
+	want := []Decision{{Action: Approve, Index: 0}, {Action: Deny, Index: 1, Reason: "wrong folder"}}
+	if !reflect.DeepEqual(decisions, want) {
+		t.Errorf("Expected %+v, got %+v", want, decisions)
+	}
+	if contents := cleaned.Content.Slice(); len(contents) != 1 || *contents[0].Text() != "Then summarize what changed." {
+		t.Errorf("Expected the decisions to be removed from the message, got %+v", contents)
+	}
+
+	if decision, ok := Decide(decisions, 1); !ok || decision.Action != Deny {
+		t.Errorf("Expected call 1 to be denied, got %+v", decision)
+	}
+	if _, ok := Decide(decisions, 2); ok {
+		t.Error("Expected no decision on call 2")
+	}
+}
+
+func TestPendingResult(t *testing.T) {
+	call := mcp.CallToolParams{Name: "delete_file", Arguments: cm.ToList([][2]string{{"path", "/tmp"}})}
+	result := PendingResult(1, call)
+
+	if !IsPending(result) || IsPending(DeniedResult(call, "")) {
+		t.Error("Expected only the pending result to be pending")
+	}
+	want := [][2]string{{"index", "1"}, {"name", "delete_file"}, {"arguments", `{"path":"/tmp"}`}}
+	if got := result.StructuredContent.Slice(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the call in the structured content, got %v", got)
+	}
+
+	history := []ai.Message{{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(call)}),
+	}}
+	if calls := Pending(history); len(calls) != 1 || calls[0].Name != "delete_file" {
+		t.Errorf("Expected the call of the last message to be pending, got %+v", calls)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/hayride-dev/bindings/go/hayride/ai"
 	"github.com/hayride-dev/bindings/go/hayride/ai/agents"
@@ -11,23 +13,48 @@ import (
 	"github.com/hayride-dev/bindings/go/hayride/ai/models"
 	"github.com/hayride-dev/bindings/go/hayride/ai/runner"
 	"github.com/hayride-dev/bindings/go/hayride/ai/runner/export"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/registry"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/runners/approval"
+	"github.com/hayride-dev/morphs/components/ai/runners/loop"
 	"go.bytecodealliance.org/cm"
 )
 
+// ToolChoiceEnv names the environment variable holding the default tool choice of a run: auto,
+// none, required or the name of a tool. A request overrides it with a /tool_choice line in the
+// user message.
+const ToolChoiceEnv = "TOOL_CHOICE"
+
+// OutputSchemaEnv names the environment variable holding a JSON Schema the answers must follow,
+// either the schema itself or the path of a file holding it
+const OutputSchemaEnv = "OUTPUT_SCHEMA"
+
+// MaxToolFailuresEnv names the environment variable holding the number of consecutive failed tool
+// calls after which a run ends with an error, DefaultMaxToolFailures when unset. Failed calls are
+// returned to the model as error results until then so it can correct them.
+const MaxToolFailuresEnv = "MAX_TOOL_FAILURES"
+
+// DefaultMaxToolFailures is the number of consecutive failed tool calls a run tolerates by default
+const DefaultMaxToolFailures = 3
+
+// ApprovalEnv names the environment variable holding the approval mode: none, the default, or
+// destructive to pause runs on calls to tools annotated as destructive until they are approved
+const ApprovalEnv = "APPROVAL"
+
+// ApprovalToolsEnv names the environment variable holding a comma separated list of tools whose
+// calls always wait for approval
+const ApprovalToolsEnv = "APPROVAL_TOOLS"
+
 var _ runner.Runner = (*defaultRunner)(nil)
 
 type defaultRunner struct {
-	options ai.RunnerOptions
-}
-
-// StreamingState tracks the progress of streaming content across different channels
-type StreamingState struct {
-	AccumulatedContent    string
-	LastFinalContent      string
-	LastAnalysisContent   string
-	LastCommentaryContent string
-	HasToolCall           bool
-	IsComplete            bool
+	loop.Runner
+	// local is the format selected in-process, nil when the model cannot be told from the
+	// environment, localErr tells why
+	local    format.Format
+	localErr error
 }
 
 func init() {
@@ -35,261 +62,125 @@ func init() {
 }
 
 func constructor(options ai.RunnerOptions) (runner.Runner, error) {
-	return &defaultRunner{
-		options: options,
-	}, nil
-}
-
-func (r *defaultRunner) Invoke(message ai.Message, agent agents.Agent, format models.Format, model graph.GraphExecutionContextStream, writer io.Writer) ([]ai.Message, error) {
-	messages := make([]ai.Message, 0)
-
-	// If we have a writer, wrap it in a message writer with writer options
-	var messageWriter *runner.Writer
-	if writer != nil {
-		messageWriter = runner.NewWriter(r.options.Writer, writer)
+	choice, err := toolchoice.Parse(os.Getenv(ToolChoiceEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ToolChoiceEnv, err)
 	}
 
-	if err := agent.Push(message); err != nil {
-		return nil, fmt.Errorf("failed to push message to agent: %w", err)
+	outputSchema, err := readOutputSchema(os.Getenv(OutputSchemaEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", OutputSchemaEnv, err)
 	}
-	toolCall := false
-	for i := 0; i <= int(r.options.MaxTurns); i++ {
-		history, err := agent.Context()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get context: %w", err)
-		}
-		// Format encode the messages
-		data, err := format.Encode(history...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to encode context messages: %w", err)
-		}
-
-		fmt.Println("Encoded Message: ", string(data))
-
-		// Call Graph Compute
-		d := graph.TensorDimensions(cm.ToList([]uint32{1}))
-		td := graph.TensorData(cm.ToList(data))
-		t := graph.NewTensor(d, graph.TensorTypeU8, td)
-		inputs := []graph.NamedTensor{
-			{
-				F0: "user",
-				F1: t,
-			},
-		}
-
-		namedTensorStream, err := model.Compute(inputs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to compute graph: %w", err)
-		}
-
-		// read the output from the stream
-		stream := namedTensorStream.F1
-		ts := graph.TensorStream(stream)
-
-		text := make([]byte, 0)
-		part := make([]byte, 256)
-
-		// Track streaming state for building complete messages
-		streamingState := &StreamingState{
-			AccumulatedContent:    "",
-			LastFinalContent:      "",
-			LastAnalysisContent:   "",
-			LastCommentaryContent: "",
-			HasToolCall:           false,
-			IsComplete:            false,
-		}
-
-		// Stream structured AI messages (Ollama-style) with proper channel handling
-		for {
-			bytesRead, err := ts.Read(part)
-			if bytesRead == 0 || err == io.EOF {
-				break
-			} else if err != nil {
-				return nil, fmt.Errorf("failed to read from tensor stream: %w", err)
-			}
-			text = append(text, part[:bytesRead]...)
-
-			// Try to process streaming content
-			if messageWriter != nil {
-				currentText := string(text)
-				r.processStreamingContent(currentText, streamingState, format, messageWriter)
-			}
-		}
-
-		// After streaming is complete, decode the final complete message
-		fmt.Printf("Complete stream data: %s\n", string(text))
-
-		completeMsg, err := format.Decode(text)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode complete stream: %w", err)
-		}
 
-		// Handle multiple content items as separate logical messages
-		// This allows format decoders to return multiple channel contents
-		contentItems := completeMsg.Content.Slice()
+	maxToolFailures, err := positiveEnv(MaxToolFailuresEnv, DefaultMaxToolFailures)
+	if err != nil {
+		return nil, err
+	}
 
-		if len(contentItems) > 1 {
-			// Multiple content items - treat as separate messages
-			for i, content := range contentItems {
-				if content.String() == "text" && content.Text() != nil {
-					// Create separate message for each content item
-					separateMsg := ai.Message{
-						Role: completeMsg.Role,
-						Content: cm.ToList([]ai.MessageContent{
-							ai.NewMessageContent(ai.Text(*content.Text())),
-						}),
-						Final: i == len(contentItems)-1, // Last item is final
-					}
+	policy, err := approval.ParsePolicy(os.Getenv(ApprovalEnv), os.Getenv(ApprovalToolsEnv))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ApprovalEnv, err)
+	}
 
-					if err := agent.Push(separateMsg); err != nil {
-						return nil, fmt.Errorf("failed to push separate message to agent: %w", err)
-					}
-					messages = append(messages, separateMsg)
+	local, localErr := localFormat()
+	return &defaultRunner{
+		Runner: loop.Runner{
+			Writer:          options.Writer,
+			MaxTurns:        int(options.MaxTurns),
+			ToolChoice:      choice,
+			OutputSchema:    outputSchema,
+			MaxToolFailures: maxToolFailures,
+			Policy:          policy,
+		},
+		local:    local,
+		localErr: localErr,
+	}, nil
+}
 
-					// Stream each separate message
-					if messageWriter != nil {
-						r.streamMessage(messageWriter, separateMsg)
-					}
-				}
-			}
-		} else {
-			// Single content item - handle normally
-			if err := agent.Push(*completeMsg); err != nil {
-				return nil, fmt.Errorf("failed to push complete message to agent: %w", err)
-			}
-			messages = append(messages, *completeMsg)
-		}
+// localFormat returns the format the models component selects for the same environment. The
+// format resource only encodes and decodes, the decoder streaming the output of a prompt comes
+// from this in-process copy. The runner world has no model repository, so MODEL must be a path
+// or a name the registry matches.
+func localFormat() (format.Format, error) {
+	f, err := registry.Default().Detect(nil)()
+	if err != nil {
+		return nil, err
+	}
+	local, ok := f.(format.Format)
+	if !ok {
+		return nil, fmt.Errorf("format %T cannot stream its output", f)
+	}
+	return local, nil
+}
 
-		// Check for tool calls in the complete message
-		if completeMsg.Role == ai.RoleAssistant {
-			for _, c := range completeMsg.Content.Slice() {
-				if c.String() == "tool-input" {
-					toolResult, err := agent.Execute(*c.ToolInput())
-					if err != nil {
-						return nil, fmt.Errorf("failed to call tool: %w", err)
-					}
-					toolCallMessage := ai.Message{
-						Role:    ai.RoleTool,
-						Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(*toolResult)}),
-					}
+// positiveEnv returns the positive number held by an environment variable, fallback when unset
+func positiveEnv(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s: expected a positive number, got %q", name, value)
+	}
+	return n, nil
+}
 
-					if err := agent.Push(toolCallMessage); err != nil {
-						return nil, fmt.Errorf("failed to push tool result to agent: %w", err)
-					}
-					messages = append(messages, toolCallMessage)
-					toolCall = true
+// readOutputSchema returns the schema held by value or by the file it names
+func readOutputSchema(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
 
-					// Stream tool result as structured message
-					if messageWriter != nil {
-						r.streamMessage(messageWriter, toolCallMessage)
-					}
-				}
-			}
+	data := []byte(value)
+	if !strings.HasPrefix(value, "{") {
+		var err error
+		if data, err = os.ReadFile(value); err != nil {
+			return nil, err
 		}
+	}
 
-		if toolCall {
-			toolCall = false
-			continue
-		}
-		break
+	// Fail early rather than on every turn
+	if _, err := grammar.Schema(data); err != nil {
+		return nil, err
 	}
-	return messages, nil
+	return data, nil
 }
 
-// processStreamingContent handles incremental streaming updates based on Harmony format channels
-func (r *defaultRunner) processStreamingContent(currentText string, state *StreamingState, format models.Format, messageWriter *runner.Writer) {
-	// Update accumulated content
-	state.AccumulatedContent = currentText
-
-	// Try to decode the current content to extract structured information
-	partialMsg, err := format.Decode([]byte(currentText))
-	if err != nil {
-		// If decode fails, check if we have new raw content to stream
-		if len(currentText) > len(state.LastFinalContent) {
-			newContent := currentText[len(state.LastFinalContent):]
-			if newContent != "" {
-				deltaMessage := ai.Message{
-					Role: ai.RoleAssistant,
-					Content: cm.ToList([]ai.MessageContent{
-						ai.NewMessageContent(ai.Text(newContent)),
-					}),
-				}
-				r.streamMessage(messageWriter, deltaMessage)
-				state.LastFinalContent = currentText
-			}
-		}
-		return
+func (r *defaultRunner) Invoke(message ai.Message, agent agents.Agent, resource models.Format, model graph.GraphExecutionContextStream, writer io.Writer) ([]ai.Message, error) {
+	// The output is read with the format selected in-process, which must be the format the
+	// models component prompts the model with
+	if r.local == nil {
+		return nil, r.Fail(writer, fmt.Errorf("no format selected for the model: %w, set %s or %s to the model of the models component", r.localErr, registry.ModelEnv, registry.FormatEnv))
 	}
+	if err := format.Verify(r.local, resource); err != nil {
+		return nil, r.Fail(writer, fmt.Errorf("%w, set %s and %s to the same values for both components", err, registry.ModelEnv, registry.FormatEnv))
+	}
+	return r.Runner.Invoke(message, agent, r.local, graphModel{model}, writer)
+}
 
-	// Successfully decoded - process different content types
-	if partialMsg.Role == ai.RoleAssistant {
-		// Check for tool calls first
-		for _, content := range partialMsg.Content.Slice() {
-			if content.String() == "tool-input" {
-				if !state.HasToolCall {
-					// Stream the complete tool call message
-					r.streamMessage(messageWriter, *partialMsg)
-					state.HasToolCall = true
-				}
-				return
-			}
-		}
-
-		// Handle text content streaming
-		var currentContent string
-		for _, content := range partialMsg.Content.Slice() {
-			if content.String() == "text" {
-				currentContent = *content.Text()
-				break
-			}
-		}
+// graphModel computes the output of the model on a graph execution context
+type graphModel struct {
+	ctx graph.GraphExecutionContextStream
+}
 
-		// Check if this is final content or intermediate content
-		if partialMsg.Final {
-			// This is final channel content
-			if len(currentContent) > len(state.LastFinalContent) {
-				newContent := currentContent[len(state.LastFinalContent):]
-				if newContent != "" {
-					deltaMessage := ai.Message{
-						Role: ai.RoleAssistant,
-						Content: cm.ToList([]ai.MessageContent{
-							ai.NewMessageContent(ai.Text(newContent)),
-						}),
-					}
-					r.streamMessage(messageWriter, deltaMessage)
-					state.LastFinalContent = currentContent
-				}
-			}
-		} else {
-			// This might be analysis or commentary content
-			// For now, we stream incremental updates but can be more selective
-			if len(currentContent) > len(state.LastAnalysisContent) {
-				newContent := currentContent[len(state.LastAnalysisContent):]
-				if newContent != "" && !state.HasToolCall {
-					// Only stream analysis/commentary if it's substantial and not a tool call
-					if len(newContent) > 10 { // Arbitrary threshold to avoid noise
-						deltaMessage := ai.Message{
-							Role: ai.RoleAssistant,
-							Content: cm.ToList([]ai.MessageContent{
-								ai.NewMessageContent(ai.Text(newContent)),
-							}),
-						}
-						r.streamMessage(messageWriter, deltaMessage)
-					}
-					state.LastAnalysisContent = currentContent
-				}
-			}
-		}
+func (m graphModel) Compute(inputs []loop.Input) (io.Reader, error) {
+	tensors := make([]graph.NamedTensor, 0, len(inputs))
+	for _, input := range inputs {
+		d := graph.TensorDimensions(cm.ToList(input.Dimensions))
+		td := graph.TensorData(cm.ToList(input.Data))
+		tensors = append(tensors, graph.NamedTensor{
+			F0: input.Name,
+			F1: graph.NewTensor(d, graph.TensorTypeU8, td),
+		})
 	}
-}
 
-// streamMessage sends a structured AI message through the writer (Ollama-style)
-func (r *defaultRunner) streamMessage(messageWriter *runner.Writer, message ai.Message) {
-	// Always send structured JSON messages, never raw text
-	data, err := json.Marshal(message)
-	if err == nil {
-		messageWriter.Write(data)
+	namedTensorStream, err := m.ctx.Compute(tensors)
+	if err != nil {
+		return nil, err
 	}
+	return graph.TensorStream(namedTensorStream.F1), nil
 }
 
 func main() {}
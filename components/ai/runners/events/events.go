@@ -0,0 +1,140 @@
+// Package events defines the events a runner streams to its clients while it works on a request.
+//
+// A run starts with run-start and ends with run-end, which carries the reason it ended. Every
+// turn in between starts with turn-start and ends with turn-end, which carries the message the
+// model wrote. While the model writes it, its answer and its reasoning are streamed as text-delta
+// and reasoning-delta events. Every tool call is sent as a tool-call event once it is complete,
+// followed by a tool-result event once it ran. When calls need approval, every call waiting for
+// a decision is sent as an approval-request event and the run ends with pending-approval. The run
+// resuming it starts with the tool-call and tool-result events of those calls, which carry the
+// turn that made the calls, and its turns are numbered on from there. An error event precedes the
+// run-end of a failed run.
+//
+// Events are written as JSON. The SSE writer sends every event in its own frame, with the event
+// type as the SSE event name and the event id as the SSE id. The raw writer sends one event per
+// line (NDJSON).
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+)
+
+// Type names an event
+type Type string
+
+const (
+	RunStart        Type = "run-start"
+	TurnStart       Type = "turn-start"
+	TextDelta       Type = "text-delta"
+	ReasoningDelta  Type = "reasoning-delta"
+	ToolCall        Type = "tool-call"
+	ApprovalRequest Type = "approval-request"
+	ToolResult      Type = "tool-result"
+	TurnEnd         Type = "turn-end"
+	RunEnd          Type = "run-end"
+	Error           Type = "error"
+)
+
+// Reason tells why a run ended
+type Reason string
+
+const (
+	// Completed runs ended with an answer
+	Completed Reason = "completed"
+	// MaxTurns runs used all their turns while the model was still calling tools
+	MaxTurns Reason = "max-turns"
+	// PendingApproval runs paused on calls that wait for a decision
+	PendingApproval Reason = "pending-approval"
+	// Failed runs ended with an error, sent in the error event before run-end
+	Failed Reason = "error"
+)
+
+// Event is a single event of a run. Only the fields of its type are set.
+type Event struct {
+	// ID numbers the events of a run from 1
+	ID   int  `json:"id"`
+	Type Type `json:"type"`
+	// Turn numbers the turns of a run from 1, set on every event of a turn
+	Turn int `json:"turn,omitempty"`
+	// Text is the new text of a delta
+	Text string `json:"text,omitempty"`
+	// Call is the call of tool-call, approval-request and tool-result events
+	Call *Call `json:"call,omitempty"`
+	// Result is the result of a tool-result event
+	Result *mcp.CallToolResult `json:"result,omitempty"`
+	// Message is the message written by the model, set on turn-end
+	Message *ai.Message `json:"message,omitempty"`
+	// Reason is set on run-end
+	Reason Reason `json:"reason,omitempty"`
+	// Error is set on error events
+	Error string `json:"error,omitempty"`
+}
+
+// Call is a tool call of the model
+type Call struct {
+	// Index is the position of the call among the calls of its turn, starting at 0
+	Index     int             `json:"index"`
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// Writer writes the events of a run, numbering them as they are written. A nil writer drops
+// events, so runs without a client need no checks. Once a write fails the writer keeps its error
+// and drops the events that follow, so a run checks Err once instead of every write.
+type Writer struct {
+	writerType ai.WriterType
+	w          io.Writer
+	id         int
+	err        error
+}
+
+// NewWriter returns a writer sending events to w in the framing of writerType
+func NewWriter(writerType ai.WriterType, w io.Writer) *Writer {
+	return &Writer{writerType: writerType, w: w}
+}
+
+// Write numbers an event and writes it, it returns the error of the first failed write
+func (w *Writer) Write(event Event) error {
+	if w == nil {
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	w.id++
+	event.ID = w.id
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", event.Type, err)
+	}
+
+	switch w.writerType {
+	case ai.WriterTypeSse:
+		// JSON holds no raw newlines, the event fits a single data line
+		_, err = fmt.Fprintf(w.w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	case ai.WriterTypeRaw:
+		_, err = fmt.Fprintf(w.w, "%s\n", data)
+	default:
+		return fmt.Errorf("unknown writer type: %s", w.writerType)
+	}
+	if err != nil {
+		w.err = fmt.Errorf("failed to write %s event: %w", event.Type, err)
+		return w.err
+	}
+	return nil
+}
+
+// Err returns the error of the first failed write, nil while every event was written
+func (w *Writer) Err() error {
+	if w == nil {
+		return nil
+	}
+	return w.err
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+)
+
+func TestWriter_SSE(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(ai.WriterTypeSse, buf)
+
+	if err := w.Write(Event{Type: RunStart}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Write(Event{Type: TextDelta, Turn: 1, Text: "Hello\nthere"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	expected := "id: 1\nevent: run-start\ndata: {\"id\":1,\"type\":\"run-start\"}\n\n" +
+		"id: 2\nevent: text-delta\ndata: {\"id\":2,\"type\":\"text-delta\",\"turn\":1,\"text\":\"Hello\\nthere\"}\n\n"
+	if buf.String() != expected {
+		t.Errorf("Expected\n%q\ngot\n%q", expected, buf.String())
+	}
+}
+
+func TestWriter_Raw(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(ai.WriterTypeRaw, buf)
+
+	call := &Call{Index: 0, Name: "get_weather", Arguments: json.RawMessage(`{"city":"Paris"}`)}
+	for _, event := range []Event{
+		{Type: ToolCall, Turn: 1, Call: call},
+		{Type: RunEnd, Reason: Completed},
+	} {
+		if err := w.Write(event); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(buf.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected one line per event, got %q", buf.String())
+	}
+
+	var event Event
+	if err := json.Unmarshal(lines[0], &event); err != nil {
+		t.Fatalf("Invalid event %s: %v", lines[0], err)
+	}
+	if event.ID != 1 || event.Type != ToolCall || event.Call == nil || event.Call.Name != "get_weather" || string(event.Call.Arguments) != `{"city":"Paris"}` {
+		t.Errorf("Unexpected tool call event %s", lines[0])
+	}
+	if string(lines[1]) != `{"id":2,"type":"run-end","reason":"completed"}` {
+		t.Errorf("Unexpected run end event %s", lines[1])
+	}
+}
+
+func TestWriter_Nil(t *testing.T) {
+	var w *Writer
+	if err := w.Write(Event{Type: RunStart}); err != nil {
+		t.Errorf("Expected a nil writer to drop events, got %v", err)
+	}
+}
+
+// failingWriter fails every write after the first n
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestWriter_Failure(t *testing.T) {
+	dst := &failingWriter{n: 1}
+	w := NewWriter(ai.WriterTypeRaw, dst)
+
+	if err := w.Write(Event{Type: RunStart}); err != nil || w.Err() != nil {
+		t.Fatalf("Expected the first event to be written, got %v", err)
+	}
+	err := w.Write(Event{Type: TurnStart, Turn: 1})
+	if err == nil || err.Error() != "failed to write turn-start event: broken pipe" {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+
+	// The events that follow are dropped with the first error
+	dst.n = 1
+	if err := w.Write(Event{Type: RunEnd}); err != w.Err() || dst.n != 1 {
+		t.Errorf("Expected later events to be dropped with the first error, got %v", err)
+	}
+}
@@ -0,0 +1,530 @@
+// Package loop runs the turns of an agent: it encodes the context, streams the output of the
+// model as events, runs the tools the model calls and returns their results to it until the
+// model answers. The runner component adapts its imports to the interfaces used here.
+package loop
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/ai/agents"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/arguments"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/stop"
+	"github.com/hayride-dev/morphs/components/ai/models/stream"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
+	"github.com/hayride-dev/morphs/components/ai/models/vision"
+	"github.com/hayride-dev/morphs/components/ai/runners/approval"
+	"github.com/hayride-dev/morphs/components/ai/runners/events"
+	"go.bytecodealliance.org/cm"
+)
+
+// PromptTensorName names the input holding the encoded prompt
+const PromptTensorName = "user"
+
+// Input is a named U8 tensor passed to the model
+type Input struct {
+	Name       string
+	Dimensions []uint32
+	Data       []byte
+}
+
+// Model computes the output of a model, which is read as it is generated
+type Model interface {
+	Compute(inputs []Input) (io.Reader, error)
+}
+
+// Runner holds the settings of the runs it invokes
+type Runner struct {
+	// Writer is the framing of the events written to the writer of a run
+	Writer ai.WriterType
+	// MaxTurns is the number of turns after the first one that a run may take
+	MaxTurns int
+	// ToolChoice is the tool choice of a run that does not request one
+	ToolChoice toolchoice.Choice
+	// OutputSchema is a JSON Schema the answers must follow, none when empty
+	OutputSchema []byte
+	// MaxToolFailures is the number of consecutive failed tool calls that ends a run
+	MaxToolFailures int
+	// Policy selects the calls that wait for approval
+	Policy approval.Policy
+}
+
+// Invoke runs the agent on a request and returns the messages of the run. Events are written to
+// writer as the run goes, they are dropped without one. A failed write does not stop the run, the
+// agent context still records all of it, but the run returns the write error once it ends.
+func (r *Runner) Invoke(message ai.Message, agent agents.Agent, f format.Format, model Model, writer io.Writer) ([]ai.Message, error) {
+	var out *events.Writer
+	if writer != nil {
+		out = events.NewWriter(r.Writer, writer)
+	}
+
+	out.Write(events.Event{Type: events.RunStart})
+	messages, reason, err := r.run(message, agent, f, model, out)
+	if err != nil {
+		fail(out, err)
+		return nil, err
+	}
+	out.Write(events.Event{Type: events.RunEnd, Reason: reason})
+	if err := out.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Fail reports a run that cannot start to writer as Invoke reports a failed run, so clients
+// always get an error event followed by run-end. It returns err.
+func (r *Runner) Fail(writer io.Writer, err error) error {
+	if writer != nil {
+		out := events.NewWriter(r.Writer, writer)
+		out.Write(events.Event{Type: events.RunStart})
+		fail(out, err)
+	}
+	return err
+}
+
+// fail writes the error event and the run-end event of a failed run
+func fail(out *events.Writer, err error) {
+	out.Write(events.Event{Type: events.Error, Error: err.Error()})
+	out.Write(events.Event{Type: events.RunEnd, Reason: events.Failed})
+}
+
+// run works on a request until the model answers or the turns run out, and returns the messages
+// of the run with the reason it ended
+func (r *Runner) run(message ai.Message, agent agents.Agent, f format.Format, model Model, out *events.Writer) ([]ai.Message, events.Reason, error) {
+	messages := make([]ai.Message, 0)
+
+	// A /tool_choice line in the request overrides the default and is kept out of the history
+	message, choice, ok, err := toolchoice.FromRequest(message)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read tool choice: %w", err)
+	}
+	if !ok {
+		choice = r.ToolChoice
+	}
+
+	// Decision lines resume a run paused on the calls that end the context
+	message, decisions, err := approval.FromRequest(message)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read approval decisions: %w", err)
+	}
+
+	history, err := agent.Context()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get context: %w", err)
+	}
+	pending := approval.Pending(history)
+
+	// A paused run only goes on once its calls are decided, a request without decisions would
+	// leave them without results
+	if len(pending) > 0 && len(decisions) == 0 {
+		return nil, "", fmt.Errorf("%d tool calls are waiting for approval, resume the run with %s, %s or %s", len(pending), approval.Approve, approval.Deny, approval.Edit)
+	}
+
+	// A resumed run goes on with the turns left to it
+	toolFailures, taken := 0, 0
+	if len(decisions) > 0 {
+		offered := tools(history)
+		taken = turnsTaken(history)
+
+		calls, results, err := r.decide(pending, decisions, offered)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// The context only grows, edited calls are restated with their new arguments so the
+		// results that follow answer the calls that ran
+		if slices.ContainsFunc(decisions, func(d approval.Decision) bool { return d.Action == approval.Edit }) {
+			contents := make([]ai.MessageContent, len(calls))
+			for i, call := range calls {
+				contents[i] = ai.NewMessageContent(call)
+			}
+			restated := ai.Message{Role: ai.RoleAssistant, Content: cm.ToList(contents), Final: true}
+			if err := agent.Push(restated); err != nil {
+				return nil, "", fmt.Errorf("failed to push edited calls to agent: %w", err)
+			}
+			messages = append(messages, restated)
+		}
+
+		for index, call := range calls {
+			out.Write(events.Event{Type: events.ToolCall, Turn: taken, Call: eventCall(index, call, offered)})
+		}
+		resumed, err := r.runCalls(agent, out, taken, calls, results, offered, &toolFailures)
+		if err != nil {
+			return nil, "", err
+		}
+		messages = append(messages, resumed...)
+	}
+
+	// A request holding only decisions adds nothing to the history
+	if len(decisions) == 0 || message.Content.Len() > 0 {
+		if err := agent.Push(message); err != nil {
+			return nil, "", fmt.Errorf("failed to push message to agent: %w", err)
+		}
+	}
+
+	for i := taken; i <= r.MaxTurns; i++ {
+		turn := i + 1
+		out.Write(events.Event{Type: events.TurnStart, Turn: turn})
+
+		history, err := agent.Context()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get context: %w", err)
+		}
+
+		// A required or named call only applies to the first turn so the model can answer with
+		// the results, none holds for the whole run. A resumed run is past its first turn.
+		turnChoice := choice
+		if i > 0 && choice.Forced() {
+			turnChoice = toolchoice.Choice{Mode: toolchoice.Auto}
+		}
+
+		// Format encode the messages, the prompt holds what its output is decoded with
+		prompt, err := f.EncodePrompt(format.Options{ToolChoice: turnChoice}, history...)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode context messages: %w", err)
+		}
+		data := prompt.Data
+
+		inputs := []Input{{Name: PromptTensorName, Dimensions: []uint32{1}, Data: data}}
+
+		// Images are passed in the order vision formats write their placeholders, other formats
+		// refer to none
+		for n, image := range prompt.Images {
+			inputs = append(inputs, bytesInput(vision.TensorName(n), image))
+		}
+
+		// A grammar constrains the tool calls and the answer when the backend supports it
+		constraint, err := r.grammar(f, history, turnChoice)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to build grammar: %w", err)
+		}
+		if constraint != nil {
+			inputs = append(inputs, textInput(grammar.TensorName, constraint.GBNF))
+			if constraint.Trigger != "" {
+				inputs = append(inputs, textInput(grammar.TriggerTensorName, constraint.Trigger))
+			}
+		}
+
+		// Output ends at the first stop sequence of the format, backends that support it stop
+		// generating there too
+		stops := f.StopSequences()
+		if len(stops) > 0 {
+			inputs = append(inputs, textInput(stop.TensorName, string(stop.Tensor(stops))))
+		}
+
+		output, err := model.Compute(inputs)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to compute graph: %w", err)
+		}
+
+		text := make([]byte, 0)
+		part := make([]byte, 256)
+
+		// Deltas are decoded from each chunk as it arrives, the complete message is decoded once
+		// the output has ended
+		decoder := f.NewDecoder(prompt)
+
+		for {
+			bytesRead, err := output.Read(part)
+			if bytesRead == 0 || err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, "", fmt.Errorf("failed to read from tensor stream: %w", err)
+			}
+			from := len(text)
+			text = append(text, part[:bytesRead]...)
+
+			// Anything generated after a stop sequence is dropped, the sequence itself is kept
+			// since formats rely on it to tell a complete turn from a partial one
+			end := stop.Find(text, from, stops)
+			if end != -1 {
+				text = text[:end]
+			}
+
+			streamDeltas(out, turn, decoder.Feed(text[from:]))
+
+			if end != -1 {
+				break
+			}
+		}
+		streamDeltas(out, turn, decoder.Close())
+
+		// After streaming is complete, decode the final complete message
+		completeMsg, err := f.DecodePrompt(prompt, text)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode complete stream: %w", err)
+		}
+
+		// Every item is kept in the order the model wrote it, reasoning is marked by the format
+		// so the next encoding can tell it from the answer
+		if err := agent.Push(*completeMsg); err != nil {
+			return nil, "", fmt.Errorf("failed to push complete message to agent: %w", err)
+		}
+		messages = append(messages, *completeMsg)
+
+		// Check for tool calls in the complete message. Failed calls are returned to the model as
+		// error results, the run only ends once too many fail in a row.
+		var calls []mcp.CallToolParams
+		if completeMsg.Role == ai.RoleAssistant {
+			for _, c := range completeMsg.Content.Slice() {
+				if c.String() == "tool-input" {
+					calls = append(calls, *c.ToolInput())
+				}
+			}
+		}
+
+		offered := tools(history)
+		for index, call := range calls {
+			out.Write(events.Event{Type: events.ToolCall, Turn: turn, Call: eventCall(index, call, offered)})
+		}
+
+		// None of the calls run while some wait for approval, the run pauses with a pending
+		// result for each of them. The calls end the context until a request resumes the run.
+		paused := false
+		for index, call := range calls {
+			if !r.Policy.Required(call, offered) {
+				continue
+			}
+			out.Write(events.Event{Type: events.ApprovalRequest, Turn: turn, Call: eventCall(index, call, offered)})
+			messages = append(messages, ai.Message{
+				Role:    ai.RoleTool,
+				Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(*approval.PendingResult(index, call))}),
+			})
+			paused = true
+		}
+		if paused {
+			out.Write(events.Event{Type: events.TurnEnd, Turn: turn, Message: completeMsg})
+			return messages, events.PendingApproval, nil
+		}
+
+		results, err := r.runCalls(agent, out, turn, calls, make([]*mcp.CallToolResult, len(calls)), offered, &toolFailures)
+		if err != nil {
+			return nil, "", err
+		}
+		messages = append(messages, results...)
+
+		out.Write(events.Event{Type: events.TurnEnd, Turn: turn, Message: completeMsg})
+
+		if len(calls) == 0 {
+			return messages, events.Completed, nil
+		}
+	}
+	// The last turn still called tools
+	return messages, events.MaxTurns, nil
+}
+
+// grammar returns the grammar for the output of a turn, nil when nothing is constrained. Tool calls
+// are written in the syntax the format declares. Turns that require a call are left unconstrained,
+// the format prefills the start of the call in the prompt.
+func (r *Runner) grammar(f format.Format, history []ai.Message, choice toolchoice.Choice) (*grammar.Grammar, error) {
+	if choice.Forced() {
+		return nil, nil
+	}
+
+	config := grammar.Config{Output: r.OutputSchema}
+	if choice.Mode != toolchoice.None {
+		if syntax, ok := f.ToolSyntax(); ok {
+			config.Tools = tools(history)
+			config.Syntax = syntax
+		}
+	}
+
+	if len(config.Tools) == 0 && len(config.Output) == 0 {
+		return nil, nil
+	}
+	return grammar.New(config)
+}
+
+// decide applies the decisions of a request to the calls of a paused run. It returns the calls
+// with their edited arguments and a result for each denied call, the results of the calls left
+// to run are nil. Calls that need approval and have no decision are an error.
+func (r *Runner) decide(calls []mcp.CallToolParams, decisions []approval.Decision, offered []mcp.Tool) ([]mcp.CallToolParams, []*mcp.CallToolResult, error) {
+	if len(calls) == 0 {
+		return nil, nil, fmt.Errorf("no tool calls are waiting for approval")
+	}
+	for _, decision := range decisions {
+		if decision.Index >= len(calls) {
+			return nil, nil, fmt.Errorf("decision on call %d, only %d calls are waiting", decision.Index, len(calls))
+		}
+	}
+
+	results := make([]*mcp.CallToolResult, len(calls))
+	for index, call := range calls {
+		decision, ok := approval.Decide(decisions, index)
+		if !ok {
+			if r.Policy.Required(call, offered) {
+				return nil, nil, fmt.Errorf("call %d to %s is waiting for a decision", index, call.Name)
+			}
+			continue
+		}
+
+		switch decision.Action {
+		case approval.Deny:
+			results[index] = approval.DeniedResult(call, decision.Reason)
+		case approval.Edit:
+			calls[index].Arguments = cm.ToList(decision.Arguments)
+		}
+	}
+	return calls, results, nil
+}
+
+// runCalls runs the calls of a turn that have no result yet, pushes the results to the agent in
+// the order of the calls and returns them as messages. failures counts the consecutive failed
+// calls of the run, calls denied by the user are not counted.
+func (r *Runner) runCalls(agent agents.Agent, out *events.Writer, turn int, calls []mcp.CallToolParams, results []*mcp.CallToolResult, offered []mcp.Tool, failures *int) ([]ai.Message, error) {
+	denied := make([]bool, len(results))
+	for index, result := range results {
+		denied[index] = result != nil
+	}
+
+	// Results are pushed in the order of the calls
+	r.executeCalls(agent, calls, offered, results)
+
+	messages := make([]ai.Message, 0, len(results))
+	for index, toolResult := range results {
+		toolCallMessage := ai.Message{
+			Role:    ai.RoleTool,
+			Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(*toolResult)}),
+		}
+
+		if err := agent.Push(toolCallMessage); err != nil {
+			return nil, fmt.Errorf("failed to push tool result to agent: %w", err)
+		}
+		messages = append(messages, toolCallMessage)
+		out.Write(events.Event{Type: events.ToolResult, Turn: turn, Call: eventCall(index, calls[index], offered), Result: toolResult})
+
+		if denied[index] {
+			continue
+		}
+		if !toolResult.IsError {
+			*failures = 0
+			continue
+		}
+		*failures++
+		if *failures >= r.MaxToolFailures {
+			return nil, fmt.Errorf("giving up after %d consecutive tool failures, last: %s", *failures, resultText(toolResult))
+		}
+	}
+	return messages, nil
+}
+
+// executeCalls runs the calls of a turn that have no result yet and stores their results in the
+// order of the calls. Calls run one after another in the order the model made them, whatever
+// their annotations say. Agents are resources imported by the component and executing a call is
+// a blocking import: under wasip2 it holds the only thread until it returns, so calls run on
+// goroutines would not overlap and would only let calls to the same agent interleave.
+func (r *Runner) executeCalls(agent agents.Agent, calls []mcp.CallToolParams, offered []mcp.Tool, results []*mcp.CallToolResult) {
+	for i, call := range calls {
+		if results[i] != nil {
+			continue
+		}
+		results[i] = execute(agent, call, offered)
+	}
+}
+
+// execute calls a tool and returns its result. Calls to tools that are not offered, calls whose
+// arguments do not follow the input schema of the tool and calls that fail are returned as error
+// results. Tools are only checked when the history offers some, agents may call tools that are
+// not listed.
+func execute(agent agents.Agent, call mcp.CallToolParams, offered []mcp.Tool) *mcp.CallToolResult {
+	if len(offered) > 0 {
+		index := slices.IndexFunc(offered, func(tool mcp.Tool) bool { return tool.Name == call.Name })
+		if index == -1 {
+			names := make([]string, len(offered))
+			for i, tool := range offered {
+				names[i] = tool.Name
+			}
+			return toolError(fmt.Sprintf("Unknown tool %s, the available tools are: %s", call.Name, strings.Join(names, ", ")))
+		}
+		if err := arguments.Validate(offered[index], call.Arguments.Slice()); err != nil {
+			return toolError(fmt.Sprintf("Invalid arguments for %s: %v", call.Name, err))
+		}
+	}
+
+	result, err := agent.Execute(call)
+	if err != nil {
+		return toolError(fmt.Sprintf("Tool %s failed: %v", call.Name, err))
+	}
+	return result
+}
+
+// toolError returns an error result holding text
+func toolError(text string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text})}),
+		IsError: true,
+	}
+}
+
+// resultText returns the text content of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content.Slice() {
+		if text := content.Text(); text != nil {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// turnsTaken returns the turns a paused run has taken, one for every assistant message since the
+// request that started it. Calls restated with edited arguments belong to the turn they edit.
+func turnsTaken(history []ai.Message) int {
+	turns := 0
+	for i := len(history) - 1; i >= 0 && history[i].Role != ai.RoleUser; i-- {
+		if history[i].Role == ai.RoleAssistant && (i == 0 || history[i-1].Role != ai.RoleAssistant) {
+			turns++
+		}
+	}
+	return turns
+}
+
+// tools returns the tools offered in the system messages
+func tools(history []ai.Message) []mcp.Tool {
+	var tools []mcp.Tool
+	for _, msg := range history {
+		if msg.Role != ai.RoleSystem {
+			continue
+		}
+		for _, content := range msg.Content.Slice() {
+			if content.String() == "tools" {
+				tools = append(tools, content.Tools().Slice()...)
+			}
+		}
+	}
+	return tools
+}
+
+// textInput returns an input holding text
+func textInput(name string, text string) Input {
+	return bytesInput(name, []byte(text))
+}
+
+// bytesInput returns an input holding data
+func bytesInput(name string, data []byte) Input {
+	return Input{Name: name, Dimensions: []uint32{uint32(len(data))}, Data: data}
+}
+
+// streamDeltas writes the answer and reasoning of a turn as they are decoded. Tool calls are
+// written once the complete message is decoded, with the arguments the tools are called with.
+func streamDeltas(out *events.Writer, turn int, deltas []stream.Delta) {
+	for _, delta := range deltas {
+		switch delta.Kind {
+		case stream.Text:
+			out.Write(events.Event{Type: events.TextDelta, Turn: turn, Text: delta.Text})
+		case stream.Reasoning:
+			out.Write(events.Event{Type: events.ReasoningDelta, Turn: turn, Text: delta.Text})
+		}
+	}
+}
+
+// eventCall returns the call of a tool event, its arguments typed by the offered tools
+func eventCall(index int, call mcp.CallToolParams, offered []mcp.Tool) *events.Call {
+	return &events.Call{Index: index, Name: call.Name, Arguments: arguments.EncodeCall(offered, call)}
+}
@@ -0,0 +1,630 @@
+package loop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hayride-dev/bindings/go/hayride/ai"
+	"github.com/hayride-dev/bindings/go/hayride/mcp"
+	"github.com/hayride-dev/morphs/components/ai/models/format"
+	"github.com/hayride-dev/morphs/components/ai/models/grammar"
+	"github.com/hayride-dev/morphs/components/ai/models/qwen"
+	"github.com/hayride-dev/morphs/components/ai/models/stop"
+	"github.com/hayride-dev/morphs/components/ai/runners/approval"
+	"github.com/hayride-dev/morphs/components/ai/runners/events"
+	"go.bytecodealliance.org/cm"
+)
+
+// fakeAgent keeps its context in memory and runs its tools as functions
+type fakeAgent struct {
+	history []ai.Message
+	tools   map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error)
+	calls   []mcp.CallToolParams
+}
+
+func (a *fakeAgent) Name() string                      { return "fake" }
+func (a *fakeAgent) Instruction() string               { return "" }
+func (a *fakeAgent) Capabilities() ([]mcp.Tool, error) { return nil, nil }
+
+func (a *fakeAgent) Context() ([]ai.Message, error) {
+	return append([]ai.Message(nil), a.history...), nil
+}
+
+func (a *fakeAgent) Push(message ai.Message) error {
+	a.history = append(a.history, message)
+	return nil
+}
+
+func (a *fakeAgent) Execute(call mcp.CallToolParams) (*mcp.CallToolResult, error) {
+	a.calls = append(a.calls, call)
+	tool, ok := a.tools[call.Name]
+	if !ok {
+		return nil, fmt.Errorf("no tool %s", call.Name)
+	}
+	return tool(call)
+}
+
+// fakeModel returns one output per turn, in chunks of a few bytes
+type fakeModel struct {
+	outputs []string
+	inputs  [][]Input
+}
+
+func (m *fakeModel) Compute(inputs []Input) (io.Reader, error) {
+	if len(m.inputs) == len(m.outputs) {
+		return nil, fmt.Errorf("no output left for turn %d", len(m.inputs)+1)
+	}
+	m.inputs = append(m.inputs, inputs)
+	return &chunkReader{data: []byte(m.outputs[len(m.inputs)-1])}, nil
+}
+
+type chunkReader struct {
+	data []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), 3)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// plainFormat writes the texts of the history one per line and reads the output as an answer,
+// like a format resource that only encodes and decodes
+type plainFormat struct{}
+
+func (plainFormat) Encode(messages ...ai.Message) ([]byte, error) {
+	var b strings.Builder
+	for _, msg := range messages {
+		for _, content := range msg.Content.Slice() {
+			if text := content.Text(); text != nil {
+				b.WriteString(*text + "\n")
+			}
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+func (plainFormat) Decode(data []byte) (*ai.Message, error) {
+	return &ai.Message{
+		Role:    ai.RoleAssistant,
+		Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text(string(data)))}),
+	}, nil
+}
+
+func textMessage(role ai.Role, text string) ai.Message {
+	return ai.Message{Role: role, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(ai.Text(text))})}
+}
+
+func toolsMessage(tools ...mcp.Tool) ai.Message {
+	return ai.Message{Role: ai.RoleSystem, Content: cm.ToList([]ai.MessageContent{ai.NewMessageContent(cm.ToList(tools))})}
+}
+
+func textResult(text string) *mcp.CallToolResult {
+	return &mcp.CallToolResult{Content: cm.ToList([]mcp.Content{mcp.NewContent(mcp.TextContent{ContentType: "text", Text: text})})}
+}
+
+// readEvents returns the events written in the raw framing
+func readEvents(t *testing.T, data []byte) []events.Event {
+	t.Helper()
+	var written []events.Event
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var event events.Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("Invalid event %s: %v", line, err)
+		}
+		written = append(written, event)
+	}
+	return written
+}
+
+// deltas returns the text of the deltas of a type
+func deltas(written []events.Event, kind events.Type) string {
+	var b strings.Builder
+	for _, event := range written {
+		if event.Type == kind {
+			b.WriteString(event.Text)
+		}
+	}
+	return b.String()
+}
+
+func newRunner() *Runner {
+	return &Runner{Writer: ai.WriterTypeRaw, MaxTurns: 3, MaxToolFailures: 3}
+}
+
+func TestInvoke_Resource(t *testing.T) {
+	agent := &fakeAgent{}
+	model := &fakeModel{outputs: []string{"Hello there, how can I help?"}}
+	out := &bytes.Buffer{}
+
+	request := textMessage(ai.RoleUser, "Hi")
+	request.Content = cm.ToList(append(request.Content.Slice(), ai.NewMessageContent(cm.ToList([]uint8{0x89, 'P', 'N', 'G'}))))
+
+	messages, err := newRunner().Invoke(request, agent, format.Resource(plainFormat{}), model, out)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+
+	if len(messages) != 1 || *messages[0].Content.Slice()[0].Text() != "Hello there, how can I help?" {
+		t.Errorf("Expected the decoded answer, got %+v", messages)
+	}
+	if prompt := string(model.inputs[0][0].Data); prompt != "Hi\n" {
+		t.Errorf("Expected the prompt encoded by the resource, got %q", prompt)
+	}
+	// Images are only passed to formats that write placeholders for them
+	if len(model.inputs[0]) != 1 {
+		t.Errorf("Expected only the prompt tensor, got %+v", model.inputs[0])
+	}
+
+	// A format resource cannot tell its syntax, the output is streamed as text
+	written := readEvents(t, out.Bytes())
+	if text := deltas(written, events.TextDelta); text != "Hello there, how can I help?" {
+		t.Errorf("Expected the output streamed as text, got %q", text)
+	}
+	if last := written[len(written)-1]; last.Type != events.RunEnd || last.Reason != events.Completed {
+		t.Errorf("Expected the run to complete, got %+v", last)
+	}
+}
+
+func TestInvoke_LocalFormat(t *testing.T) {
+	f, err := qwen.NewQwen3(qwen.Qwen3Config{})
+	if err != nil {
+		t.Fatalf("NewQwen3 failed: %v", err)
+	}
+	local, ok := f.(format.Format)
+	if !ok {
+		t.Fatal("Qwen 3 does not implement format.Format")
+	}
+
+	agent := &fakeAgent{
+		history: []ai.Message{toolsMessage(mcp.Tool{Name: "get_weather", InputSchema: mcp.ToolSchema{SchemaType: "object"}})},
+		tools: map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error){
+			"get_weather": func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return textResult("sunny"), nil },
+		},
+	}
+	model := &fakeModel{outputs: []string{
+		"<think>\nCheck the weather.\n</think>\n\n<tool_call>\n{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}\n</tool_call><|im_end|>",
+		"It is sunny in Paris.<|im_end|>\n<|im_start|>user\nThanks",
+	}}
+	out := &bytes.Buffer{}
+
+	messages, err := newRunner().Invoke(textMessage(ai.RoleUser, "Weather in Paris?"), agent, local, model, out)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+
+	// The call, its result and the answer
+	if len(messages) != 3 || messages[1].Role != ai.RoleTool {
+		t.Fatalf("Expected the call, its result and the answer, got %+v", messages)
+	}
+	if len(agent.calls) != 1 || agent.calls[0].Arguments.Slice()[0] != [2]string{"city", "Paris"} {
+		t.Errorf("Expected get_weather to be called once for Paris, got %+v", agent.calls)
+	}
+
+	// The stop sequences come from the format, not from the prompt
+	stops := slices.IndexFunc(model.inputs[1], func(input Input) bool { return input.Name == stop.TensorName })
+	if stops == -1 || string(model.inputs[1][stops].Data) != `["<|im_end|>","<|endoftext|>"]` {
+		t.Errorf("Expected the stop sequences of Qwen 3, got %+v", model.inputs[1])
+	}
+
+	// The decoder of the format tells the reasoning and the call from the answer
+	written := readEvents(t, out.Bytes())
+	if reasoning := deltas(written, events.ReasoningDelta); strings.TrimSpace(reasoning) != "Check the weather." {
+		t.Errorf("Expected the reasoning to be streamed, got %q", reasoning)
+	}
+	if text := deltas(written, events.TextDelta); strings.TrimSpace(text) != "It is sunny in Paris." {
+		t.Errorf("Expected only the answer streamed as text, got %q", text)
+	}
+}
+
+func TestInvoke_Grammar(t *testing.T) {
+	f, err := qwen.NewQwen3(qwen.Qwen3Config{})
+	if err != nil {
+		t.Fatalf("NewQwen3 failed: %v", err)
+	}
+
+	agent := &fakeAgent{history: []ai.Message{toolsMessage(mcp.Tool{Name: "get_weather", InputSchema: mcp.ToolSchema{SchemaType: "object"}})}}
+	model := &fakeModel{outputs: []string{"Llama writes <function=name> calls.<|im_end|>"}}
+
+	// Text quoting the syntax of other formats does not change the syntax of the grammar
+	request := textMessage(ai.RoleUser, "How do <function=get_weather> and ```tool_code calls differ?")
+	if _, err := newRunner().Invoke(request, agent, f.(format.Format), model, nil); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	trigger := slices.IndexFunc(model.inputs[0], func(input Input) bool { return input.Name == grammar.TriggerTensorName })
+	if trigger == -1 || string(model.inputs[0][trigger].Data) != grammar.Hermes.Trigger {
+		t.Errorf("Expected the Hermes syntax of Qwen 3, got %+v", model.inputs[0])
+	}
+}
+
+func TestInvoke_ToolChoice(t *testing.T) {
+	f, err := qwen.NewQwen3(qwen.Qwen3Config{})
+	if err != nil {
+		t.Fatalf("NewQwen3 failed: %v", err)
+	}
+
+	agent := &fakeAgent{
+		history: []ai.Message{toolsMessage(mcp.Tool{Name: "get_weather", InputSchema: mcp.ToolSchema{SchemaType: "object"}})},
+		tools: map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error){
+			"get_weather": func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return textResult("sunny"), nil },
+		},
+	}
+	// The format prefills the start of the named call, the model only writes its arguments
+	model := &fakeModel{outputs: []string{
+		"{\"city\": \"Paris\"}}\n</tool_call><|im_end|>",
+		"It is sunny in Paris.<|im_end|>",
+	}}
+
+	request := textMessage(ai.RoleUser, "Weather in Paris?\n/tool_choice get_weather")
+	if _, err := newRunner().Invoke(request, agent, f.(format.Format), model, nil); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if len(agent.calls) != 1 || agent.calls[0].Name != "get_weather" {
+		t.Errorf("Expected the prefilled call to run, got %+v", agent.calls)
+	}
+
+	// The choice is passed to the format, it is not part of the context
+	for _, msg := range agent.history {
+		for _, content := range msg.Content.Slice() {
+			if text := content.Text(); text != nil && strings.Contains(*text, "tool_choice") {
+				t.Errorf("Expected no tool choice in the context, got %q", *text)
+			}
+		}
+	}
+	if !strings.HasSuffix(string(model.inputs[0][0].Data), `<tool_call>`+"\n"+`{"name": "get_weather", "arguments": `) {
+		t.Errorf("Expected the call to be prefilled, got %q", model.inputs[0][0].Data)
+	}
+
+	// A format resource only encodes with auto
+	_, err = newRunner().Invoke(request, &fakeAgent{}, format.Resource(plainFormat{}), &fakeModel{outputs: []string{"Hi"}}, nil)
+	if err == nil {
+		t.Error("Expected an error for a tool choice the format resource cannot encode")
+	}
+}
+
+// callOutput returns a Qwen 3 turn calling a tool
+func callOutput(name string, args string) string {
+	return fmt.Sprintf("<tool_call>\n{\"name\": %q, \"arguments\": %s}\n</tool_call><|im_end|>", name, args)
+}
+
+// errorTexts returns the text of the error results of the messages
+func errorTexts(messages []ai.Message) []string {
+	var texts []string
+	for _, msg := range messages {
+		for _, content := range msg.Content.Slice() {
+			if content.String() == "tool-output" && content.ToolOutput().IsError {
+				texts = append(texts, resultText(content.ToolOutput()))
+			}
+		}
+	}
+	return texts
+}
+
+func TestInvoke_ToolErrors(t *testing.T) {
+	weather := mcp.Tool{
+		Name: "get_weather",
+		InputSchema: mcp.ToolSchema{
+			SchemaType: "object",
+			Properties: cm.ToList([][2]string{{"city", `{"type": "string"}`}, {"days", `{"type": "integer"}`}}),
+			Required:   cm.ToList([]string{"city"}),
+		},
+	}
+	fails := mcp.Tool{Name: "fails", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	answer := "Done.<|im_end|>"
+
+	tests := []struct {
+		name        string
+		outputs     []string
+		maxFailures int
+		wantErrors  []string
+		wantErr     string
+	}{
+		{
+			name:       "unknown tool",
+			outputs:    []string{callOutput("get_time", `{}`), answer},
+			wantErrors: []string{"Unknown tool get_time, the available tools are: get_weather, fails"},
+		},
+		{
+			name:       "missing argument",
+			outputs:    []string{callOutput("get_weather", `{}`), answer},
+			wantErrors: []string{"Invalid arguments for get_weather"},
+		},
+		{
+			name:       "argument of the wrong type",
+			outputs:    []string{callOutput("get_weather", `{"city": "Paris", "days": "two"}`), answer},
+			wantErrors: []string{"Invalid arguments for get_weather"},
+		},
+		{
+			name:       "numeric string argument",
+			outputs:    []string{callOutput("get_weather", `{"city": "42"}`), answer},
+			wantErrors: nil,
+		},
+		{
+			name:       "execution fails",
+			outputs:    []string{callOutput("fails", `{}`), answer},
+			wantErrors: []string{"Tool fails failed: out of service"},
+		},
+		{
+			name:       "success resets the failures",
+			outputs:    []string{callOutput("fails", `{}`), callOutput("get_weather", `{"city": "Paris"}`), callOutput("fails", `{}`), answer},
+			wantErrors: []string{"Tool fails failed", "Tool fails failed"},
+		},
+		{
+			name:        "too many failures in a row",
+			outputs:     []string{callOutput("fails", `{}`), callOutput("get_time", `{}`), answer},
+			maxFailures: 2,
+			wantErr:     "giving up after 2 consecutive tool failures, last: Unknown tool get_time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := qwen.NewQwen3(qwen.Qwen3Config{})
+			agent := &fakeAgent{
+				history: []ai.Message{toolsMessage(weather, fails)},
+				tools: map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error){
+					"get_weather": func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return textResult("sunny"), nil },
+					"fails":       func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return nil, fmt.Errorf("out of service") },
+				},
+			}
+			runner := newRunner()
+			if tt.maxFailures > 0 {
+				runner.MaxToolFailures = tt.maxFailures
+			}
+			out := &bytes.Buffer{}
+
+			messages, err := runner.Invoke(textMessage(ai.RoleUser, "Weather?"), agent, f.(format.Format), &fakeModel{outputs: tt.outputs}, out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				if last := readEvents(t, out.Bytes()); last[len(last)-1].Reason != events.Failed {
+					t.Errorf("Expected the run to fail, got %+v", last[len(last)-1])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Invoke failed: %v", err)
+			}
+
+			// Error results are returned to the model, which answers once it is done
+			got := errorTexts(messages)
+			if len(got) != len(tt.wantErrors) {
+				t.Fatalf("Expected %d error results, got %q", len(tt.wantErrors), got)
+			}
+			for i, want := range tt.wantErrors {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("Expected error result %d to start with %q, got %q", i, want, got[i])
+				}
+			}
+			if last := messages[len(messages)-1]; *last.Content.Slice()[0].Text() != "Done." {
+				t.Errorf("Expected the run to end with the answer, got %+v", last)
+			}
+		})
+	}
+}
+
+func TestInvoke_CallOrder(t *testing.T) {
+	readOnly := mcp.Tool{Name: "read_file", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	readOnly.Annotations.ReadOnlyHint = true
+	idempotent := mcp.Tool{Name: "create_folder", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	idempotent.Annotations.IdempotentHint = true
+	write := mcp.Tool{Name: "write_file", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+
+	agent := &fakeAgent{history: []ai.Message{toolsMessage(readOnly, idempotent, write)}}
+	agent.tools = map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error){}
+	for _, name := range []string{"read_file", "create_folder", "write_file"} {
+		agent.tools[name] = func(call mcp.CallToolParams) (*mcp.CallToolResult, error) {
+			return textResult(fmt.Sprintf("%s %d", call.Name, len(agent.calls))), nil
+		}
+	}
+
+	// Calls without side effects and calls with them are mixed in one turn
+	names := []string{"read_file", "write_file", "read_file", "create_folder", "write_file", "read_file"}
+	var output strings.Builder
+	for _, name := range names {
+		output.WriteString(fmt.Sprintf("<tool_call>\n{\"name\": %q, \"arguments\": {}}\n</tool_call>\n", name))
+	}
+	output.WriteString("<|im_end|>")
+	model := &fakeModel{outputs: []string{output.String(), "Done.<|im_end|>"}}
+
+	f, _ := qwen.NewQwen3(qwen.Qwen3Config{})
+	messages, err := newRunner().Invoke(textMessage(ai.RoleUser, "Update the files"), agent, f.(format.Format), model, nil)
+	if err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+
+	// Every call runs once, in the order the model made them, whatever its annotations
+	var called []string
+	for _, call := range agent.calls {
+		called = append(called, call.Name)
+	}
+	if !slices.Equal(called, names) {
+		t.Errorf("Expected the calls to run in order %v, got %v", names, called)
+	}
+
+	// Each result follows the call it answers
+	results := messages[1 : len(messages)-1]
+	if len(results) != len(names) {
+		t.Fatalf("Expected %d results, got %d", len(names), len(results))
+	}
+	for i, msg := range results {
+		want := fmt.Sprintf("%s %d", names[i], i+1)
+		if got := resultText(msg.Content.Slice()[0].ToolOutput()); got != want {
+			t.Errorf("Expected result %d to be %q, got %q", i, want, got)
+		}
+	}
+}
+
+// runEnd returns the reason of the run end event
+func runEnd(t *testing.T, out *bytes.Buffer) events.Reason {
+	t.Helper()
+	written := readEvents(t, out.Bytes())
+	return written[len(written)-1].Reason
+}
+
+func TestInvoke_Approval(t *testing.T) {
+	read := mcp.Tool{Name: "read_file", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	read.Annotations.ReadOnlyHint = true
+	remove := mcp.Tool{Name: "delete_file", InputSchema: mcp.ToolSchema{SchemaType: "object"}}
+	remove.Annotations.DestructiveHint = true
+
+	calls := "<tool_call>\n{\"name\": \"read_file\", \"arguments\": {\"path\": \"/tmp/a\"}}\n</tool_call>\n" +
+		"<tool_call>\n{\"name\": \"delete_file\", \"arguments\": {\"path\": \"/tmp/a\"}}\n</tool_call><|im_end|>"
+
+	// paused returns an agent whose run paused on the calls, and the runner that paused it
+	paused := func(t *testing.T) (*fakeAgent, *Runner) {
+		agent := &fakeAgent{history: []ai.Message{toolsMessage(read, remove)}}
+		agent.tools = map[string]func(mcp.CallToolParams) (*mcp.CallToolResult, error){
+			"read_file":   func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return textResult("contents"), nil },
+			"delete_file": func(mcp.CallToolParams) (*mcp.CallToolResult, error) { return textResult("deleted"), nil },
+		}
+		runner := newRunner()
+		runner.Policy = approval.Policy{Destructive: true}
+
+		f, _ := qwen.NewQwen3(qwen.Qwen3Config{})
+		out := &bytes.Buffer{}
+		messages, err := runner.Invoke(textMessage(ai.RoleUser, "Clean up /tmp/a"), agent, f.(format.Format), &fakeModel{outputs: []string{calls}}, out)
+		if err != nil {
+			t.Fatalf("Invoke failed: %v", err)
+		}
+
+		// None of the calls run, the destructive one waits for a decision
+		if reason := runEnd(t, out); reason != events.PendingApproval {
+			t.Fatalf("Expected the run to pause, got %s", reason)
+		}
+		if len(agent.calls) != 0 {
+			t.Fatalf("Expected no call to run while paused, got %+v", agent.calls)
+		}
+		if len(messages) != 2 || !approval.IsPending(messages[1].Content.Slice()[0].ToolOutput()) {
+			t.Fatalf("Expected the calls and a pending result for delete_file, got %+v", messages)
+		}
+		if pending := approval.Pending(agent.history); len(pending) != 2 {
+			t.Fatalf("Expected the calls to end the context, got %+v", pending)
+		}
+		return agent, runner
+	}
+
+	resume := func(t *testing.T, agent *fakeAgent, runner *Runner, request string, outputs ...string) ([]ai.Message, events.Reason, error) {
+		f, _ := qwen.NewQwen3(qwen.Qwen3Config{})
+		out := &bytes.Buffer{}
+		messages, err := runner.Invoke(textMessage(ai.RoleUser, request), agent, f.(format.Format), &fakeModel{outputs: outputs}, out)
+		return messages, runEnd(t, out), err
+	}
+
+	t.Run("approve", func(t *testing.T) {
+		agent, runner := paused(t)
+		_, reason, err := resume(t, agent, runner, "/approve", "Done.<|im_end|>")
+		if err != nil || reason != events.Completed {
+			t.Fatalf("Expected the run to complete, got %s, %v", reason, err)
+		}
+		if len(agent.calls) != 2 || agent.calls[0].Name != "read_file" || agent.calls[1].Name != "delete_file" {
+			t.Errorf("Expected both calls to run in order, got %+v", agent.calls)
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		agent, runner := paused(t)
+		messages, reason, err := resume(t, agent, runner, "/deny 1 keep it", "Kept.<|im_end|>")
+		if err != nil || reason != events.Completed {
+			t.Fatalf("Expected the run to complete, got %s, %v", reason, err)
+		}
+		if len(agent.calls) != 1 || agent.calls[0].Name != "read_file" {
+			t.Errorf("Expected only read_file to run, got %+v", agent.calls)
+		}
+		denied := messages[1].Content.Slice()[0].ToolOutput()
+		if !denied.IsError || resultText(denied) != "The user denied the call to delete_file: keep it" {
+			t.Errorf("Expected the denial to be returned to the model, got %+v", denied)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		agent, runner := paused(t)
+		messages, reason, err := resume(t, agent, runner, "/edit 1 {\"path\": \"/tmp/scratch\"}", "Done.<|im_end|>")
+		if err != nil || reason != events.Completed {
+			t.Fatalf("Expected the run to complete, got %s, %v", reason, err)
+		}
+		want := [2]string{"path", "/tmp/scratch"}
+		if len(agent.calls) != 2 || agent.calls[1].Arguments.Slice()[0] != want {
+			t.Fatalf("Expected delete_file to run with the edited path, got %+v", agent.calls)
+		}
+
+		// The context records the calls that ran, with the results following them
+		restated := messages[0]
+		if restated.Role != ai.RoleAssistant || restated.Content.Slice()[1].ToolInput().Arguments.Slice()[0] != want {
+			t.Errorf("Expected the edited calls to be restated, got %+v", restated)
+		}
+		i := slices.IndexFunc(agent.history, func(msg ai.Message) bool { return msg.Role == ai.RoleTool })
+		if i < 1 || agent.history[i-1].Content.Slice()[1].ToolInput().Arguments.Slice()[0] != want {
+			t.Errorf("Expected the results to follow the edited calls in the context")
+		}
+	})
+
+	t.Run("request without decisions", func(t *testing.T) {
+		agent, runner := paused(t)
+		before := len(agent.history)
+		if _, _, err := resume(t, agent, runner, "What happened?", "Nothing.<|im_end|>"); err == nil || !strings.Contains(err.Error(), "waiting for approval") {
+			t.Fatalf("Expected an error while calls wait for approval, got %v", err)
+		}
+		if len(agent.history) != before || len(agent.calls) != 0 {
+			t.Errorf("Expected the paused run to be left as it was")
+		}
+	})
+
+	t.Run("resumed runs keep their turns", func(t *testing.T) {
+		agent, runner := paused(t)
+		// The paused turn was the first of two
+		runner.MaxTurns = 1
+		again := callOutput("read_file", `{"path": "/tmp/b"}`)
+		_, reason, err := resume(t, agent, runner, "/approve", again, again)
+		if err != nil {
+			t.Fatalf("Invoke failed: %v", err)
+		}
+		if reason != events.MaxTurns || len(agent.calls) != 3 {
+			t.Errorf("Expected one turn after the resumed calls, got %s with calls %+v", reason, agent.calls)
+		}
+	})
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, fmt.Errorf("broken pipe") }
+
+func TestInvoke_WriteError(t *testing.T) {
+	agent := &fakeAgent{}
+	model := &fakeModel{outputs: []string{"Hello there, how can I help?"}}
+
+	_, err := newRunner().Invoke(textMessage(ai.RoleUser, "Hi"), agent, format.Resource(plainFormat{}), model, failingWriter{})
+	if err == nil || !strings.Contains(err.Error(), "broken pipe") {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+	if len(agent.history) == 0 {
+		t.Errorf("Expected the run to go on and record its messages")
+	}
+}
+
+func TestFail(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := newRunner().Fail(out, fmt.Errorf("no format"))
+	if err == nil || err.Error() != "no format" {
+		t.Fatalf("Expected the error to be returned, got %v", err)
+	}
+
+	written := readEvents(t, out.Bytes())
+	if len(written) != 3 || written[0].Type != events.RunStart || written[1].Type != events.Error || written[1].Error != "no format" || written[2].Reason != events.Failed {
+		t.Errorf("Expected run-start, error and a failed run-end, got %+v", written)
+	}
+
+	if err := newRunner().Fail(nil, fmt.Errorf("no format")); err == nil {
+		t.Errorf("Expected the error without a writer")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/hayride-dev/bindings/go/hayride/ai"
@@ -19,6 +20,8 @@ import (
 	"github.com/hayride-dev/bindings/go/hayride/ai/runner"
 	"github.com/hayride-dev/bindings/go/hayride/mcp/tools"
 	"github.com/hayride-dev/bindings/go/wasi/cli"
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+	"github.com/hayride-dev/morphs/components/ai/models/registry"
 	"go.bytecodealliance.org/cm"
 )
 
@@ -32,6 +35,10 @@ func main() {
 		log.Fatal("failed to download model:", err)
 	}
 
+	// Read the model facts from the GGUF header once, the models component selects its format
+	// from the same metadata when MODEL names this model. The agent works without them.
+	summary, details := describeModel(path)
+
 	// Initialize the context, tools, and model format
 	ctx, err := ctx.New()
 	if err != nil {
@@ -81,7 +88,8 @@ func main() {
 	}
 	writer := cli.GetStdout(true)
 
-	writer.Write([]byte("What can I help with?\n"))
+	writer.Write([]byte(summary))
+	writer.Write([]byte("What can I help with? (type '/info' for the model details)\n"))
 	for {
 		input, _ := reader.ReadString('\n')
 		prompt := strings.TrimSpace(input)
@@ -89,6 +97,10 @@ func main() {
 			writer.Write([]byte("Goodbye!\n"))
 			break
 		}
+		if prompt == "/info" {
+			writer.Write([]byte(details))
+			continue
+		}
 
 		msg := ai.Message{
 			Role: ai.RoleUser,
@@ -113,3 +125,38 @@ func main() {
 		writer.Write([]byte("\nWhat else can I help with? (type 'exit' to quit)\n"))
 	}
 }
+
+// describeModel summarizes the GGUF metadata of a model and the format selected for it, details
+// adds the special tokens and the chat template
+func describeModel(path string) (summary string, details string) {
+	info, err := gguf.Open(path)
+	if err != nil {
+		summary = fmt.Sprintf("failed to read model metadata: %v\n", err)
+		return summary, summary
+	}
+
+	builder := &strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Model: %s (%s, %s)\n", info.Name(), info.Architecture(), info.Quantization()))
+	builder.WriteString(fmt.Sprintf("Context length: %d\n", info.ContextLength()))
+
+	if name, _, err := registry.Default().ForFile(info); err != nil {
+		builder.WriteString(fmt.Sprintf("Format: %v\n", err))
+	} else {
+		builder.WriteString(fmt.Sprintf("Format: %s\n", name))
+	}
+	summary = builder.String()
+
+	special := info.SpecialTokens()
+	names := make([]string, 0, len(special))
+	for name := range special {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		id := special[name]
+		token, _ := info.Token(id)
+		builder.WriteString(fmt.Sprintf("Special token %s: %d %s\n", name, id, token))
+	}
+	builder.WriteString(fmt.Sprintf("Chat template:\n%s\n", info.ChatTemplate()))
+	return summary, builder.String()
+}
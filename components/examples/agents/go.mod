@@ -5,3 +5,7 @@ go 1.23.6
 require github.com/hayride-dev/bindings v0.0.66
 
 require go.bytecodealliance.org/cm v0.2.2
+
+require github.com/hayride-dev/morphs/components/ai/models v0.0.0
+
+replace github.com/hayride-dev/morphs/components/ai/models => ../../ai/models
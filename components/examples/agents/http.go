@@ -18,11 +18,17 @@ import (
 	"github.com/hayride-dev/bindings/go/hayride/mcp/tools"
 	"github.com/hayride-dev/bindings/go/hayride/x/net/http/server"
 	"github.com/hayride-dev/bindings/go/hayride/x/net/http/server/export"
+	"github.com/hayride-dev/bindings/go/wasi/net/http/handle"
+	"github.com/hayride-dev/morphs/components/ai/models/gguf"
+	"github.com/hayride-dev/morphs/components/ai/models/registry"
+	"github.com/hayride-dev/morphs/components/ai/models/toolchoice"
 	"go.bytecodealliance.org/cm"
 )
 
 type promptReq struct {
 	Message string `json:"message"`
+	// ToolChoice is auto, none, required or the name of a tool, the runner default when empty
+	ToolChoice string `json:"tool_choice,omitempty"`
 }
 
 type promptResp struct {
@@ -65,6 +71,7 @@ func init() {
 	h := &handler{
 		agent:  a,
 		runner: runner,
+		writer: runnerOpts.Writer,
 	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/generate", h.handlerFunc)
@@ -78,6 +85,8 @@ func init() {
 type handler struct {
 	agent  agents.Agent
 	runner runner.Runner
+	// writer is the framing of the events the runner streams
+	writer ai.WriterType
 }
 
 func (h *handler) handlerFunc(w http.ResponseWriter, r *http.Request) {
@@ -91,39 +100,85 @@ func (h *handler) handlerFunc(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Received message:", req.Message)
 
+	contents := []ai.MessageContent{
+		ai.NewMessageContent(ai.Text(req.Message)),
+	}
+
+	// The runner reads the tool choice of the request from a /tool_choice line
+	if req.ToolChoice != "" {
+		choice, err := toolchoice.Parse(req.ToolChoice)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		contents = append(contents, ai.NewMessageContent(ai.Text(toolchoice.Directive+" "+choice.String())))
+	}
+
 	msg := ai.Message{
-		Role: ai.RoleUser,
-		Content: cm.ToList([]ai.MessageContent{
-			ai.NewMessageContent(ai.Text(req.Message)),
-		}),
+		Role:    ai.RoleUser,
+		Content: cm.ToList(contents),
 	}
 
 	repo := repository.New()
 	path, err := repo.DownloadModel("bartowski/Meta-Llama-3.1-8B-Instruct-GGUF/Meta-Llama-3.1-8B-Instruct-Q5_K_M.gguf")
 	if err != nil {
-		log.Fatal("failed to download model:", err)
+		http.Error(w, "failed to download model: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The models component selects its format from the same GGUF metadata when MODEL names
+	// this model, log what it will pick
+	if info, err := gguf.Open(path); err != nil {
+		fmt.Println("failed to read model metadata:", err)
+	} else if name, _, err := registry.Default().ForFile(info); err != nil {
+		fmt.Println("no format for model:", err)
+	} else {
+		fmt.Printf("Model %s uses format %s with a context length of %d\n", info.Name(), name, info.ContextLength())
 	}
 
 	format, err := models.New()
 	if err != nil {
-		log.Fatal("failed to create model format:", err)
+		http.Error(w, "failed to create model format: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	// host provides a graph stream
 	inferenceStream, err := graph.LoadByName(path)
 	if err != nil {
-		log.Fatal("failed to load graph:", err)
+		http.Error(w, "failed to load graph: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	graphExecutionCtxStream, err := inferenceStream.InitExecutionContextStream()
 	if err != nil {
-		log.Fatal("failed to initialize graph execution context stream:", err)
+		http.Error(w, "failed to initialize graph execution context stream: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	if _, err := h.runner.Invoke(msg, h.agent, format, graphExecutionCtxStream, w); err != nil {
-		http.Error(w, "failed to invoke agent: "+err.Error(), http.StatusInternalServerError)
+	// The runner sets event-stream headers on a response writer whatever its framing, so the
+	// headers are written here and the runner gets the body stream
+	response, ok := w.(*handle.WasiResponseWriter)
+	if !ok {
+		http.Error(w, "response writer cannot stream", http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", contentType(h.writer))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Once streaming, the runner reports failures to the client as an error event followed by
+	// run-end
+	if _, err := h.runner.Invoke(msg, h.agent, format, graphExecutionCtxStream, response.Writer); err != nil {
+		fmt.Println("failed to invoke agent:", err)
+	}
+}
+
+// contentType returns the content type of the events streamed in the framing of writerType
+func contentType(writerType ai.WriterType) string {
+	if writerType == ai.WriterTypeRaw {
+		return "application/x-ndjson"
+	}
+	return "text/event-stream"
 }
 
 func main() {}
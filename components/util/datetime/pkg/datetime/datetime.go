@@ -1,7 +1,36 @@
 package datetime
 
-import "github.com/hayride-dev/morphs/components/util/datetime/pkg/internal/gen/hayride/datetime/datetime"
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hayride-dev/morphs/components/util/datetime/pkg/internal/gen/hayride/datetime/datetime"
+)
+
+const (
+	// The datetime component answers with a sentence such as
+	// "Today's date is Monday, August 12, 2025 and it is 03:04 PM +00:00"
+	datePrefix    = "Today's date is "
+	timeSeparator = " and it is "
+	dateLayout    = "Monday, January 02, 2006"
+)
 
 func Date() string {
 	return datetime.Date()
 }
+
+// Today returns the current date reported by the datetime component
+func Today() (time.Time, error) {
+	return Parse(Date())
+}
+
+// Parse returns the date of a sentence returned by Date, the time of day is ignored
+func Parse(date string) (time.Time, error) {
+	day, _, _ := strings.Cut(strings.TrimPrefix(date, datePrefix), timeSeparator)
+	t, err := time.Parse(dateLayout, strings.TrimSpace(day))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse date %q: %w", date, err)
+	}
+	return t, nil
+}